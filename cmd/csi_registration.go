@@ -13,6 +13,9 @@ import (
 	"strings"
 
 	"github.com/Datadog/datadog-csi-driver/pkg/driver"
+	"github.com/Datadog/datadog-csi-driver/pkg/driver/publishers"
+	"github.com/Datadog/datadog-csi-driver/pkg/kubeclient"
+	"github.com/Datadog/datadog-csi-driver/pkg/policy"
 	"github.com/Datadog/datadog-csi-driver/utils"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/spf13/viper"
@@ -22,6 +25,57 @@ import (
 // registerAndStartCSIDriver registers the CSI driver and starts it
 // This is a blocking operation.
 func registerAndStartCSIDriver(ctx context.Context) error {
+	publishTimeouts, err := driver.ParsePublishTimeouts(getStringSlice("publish-timeouts"))
+	if err != nil {
+		return err
+	}
+
+	admissionPolicy, err := loadAdmissionPolicy(viper.GetString("admission-policy-file"))
+	if err != nil {
+		return err
+	}
+
+	sockets, err := loadSockets(viper.GetString("socket-registry-file"))
+	if err != nil {
+		return err
+	}
+
+	preloadVerification, err := publishers.ParsePreloadVerification(viper.GetString("preload-verification"))
+	if err != nil {
+		return err
+	}
+
+	legacySchemaPolicy, err := publishers.ParseLegacySchemaPolicy(viper.GetString("legacy-schema-policy"))
+	if err != nil {
+		return err
+	}
+
+	libraryStoreBudget, err := driver.ParseLibraryStoreBudget(viper.GetString("library-store-budget"))
+	if err != nil {
+		return err
+	}
+
+	scratchSize, err := driver.ParseScratchSize(viper.GetString("scratch-default-size"), viper.GetString("scratch-max-size"))
+	if err != nil {
+		return err
+	}
+
+	prefetchLibraries, err := driver.ParsePrefetchLibraries(getStringSlice("prefetch-libraries"))
+	if err != nil {
+		return err
+	}
+
+	pods, err := newPods(preloadVerification)
+	if err != nil {
+		return err
+	}
+
+	podEvents, err := newPodEvents(legacySchemaPolicy, viper.GetString("driver-name"))
+	if err != nil {
+		return err
+	}
+	defer podEvents.Stop()
+
 	// Create CSI driver
 	csiDriver, err := driver.NewDatadogCSIDriver(
 		viper.GetString("driver-name"),
@@ -31,6 +85,27 @@ func registerAndStartCSIDriver(ctx context.Context) error {
 		Version,
 		viper.GetBool("apm-enabled"),
 		getRegistryAllowList(),
+		driver.WithSelfCheckInterval(viper.GetDuration("self-check-interval")),
+		driver.WithKubeletRootDir(viper.GetString("kubelet-root-dir")),
+		driver.WithReconcileInterval(viper.GetDuration("reconcile-interval")),
+		driver.WithPublishTimeouts(publishTimeouts),
+		driver.WithMaxConcurrentDownloads(viper.GetInt("max-concurrent-downloads")),
+		driver.WithDownloadPriorityByDemand(viper.GetBool("prioritize-downloads-by-demand")),
+		driver.WithDownloadWait(viper.GetDuration("download-wait")),
+		driver.WithAdmissionPolicy(admissionPolicy),
+		driver.WithPodInfoOnMount(viper.GetBool("pod-info-on-mount")),
+		driver.WithSockets(sockets),
+		driver.WithPreloadVerification(preloadVerification),
+		driver.WithPods(pods),
+		driver.WithLegacySchemaPolicy(legacySchemaPolicy),
+		driver.WithPodEventRecorder(podEvents),
+		driver.WithLibraryStoreBudget(libraryStoreBudget),
+		driver.WithPrefetchLibraries(prefetchLibraries),
+		driver.WithScratchSize(scratchSize),
+		driver.WithSocketWait(publishers.SocketWait{
+			Timeout:       viper.GetDuration("socket-wait-timeout"),
+			CheckLiveness: viper.GetBool("socket-wait-liveness"),
+		}),
 	)
 	if err != nil {
 		log.Error("Failed to create CSI driver", "error", err)
@@ -83,10 +158,76 @@ func registerAndStartCSIDriver(ctx context.Context) error {
 	}
 }
 
-// getRegistryAllowList returns the registry allow list, handling the case where
-// Viper returns a comma-separated env var as a single element instead of splitting it.
+// loadAdmissionPolicy loads the admission policy file, if any. A Kubernetes
+// client is only created when a rule reads the namespace labels.
+func loadAdmissionPolicy(path string) (*policy.Policy, error) {
+	if path == "" {
+		return nil, nil
+	}
+	admissionPolicy, err := policy.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if admissionPolicy.NeedsNamespaceLabels() {
+		labeler, err := kubeclient.NewInClusterNamespaceLabels(kubeclient.DefaultNamespaceLabelsTTL)
+		if err != nil {
+			return nil, fmt.Errorf("admission policy reads namespace labels: %w", err)
+		}
+		admissionPolicy.SetNamespaceLabeler(labeler)
+	}
+	log.Info("Loaded admission policy", "path", path, "rules", admissionPolicy.Rules())
+	return admissionPolicy, nil
+}
+
+// newPodEvents creates the recorder of the events sent to the pods, which is
+// only needed to warn about the deprecated mode/path schema.
+func newPodEvents(legacySchemaPolicy publishers.LegacySchemaPolicy, driverName string) (*kubeclient.PodEvents, error) {
+	if legacySchemaPolicy != publishers.LegacySchemaWarn {
+		return nil, nil
+	}
+	podEvents, err := kubeclient.NewInClusterPodEvents(driverName)
+	if err != nil {
+		return nil, fmt.Errorf("legacy schema policy %q sends events: %w", legacySchemaPolicy, err)
+	}
+	return podEvents, nil
+}
+
+// newPods creates the reader of the pods, which is only needed to tell a
+// pending apm-inject library volume from a missing one when the preload
+// verification mounts an empty file.
+func newPods(verification publishers.PreloadVerification) (publishers.PodGetter, error) {
+	if verification != publishers.PreloadVerificationEmpty {
+		return nil, nil
+	}
+	pods, err := kubeclient.NewInClusterPods()
+	if err != nil {
+		return nil, fmt.Errorf("preload verification %q reads pods: %w", verification, err)
+	}
+	return pods, nil
+}
+
+// loadSockets loads the socket registry file, if any.
+func loadSockets(path string) ([]publishers.NamedSocket, error) {
+	if path == "" {
+		return nil, nil
+	}
+	sockets, err := publishers.LoadSockets(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Loaded socket registry", "path", path, "sockets", len(sockets))
+	return sockets, nil
+}
+
+// getRegistryAllowList returns the registry allow list.
 func getRegistryAllowList() []string {
-	raw := viper.GetStringSlice("registry-allow-list")
+	return getStringSlice("registry-allow-list")
+}
+
+// getStringSlice returns a string slice setting, handling the case where
+// Viper returns a comma-separated env var as a single element instead of splitting it.
+func getStringSlice(key string) []string {
+	raw := viper.GetStringSlice(key)
 	var result []string
 	for _, item := range raw {
 		for _, r := range strings.Split(item, ",") {
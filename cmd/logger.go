@@ -8,6 +8,8 @@ package main
 import (
 	log "log/slog"
 	"os"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
 )
 
 func init() {
@@ -27,5 +29,6 @@ func init() {
 	handler := log.NewJSONHandler(os.Stdout, &log.HandlerOptions{
 		Level: level,
 	})
-	log.SetDefault(log.New(handler))
+	// Tag the log lines of a request with the pod it is for.
+	log.SetDefault(log.New(podinfo.NewLogHandler(handler)))
 }
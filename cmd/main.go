@@ -12,6 +12,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Datadog/datadog-csi-driver/pkg/driver"
 	"github.com/Datadog/datadog-csi-driver/pkg/metrics"
@@ -34,10 +35,79 @@ func init() {
 	// Env var: DD_APM_ENABLED
 	pflag.Bool("apm-enabled", true, "Enable APM/SSI publishers (library and injector preload)")
 
+	// YAML file of named socket volume types, on top of APMSocket, DSDSocket and their directory variants.
+	// Env var: DD_SOCKET_REGISTRY_FILE
+	pflag.String("socket-registry-file", "", "Path to a YAML file of named socket volume types, each mapping a volume type name to a host socket or directory. Empty only serves the APM and DogStatsD sockets.")
+
+	// Policy applied when the launcher of a DatadogInjectorPreload volume is not found in an apm-inject library volume of the pod.
+	// Env var: DD_PRELOAD_VERIFICATION
+	pflag.String("preload-verification", "disabled", "Verify that an apm-inject library volume of the pod provides the launcher of DatadogInjectorPreload volumes: disabled, empty (mount an empty preload file) or fail (fail the publish)")
+
+	// Maximum wait of socket file volumes for their agent socket, e.g. while the agent starts after a node boot.
+	// Env var: DD_SOCKET_WAIT_TIMEOUT
+	pflag.Duration("socket-wait-timeout", 0, "Maximum duration a socket volume publish waits for its agent socket to be created, at most 90s. Volumes can override it with the dd.csi.datadog.com/socket.wait-timeout attribute. 0 fails immediately.")
+
+	// Also wait for the agent socket to accept connections.
+	// Env var: DD_SOCKET_WAIT_LIVENESS
+	pflag.Bool("socket-wait-liveness", false, "Check that the agent socket accepts connections before publishing a socket volume. Volumes can override it with the dd.csi.datadog.com/socket.wait-liveness attribute.")
+
+	// Enforcement level of the deprecated mode/path volume schema.
+	// Env var: DD_LEGACY_SCHEMA_POLICY
+	pflag.String("legacy-schema-policy", "allow", "Enforcement level of the deprecated mode/path volume attributes: allow, warn (also send a Kubernetes event to the pod) or reject (fail the publish)")
+
 	// Allowed registries for DatadogLibrary volumes. If empty, all registries are allowed.
 	// Env var: DD_REGISTRY_ALLOW_LIST (comma-separated)
 	pflag.StringSlice("registry-allow-list", []string{}, "Allowed registries for DatadogLibrary volumes. If empty, all registries are allowed.")
 
+	// Kubelet root directory, scanned to reconcile library volume links with the host mount table.
+	// Env var: DD_KUBELET_ROOT_DIR
+	pflag.String("kubelet-root-dir", "/var/lib/kubelet", "Kubelet root directory, used to reconcile library volumes with the host mount table and to validate volume target paths. Empty disables both.")
+
+	// Delay between two reconciliations of library volume links with the host mount table.
+	// Env var: DD_RECONCILE_INTERVAL
+	pflag.Duration("reconcile-interval", 10*time.Minute, "Delay between two reconciliations of library volumes with the host mount table. 0 only reconciles at startup.")
+
+	// Maximum duration of a publish per volume type, on top of the kubelet deadline, e.g. DatadogLibrary=2m.
+	// Env var: DD_PUBLISH_TIMEOUTS (comma-separated)
+	pflag.StringSlice("publish-timeouts", []string{}, "Maximum duration of NodePublishVolume per volume type, as <volume type>=<duration> entries. Volume types without an entry are only bound by the kubelet deadline.")
+
+	// Maximum number of libraries downloaded and extracted at once on the node.
+	// Env var: DD_MAX_CONCURRENT_DOWNLOADS
+	pflag.Int("max-concurrent-downloads", 4, "Maximum number of libraries downloaded and extracted at once. Other downloads wait in a queue. 0 disables the limit.")
+
+	// How long a publish waits for a library downloading in the background before failing with Unavailable.
+	// Env var: DD_DOWNLOAD_WAIT
+	pflag.Duration("download-wait", 0, "How long a publish waits for a library downloading in the background before failing with Unavailable so that kubelet retries it. The publish holds its volume lock while it waits. 0 fails it right away.")
+
+	// Start the queued downloads needed by the most waiting volumes first.
+	// Env var: DD_PRIORITIZE_DOWNLOADS_BY_DEMAND
+	pflag.Bool("prioritize-downloads-by-demand", false, "Start the queued library downloads needed by the most waiting volumes first, instead of the oldest ones")
+
+	// YAML file of CEL rules every NodePublishVolume request must satisfy.
+	// Env var: DD_ADMISSION_POLICY_FILE
+	pflag.String("admission-policy-file", "", "Path to a YAML file of CEL rules evaluated on every NodePublishVolume request. Empty admits all volumes.")
+
+	// Whether the CSIDriver object sets podInfoOnMount, so that kubelet sets the pod identity of the volume context.
+	// Env var: DD_POD_INFO_ON_MOUNT
+	pflag.Bool("pod-info-on-mount", false, "Trust the pod identity of the volume context, which requires podInfoOnMount: true on the CSIDriver object. Otherwise admission policy rules depending on pod or namespaceObject deny the volume.")
+
+	// Delay between two runs of the readiness checks reported by the Identity Probe RPC.
+	// Env var: DD_SELF_CHECK_INTERVAL
+	pflag.Duration("self-check-interval", 30*time.Second, "Delay between two runs of the driver readiness checks")
+
+	// Size budget of the library store, as a Kubernetes quantity, e.g. 10Gi.
+	// Env var: DD_LIBRARY_STORE_BUDGET
+	pflag.String("library-store-budget", "", "Size the library store may grow to (e.g. 10Gi) before unused libraries are evicted, least recently used first. If empty, unused libraries are removed 15 minutes after their last volume.")
+
+	// Default and maximum size caps of the DatadogScratch volumes, as Kubernetes quantities.
+	// Env vars: DD_SCRATCH_DEFAULT_SIZE, DD_SCRATCH_MAX_SIZE
+	pflag.String("scratch-default-size", "64Mi", "Size cap of the DatadogScratch volumes that do not set dd.csi.datadog.com/scratch.size")
+	pflag.String("scratch-max-size", "1Gi", "Largest size cap a DatadogScratch volume may set with dd.csi.datadog.com/scratch.size. Scratch volumes share the storage path with the library store.")
+
+	// Libraries downloaded at startup and after every tag refresh, and never cleaned up, e.g. gcr.io/datadoghq/apm-inject:0.
+	// Env var: DD_PREFETCH_LIBRARIES (comma-separated)
+	pflag.StringSlice("prefetch-libraries", []string{}, "Libraries to download in the background at startup and after every tag refresh, as <registry>/<package>:<version> references. They are pinned in the store and never cleaned up.")
+
 	// Parse flags
 	pflag.Parse()
 
@@ -6,24 +6,35 @@
 package driver
 
 import (
+	"context"
 	"fmt"
 	log "log/slog"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Datadog/datadog-csi-driver/pkg/driver/publishers"
 	"github.com/Datadog/datadog-csi-driver/pkg/librarymanager"
 	"github.com/Datadog/datadog-csi-driver/pkg/metrics"
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
+	"github.com/Datadog/datadog-csi-driver/pkg/policy"
 	"github.com/Datadog/datadog-csi-driver/pkg/registryauth"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/spf13/afero"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/utils/mount"
 )
 
 const (
 	// cleanupDelay is the delay before cleaning up unused libraries.
 	cleanupDelay = 15 * time.Minute
+	// lruCleanupGracePeriod is how long a library is kept after its last use
+	// when the store has a size budget, so that a library downloaded in the
+	// background is still there when kubelet retries the publish.
+	lruCleanupGracePeriod = 5 * time.Minute
+	// legacySchemaEventReason is the reason of the events warning about the deprecated mode/path schema.
+	legacySchemaEventReason = "DeprecatedVolumeSchema"
 )
 
 // DatadogCSIDriver is datadog CSI driver implementing CSI Node and Identity Server
@@ -37,6 +48,295 @@ type DatadogCSIDriver struct {
 	libraryManager *librarymanager.LibraryManager
 	fs             afero.Afero
 	mounter        mount.Interface
+	selfChecker    *selfChecker
+	socketRebinder *publishers.SocketRebinder
+
+	kubeletRootDir  string
+	publishTimeouts map[string]time.Duration
+	admissionPolicy *policy.Policy
+	podInfoOnMount  bool
+}
+
+// driverOptions holds the optional driver settings.
+type driverOptions struct {
+	selfCheckInterval   time.Duration
+	kubeletRootDir      string
+	reconcileInterval   time.Duration
+	publishTimeouts     map[string]time.Duration
+	downloadQueue       librarymanager.DownloadQueueConfig
+	downloadWait        time.Duration
+	admissionPolicy     *policy.Policy
+	podInfoOnMount      bool
+	sockets             []publishers.NamedSocket
+	preloadVerification publishers.PreloadVerification
+	pods                publishers.PodGetter
+	socketWait          publishers.SocketWait
+	legacySchemaPolicy  publishers.LegacySchemaPolicy
+	podEvents           PodEventRecorder
+	libraryStoreBudget  int64
+	prefetchLibraries   []*librarymanager.Library
+	scratchSize         publishers.ScratchSize
+}
+
+// PodEventRecorder records Kubernetes events on the pods volumes are published for.
+type PodEventRecorder interface {
+	Warn(pod podinfo.PodInfo, reason, message string)
+}
+
+// DriverOption is a functional option for configuring the driver.
+type DriverOption func(*driverOptions)
+
+// WithKubeletRootDir sets the kubelet root directory scanned when reconciling
+// library volume links against the host mount table. Volumes are only
+// published to target paths under it. The reconciliation and the target path
+// validation are disabled when it is empty.
+func WithKubeletRootDir(dir string) DriverOption {
+	return func(o *driverOptions) {
+		o.kubeletRootDir = dir
+	}
+}
+
+// WithReconcileInterval sets the delay between two reconciliations of library
+// volume links against the host mount table.
+func WithReconcileInterval(interval time.Duration) DriverOption {
+	return func(o *driverOptions) {
+		o.reconcileInterval = interval
+	}
+}
+
+// WithMaxConcurrentDownloads limits the number of libraries downloaded and
+// extracted at once on the node. The limit is disabled when it is zero or less.
+func WithMaxConcurrentDownloads(limit int) DriverOption {
+	return func(o *driverOptions) {
+		o.downloadQueue.MaxConcurrent = limit
+	}
+}
+
+// WithDownloadPriorityByDemand starts the queued library downloads needed by
+// the most waiting volumes first, instead of the oldest ones.
+func WithDownloadPriorityByDemand(enabled bool) DriverOption {
+	return func(o *driverOptions) {
+		o.downloadQueue.PrioritizeDemand = enabled
+	}
+}
+
+// WithDownloadWait sets how long a publish waits for a library downloading in
+// the background before failing with Unavailable and letting kubelet retry. The
+// publish holds its volume lock while it waits, so it fails right away when the
+// wait is zero.
+func WithDownloadWait(wait time.Duration) DriverOption {
+	return func(o *driverOptions) {
+		o.downloadWait = wait
+	}
+}
+
+// WithPublishTimeouts bounds the duration of NodePublishVolume per volume type,
+// on top of the deadline set by kubelet. A publish that times out stops its
+// library download and fails with DeadlineExceeded.
+func WithPublishTimeouts(timeouts map[string]time.Duration) DriverOption {
+	return func(o *driverOptions) {
+		o.publishTimeouts = timeouts
+	}
+}
+
+// WithAdmissionPolicy sets the policy every NodePublishVolume request must
+// satisfy. Denied volumes fail with PermissionDenied. All volumes are admitted
+// when it is nil.
+func WithAdmissionPolicy(admissionPolicy *policy.Policy) DriverOption {
+	return func(o *driverOptions) {
+		o.admissionPolicy = admissionPolicy
+	}
+}
+
+// WithPodInfoOnMount declares that the CSIDriver object sets podInfoOnMount, so
+// that kubelet overrides the pod identity keys of the volume context. Without
+// it, admission policy rules depending on the pod identity deny the volume,
+// since the author of an inline volume could set these keys.
+func WithPodInfoOnMount(enabled bool) DriverOption {
+	return func(o *driverOptions) {
+		o.podInfoOnMount = enabled
+	}
+}
+
+// WithSockets registers socket volume types on top of the APM and DogStatsD
+// ones, or overrides them.
+func WithSockets(sockets []publishers.NamedSocket) DriverOption {
+	return func(o *driverOptions) {
+		o.sockets = sockets
+	}
+}
+
+// WithPreloadVerification sets the policy applied when the launcher preloaded by
+// a DatadogInjectorPreload volume is not found in an apm-inject library volume
+// of the same pod.
+func WithPreloadVerification(verification publishers.PreloadVerification) DriverOption {
+	return func(o *driverOptions) {
+		o.preloadVerification = verification
+	}
+}
+
+// WithPods sets the reader of the pods volumes are published for. The preload
+// verification uses it to retry a DatadogInjectorPreload volume with
+// Unavailable while the apm-inject library volume of its pod is pending. The
+// pod identity is only trusted with WithPodInfoOnMount.
+func WithPods(pods publishers.PodGetter) DriverOption {
+	return func(o *driverOptions) {
+		o.pods = pods
+	}
+}
+
+// WithSocketWait sets how long socket file volumes wait for their agent socket
+// when it is not ready, and whether the socket must accept connections. Volumes
+// can override it. A publish still not ready after the wait fails with Unavailable.
+func WithSocketWait(wait publishers.SocketWait) DriverOption {
+	return func(o *driverOptions) {
+		o.socketWait = wait
+	}
+}
+
+// WithLegacySchemaPolicy sets the enforcement level of the deprecated mode/path
+// schema. Rejected volumes fail with InvalidArgument and the type to migrate to.
+func WithLegacySchemaPolicy(policy publishers.LegacySchemaPolicy) DriverOption {
+	return func(o *driverOptions) {
+		o.legacySchemaPolicy = policy
+	}
+}
+
+// WithPodEventRecorder sets the recorder of the Kubernetes events sent to the
+// pods, e.g. to warn about the deprecated mode/path schema. No event is sent
+// when it is nil.
+func WithPodEventRecorder(recorder PodEventRecorder) DriverOption {
+	return func(o *driverOptions) {
+		o.podEvents = recorder
+	}
+}
+
+// WithLibraryStoreBudget keeps unused libraries in the store until it grows
+// over the given number of bytes, then evicts them least recently used first.
+// Unused libraries are removed after a fixed delay when it is zero or less.
+func WithLibraryStoreBudget(budgetBytes int64) DriverOption {
+	return func(o *driverOptions) {
+		o.libraryStoreBudget = budgetBytes
+	}
+}
+
+// WithScratchSize sets the default and the maximum size caps of the
+// DatadogScratch volumes.
+func WithScratchSize(size publishers.ScratchSize) DriverOption {
+	return func(o *driverOptions) {
+		o.scratchSize = size
+	}
+}
+
+// WithPrefetchLibraries downloads libraries in the background at startup and
+// after every refresh of their tag, and pins them so they are never cleaned up.
+// They are ignored when SSI storage is disabled.
+func WithPrefetchLibraries(libs []*librarymanager.Library) DriverOption {
+	return func(o *driverOptions) {
+		o.prefetchLibraries = libs
+	}
+}
+
+// ParsePrefetchLibraries parses the libraries to prefetch given as
+// <registry>/<package>:<version> references.
+func ParsePrefetchLibraries(entries []string) ([]*librarymanager.Library, error) {
+	var libs []*librarymanager.Library
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		lib, err := librarymanager.ParseLibraryReference(entry, true)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefetch library: %w", err)
+		}
+		libs = append(libs, lib)
+	}
+	return libs, nil
+}
+
+// ParseLibraryStoreBudget parses the size budget of the library store given as
+// a Kubernetes quantity, e.g. "10Gi". An empty value disables the budget.
+func ParseLibraryStoreBudget(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid library store budget %q: %w", value, err)
+	}
+	if quantity.Sign() <= 0 {
+		return 0, fmt.Errorf("invalid library store budget %q: must be positive", value)
+	}
+	return quantity.Value(), nil
+}
+
+// ParseScratchSize parses the default and the maximum size caps of the
+// DatadogScratch volumes, given as Kubernetes quantities.
+func ParseScratchSize(defaultValue, maxValue string) (publishers.ScratchSize, error) {
+	var size publishers.ScratchSize
+	for _, field := range []struct {
+		name  string
+		value string
+		bytes *int64
+	}{
+		{"default scratch size", defaultValue, &size.Default},
+		{"maximum scratch size", maxValue, &size.Max},
+	} {
+		quantity, err := resource.ParseQuantity(strings.TrimSpace(field.value))
+		if err != nil {
+			return size, fmt.Errorf("invalid %s %q: %w", field.name, field.value, err)
+		}
+		if quantity.Value() < 1<<20 {
+			return size, fmt.Errorf("invalid %s %q: must be at least 1Mi", field.name, field.value)
+		}
+		*field.bytes = quantity.Value()
+	}
+	if size.Default > size.Max {
+		return size, fmt.Errorf("invalid default scratch size %q: must not exceed the maximum scratch size %q", defaultValue, maxValue)
+	}
+	return size, nil
+}
+
+// ParsePublishTimeouts parses publish timeouts given as "<volume type>=<duration>" entries.
+func ParsePublishTimeouts(entries []string) (map[string]time.Duration, error) {
+	timeouts := map[string]time.Duration{}
+	for _, entry := range entries {
+		volumeType, value, found := strings.Cut(entry, "=")
+		volumeType = strings.TrimSpace(volumeType)
+		if !found || volumeType == "" {
+			return nil, fmt.Errorf("invalid publish timeout %q, expected <volume type>=<duration>", entry)
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid publish timeout %q: %w", entry, err)
+		}
+		if timeout <= 0 {
+			return nil, fmt.Errorf("invalid publish timeout %q: must be positive", entry)
+		}
+		timeouts[volumeType] = timeout
+	}
+	return timeouts, nil
+}
+
+// WithSelfCheckInterval sets the delay between two runs of the readiness checks
+// reported by the Probe RPC.
+func WithSelfCheckInterval(interval time.Duration) DriverOption {
+	return func(o *driverOptions) {
+		if interval > 0 {
+			o.selfCheckInterval = interval
+		}
+	}
+}
+
+// newCleanupStrategy returns the strategy removing the unused libraries: LRU
+// evictions when the store has a size budget, a fixed delay otherwise.
+func newCleanupStrategy(libraryStoreBudget int64) librarymanager.CleanupStrategy {
+	if libraryStoreBudget > 0 {
+		return librarymanager.NewLRUCleanupStrategy(libraryStoreBudget, lruCleanupGracePeriod)
+	}
+	return librarymanager.NewDelayedCleanupStrategy(cleanupDelay)
 }
 
 // Version returns the CSI driver version
@@ -46,6 +346,10 @@ func (driver *DatadogCSIDriver) Version() string {
 
 // Stop ensures all dependencies are stopped correctly.
 func (driver *DatadogCSIDriver) Stop() error {
+	if driver.selfChecker != nil {
+		driver.selfChecker.stopLoop()
+	}
+	driver.socketRebinder.Stop()
 	if driver.libraryManager == nil {
 		return nil
 	}
@@ -78,17 +382,32 @@ func newDatadogCSIDriver(
 	name, apmHostSocketPath, dsdHostSocketPath, storageBasePath, version string,
 	apmEnabled bool,
 	allowedRegistries []string,
+	opts ...DriverOption,
 ) (*DatadogCSIDriver, error) {
-	requestedStorageBasePath := storageBasePath
+	options := driverOptions{selfCheckInterval: defaultSelfCheckInterval}
+	for _, opt := range opts {
+		opt(&options)
+	}
 
-	var err error
-	storageBasePath, err = createStorageDir(fs, storageBasePath)
+	sockets, err := publishers.NewSocketRegistry(apmHostSocketPath, dsdHostSocketPath, options.sockets)
 	if err != nil {
-		logger.Warn("Disabling SSI storage", "storage_base_path", requestedStorageBasePath, "error", err)
+		return nil, fmt.Errorf("invalid socket registry: %w", err)
+	}
+
+	requestedStorageBasePath := strings.TrimSpace(storageBasePath)
+
+	storageBasePath, storageErr := createStorageDir(fs, storageBasePath)
+	if storageErr != nil {
+		logger.Warn("Disabling SSI storage", "storage_base_path", requestedStorageBasePath, "error", storageErr)
 		storageBasePath = ""
 	}
 
+	// The rebinder is seeded from the library manager, which is created first:
+	// the reconciler reaches it through this pointer once it is created.
+	var rebinderRef atomic.Pointer[publishers.SocketRebinder]
+
 	var lm *librarymanager.LibraryManager
+	var lmErr error
 	if storageBasePath != "" {
 		downloader := librarymanager.NewDownloader()
 		keychain, err := registryauth.NewKeychainFromEnvironment()
@@ -98,31 +417,176 @@ func newDatadogCSIDriver(
 		if keychain != nil {
 			downloader = librarymanager.NewDownloaderWithKeychain(keychain)
 		}
-		lm, err = librarymanager.NewLibraryManager(
-			storageBasePath,
+		lmOpts := []librarymanager.LibraryManagerOption{
 			librarymanager.WithFilesystem(fs),
 			librarymanager.WithDownloader(downloader),
-			librarymanager.WithCleanupStrategy(librarymanager.NewDelayedCleanupStrategy(cleanupDelay)),
+			librarymanager.WithCleanupStrategy(newCleanupStrategy(options.libraryStoreBudget)),
 			librarymanager.WithEventListener(metrics.NewLibraryListener()),
-		)
+			librarymanager.WithMounter(mounter),
+			librarymanager.WithBackgroundDownloads(options.downloadWait),
+			librarymanager.WithDownloadQueue(options.downloadQueue),
+		}
+		if len(options.prefetchLibraries) > 0 {
+			lmOpts = append(lmOpts, librarymanager.WithPrefetch(librarymanager.PrefetchConfig{Libraries: options.prefetchLibraries}))
+		}
+		if options.kubeletRootDir != "" {
+			lmOpts = append(lmOpts, librarymanager.WithReconciler(librarymanager.ReconcilerConfig{
+				KubeletRootDir: options.kubeletRootDir,
+				DriverName:     name,
+				Interval:       options.reconcileInterval,
+				OnPublicationRemoved: func(_ string, publication librarymanager.Publication) {
+					rebinderRef.Load().Forget(publication.TargetPath)
+				},
+			}))
+		}
+		lm, err = librarymanager.NewLibraryManager(storageBasePath, lmOpts...)
 		if err != nil {
-			return nil, err
+			// Keep serving the socket volume types, Probe reports the driver
+			// as not ready until it is restarted with a working database.
+			logger.Warn("Disabling SSI storage", "storage_base_path", requestedStorageBasePath, "error", err)
+			storageBasePath = ""
+			lmErr = err
+			lm = nil
 		}
 	}
 
+	checker := newSelfChecker(options.selfCheckInterval,
+		getSelfChecks(fs, requestedStorageBasePath, storageErr, lm, lmErr, sockets)...)
+	checker.start()
+
+	socketRebinder := newSocketRebinder(fs, mounter, logger, sockets, lm)
+	rebinderRef.Store(socketRebinder)
+
 	return &DatadogCSIDriver{
 		name:    name,
 		version: version,
 
-		publisher:      publishers.GetPublishers(fs, mounter, apmHostSocketPath, dsdHostSocketPath, storageBasePath, lm, apmEnabled, allowedRegistries),
+		publisher: publishers.GetPublishers(publishers.Config{
+			FS:                  fs,
+			Mounter:             mounter,
+			StorageBasePath:     storageBasePath,
+			Sockets:             sockets,
+			SocketRebinder:      socketRebinder,
+			SocketWait:          options.socketWait,
+			LibraryManager:      lm,
+			APMEnabled:          apmEnabled,
+			AllowedRegistries:   allowedRegistries,
+			PreloadVerification: options.preloadVerification,
+			Pods:                options.pods,
+			PodTrusted:          options.podInfoOnMount,
+			LegacySchema:        newLegacySchema(options.legacySchemaPolicy, options.podEvents, options.podInfoOnMount),
+			ScratchSize:         options.scratchSize,
+		}),
 		libraryManager: lm,
 		fs:             fs,
 		mounter:        mounter,
+		selfChecker:    checker,
+		socketRebinder: socketRebinder,
+
+		kubeletRootDir:  options.kubeletRootDir,
+		publishTimeouts: options.publishTimeouts,
+		admissionPolicy: options.admissionPolicy,
+		podInfoOnMount:  options.podInfoOnMount,
 	}, nil
 }
 
+// newLegacySchema counts the publishes using the deprecated mode/path schema,
+// and warns their pods when the policy asks for it. The pod identity is only
+// used when it is trusted, so that the author of an inline volume cannot have
+// events posted on another pod.
+func newLegacySchema(policy publishers.LegacySchemaPolicy, podEvents PodEventRecorder, podTrusted bool) publishers.LegacySchema {
+	return publishers.LegacySchema{
+		Policy: policy,
+		OnPublish: func(_ context.Context, use publishers.LegacySchemaUse) {
+			if !podTrusted {
+				use.Pod = podinfo.PodInfo{}
+			}
+			metrics.RecordLegacySchemaPublish(use.Pod.Namespace, use.Mode)
+			if use.Policy == publishers.LegacySchemaWarn && podEvents != nil && !use.Pod.IsZero() {
+				podEvents.Warn(use.Pod, legacySchemaEventReason,
+					fmt.Sprintf("Volume uses the deprecated mode/path attributes (mode %q, path %q), which will be removed: use %s instead", use.Mode, use.Path, use.Migration()))
+			}
+		},
+	}
+}
+
+// newSocketRebinder starts re-binding the socket file volumes when the agent
+// recreates its sockets. The volumes published before the driver started are
+// found in the publication records, when they are persisted.
+func newSocketRebinder(fs afero.Afero, mounter mount.Interface, logger *log.Logger, sockets publishers.SocketRegistry, lm *librarymanager.LibraryManager) *publishers.SocketRebinder {
+	rebinder := publishers.NewSocketRebinder(fs, mounter, sockets, func(volumeType publishers.VolumeType, err error) {
+		status := metrics.StatusSuccess
+		if err != nil {
+			status = metrics.StatusFailed
+		}
+		metrics.RecordSocketRebind(string(volumeType), status)
+	})
+
+	if lm != nil {
+		publications, err := lm.ListPublications()
+		if err != nil {
+			logger.Warn("Could not list the published volumes, the socket volumes published before the restart will not be re-bound", "error", err)
+		}
+		for _, publication := range publications {
+			rebinder.Track(publishers.VolumeType(publication.VolumeType), publication.TargetPath)
+		}
+	}
+
+	if err := rebinder.Start(); err != nil {
+		logger.Warn("Socket volumes will not be re-bound when the agent recreates its sockets", "error", err)
+	}
+	return rebinder
+}
+
+// getSelfChecks returns the readiness checks matching the driver configuration.
+// A startup failure of the storage or of the database is reported until the
+// driver restarts, since the SSI publishers are disabled for its whole lifetime.
+func getSelfChecks(
+	fs afero.Afero,
+	storageBasePath string,
+	storageErr error,
+	lm *librarymanager.LibraryManager,
+	lmErr error,
+	sockets publishers.SocketRegistry,
+) []selfCheck {
+	var checks []selfCheck
+
+	if storageBasePath != "" {
+		checks = append(checks,
+			selfCheck{name: "storage", check: func() error {
+				if storageErr != nil {
+					return fmt.Errorf("SSI storage was disabled at startup: %w", storageErr)
+				}
+				_, err := createStorageDir(fs, storageBasePath)
+				return err
+			}},
+			selfCheck{name: "database", check: func() error {
+				if storageErr != nil {
+					return fmt.Errorf("library database was not opened because SSI storage is disabled")
+				}
+				if lm == nil {
+					return fmt.Errorf("could not open library database: %w", lmErr)
+				}
+				return lm.CheckDatabase()
+			}},
+		)
+	}
+	if apmSocketPath := sockets.EnabledPath(publishers.APMSocket); apmSocketPath != "" {
+		checks = append(checks, selfCheck{name: "apm_socket", check: func() error {
+			return checkSocket(fs, apmSocketPath)
+		}})
+	}
+	if dsdSocketPath := sockets.EnabledPath(publishers.DSDSocket); dsdSocketPath != "" {
+		checks = append(checks, selfCheck{name: "dsd_socket", check: func() error {
+			return checkSocket(fs, dsdSocketPath)
+		}})
+	}
+
+	return checks
+}
+
 // NewDatadogCSIDriver builds and returns a new Datadog CSI driver
-func NewDatadogCSIDriver(name, apmHostSocketPath, dsdHostSocketPath, storageBasePath, version string, apmEnabled bool, allowedRegistries []string) (*DatadogCSIDriver, error) {
+func NewDatadogCSIDriver(name, apmHostSocketPath, dsdHostSocketPath, storageBasePath, version string, apmEnabled bool, allowedRegistries []string, opts ...DriverOption) (*DatadogCSIDriver, error) {
 	return newDatadogCSIDriver(
 		afero.Afero{Fs: afero.NewOsFs()},
 		mount.New(""),
@@ -134,5 +598,6 @@ func NewDatadogCSIDriver(name, apmHostSocketPath, dsdHostSocketPath, storageBase
 		version,
 		apmEnabled,
 		allowedRegistries,
+		opts...,
 	)
 }
@@ -7,9 +7,13 @@ package driver
 
 import (
 	"bytes"
+	"context"
 	"log/slog"
 	"testing"
+	"time"
 
+	"github.com/Datadog/datadog-csi-driver/pkg/driver/publishers"
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
@@ -116,7 +120,7 @@ func TestNewDatadogCSIDriver_DisablesSSIStorageWhenStoragePathIsNotWritable(t *t
 	assert.Contains(t, logs.String(), "storage_base_path=/var/datadog")
 
 	t.Run("library volume is ignored", func(t *testing.T) {
-		resp, err := driver.publisher.Publish(&csi.NodePublishVolumeRequest{
+		resp, err := driver.publisher.Publish(context.Background(), &csi.NodePublishVolumeRequest{
 			VolumeId:   "library-volume",
 			TargetPath: "/target/library",
 			Readonly:   true,
@@ -130,7 +134,7 @@ func TestNewDatadogCSIDriver_DisablesSSIStorageWhenStoragePathIsNotWritable(t *t
 	})
 
 	t.Run("injector preload volume is ignored", func(t *testing.T) {
-		resp, err := driver.publisher.Publish(&csi.NodePublishVolumeRequest{
+		resp, err := driver.publisher.Publish(context.Background(), &csi.NodePublishVolumeRequest{
 			VolumeId:   "preload-volume",
 			TargetPath: "/target/ld.so.preload",
 			Readonly:   true,
@@ -143,3 +147,91 @@ func TestNewDatadogCSIDriver_DisablesSSIStorageWhenStoragePathIsNotWritable(t *t
 		assert.Nil(t, resp)
 	})
 }
+
+func TestNewDatadogCSIDriver_RejectsInvalidSockets(t *testing.T) {
+	_, err := newDatadogCSIDriver(
+		afero.Afero{Fs: afero.NewMemMapFs()},
+		mount.NewFakeMounter(nil),
+		slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)),
+		"test-driver",
+		"/tmp/apm.sock",
+		"/tmp/dsd.sock",
+		"",
+		"test-version",
+		true,
+		nil,
+		WithSockets([]publishers.NamedSocket{{Name: "OTLPSocket", Path: "otlp.sock"}}),
+	)
+
+	assert.ErrorContains(t, err, "invalid socket registry")
+}
+
+func TestParseLibraryStoreBudget(t *testing.T) {
+	budget, err := ParseLibraryStoreBudget(" 10Gi ")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10<<30), budget)
+
+	budget, err = ParseLibraryStoreBudget("")
+	require.NoError(t, err)
+	assert.Zero(t, budget)
+
+	for _, value := range []string{"lots", "0", "-1Gi"} {
+		_, err := ParseLibraryStoreBudget(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestParseScratchSize(t *testing.T) {
+	size, err := ParseScratchSize(" 64Mi ", "1Gi")
+	require.NoError(t, err)
+	assert.Equal(t, publishers.ScratchSize{Default: 64 << 20, Max: 1 << 30}, size)
+
+	for _, values := range [][2]string{{"lots", "1Gi"}, {"64Mi", ""}, {"4096", "1Gi"}, {"64Mi", "-1Gi"}, {"2Gi", "1Gi"}} {
+		_, err := ParseScratchSize(values[0], values[1])
+		assert.Error(t, err, values)
+	}
+}
+
+func TestParsePrefetchLibraries(t *testing.T) {
+	libs, err := ParsePrefetchLibraries([]string{"gcr.io/datadoghq/apm-inject:0", " localhost:5000/dd-lib-java-init:v1 ", ""})
+	require.NoError(t, err)
+	require.Len(t, libs, 2)
+	assert.Equal(t, "gcr.io/datadoghq/apm-inject:0", libs[0].Image())
+	assert.Equal(t, "localhost:5000/dd-lib-java-init:v1", libs[1].Image())
+
+	for _, entry := range []string{"apm-inject:0", "gcr.io/datadoghq/apm-inject"} {
+		_, err := ParsePrefetchLibraries([]string{entry})
+		assert.Error(t, err, entry)
+	}
+}
+
+func TestParsePublishTimeouts(t *testing.T) {
+	timeouts, err := ParsePublishTimeouts([]string{"DatadogLibrary=2m", " DatadogInjectorPreload = 30s "})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{
+		"DatadogLibrary":         2 * time.Minute,
+		"DatadogInjectorPreload": 30 * time.Second,
+	}, timeouts)
+
+	for _, entry := range []string{"DatadogLibrary", "=2m", "DatadogLibrary=soon", "DatadogLibrary=0s"} {
+		_, err := ParsePublishTimeouts([]string{entry})
+		assert.Error(t, err, entry)
+	}
+}
+
+func TestNewLegacySchema_WarnsTrustedPodsOnly(t *testing.T) {
+	use := publishers.LegacySchemaUse{
+		Mode:   "socket",
+		Path:   "/tmp/apm.sock",
+		Pod:    podinfo.PodInfo{Name: "web-1", Namespace: "shop"},
+		Policy: publishers.LegacySchemaWarn,
+	}
+
+	podEvents := &recordingPodEvents{}
+	newLegacySchema(publishers.LegacySchemaWarn, podEvents, false).OnPublish(context.Background(), use)
+	assert.Empty(t, podEvents.warnings, "an untrusted pod identity may name any pod")
+
+	newLegacySchema(publishers.LegacySchemaWarn, podEvents, true).OnPublish(context.Background(), use)
+	require.Len(t, podEvents.warnings, 1)
+	assert.Contains(t, podEvents.warnings[0], "shop/web-1 DeprecatedVolumeSchema")
+}
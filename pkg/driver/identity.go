@@ -10,8 +10,15 @@ import (
 	log "log/slog"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
+// probeReasonHeader is the gRPC response header giving why Probe reports the
+// driver as not ready, since ProbeResponse has no field for it.
+const probeReasonHeader = "datadog-csi-not-ready-reason"
+
 func (d *DatadogCSIDriver) GetPluginInfo(_ context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
 	log.Debug("Method GetPluginInfo called", "request", req)
 
@@ -35,8 +42,16 @@ func (d *DatadogCSIDriver) GetPluginCapabilities(_ context.Context, req *csi.Get
 	}, nil
 }
 
-func (d *DatadogCSIDriver) Probe(_ context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+func (d *DatadogCSIDriver) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
 	log.Debug("Method Probe called", "request", req)
 
-	return &csi.ProbeResponse{}, nil
+	ready, reason := d.selfChecker.ready()
+	if !ready {
+		log.Info("Driver is not ready", "reason", reason)
+		if err := grpc.SetHeader(ctx, metadata.Pairs(probeReasonHeader, reason)); err != nil {
+			log.Debug("Could not send the reason of the Probe response", "error", err)
+		}
+	}
+
+	return &csi.ProbeResponse{Ready: wrapperspb.Bool(ready)}, nil
 }
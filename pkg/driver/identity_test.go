@@ -0,0 +1,165 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package driver
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/driver/publishers"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"k8s.io/utils/mount"
+)
+
+// headerRecordingStream records the response headers set by a gRPC handler.
+type headerRecordingStream struct {
+	header metadata.MD
+}
+
+func (s *headerRecordingStream) Method() string { return "/csi.v1.Identity/Probe" }
+
+func (s *headerRecordingStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *headerRecordingStream) SendHeader(md metadata.MD) error { return s.SetHeader(md) }
+
+func (s *headerRecordingStream) SetTrailer(metadata.MD) error { return nil }
+
+func TestProbe(t *testing.T) {
+	// Unix socket paths are limited to 108 characters, so t.TempDir() can be too long
+	dir, err := os.MkdirTemp("", "csi-probe-*")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	apmSocketPath := filepath.Join(dir, "apm.socket")
+	dsdSocketPath := filepath.Join(dir, "dsd.socket")
+	for _, path := range []string{apmSocketPath, dsdSocketPath} {
+		listener, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = listener.Close() })
+	}
+
+	blockedStoragePath := filepath.Join(dir, "blocked")
+	require.NoError(t, os.WriteFile(blockedStoragePath, []byte{}, 0o644))
+
+	disabled := false
+	tests := map[string]struct {
+		apmSocketPath   string
+		dsdSocketPath   string
+		sockets         []publishers.NamedSocket
+		storageBasePath string
+		expectReady     bool
+		expectReason    string
+	}{
+		"all checks pass": {
+			apmSocketPath:   apmSocketPath,
+			dsdSocketPath:   dsdSocketPath,
+			storageBasePath: filepath.Join(t.TempDir(), "storage"),
+			expectReady:     true,
+		},
+		"unconfigured sockets and storage are not checked": {
+			expectReady: true,
+		},
+		"missing socket is not ready": {
+			apmSocketPath: filepath.Join(dir, "missing.socket"),
+			dsdSocketPath: dsdSocketPath,
+			expectReady:   false,
+			expectReason:  "apm_socket: socket",
+		},
+		"disabled socket is not checked": {
+			apmSocketPath: filepath.Join(dir, "missing.socket"),
+			dsdSocketPath: dsdSocketPath,
+			sockets:       []publishers.NamedSocket{{Name: publishers.APMSocket, Enabled: &disabled}},
+			expectReady:   true,
+		},
+		"socket moved in the registry is checked at its new path": {
+			apmSocketPath: filepath.Join(dir, "missing.socket"),
+			dsdSocketPath: dsdSocketPath,
+			sockets:       []publishers.NamedSocket{{Name: publishers.APMSocket, Path: apmSocketPath}},
+			expectReady:   true,
+		},
+		"storage disabled at startup is not ready": {
+			apmSocketPath:   apmSocketPath,
+			dsdSocketPath:   dsdSocketPath,
+			storageBasePath: filepath.Join(blockedStoragePath, "storage"),
+			expectReady:     false,
+			expectReason:    "storage",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			driver, err := newDatadogCSIDriver(
+				afero.Afero{Fs: afero.NewOsFs()},
+				mount.NewFakeMounter(nil),
+				slog.New(slog.NewTextHandler(io.Discard, nil)),
+				"test-driver",
+				tc.apmSocketPath,
+				tc.dsdSocketPath,
+				tc.storageBasePath,
+				"test-version",
+				true,
+				nil,
+				WithSockets(tc.sockets),
+			)
+			require.NoError(t, err)
+			defer driver.Stop()
+
+			stream := &headerRecordingStream{}
+			resp, err := driver.Probe(grpc.NewContextWithServerTransportStream(context.Background(), stream), &csi.ProbeRequest{})
+
+			require.NoError(t, err)
+			require.NotNil(t, resp.GetReady())
+			assert.Equal(t, tc.expectReady, resp.GetReady().GetValue())
+			reason := stream.header.Get(probeReasonHeader)
+			if tc.expectReady {
+				assert.Empty(t, reason)
+			} else {
+				require.Len(t, reason, 1)
+				assert.Contains(t, reason[0], tc.expectReason)
+			}
+		})
+	}
+}
+
+func TestProbe_ReportsClosedDatabase(t *testing.T) {
+	driver, err := newDatadogCSIDriver(
+		afero.Afero{Fs: afero.NewOsFs()},
+		mount.NewFakeMounter(nil),
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		"test-driver",
+		"",
+		"",
+		t.TempDir(),
+		"test-version",
+		true,
+		nil,
+	)
+	require.NoError(t, err)
+	require.NotNil(t, driver.libraryManager)
+
+	require.NoError(t, driver.Stop())
+	driver.selfChecker.run()
+
+	resp, err := driver.Probe(context.Background(), &csi.ProbeRequest{})
+
+	require.NoError(t, err)
+	assert.False(t, resp.GetReady().GetValue())
+	_, reason := driver.selfChecker.ready()
+	assert.Contains(t, reason, "database")
+}
@@ -7,16 +7,38 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	log "log/slog"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/Datadog/datadog-csi-driver/pkg/driver/publishers"
+	"github.com/Datadog/datadog-csi-driver/pkg/librarymanager"
 	"github.com/Datadog/datadog-csi-driver/pkg/metrics"
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
+	"github.com/Datadog/datadog-csi-driver/pkg/policy"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func (d *DatadogCSIDriver) NodeGetCapabilities(context.Context, *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	return &csi.NodeGetCapabilitiesResponse{}, nil
+	return &csi.NodeGetCapabilitiesResponse{
+		Capabilities: []*csi.NodeServiceCapability{
+			nodeServiceCapability(csi.NodeServiceCapability_RPC_GET_VOLUME_STATS),
+			nodeServiceCapability(csi.NodeServiceCapability_RPC_VOLUME_CONDITION),
+		},
+	}, nil
+}
+
+func nodeServiceCapability(capability csi.NodeServiceCapability_RPC_Type) *csi.NodeServiceCapability {
+	return &csi.NodeServiceCapability{
+		Type: &csi.NodeServiceCapability_Rpc{
+			Rpc: &csi.NodeServiceCapability_RPC{Type: capability},
+		},
+	}
 }
 
 func (d *DatadogCSIDriver) NodeGetInfo(context.Context, *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
@@ -25,45 +47,226 @@ func (d *DatadogCSIDriver) NodeGetInfo(context.Context, *csi.NodeGetInfoRequest)
 	}, nil
 }
 
-func (d *DatadogCSIDriver) NodePublishVolume(_ context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
-	log.Info("Received NodePublishVolumeRequest",
+func (d *DatadogCSIDriver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	volumeCtx := req.GetVolumeContext()
+	// Without podInfoOnMount, the pod attributes of the volume context may have
+	// been set by the author of an inline volume: the pod is left unknown.
+	var pod podinfo.PodInfo
+	if d.podInfoOnMount {
+		pod = podinfo.FromVolumeContext(volumeCtx)
+	}
+	ctx = podinfo.NewContext(ctx, pod)
+
+	log.InfoContext(ctx, "Received NodePublishVolumeRequest",
 		"target_path", req.GetTargetPath(),
 		"volume_id", req.GetVolumeId(),
-		"volume_context", req.GetVolumeContext())
+		"volume_context", volumeCtx)
+
+	ctx, cancel := d.publishContext(ctx, volumeCtx["type"])
+	defer cancel()
 
-	resp, err := d.publisher.Publish(req)
+	if err := d.validateTargetPath(req.GetTargetPath()); err != nil {
+		metrics.RecordVolumeMountAttempt(volumeCtx["type"], pod.Namespace, metrics.StatusFailed)
+		return nil, err
+	}
+
+	if err := d.admit(ctx, req, pod); err != nil {
+		return nil, err
+	}
+
+	resp, err := d.publisher.Publish(ctx, req)
+	var inProgress *librarymanager.DownloadInProgressError
+	if errors.As(err, &inProgress) {
+		metrics.RecordVolumeMountAttempt(volumeCtx["type"], pod.Namespace, metrics.StatusInProgress)
+		log.InfoContext(ctx, "Library is still downloading, publish will be retried",
+			"volume_id", req.GetVolumeId(),
+			"image", inProgress.Image,
+			"elapsed", inProgress.Elapsed)
+		return nil, status.Error(codes.Unavailable, inProgress.Error())
+	}
+	var injectorPending *publishers.InjectorLibraryPendingError
+	if errors.As(err, &injectorPending) {
+		metrics.RecordVolumeMountAttempt(volumeCtx["type"], pod.Namespace, metrics.StatusInProgress)
+		log.InfoContext(ctx, "Injector library volume is not published yet, publish will be retried",
+			"volume_id", req.GetVolumeId(),
+			"pod", injectorPending.Pod)
+		return nil, status.Error(codes.Unavailable, injectorPending.Error())
+	}
+	var legacySchema *publishers.LegacySchemaError
+	if errors.As(err, &legacySchema) {
+		metrics.RecordVolumeMountAttempt(volumeCtx["type"], pod.Namespace, metrics.StatusFailed)
+		return nil, status.Error(codes.InvalidArgument, legacySchema.Error())
+	}
+	var socketUnavailable *publishers.SocketUnavailableError
+	if errors.As(err, &socketUnavailable) {
+		metrics.RecordVolumeMountAttempt(volumeCtx["type"], pod.Namespace, metrics.StatusFailed)
+		log.WarnContext(ctx, "Agent socket is not ready, publish will be retried",
+			"volume_id", req.GetVolumeId(),
+			"host_path", socketUnavailable.Path,
+			"waited", socketUnavailable.Waited)
+		return nil, status.Error(codes.Unavailable, socketUnavailable.Error())
+	}
 	if err != nil {
-		volumeCtx := req.GetVolumeContext()
-		metrics.RecordVolumeMountAttempt(volumeCtx["type"], req.GetTargetPath(), metrics.StatusFailed)
+		metrics.RecordVolumeMountAttempt(volumeCtx["type"], pod.Namespace, metrics.StatusFailed)
+		if ctx.Err() != nil {
+			// Surface the deadline or the cancellation so that kubelet retries the publish
+			return nil, status.Errorf(status.FromContextError(ctx.Err()).Code(), "failed to publish volume: %v", err)
+		}
 		return nil, fmt.Errorf("failed to publish volume: %v", err)
 	}
 
 	if resp == nil {
-		volumeCtx := req.GetVolumeContext()
-		metrics.RecordVolumeMountAttempt(volumeCtx["type"], req.GetTargetPath(), metrics.StatusUnsupported)
+		metrics.RecordVolumeMountAttempt(volumeCtx["type"], pod.Namespace, metrics.StatusUnsupported)
 		return nil, fmt.Errorf("unsupported volume type: %q", volumeCtx["type"])
 	}
 
-	metrics.RecordVolumeMountAttempt(string(resp.VolumeType), resp.VolumePath, metrics.StatusSuccess)
+	// The volume path, e.g. the library image, used to be a metric label: it is
+	// logged instead to keep the metric cardinality bounded.
+	log.InfoContext(ctx, "Volume published",
+		"volume_id", req.GetVolumeId(),
+		"volume_type", resp.VolumeType,
+		"volume_path", resp.VolumePath)
+	metrics.RecordVolumeMountAttempt(string(resp.VolumeType), resp.Pod.Namespace, metrics.StatusSuccess)
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
-func (d *DatadogCSIDriver) NodeUnpublishVolume(_ context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+// validateTargetPath rejects target paths outside of the kubelet root
+// directory, so that a publish request cannot bind host files anywhere else on
+// the node.
+func (d *DatadogCSIDriver) validateTargetPath(targetPath string) error {
+	if d.kubeletRootDir == "" {
+		return nil
+	}
+	if !filepath.IsAbs(targetPath) {
+		return status.Errorf(codes.InvalidArgument, "target path %q must be absolute", targetPath)
+	}
+	rel, err := filepath.Rel(filepath.Clean(d.kubeletRootDir), filepath.Clean(targetPath))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return status.Errorf(codes.InvalidArgument, "target path %q is not under the kubelet root directory %q", targetPath, d.kubeletRootDir)
+	}
+	return nil
+}
+
+// admit evaluates the admission policy for a publish request. It returns a
+// PermissionDenied status naming the denying rule, or an Unavailable status when
+// the policy could not be evaluated so that kubelet retries the publish.
+func (d *DatadogCSIDriver) admit(ctx context.Context, req *csi.NodePublishVolumeRequest, pod podinfo.PodInfo) error {
+	if d.admissionPolicy == nil {
+		return nil
+	}
+
+	volumeCtx := req.GetVolumeContext()
+	registry, pkg, version := publishers.LibraryFromVolumeContext(volumeCtx)
+	decision, err := d.admissionPolicy.Evaluate(ctx, policy.Input{
+		VolumeType:    volumeCtx["type"],
+		VolumeContext: volumeCtx,
+		ReadOnly:      req.GetReadonly(),
+		Pod:           pod,
+		PodTrusted:    d.podInfoOnMount,
+		Library:       policy.Library{Registry: registry, Package: pkg, Version: version},
+	})
+	if err != nil {
+		metrics.RecordAdmissionDecision(volumeCtx["type"], pod.Namespace, metrics.AdmissionError, "")
+		metrics.RecordVolumeMountAttempt(volumeCtx["type"], pod.Namespace, metrics.StatusFailed)
+		log.ErrorContext(ctx, "Failed to evaluate the admission policy",
+			"volume_id", req.GetVolumeId(),
+			"error", err)
+		return status.Errorf(codes.Unavailable, "failed to evaluate the admission policy: %v", err)
+	}
+
+	if !decision.Allowed {
+		metrics.RecordAdmissionDecision(volumeCtx["type"], pod.Namespace, metrics.AdmissionDenied, decision.Rule)
+		metrics.RecordVolumeMountAttempt(volumeCtx["type"], pod.Namespace, metrics.StatusDenied)
+		log.WarnContext(ctx, "Volume denied by the admission policy",
+			"volume_id", req.GetVolumeId(),
+			"volume_type", volumeCtx["type"],
+			"rule", decision.Rule,
+			"reason", decision.Reason)
+		return status.Errorf(codes.PermissionDenied, "volume denied by admission policy rule %q: %s", decision.Rule, decision.Reason)
+	}
+
+	metrics.RecordAdmissionDecision(volumeCtx["type"], pod.Namespace, metrics.AdmissionAllowed, "")
+	return nil
+}
+
+// publishContext returns the context of a publish request, bounded by the
+// timeout configured for its volume type if any. The request context itself
+// carries the deadline set by kubelet.
+func (d *DatadogCSIDriver) publishContext(ctx context.Context, volumeType string) (context.Context, context.CancelFunc) {
+	if timeout, ok := d.publishTimeouts[volumeType]; ok {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (d *DatadogCSIDriver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	log.Info("Received NodeUnpublishVolumeRequest",
 		"target_path", req.GetTargetPath(),
 		"volume_id", req.GetVolumeId())
 
-	resp, err := d.publisher.Unpublish(req)
+	resp, err := d.publisher.Unpublish(ctx, req)
 	if err != nil {
-		metrics.RecordVolumeUnMountAttempt(metrics.StatusFailed)
+		var volumeType string
+		if resp != nil {
+			volumeType = string(resp.VolumeType)
+		}
+		metrics.RecordVolumeUnMountAttempt(volumeType, metrics.StatusFailed)
 		return nil, fmt.Errorf("failed to unpublish volume: %v", err)
 	}
 
 	if resp == nil {
-		metrics.RecordVolumeUnMountAttempt(metrics.StatusUnsupported)
+		metrics.RecordVolumeUnMountAttempt("", metrics.StatusUnsupported)
 		return nil, fmt.Errorf("unpublish volume request not supported by any publisher")
 	}
 
-	metrics.RecordVolumeUnMountAttempt(metrics.StatusSuccess)
+	metrics.RecordVolumeUnMountAttempt(string(resp.VolumeType), metrics.StatusSuccess)
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
+
+func (d *DatadogCSIDriver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	log.Debug("Received NodeGetVolumeStatsRequest",
+		"volume_path", req.GetVolumePath(),
+		"volume_id", req.GetVolumeId())
+
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
+	}
+	if req.GetVolumePath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume path is required")
+	}
+
+	exists, err := d.fs.Exists(req.GetVolumePath())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to check volume path: %v", err)
+	}
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "volume path %q does not exist", req.GetVolumePath())
+	}
+
+	stats, err := d.publisher.Stats(ctx, req)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get volume stats: %v", err)
+	}
+	if stats == nil {
+		return nil, status.Errorf(codes.NotFound, "volume %q is not published", req.GetVolumeId())
+	}
+
+	if stats.Abnormal {
+		log.Warn("Volume is abnormal",
+			"volume_path", req.GetVolumePath(),
+			"volume_id", req.GetVolumeId(),
+			"volume_type", stats.VolumeType,
+			"message", stats.Message)
+	}
+
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{
+			{Unit: csi.VolumeUsage_BYTES, Used: stats.UsedBytes},
+			{Unit: csi.VolumeUsage_INODES, Used: stats.UsedInodes},
+		},
+		VolumeCondition: &csi.VolumeCondition{
+			Abnormal: stats.Abnormal,
+			Message:  stats.Message,
+		},
+	}, nil
+}
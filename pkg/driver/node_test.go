@@ -0,0 +1,384 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/driver/publishers"
+	"github.com/Datadog/datadog-csi-driver/pkg/librarymanager"
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
+	"github.com/Datadog/datadog-csi-driver/pkg/policy"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/mount"
+)
+
+func newTestDriver(t *testing.T, fs afero.Afero) *DatadogCSIDriver {
+	t.Helper()
+	driver, err := newDatadogCSIDriver(
+		fs,
+		mount.NewFakeMounter(nil),
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		"test-driver",
+		"/tmp/apm.sock",
+		"/tmp/dsd.sock",
+		"",
+		"test-version",
+		true,
+		nil,
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = driver.Stop() })
+	return driver
+}
+
+func TestNodeGetCapabilities_AdvertisesVolumeStatsAndCondition(t *testing.T) {
+	driver := newTestDriver(t, afero.Afero{Fs: afero.NewMemMapFs()})
+
+	resp, err := driver.NodeGetCapabilities(context.Background(), &csi.NodeGetCapabilitiesRequest{})
+
+	require.NoError(t, err)
+	var types []csi.NodeServiceCapability_RPC_Type
+	for _, capability := range resp.GetCapabilities() {
+		types = append(types, capability.GetRpc().GetType())
+	}
+	assert.Contains(t, types, csi.NodeServiceCapability_RPC_GET_VOLUME_STATS)
+	assert.Contains(t, types, csi.NodeServiceCapability_RPC_VOLUME_CONDITION)
+}
+
+func TestNodeGetVolumeStats(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	require.NoError(t, fs.WriteFile("/target/dsd/dsd.socket", []byte{}, 0644))
+	require.NoError(t, fs.WriteFile("/target/dsd/other", []byte("abcd"), 0644))
+	driver := newTestDriver(t, fs)
+
+	t.Run("missing volume ID is rejected", func(t *testing.T) {
+		_, err := driver.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumePath: "/target/dsd"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("missing volume path is rejected", func(t *testing.T) {
+		_, err := driver.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "volume"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("unknown volume path is not found", func(t *testing.T) {
+		_, err := driver.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+			VolumeId:   "volume",
+			VolumePath: "/target/unknown",
+		})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("bind-mount target usage is reported", func(t *testing.T) {
+		resp, err := driver.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+			VolumeId:   "volume",
+			VolumePath: "/target/dsd",
+		})
+
+		require.NoError(t, err)
+		require.Len(t, resp.GetUsage(), 2)
+		assert.Equal(t, csi.VolumeUsage_BYTES, resp.GetUsage()[0].GetUnit())
+		assert.Equal(t, int64(4), resp.GetUsage()[0].GetUsed())
+		assert.Equal(t, csi.VolumeUsage_INODES, resp.GetUsage()[1].GetUnit())
+		assert.Equal(t, int64(3), resp.GetUsage()[1].GetUsed())
+		assert.False(t, resp.GetVolumeCondition().GetAbnormal())
+	})
+}
+
+// blockingPublisher is a publisher whose publishes only end with their context.
+type blockingPublisher struct {
+	publishers.Publisher
+}
+
+func (blockingPublisher) Publish(ctx context.Context, _ *csi.NodePublishVolumeRequest) (*publishers.PublisherResponse, error) {
+	<-ctx.Done()
+	return &publishers.PublisherResponse{VolumeType: publishers.DatadogLibrary}, ctx.Err()
+}
+
+func TestNodePublishVolume_Deadlines(t *testing.T) {
+	driver := newTestDriver(t, afero.Afero{Fs: afero.NewMemMapFs()})
+	driver.publisher = blockingPublisher{}
+	driver.publishTimeouts = map[string]time.Duration{string(publishers.DatadogLibrary): time.Millisecond}
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:      "volume",
+		TargetPath:    "/target",
+		VolumeContext: map[string]string{"type": string(publishers.DatadogLibrary)},
+	}
+
+	t.Run("volume type timeout", func(t *testing.T) {
+		_, err := driver.NodePublishVolume(context.Background(), req)
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	})
+
+	t.Run("cancelled request", func(t *testing.T) {
+		driver.publishTimeouts = nil
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := driver.NodePublishVolume(ctx, req)
+		assert.Equal(t, codes.Canceled, status.Code(err))
+	})
+}
+
+// downloadingPublisher is a publisher whose library is always still downloading.
+type downloadingPublisher struct {
+	publishers.Publisher
+}
+
+func (downloadingPublisher) Publish(context.Context, *csi.NodePublishVolumeRequest) (*publishers.PublisherResponse, error) {
+	err := &librarymanager.DownloadInProgressError{Image: "registry/dd-lib-java-init:v1", Elapsed: time.Minute}
+	return &publishers.PublisherResponse{VolumeType: publishers.DatadogLibrary}, fmt.Errorf("failed to get library for volume: %w", err)
+}
+
+func TestNodePublishVolume_DownloadInProgressIsUnavailable(t *testing.T) {
+	driver := newTestDriver(t, afero.Afero{Fs: afero.NewMemMapFs()})
+	driver.publisher = downloadingPublisher{}
+
+	_, err := driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:      "volume",
+		TargetPath:    "/target",
+		VolumeContext: map[string]string{"type": string(publishers.DatadogLibrary)},
+	})
+
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "still downloading")
+}
+
+// publishedPublisher is a publisher whose publishes always succeed.
+type publishedPublisher struct {
+	publishers.Publisher
+}
+
+func (publishedPublisher) Publish(context.Context, *csi.NodePublishVolumeRequest) (*publishers.PublisherResponse, error) {
+	return &publishers.PublisherResponse{VolumeType: publishers.DSDSocketDirectory}, nil
+}
+
+func TestNodePublishVolume_AdmissionPolicy(t *testing.T) {
+	admissionPolicy, err := policy.New([]policy.Rule{{
+		Name:       "no-kube-system",
+		Expression: `pod.namespace != "kube-system"`,
+	}})
+	require.NoError(t, err)
+	driver := newTestDriver(t, afero.Afero{Fs: afero.NewMemMapFs()})
+	driver.publisher = publishedPublisher{}
+	driver.admissionPolicy = admissionPolicy
+
+	publish := func(namespace string) error {
+		_, err := driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:   "volume",
+			TargetPath: "/target",
+			VolumeContext: map[string]string{
+				"type":                  string(publishers.DSDSocketDirectory),
+				podinfo.KeyPodNamespace: namespace,
+			},
+		})
+		return err
+	}
+
+	// Without podInfoOnMount, the namespace may have been set by the volume author.
+	err = publish("shop")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "podInfoOnMount")
+
+	driver.podInfoOnMount = true
+	assert.NoError(t, publish("shop"))
+
+	err = publish("kube-system")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), `"no-kube-system"`)
+}
+
+// podRecordingPublisher records the pod identity carried by the context of publishes.
+type podRecordingPublisher struct {
+	publishers.Publisher
+	pods []podinfo.PodInfo
+}
+
+func (p *podRecordingPublisher) Publish(ctx context.Context, _ *csi.NodePublishVolumeRequest) (*publishers.PublisherResponse, error) {
+	pod, _ := podinfo.FromContext(ctx)
+	p.pods = append(p.pods, pod)
+	return &publishers.PublisherResponse{VolumeType: publishers.DSDSocketDirectory, Pod: pod}, nil
+}
+
+func TestNodePublishVolume_PodIdentityRequiresPodInfoOnMount(t *testing.T) {
+	driver := newTestDriver(t, afero.Afero{Fs: afero.NewMemMapFs()})
+	publisher := &podRecordingPublisher{}
+	driver.publisher = publisher
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:   "volume",
+		TargetPath: "/target",
+		VolumeContext: map[string]string{
+			"type":                  string(publishers.DSDSocketDirectory),
+			podinfo.KeyPodName:      "web-0",
+			podinfo.KeyPodNamespace: "shop",
+			podinfo.KeyPodUID:       "uid-1",
+		},
+	}
+
+	// Without podInfoOnMount, the pod attributes may have been set by the volume author.
+	_, err := driver.NodePublishVolume(context.Background(), req)
+	require.NoError(t, err)
+
+	driver.podInfoOnMount = true
+	_, err = driver.NodePublishVolume(context.Background(), req)
+	require.NoError(t, err)
+
+	assert.Equal(t, []podinfo.PodInfo{{}, {Name: "web-0", Namespace: "shop", UID: "uid-1"}}, publisher.pods)
+}
+
+func TestNodePublishVolume_TargetPathUnderKubeletRoot(t *testing.T) {
+	driver := newTestDriver(t, afero.Afero{Fs: afero.NewMemMapFs()})
+	driver.publisher = publishedPublisher{}
+	driver.kubeletRootDir = "/var/lib/kubelet"
+
+	tests := map[string]struct {
+		targetPath string
+		expectErr  bool
+	}{
+		"pod volume":            {targetPath: "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/dsd/mount"},
+		"kubelet root itself":   {targetPath: "/var/lib/kubelet", expectErr: true},
+		"outside the root":      {targetPath: "/etc/cron.d", expectErr: true},
+		"sibling with a prefix": {targetPath: "/var/lib/kubelet-other/mount", expectErr: true},
+		"escaping the root":     {targetPath: "/var/lib/kubelet/pods/../../../../etc", expectErr: true},
+		"relative path":         {targetPath: "var/lib/kubelet/pods/uid/mount", expectErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:      "volume",
+				TargetPath:    tc.targetPath,
+				VolumeContext: map[string]string{"type": string(publishers.DSDSocketDirectory)},
+			})
+			if tc.expectErr {
+				assert.Equal(t, codes.InvalidArgument, status.Code(err))
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// socketlessPublisher is a publisher whose agent socket is never ready.
+type socketlessPublisher struct {
+	publishers.Publisher
+}
+
+func (socketlessPublisher) Publish(context.Context, *csi.NodePublishVolumeRequest) (*publishers.PublisherResponse, error) {
+	err := &publishers.SocketUnavailableError{Path: "/var/run/datadog/apm.socket", Waited: time.Minute, Reason: "socket not found"}
+	return &publishers.PublisherResponse{VolumeType: publishers.APMSocket}, err
+}
+
+func TestNodePublishVolume_SocketUnavailable(t *testing.T) {
+	driver := newTestDriver(t, afero.Afero{Fs: afero.NewMemMapFs()})
+	driver.publisher = socketlessPublisher{}
+
+	_, err := driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:      "volume",
+		TargetPath:    "/target",
+		VolumeContext: map[string]string{"type": string(publishers.APMSocket)},
+	})
+
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "/var/run/datadog/apm.socket is not ready")
+}
+
+// injectorPendingPublisher is a publisher whose apm-inject library volume is never published.
+type injectorPendingPublisher struct {
+	publishers.Publisher
+}
+
+func (injectorPendingPublisher) Publish(context.Context, *csi.NodePublishVolumeRequest) (*publishers.PublisherResponse, error) {
+	return &publishers.PublisherResponse{VolumeType: publishers.DatadogInjectorPreload}, &publishers.InjectorLibraryPendingError{Pod: "shop/web"}
+}
+
+func TestNodePublishVolume_InjectorLibraryPendingIsUnavailable(t *testing.T) {
+	driver := newTestDriver(t, afero.Afero{Fs: afero.NewMemMapFs()})
+	driver.publisher = injectorPendingPublisher{}
+
+	_, err := driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:      "volume",
+		TargetPath:    "/target",
+		VolumeContext: map[string]string{"type": string(publishers.DatadogInjectorPreload)},
+	})
+
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "pod shop/web is not published yet")
+}
+
+// recordingPodEvents records the warnings sent to the pods.
+type recordingPodEvents struct {
+	warnings []string
+}
+
+func (r *recordingPodEvents) Warn(pod podinfo.PodInfo, reason, message string) {
+	r.warnings = append(r.warnings, fmt.Sprintf("%s/%s %s: %s", pod.Namespace, pod.Name, reason, message))
+}
+
+func TestNodePublishVolume_LegacySchema(t *testing.T) {
+	volumeCtx := map[string]string{
+		"mode":                  "socket",
+		"path":                  "/tmp/apm.sock",
+		podinfo.KeyPodName:      "web-1",
+		podinfo.KeyPodNamespace: "shop",
+	}
+
+	t.Run("warn sends an event and publishes", func(t *testing.T) {
+		podEvents := &recordingPodEvents{}
+		driver, err := newDatadogCSIDriver(afero.Afero{Fs: afero.NewMemMapFs()}, mount.NewFakeMounter(nil), slog.New(slog.NewTextHandler(io.Discard, nil)),
+			"test-driver", "/tmp/apm.sock", "/tmp/dsd.sock", "", "test-version", true, nil,
+			WithLegacySchemaPolicy(publishers.LegacySchemaWarn), WithPodEventRecorder(podEvents), WithPodInfoOnMount(true))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = driver.Stop() })
+
+		_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{VolumeId: "volume", TargetPath: "/target", VolumeContext: volumeCtx})
+		// The socket does not exist
+		assert.Error(t, err)
+		assert.NotEqual(t, codes.InvalidArgument, status.Code(err))
+		require.Len(t, podEvents.warnings, 1)
+		assert.Contains(t, podEvents.warnings[0], "shop/web-1 DeprecatedVolumeSchema")
+		assert.Contains(t, podEvents.warnings[0], "use 'type: APMSocket' instead")
+	})
+
+	t.Run("warn sends no event for an untrusted pod", func(t *testing.T) {
+		podEvents := &recordingPodEvents{}
+		driver, err := newDatadogCSIDriver(afero.Afero{Fs: afero.NewMemMapFs()}, mount.NewFakeMounter(nil), slog.New(slog.NewTextHandler(io.Discard, nil)),
+			"test-driver", "/tmp/apm.sock", "/tmp/dsd.sock", "", "test-version", true, nil,
+			WithLegacySchemaPolicy(publishers.LegacySchemaWarn), WithPodEventRecorder(podEvents))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = driver.Stop() })
+
+		// Without podInfoOnMount, the pod attributes may have been set by the volume author.
+		_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{VolumeId: "volume", TargetPath: "/target", VolumeContext: volumeCtx})
+		assert.Error(t, err)
+		assert.NotEqual(t, codes.InvalidArgument, status.Code(err))
+		assert.Empty(t, podEvents.warnings)
+	})
+
+	t.Run("reject fails with the type to migrate to", func(t *testing.T) {
+		podEvents := &recordingPodEvents{}
+		driver, err := newDatadogCSIDriver(afero.Afero{Fs: afero.NewMemMapFs()}, mount.NewFakeMounter(nil), slog.New(slog.NewTextHandler(io.Discard, nil)),
+			"test-driver", "/tmp/apm.sock", "/tmp/dsd.sock", "", "test-version", true, nil,
+			WithLegacySchemaPolicy(publishers.LegacySchemaReject), WithPodEventRecorder(podEvents))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = driver.Stop() })
+
+		_, err = driver.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{VolumeId: "volume", TargetPath: "/target", VolumeContext: volumeCtx})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), "use 'type: APMSocket' instead")
+		assert.Empty(t, podEvents.warnings, "rejected publishes are surfaced by kubelet")
+	})
+}
@@ -0,0 +1,213 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/spf13/afero"
+	"k8s.io/utils/mount"
+)
+
+const (
+	// VolumeContext keys for DatadogAgentEnv volumes
+	keyAgentEnvAPMSocketPath = "dd.csi.datadog.com/agent-env.apm-socket-path"
+	keyAgentEnvAPMSocketDir  = "dd.csi.datadog.com/agent-env.apm-socket-dir"
+	keyAgentEnvDSDSocketPath = "dd.csi.datadog.com/agent-env.dsd-socket-path"
+	keyAgentEnvDSDSocketDir  = "dd.csi.datadog.com/agent-env.dsd-socket-dir"
+	keyAgentEnvLayout        = "dd.csi.datadog.com/agent-env.layout"
+
+	// agentEnvLayoutFile mounts the environment file at the target path
+	agentEnvLayoutFile = "file"
+	// agentEnvLayoutDirectory mounts a directory holding the environment file
+	// and one file per variable at the target path
+	agentEnvLayoutDirectory = "directory"
+
+	// agentEnvDir is the directory of the rendered files, under the storage base path
+	agentEnvDir = "agent-env"
+	// agentEnvFileName is the name of the environment file
+	agentEnvFileName = "agent.env"
+
+	envTraceAgentURL = "DD_TRACE_AGENT_URL"
+	envDogStatsDURL  = "DD_DOGSTATSD_URL"
+)
+
+// agentEnvPathPattern only allows plain absolute paths, so that socket paths
+// cannot inject lines or shell syntax in the sourced environment file.
+var agentEnvPathPattern = regexp.MustCompile(`^/[A-Za-z0-9._/-]+$`)
+
+// envVariable is a variable of the rendered agent environment.
+type envVariable struct {
+	name  string
+	value string
+}
+
+// agentEnvPublisher handles DatadogAgentEnv volumes. It renders the agent
+// connection settings of the volume in a read-only file, so that tracers can
+// source it instead of hardcoding where the sockets are mounted.
+type agentEnvPublisher struct {
+	fs      afero.Afero
+	mounter mount.Interface
+	// sockets resolves the APMSocket and DSDSocket types to their host paths
+	sockets SocketRegistry
+	// basePath is the directory holding one subdirectory of rendered files per volume
+	basePath string
+}
+
+// Publish renders the agent connection settings of the volume and bind-mounts them read-only to the target path.
+func (s agentEnvPublisher) Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
+	volumeCtx := req.GetVolumeContext()
+	if VolumeType(volumeCtx["type"]) != DatadogAgentEnv {
+		return nil, nil // Not our volume
+	}
+	resp := &PublisherResponse{VolumeType: DatadogAgentEnv}
+
+	dir, err := volumeDir(s.basePath, req.GetVolumeId())
+	if err != nil {
+		return resp, err
+	}
+
+	layout := volumeCtx[keyAgentEnvLayout]
+	if layout == "" {
+		layout = agentEnvLayoutFile
+	}
+	if layout != agentEnvLayoutFile && layout != agentEnvLayoutDirectory {
+		return resp, fmt.Errorf("invalid %s %q, expected %q or %q", keyAgentEnvLayout, layout, agentEnvLayoutFile, agentEnvLayoutDirectory)
+	}
+
+	env, err := s.agentEnv(volumeCtx)
+	if err != nil {
+		return resp, err
+	}
+	if err := s.render(dir, env, layout == agentEnvLayoutDirectory); err != nil {
+		return resp, fmt.Errorf("failed to render agent environment: %w", err)
+	}
+
+	hostPath := dir
+	if layout == agentEnvLayoutFile {
+		hostPath = filepath.Join(dir, agentEnvFileName)
+	}
+	resp.VolumePath = hostPath
+	resp.HostPath = hostPath
+
+	return resp, bindMount(ctx, s.fs, s.mounter, bindMountArgs{
+		hostPath:   hostPath,
+		targetPath: req.GetTargetPath(),
+		isFile:     layout == agentEnvLayoutFile,
+		readOnly:   true,
+		options:    mountOptions(DatadogAgentEnv),
+	})
+}
+
+// Unpublish unmounts the volume and removes its rendered files.
+func (s agentEnvPublisher) Unpublish(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+	// We don't have VolumeContext in Unpublish, so we check if the volume has rendered files
+	dir, err := volumeDir(s.basePath, req.GetVolumeId())
+	if err != nil {
+		return nil, nil // Not our volume
+	}
+	exists, err := s.fs.DirExists(dir)
+	if err != nil || !exists {
+		return nil, nil // Not our volume
+	}
+
+	resp := &PublisherResponse{VolumeType: DatadogAgentEnv}
+	if err := bindUnmount(ctx, s.fs, s.mounter, req.GetTargetPath()); err != nil {
+		return resp, fmt.Errorf("failed to unmount agent environment: %w", err)
+	}
+	if err := s.fs.RemoveAll(dir); err != nil {
+		return resp, fmt.Errorf("failed to remove agent environment: %w", err)
+	}
+	return resp, nil
+}
+
+// Stats reports the usage of the bind-mount target of an agent environment volume.
+func (s agentEnvPublisher) Stats(_ context.Context, req *csi.NodeGetVolumeStatsRequest) (*VolumeStats, error) {
+	dir, err := volumeDir(s.basePath, req.GetVolumeId())
+	if err != nil {
+		return nil, nil // Not our volume
+	}
+	exists, err := s.fs.DirExists(dir)
+	if err != nil || !exists {
+		return nil, nil // Not our volume
+	}
+
+	usedBytes, usedInodes, err := pathUsage(s.fs, req.GetVolumePath())
+	if err != nil {
+		return &VolumeStats{VolumeType: DatadogAgentEnv}, err
+	}
+	return &VolumeStats{VolumeType: DatadogAgentEnv, UsedBytes: usedBytes, UsedInodes: usedInodes}, nil
+}
+
+// agentEnv returns the agent connection settings of the sockets enabled in
+// the socket registry. A socket is reachable in the container at the path given by the volume context, or in
+// the directory given by the volume context, or at its host path, which is
+// where the Datadog admission controller mounts it by default.
+func (s agentEnvPublisher) agentEnv(volumeCtx map[string]string) ([]envVariable, error) {
+	var env []envVariable
+	sockets := []struct {
+		name     string
+		hostPath string
+		pathKey  string
+		dirKey   string
+	}{
+		{name: envTraceAgentURL, hostPath: s.sockets.EnabledPath(APMSocket), pathKey: keyAgentEnvAPMSocketPath, dirKey: keyAgentEnvAPMSocketDir},
+		{name: envDogStatsDURL, hostPath: s.sockets.EnabledPath(DSDSocket), pathKey: keyAgentEnvDSDSocketPath, dirKey: keyAgentEnvDSDSocketDir},
+	}
+	for _, socket := range sockets {
+		if socket.hostPath == "" {
+			continue
+		}
+		path, dir := volumeCtx[socket.pathKey], volumeCtx[socket.dirKey]
+		switch {
+		case path != "" && dir != "":
+			return nil, fmt.Errorf("%s and %s are mutually exclusive", socket.pathKey, socket.dirKey)
+		case dir != "":
+			path = filepath.Join(dir, filepath.Base(socket.hostPath))
+		case path == "":
+			path = socket.hostPath
+		}
+		if !agentEnvPathPattern.MatchString(path) {
+			return nil, fmt.Errorf("invalid socket path %q of %s, expected an absolute path made of letters, digits, '.', '_', '-' and '/'", path, socket.name)
+		}
+		env = append(env, envVariable{name: socket.name, value: "unix://" + filepath.Clean(path)})
+	}
+	return env, nil
+}
+
+// render writes the environment file of a volume, with single-quoted values,
+// and one file per variable when individualFiles is set. Files are rewritten on every publish so that
+// they reflect the current driver configuration.
+func (s agentEnvPublisher) render(volumeDir string, env []envVariable, individualFiles bool) error {
+	if err := s.fs.MkdirAll(volumeDir, 0o755); err != nil {
+		return err
+	}
+
+	var content strings.Builder
+	for _, variable := range env {
+		fmt.Fprintf(&content, "%s='%s'\n", variable.name, variable.value)
+		if individualFiles {
+			if err := s.fs.WriteFile(filepath.Join(volumeDir, variable.name), []byte(variable.value), 0o444); err != nil {
+				return err
+			}
+		}
+	}
+	return s.fs.WriteFile(filepath.Join(volumeDir, agentEnvFileName), []byte(content.String()), 0o444)
+}
+
+func newAgentEnvPublisher(fs afero.Afero, mounter mount.Interface, sockets SocketRegistry, storageBasePath string) Publisher {
+	return agentEnvPublisher{
+		fs:       fs,
+		mounter:  mounter,
+		sockets:  sockets,
+		basePath: filepath.Join(storageBasePath, agentEnvDir),
+	}
+}
@@ -0,0 +1,205 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/mount"
+)
+
+func TestAgentEnvPublisher_Publish_TypeSelection(t *testing.T) {
+	publisher := agentEnvPublisher{}
+
+	resp, err := publisher.Publish(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:      "test-volume",
+		TargetPath:    "/target/path",
+		VolumeContext: map[string]string{"type": "APMSocket"},
+	})
+
+	assert.Nil(t, resp)
+	assert.NoError(t, err)
+}
+
+func TestAgentEnvPublisher_Publish(t *testing.T) {
+	tests := map[string]struct {
+		volumeContext   map[string]string
+		expectedHost    string
+		expectedContent string
+		expectedFiles   map[string]string
+		expectErr       bool
+	}{
+		"sockets at their host path by default": {
+			volumeContext:   map[string]string{},
+			expectedHost:    "/storage/agent-env/vol-1/agent.env",
+			expectedContent: "DD_TRACE_AGENT_URL='unix:///var/run/datadog/apm.socket'\nDD_DOGSTATSD_URL='unix:///var/run/datadog/dsd.socket'\n",
+		},
+		"socket paths and directories from the volume context": {
+			volumeContext: map[string]string{
+				keyAgentEnvAPMSocketPath: "/var/sockets/apm/apm.socket",
+				keyAgentEnvDSDSocketDir:  "/var/sockets/dsd",
+			},
+			expectedHost:    "/storage/agent-env/vol-1/agent.env",
+			expectedContent: "DD_TRACE_AGENT_URL='unix:///var/sockets/apm/apm.socket'\nDD_DOGSTATSD_URL='unix:///var/sockets/dsd/dsd.socket'\n",
+		},
+		"directory layout": {
+			volumeContext:   map[string]string{keyAgentEnvLayout: agentEnvLayoutDirectory},
+			expectedHost:    "/storage/agent-env/vol-1",
+			expectedContent: "DD_TRACE_AGENT_URL='unix:///var/run/datadog/apm.socket'\nDD_DOGSTATSD_URL='unix:///var/run/datadog/dsd.socket'\n",
+			expectedFiles: map[string]string{
+				"DD_TRACE_AGENT_URL": "unix:///var/run/datadog/apm.socket",
+				"DD_DOGSTATSD_URL":   "unix:///var/run/datadog/dsd.socket",
+			},
+		},
+		"unknown layout": {
+			volumeContext: map[string]string{keyAgentEnvLayout: "yaml"},
+			expectErr:     true,
+		},
+		"socket path and directory are exclusive": {
+			volumeContext: map[string]string{
+				keyAgentEnvAPMSocketPath: "/var/sockets/apm/apm.socket",
+				keyAgentEnvAPMSocketDir:  "/var/sockets/apm",
+			},
+			expectErr: true,
+		},
+		"relative socket path": {
+			volumeContext: map[string]string{keyAgentEnvDSDSocketPath: "dsd.socket"},
+			expectErr:     true,
+		},
+		"socket path injecting a variable": {
+			volumeContext: map[string]string{keyAgentEnvAPMSocketPath: "/x\nLD_PRELOAD=/evil.so"},
+			expectErr:     true,
+		},
+		"socket directory with shell syntax": {
+			volumeContext: map[string]string{keyAgentEnvDSDSocketDir: "/var/$(reboot)"},
+			expectErr:     true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			fs := afero.Afero{Fs: afero.NewMemMapFs()}
+			mounter := mount.NewFakeMounter(nil)
+			publisher := newAgentEnvPublisher(fs, mounter, testSocketRegistry(t, "/var/run/datadog/apm.socket", "/var/run/datadog/dsd.socket"), "/storage")
+
+			volumeContext := map[string]string{"type": string(DatadogAgentEnv)}
+			for k, v := range tc.volumeContext {
+				volumeContext[k] = v
+			}
+			resp, err := publisher.Publish(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:      "vol-1",
+				TargetPath:    "/target/env",
+				VolumeContext: volumeContext,
+			})
+
+			require.NotNil(t, resp)
+			assert.Equal(t, DatadogAgentEnv, resp.VolumeType)
+			if tc.expectErr {
+				assert.Error(t, err)
+				assert.Empty(t, mounter.GetLog())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedHost, resp.HostPath)
+
+			content, err := fs.ReadFile("/storage/agent-env/vol-1/agent.env")
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedContent, string(content))
+			for file, expected := range tc.expectedFiles {
+				content, err := fs.ReadFile("/storage/agent-env/vol-1/" + file)
+				require.NoError(t, err)
+				assert.Equal(t, expected, string(content))
+			}
+
+			mountPoints, err := mounter.List()
+			require.NoError(t, err)
+			require.Len(t, mountPoints, 1)
+			assert.Equal(t, tc.expectedHost, mountPoints[0].Device)
+			assert.Equal(t, "/target/env", mountPoints[0].Path)
+			assert.Contains(t, mountPoints[0].Opts, "ro")
+		})
+	}
+}
+
+func TestAgentEnvPublisher_Publish_FollowsSocketRegistry(t *testing.T) {
+	disabled := false
+	sockets, err := NewSocketRegistry("/var/run/datadog/apm.socket", "/var/run/datadog/dsd.socket", []NamedSocket{
+		{Name: APMSocket, Enabled: &disabled},
+		{Name: DSDSocket, Path: "/var/run/dogstatsd/dsd.socket"},
+	})
+	require.NoError(t, err)
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	publisher := newAgentEnvPublisher(fs, mount.NewFakeMounter(nil), sockets, "/storage")
+
+	_, err = publisher.Publish(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:      "vol-1",
+		TargetPath:    "/target/env",
+		VolumeContext: map[string]string{"type": string(DatadogAgentEnv)},
+	})
+	require.NoError(t, err)
+
+	content, err := fs.ReadFile("/storage/agent-env/vol-1/agent.env")
+	require.NoError(t, err)
+	assert.Equal(t, "DD_DOGSTATSD_URL='unix:///var/run/dogstatsd/dsd.socket'\n", string(content))
+}
+
+func TestAgentEnvPublisher_Publish_RejectsInvalidVolumeID(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	publisher := newAgentEnvPublisher(fs, mount.NewFakeMounter(nil), testSocketRegistry(t, "/apm.socket", "/dsd.socket"), "/storage")
+
+	resp, err := publisher.Publish(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:      "../vol-1",
+		TargetPath:    "/target/env",
+		VolumeContext: map[string]string{"type": string(DatadogAgentEnv)},
+	})
+
+	assert.NotNil(t, resp)
+	assert.Error(t, err)
+}
+
+func TestAgentEnvPublisher_UnpublishAndStats(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	mounter := mount.NewFakeMounter(nil)
+	publisher := newAgentEnvPublisher(fs, mounter, testSocketRegistry(t, "/apm.socket", "/dsd.socket"), "/storage")
+	ctx := context.Background()
+
+	// Volumes without rendered files belong to other publishers
+	resp, err := publisher.Unpublish(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "vol-1", TargetPath: "/target/env"})
+	assert.Nil(t, resp)
+	assert.NoError(t, err)
+	stats, err := publisher.Stats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: "vol-1", VolumePath: "/target/env"})
+	assert.Nil(t, stats)
+	assert.NoError(t, err)
+
+	_, err = publisher.Publish(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:      "vol-1",
+		TargetPath:    "/target/env",
+		VolumeContext: map[string]string{"type": string(DatadogAgentEnv)},
+	})
+	require.NoError(t, err)
+
+	stats, err = publisher.Stats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: "vol-1", VolumePath: "/target/env"})
+	require.NoError(t, err)
+	require.NotNil(t, stats)
+	assert.Equal(t, DatadogAgentEnv, stats.VolumeType)
+
+	resp, err = publisher.Unpublish(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "vol-1", TargetPath: "/target/env"})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, DatadogAgentEnv, resp.VolumeType)
+
+	exists, err := fs.Exists("/storage/agent-env/vol-1")
+	require.NoError(t, err)
+	assert.False(t, exists, "rendered files are removed")
+	mountPoints, err := mounter.List()
+	require.NoError(t, err)
+	assert.Empty(t, mountPoints)
+}
@@ -6,6 +6,7 @@
 package publishers
 
 import (
+	"context"
 	"log/slog"
 	"os"
 
@@ -16,8 +17,14 @@ import (
 	"k8s.io/utils/mount"
 )
 
-var remountReadOnlyBind = func(hostPath, targetPath string) error {
-	return unix.Mount(hostPath, targetPath, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, "")
+// remountBind remounts a bind mount with the given options. A remount replaces
+// all the flags of the mount, so every option of the mount must be given.
+var remountBind = func(hostPath, targetPath string, options []string) error {
+	flags := uintptr(unix.MS_BIND | unix.MS_REMOUNT)
+	for _, option := range options {
+		flags |= mountOptionFlags[option]
+	}
+	return unix.Mount(hostPath, targetPath, "", flags, "")
 }
 
 type bindMountArgs struct {
@@ -25,13 +32,17 @@ type bindMountArgs struct {
 	targetPath string
 	isFile     bool
 	readOnly   bool
+	// recursive also binds the mounts found under hostPath
+	recursive bool
+	// options are added to the mount options, see mountOptions
+	options []string
 }
 
 // bindMount performs a bind mount from hostPath to targetPath.
 // It creates the target path if it doesn't exist (as file if isFile, directory otherwise).
 // Returns nil if already mounted or mount succeeds.
-func bindMount(afs afero.Afero, mounter mount.Interface, args bindMountArgs) error {
-	slog.Info("bindMount: mounting", "host_path", args.hostPath, "target_path", args.targetPath, "read_only", args.readOnly)
+func bindMount(ctx context.Context, afs afero.Afero, mounter mount.Interface, args bindMountArgs) error {
+	slog.InfoContext(ctx, "bindMount: mounting", "host_path", args.hostPath, "target_path", args.targetPath, "read_only", args.readOnly, "options", args.options)
 
 	// Verify source path exists before attempting mount
 	exists, err := afs.Exists(args.hostPath)
@@ -55,34 +66,39 @@ func bindMount(afs afero.Afero, mounter mount.Interface, args bindMountArgs) err
 		return status.Errorf(codes.Internal, "bindMount: failed to check mount point: %v", err)
 	}
 
+	options := args.options
+	if args.readOnly {
+		options = append([]string{mountOptionReadOnly}, options...)
+	}
+
 	// Perform bind mount if not already mounted
 	if notMnt {
-		options := []string{"bind"}
-		if args.readOnly {
-			options = append(options, "ro")
+		bind := "bind"
+		if args.recursive {
+			bind = "rbind"
 		}
-		if err := mounter.Mount(args.hostPath, args.targetPath, "", options); err != nil {
-			slog.Error("bindMount: failed to mount", "error", err, "host_path", args.hostPath, "target_path", args.targetPath)
+		if err := mounter.Mount(args.hostPath, args.targetPath, "", append([]string{bind}, options...)); err != nil {
+			slog.ErrorContext(ctx, "bindMount: failed to mount", "error", err, "host_path", args.hostPath, "target_path", args.targetPath)
 			return status.Errorf(codes.Internal, "bindMount: failed to mount: %v", err)
 		}
 	} else {
-		slog.Info("bindMount: already mounted, skipping", "target_path", args.targetPath)
+		slog.InfoContext(ctx, "bindMount: already mounted, skipping", "target_path", args.targetPath)
 		if args.readOnly {
-			if err := remountReadOnlyBind(args.hostPath, args.targetPath); err != nil {
-				slog.Error("bindMount: failed to remount read-only", "error", err, "host_path", args.hostPath, "target_path", args.targetPath)
+			if err := remountBind(args.hostPath, args.targetPath, options); err != nil {
+				slog.ErrorContext(ctx, "bindMount: failed to remount read-only", "error", err, "host_path", args.hostPath, "target_path", args.targetPath)
 				return status.Errorf(codes.Internal, "bindMount: failed to remount read-only: %v", err)
 			}
 		}
 	}
 
-	slog.Info("bindMount: successfully mounted", "host_path", args.hostPath, "target_path", args.targetPath, "read_only", args.readOnly)
+	slog.InfoContext(ctx, "bindMount: successfully mounted", "host_path", args.hostPath, "target_path", args.targetPath, "read_only", args.readOnly)
 	return nil
 }
 
 // bindUnmount unmounts the target path and removes it.
 // Returns nil if target doesn't exist or unmount succeeds.
-func bindUnmount(afs afero.Afero, mounter mount.Interface, targetPath string) error {
-	slog.Info("bindUnmount: unmounting", "target_path", targetPath)
+func bindUnmount(ctx context.Context, afs afero.Afero, mounter mount.Interface, targetPath string) error {
+	slog.InfoContext(ctx, "bindUnmount: unmounting", "target_path", targetPath)
 
 	// Check if target exists
 	exists, err := afs.Exists(targetPath)
@@ -90,21 +106,21 @@ func bindUnmount(afs afero.Afero, mounter mount.Interface, targetPath string) er
 		return status.Errorf(codes.Internal, "bindUnmount: failed to check if target exists: %v", err)
 	}
 	if !exists {
-		slog.Info("bindUnmount: target path does not exist, nothing to unmount", "target_path", targetPath)
+		slog.InfoContext(ctx, "bindUnmount: target path does not exist, nothing to unmount", "target_path", targetPath)
 		return nil
 	}
 
 	// Always attempt to unmount - IsLikelyNotMountPoint is unreliable for bind mounts
 	// (See https://github.com/kubernetes/utils/blob/914a6e7505707ae6d13abe19730c24b4cfde9e6f/mount/mount.go#L59-L60)
 	if err := mounter.Unmount(targetPath); err != nil {
-		slog.Error("bindUnmount: failed to unmount", "error", err, "target_path", targetPath)
+		slog.ErrorContext(ctx, "bindUnmount: failed to unmount", "error", err, "target_path", targetPath)
 	}
 
 	// Try to remove the target path
 	if err := afs.RemoveAll(targetPath); err != nil {
-		slog.Info("bindUnmount: failed to remove target path, Kubernetes will clean it up", "error", err, "target_path", targetPath)
+		slog.InfoContext(ctx, "bindUnmount: failed to remove target path, Kubernetes will clean it up", "error", err, "target_path", targetPath)
 	}
 
-	slog.Info("bindUnmount: successfully unmounted", "target_path", targetPath)
+	slog.InfoContext(ctx, "bindUnmount: successfully unmounted", "target_path", targetPath)
 	return nil
 }
@@ -6,6 +6,7 @@
 package publishers
 
 import (
+	"context"
 	"testing"
 
 	"github.com/spf13/afero"
@@ -51,7 +52,7 @@ func TestBindMount_CreatesTargetDirectory(t *testing.T) {
 	// Create source directory
 	require.NoError(t, fs.MkdirAll("/host/dir", 0755))
 
-	err := bindMount(fs, mounter, bindMountArgs{
+	err := bindMount(context.Background(), fs, mounter, bindMountArgs{
 		hostPath:   "/host/dir",
 		targetPath: "/target/dir",
 		isFile:     false,
@@ -80,7 +81,7 @@ func TestBindMount_CreatesTargetFile(t *testing.T) {
 	_, err := fs.Create("/host/socket.sock")
 	require.NoError(t, err)
 
-	err = bindMount(fs, mounter, bindMountArgs{
+	err = bindMount(context.Background(), fs, mounter, bindMountArgs{
 		hostPath:   "/host/socket.sock",
 		targetPath: "/target/socket.sock",
 		isFile:     true,
@@ -105,7 +106,7 @@ func TestBindMount_CallsMounter(t *testing.T) {
 	// Create source directory
 	require.NoError(t, fs.MkdirAll("/host/path", 0755))
 
-	err := bindMount(fs, mounter, bindMountArgs{
+	err := bindMount(context.Background(), fs, mounter, bindMountArgs{
 		hostPath:   "/host/path",
 		targetPath: "/target/path",
 		isFile:     false,
@@ -127,7 +128,7 @@ func TestBindMount_ReadOnlyRemountsBind(t *testing.T) {
 
 	require.NoError(t, fs.MkdirAll("/host/path", 0755))
 
-	err := bindMount(fs, mounter, bindMountArgs{
+	err := bindMount(context.Background(), fs, mounter, bindMountArgs{
 		hostPath:   "/host/path",
 		targetPath: "/target/path",
 		isFile:     false,
@@ -143,26 +144,47 @@ func TestBindMount_ReadOnlyRemountsAlreadyMountedTarget(t *testing.T) {
 	fs := afero.Afero{Fs: afero.NewMemMapFs()}
 	mounter := &recordingMounter{FakeMounter: mount.NewFakeMounter(nil), alreadyMount: true}
 	var remounted []recordedMount
-	originalRemountReadOnlyBind := remountReadOnlyBind
-	remountReadOnlyBind = func(hostPath, targetPath string) error {
-		remounted = append(remounted, recordedMount{source: hostPath, target: targetPath})
+	originalRemountBind := remountBind
+	remountBind = func(hostPath, targetPath string, options []string) error {
+		remounted = append(remounted, recordedMount{source: hostPath, target: targetPath, options: options})
 		return nil
 	}
 	t.Cleanup(func() {
-		remountReadOnlyBind = originalRemountReadOnlyBind
+		remountBind = originalRemountBind
 	})
 
 	require.NoError(t, fs.MkdirAll("/host/path", 0755))
 	require.NoError(t, fs.MkdirAll("/target/path", 0755))
 
-	err := bindMount(fs, mounter, bindMountArgs{
+	err := bindMount(context.Background(), fs, mounter, bindMountArgs{
 		hostPath:   "/host/path",
 		targetPath: "/target/path",
 		isFile:     false,
 		readOnly:   true,
+		options:    []string{mountOptionNoSuid},
 	})
 
 	require.NoError(t, err)
 	assert.Empty(t, mounter.mounts)
-	assert.Equal(t, []recordedMount{{source: "/host/path", target: "/target/path"}}, remounted)
+	assert.Equal(t, []recordedMount{{source: "/host/path", target: "/target/path", options: []string{"ro", "nosuid"}}}, remounted,
+		"the remount keeps the hardened options")
+}
+
+func TestBindMount_AddsMountOptions(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	mounter := &recordingMounter{FakeMounter: mount.NewFakeMounter(nil)}
+
+	require.NoError(t, fs.MkdirAll("/host/path", 0755))
+
+	err := bindMount(context.Background(), fs, mounter, bindMountArgs{
+		hostPath:   "/host/path",
+		targetPath: "/target/path",
+		isFile:     false,
+		readOnly:   true,
+		options:    mountOptions(DatadogLibrary),
+	})
+
+	require.NoError(t, err)
+	require.Len(t, mounter.mounts, 1)
+	assert.Equal(t, []string{"bind", "ro", "nosuid", "nodev"}, mounter.mounts[0].options)
 }
@@ -6,23 +6,41 @@
 package publishers
 
 import (
+	"context"
 	"fmt"
 	log "log/slog"
 
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 )
 
 // chainPublisher is a publisher that chains multiple publishers together.
-// It stops at the first publisher that returns a non-nil response.
+// It stops at the first publisher that returns a non-nil response, which it
+// tags with the pod identity carried by the context of publish requests.
 type chainPublisher struct {
 	publishers []Publisher
 }
 
-func (s chainPublisher) Publish(req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
+func (s chainPublisher) Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
 	for _, publisher := range s.publishers {
-		resp, err := publisher.Publish(req)
+		resp, err := publisher.Publish(ctx, req)
 		if err != nil {
-			log.Info("failed to publish volume with publisher", "error", err, "publisher", fmt.Sprintf("%T", publisher))
+			log.InfoContext(ctx, "failed to publish volume with publisher", "error", err, "publisher", fmt.Sprintf("%T", publisher))
+		}
+		if resp != nil {
+			out := *resp
+			out.Pod, _ = podinfo.FromContext(ctx)
+			return &out, err
+		}
+	}
+	return nil, nil
+}
+
+func (s chainPublisher) Unpublish(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+	for _, publisher := range s.publishers {
+		resp, err := publisher.Unpublish(ctx, req)
+		if err != nil {
+			log.InfoContext(ctx, "failed to unpublish volume with publisher", "error", err, "publisher", fmt.Sprintf("%T", publisher))
 		}
 		if resp != nil {
 			return resp, err
@@ -31,11 +49,11 @@ func (s chainPublisher) Publish(req *csi.NodePublishVolumeRequest) (*PublisherRe
 	return nil, nil
 }
 
-func (s chainPublisher) Unpublish(req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+func (s chainPublisher) Stats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*VolumeStats, error) {
 	for _, publisher := range s.publishers {
-		resp, err := publisher.Unpublish(req)
+		resp, err := publisher.Stats(ctx, req)
 		if err != nil {
-			log.Info("failed to unpublish volume with publisher", "error", err, "publisher", fmt.Sprintf("%T", publisher))
+			log.InfoContext(ctx, "failed to get volume stats with publisher", "error", err, "publisher", fmt.Sprintf("%T", publisher))
 		}
 		if resp != nil {
 			return resp, err
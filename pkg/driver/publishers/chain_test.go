@@ -6,9 +6,11 @@
 package publishers
 
 import (
+	"context"
 	"errors"
 	"testing"
 
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/assert"
 )
@@ -19,16 +21,22 @@ type mockPublisher struct {
 	publishErr    error
 	unpublishResp *PublisherResponse
 	unpublishErr  error
+	statsResp     *VolumeStats
+	statsErr      error
 }
 
-func (m mockPublisher) Publish(req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
+func (m mockPublisher) Publish(_ context.Context, req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
 	return m.publishResp, m.publishErr
 }
 
-func (m mockPublisher) Unpublish(req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+func (m mockPublisher) Unpublish(_ context.Context, req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
 	return m.unpublishResp, m.unpublishErr
 }
 
+func (m mockPublisher) Stats(_ context.Context, req *csi.NodeGetVolumeStatsRequest) (*VolumeStats, error) {
+	return m.statsResp, m.statsErr
+}
+
 func TestChainPublisher_Publish_StopsAtFirstResponse(t *testing.T) {
 	firstResp := &PublisherResponse{VolumeType: "First", VolumePath: "/first"}
 	secondResp := &PublisherResponse{VolumeType: "Second", VolumePath: "/second"}
@@ -39,19 +47,34 @@ func TestChainPublisher_Publish_StopsAtFirstResponse(t *testing.T) {
 		mockPublisher{publishResp: secondResp}, // never reached
 	)
 
-	resp, err := chain.Publish(&csi.NodePublishVolumeRequest{})
+	resp, err := chain.Publish(context.Background(), &csi.NodePublishVolumeRequest{})
 
 	assert.NoError(t, err)
 	assert.Equal(t, firstResp, resp)
 }
 
+func TestChainPublisher_Publish_SetsPodInfo(t *testing.T) {
+	chain := newChainPublisher(mockPublisher{publishResp: &PublisherResponse{VolumeType: "First"}})
+
+	pod := podinfo.PodInfo{Name: "web-0", Namespace: "shop", UID: "uid-1"}
+	resp, err := chain.Publish(podinfo.NewContext(context.Background(), pod), &csi.NodePublishVolumeRequest{
+		VolumeContext: map[string]string{
+			podinfo.KeyPodName:      "spoofed",
+			podinfo.KeyPodNamespace: "kube-system",
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, pod, resp.Pod, "the pod is taken from the context, not from the volume context")
+}
+
 func TestChainPublisher_Publish_ReturnsNilIfNoPublisherMatches(t *testing.T) {
 	chain := newChainPublisher(
 		mockPublisher{publishResp: nil},
 		mockPublisher{publishResp: nil},
 	)
 
-	resp, err := chain.Publish(&csi.NodePublishVolumeRequest{})
+	resp, err := chain.Publish(context.Background(), &csi.NodePublishVolumeRequest{})
 
 	assert.NoError(t, err)
 	assert.Nil(t, resp)
@@ -66,7 +89,7 @@ func TestChainPublisher_Publish_ReturnsErrorWithResponse(t *testing.T) {
 		mockPublisher{publishResp: expectedResp, publishErr: expectedErr},
 	)
 
-	resp, err := chain.Publish(&csi.NodePublishVolumeRequest{})
+	resp, err := chain.Publish(context.Background(), &csi.NodePublishVolumeRequest{})
 
 	assert.Equal(t, expectedErr, err)
 	assert.Equal(t, expectedResp, resp)
@@ -81,7 +104,22 @@ func TestChainPublisher_Unpublish_StopsAtFirstResponse(t *testing.T) {
 		mockPublisher{unpublishResp: &PublisherResponse{VolumeType: "Never", VolumePath: "/never"}},
 	)
 
-	resp, err := chain.Unpublish(&csi.NodeUnpublishVolumeRequest{})
+	resp, err := chain.Unpublish(context.Background(), &csi.NodeUnpublishVolumeRequest{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, firstResp, resp)
+}
+
+func TestChainPublisher_Stats_StopsAtFirstResponse(t *testing.T) {
+	firstResp := &VolumeStats{VolumeType: "First", UsedBytes: 1, UsedInodes: 1}
+
+	chain := newChainPublisher(
+		mockPublisher{statsResp: nil},
+		mockPublisher{statsResp: firstResp},
+		mockPublisher{statsResp: &VolumeStats{VolumeType: "Never"}},
+	)
+
+	resp, err := chain.Stats(context.Background(), &csi.NodeGetVolumeStatsRequest{})
 
 	assert.NoError(t, err)
 	assert.Equal(t, firstResp, resp)
@@ -91,13 +129,19 @@ func TestChainPublisher_EmptyChain(t *testing.T) {
 	chain := newChainPublisher()
 
 	t.Run("Publish returns nil", func(t *testing.T) {
-		resp, err := chain.Publish(&csi.NodePublishVolumeRequest{})
+		resp, err := chain.Publish(context.Background(), &csi.NodePublishVolumeRequest{})
 		assert.NoError(t, err)
 		assert.Nil(t, resp)
 	})
 
 	t.Run("Unpublish returns nil", func(t *testing.T) {
-		resp, err := chain.Unpublish(&csi.NodeUnpublishVolumeRequest{})
+		resp, err := chain.Unpublish(context.Background(), &csi.NodeUnpublishVolumeRequest{})
+		assert.NoError(t, err)
+		assert.Nil(t, resp)
+	})
+
+	t.Run("Stats returns nil", func(t *testing.T) {
+		resp, err := chain.Stats(context.Background(), &csi.NodeGetVolumeStatsRequest{})
 		assert.NoError(t, err)
 		assert.Nil(t, resp)
 	})
@@ -14,6 +14,21 @@ Supported volume types are:
   - APMSocketDirectory: mounts the directory containing the APM socket.
   - DSDSocketDirectory: mounts the directory containing the DogStatsD socket.
   - DatadogSocketsDirectory: mounts the directory containing both sockets.
+  - AgentSocketsDirectory: mounts a private directory holding only the
+    selected sockets, instead of their whole parent directory.
+  - DatadogLibrary: mounts a Datadog instrumentation library.
+  - DatadogLibraries: mounts several instrumentation libraries, one
+    sub-directory per package.
+  - DatadogAgentEnv: mounts a read-only file of agent connection settings
+    (DD_TRACE_AGENT_URL, DD_DOGSTATSD_URL), or a directory holding it and one
+    file per setting.
+  - DatadogScratch: mounts a writable directory private to the volume, for
+    crash dumps and spill files. It is remounted read-only once it goes over
+    its size cap, and deleted on unpublish.
+
+More socket volume types can be registered in the socket registry, each
+mounting a socket file or a directory. The registry can also disable or move
+the built-in socket types.
 
 Note: the legacy `mode`/`path` schema is still supported but is considered
 obsolete. Callers should migrate to the `type` schema instead.
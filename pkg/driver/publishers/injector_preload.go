@@ -6,50 +6,330 @@
 package publishers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	log "log/slog"
+	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"sync"
 
+	"github.com/Datadog/datadog-csi-driver/pkg/librarymanager"
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/spf13/afero"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/utils/mount"
 )
 
 const (
-	// Default content for ld.so.preload
-	defaultPreloadContent = "/opt/datadog-packages/datadog-apm-inject/stable/inject/launcher.preload.so\n"
+	// VolumeContext keys for DatadogInjectorPreload volumes, they are mutually exclusive
+	keyInjectorLauncherPath = "dd.csi.datadog.com/injector.launcher-path"
+	keyInjectorVersion      = "dd.csi.datadog.com/injector.version"
+
+	// injectorMountPath is where containers mount the apm-inject library, the launcher paths point into it
+	injectorMountPath = "/opt/datadog-packages/datadog-apm-inject"
+	// Default launcher, preloaded when the volume does not select one
+	defaultLauncherPath = "/opt/datadog-packages/datadog-apm-inject/stable/inject/launcher.preload.so"
+	// launcherPathFormat is the launcher path of an injector version
+	launcherPathFormat = "/opt/datadog-packages/datadog-apm-inject/%s/inject/launcher.preload.so"
+
+	// preloadDir is the directory of the preload files, under the storage base path
+	preloadDir = "preload"
+	// preloadContentsDir holds one immutable file per distinct content, named after its SHA-256
+	preloadContentsDir = "contents"
+	// preloadVolumesDir holds one file per published volume, holding the SHA-256 of its content
+	preloadVolumesDir = "volumes"
+)
+
+// PreloadVerification is the policy applied when the launcher preloaded by a
+// DatadogInjectorPreload volume is not found in an apm-inject library volume
+// of the same pod.
+type PreloadVerification string
+
+const (
+	// PreloadVerificationDisabled mounts the preload file without verifying the launcher
+	PreloadVerificationDisabled PreloadVerification = "disabled"
+	// PreloadVerificationEmpty mounts an empty preload file when the launcher is not found
+	PreloadVerificationEmpty PreloadVerification = "empty"
+	// PreloadVerificationFail fails the publish when the launcher is not found, so that kubelet retries it
+	PreloadVerificationFail PreloadVerification = "fail"
+)
+
+// ParsePreloadVerification parses a preload verification policy. An empty value disables the verification.
+func ParsePreloadVerification(value string) (PreloadVerification, error) {
+	switch verification := PreloadVerification(strings.TrimSpace(value)); verification {
+	case "":
+		return PreloadVerificationDisabled, nil
+	case PreloadVerificationDisabled, PreloadVerificationEmpty, PreloadVerificationFail:
+		return verification, nil
+	default:
+		return "", fmt.Errorf("invalid preload verification %q, expected %q, %q or %q",
+			value, PreloadVerificationDisabled, PreloadVerificationEmpty, PreloadVerificationFail)
+	}
+}
+
+// podLibraries lists the libraries linked to the volumes of a pod. It is implemented by the library manager.
+type podLibraries interface {
+	ListPodLibraries(podUID string) ([]librarymanager.PodLibrary, error)
+}
+
+// PodGetter reads the pods volumes are published for.
+type PodGetter interface {
+	GetPod(ctx context.Context, namespace, name string) (*corev1.Pod, error)
+}
+
+// InjectorLibraryPendingError is returned when the launcher of a
+// DatadogInjectorPreload volume cannot be verified yet, because the apm-inject
+// library volume of the same pod is not published yet.
+type InjectorLibraryPendingError struct {
+	// Pod is the namespace and name of the pod.
+	Pod string
+}
+
+func (e *InjectorLibraryPendingError) Error() string {
+	return fmt.Sprintf("the %s library volume of pod %s is not published yet, retry later", injectorPackage, e.Pod)
+}
+
+var (
+	// launcherPathPattern only allows plain absolute paths to a shared object
+	launcherPathPattern = regexp.MustCompile(`^/[A-Za-z0-9._/-]+\.so$`)
+	// injectorVersionPattern only allows a single path element
+	injectorVersionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
 )
 
-// injectorPreloadPublisher mounts an ld.so.preload file into containers.
+// injectorPreloadPublisher mounts an ld.so.preload file into containers. Each
+// distinct content is written once as an immutable file named after its hash,
+// so that a new content never changes the file mounted by running pods.
+// Content files are removed once no volume references them.
 type injectorPreloadPublisher struct {
 	fs      afero.Afero
 	mounter mount.Interface
-	// preloadFilePath is the path to the preload file
-	preloadFilePath string
-	// mu protects the check-and-create operation
+	// contentsPath is the directory of the content-addressed preload files
+	contentsPath string
+	// volumesPath is the directory of the volume references to the preload files
+	volumesPath string
+	// mu serializes the content file creation, the volume references and the garbage collection
 	mu sync.Mutex
 	// disabled indicates if SSI is disabled (publish requests will be rejected)
 	disabled bool
+	// verification is the policy applied when the preloaded launcher is not found
+	verification PreloadVerification
+	// libraries resolves the library volumes of a pod to verify the launcher, it is nil without a library manager
+	libraries podLibraries
+	// pods reads the volumes of a pod, to tell whether its apm-inject library volume is pending. It may be nil.
+	pods PodGetter
+	// podTrusted is true when kubelet sets the pod identity of the volume context
+	podTrusted bool
 }
 
-func (p *injectorPreloadPublisher) ensurePreloadFileExists() error {
-	// Fast path: check without lock
-	if exists, err := p.fs.Exists(p.preloadFilePath); err != nil || exists {
-		return err
+// preloadContent returns the ld.so.preload content selected by the volume context.
+func preloadContent(volumeCtx map[string]string) (string, error) {
+	launcherPath, version := volumeCtx[keyInjectorLauncherPath], volumeCtx[keyInjectorVersion]
+	switch {
+	case launcherPath != "" && version != "":
+		return "", fmt.Errorf("%s and %s are mutually exclusive", keyInjectorLauncherPath, keyInjectorVersion)
+	case version != "":
+		if !injectorVersionPattern.MatchString(version) || version == "." || version == ".." {
+			return "", fmt.Errorf("invalid %s %q", keyInjectorVersion, version)
+		}
+		launcherPath = fmt.Sprintf(launcherPathFormat, version)
+	case launcherPath != "":
+		if !launcherPathPattern.MatchString(launcherPath) || filepath.Clean(launcherPath) != launcherPath {
+			return "", fmt.Errorf("invalid %s %q, expected a clean absolute path to a .so file", keyInjectorLauncherPath, launcherPath)
+		}
+	default:
+		launcherPath = defaultLauncherPath
+	}
+	return launcherPath + "\n", nil
+}
+
+// verifyLauncher checks that the launcher preloaded by a volume is provided by
+// an apm-inject library volume of the same pod. It returns why it is not, or an
+// InjectorLibraryPendingError when the pod has an apm-inject library volume
+// that is not published yet.
+func (p *injectorPreloadPublisher) verifyLauncher(ctx context.Context, volumeCtx map[string]string, content string) (verified bool, reason string, err error) {
+	pod := podinfo.FromVolumeContext(volumeCtx)
+	if !p.podTrusted {
+		return false, "the pod identity is only trusted when the CSIDriver object enables podInfoOnMount", nil
+	}
+	if pod.UID == "" {
+		return false, "the pod UID is unknown, podInfoOnMount must be enabled on the CSIDriver object", nil
+	}
+	if p.libraries == nil {
+		return false, "library volumes are not tracked without SSI storage", nil
+	}
+	launcherPath := strings.TrimSpace(content)
+	launcherFile, found := strings.CutPrefix(launcherPath, injectorMountPath+"/")
+	if !found {
+		return false, fmt.Sprintf("the launcher %s is not part of the %s library", launcherPath, injectorPackage), nil
 	}
 
-	// Slow path: lock and double-check before creating
+	libraries, err := p.libraries.ListPodLibraries(pod.UID)
+	if err != nil {
+		return false, "", err
+	}
+	linked := false
+	for _, library := range libraries {
+		if library.Package != injectorPackage {
+			continue
+		}
+		linked = true
+		// The container mount path maps to the source directory of the image
+		sourceDir := librarySourceDir(library.Path, injectorPackage, library.SourcePath)
+		exists, err := p.fs.Exists(filepath.Join(sourceDir, launcherFile))
+		if err != nil {
+			return false, "", err
+		}
+		if exists {
+			return true, "", nil
+		}
+	}
+	if linked {
+		return false, fmt.Sprintf("no %s library volume of the pod contains the launcher %s", injectorPackage, launcherPath), nil
+	}
+
+	// Kubelet publishes the volumes of a pod in no particular order: the
+	// library volume may not be published yet.
+	if p.pods == nil {
+		return false, fmt.Sprintf("no %s library volume of the pod is published", injectorPackage), nil
+	}
+	pending, err := p.hasInjectorLibraryVolume(ctx, pod)
+	if err != nil {
+		return false, "", err
+	}
+	if pending {
+		return false, "", &InjectorLibraryPendingError{Pod: pod.Namespace + "/" + pod.Name}
+	}
+	return false, fmt.Sprintf("the pod has no %s library volume", injectorPackage), nil
+}
+
+// hasInjectorLibraryVolume returns true if the spec of the pod has a library
+// volume of the apm-inject package.
+func (p *injectorPreloadPublisher) hasInjectorLibraryVolume(ctx context.Context, pod podinfo.PodInfo) (bool, error) {
+	spec, err := p.pods.GetPod(ctx, pod.Namespace, pod.Name)
+	if err != nil {
+		return false, err
+	}
+	if string(spec.UID) != pod.UID {
+		return false, fmt.Errorf("pod %s/%s has UID %s instead of %s", pod.Namespace, pod.Name, spec.UID, pod.UID)
+	}
+	for _, volume := range spec.Spec.Volumes {
+		if volume.CSI == nil {
+			continue
+		}
+		attributes := volume.CSI.VolumeAttributes
+		switch VolumeType(attributes["type"]) {
+		case DatadogLibrary:
+			if attributes[keyLibraryPackage] == injectorPackage {
+				return true, nil
+			}
+		case DatadogLibraries:
+			libs, err := parseLibraries(attributes[keyLibraries])
+			if err != nil {
+				continue
+			}
+			if slices.ContainsFunc(libs, func(lib *librarymanager.Library) bool { return lib.Name() == injectorPackage }) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// ensurePreloadFile writes the content file of a volume if it does not exist
+// yet, and records that the volume references it. It returns the content file path.
+func (p *injectorPreloadPublisher) ensurePreloadFile(volumeID, content string) (string, error) {
+	referencePath, err := volumeDir(p.volumesPath, volumeID)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(content))
+	hash := hex.EncodeToString(sum[:])
+	contentPath := filepath.Join(p.contentsPath, hash)
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if exists, err := p.fs.Exists(p.preloadFilePath); err != nil || exists {
+	if err := p.fs.MkdirAll(p.contentsPath, 0o755); err != nil {
+		return "", err
+	}
+	if err := p.fs.MkdirAll(p.volumesPath, 0o755); err != nil {
+		return "", err
+	}
+	exists, err := p.fs.Exists(contentPath)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		// Write then rename, so that a content file is never seen partially written
+		tmp, err := afero.TempFile(p.fs, p.contentsPath, ".tmp-*")
+		if err != nil {
+			return "", err
+		}
+		_, writeErr := tmp.WriteString(content)
+		closeErr := tmp.Close()
+		if err := errors.Join(writeErr, closeErr, p.fs.Chmod(tmp.Name(), 0o444)); err != nil {
+			_ = p.fs.Remove(tmp.Name())
+			return "", err
+		}
+		if err := p.fs.Rename(tmp.Name(), contentPath); err != nil {
+			_ = p.fs.Remove(tmp.Name())
+			return "", err
+		}
+	}
+	if err := p.fs.WriteFile(referencePath, []byte(hash), 0o644); err != nil {
+		return "", err
+	}
+	return contentPath, nil
+}
+
+// releasePreloadFile removes the reference of a volume and garbage-collects
+// the content files no volume references any more.
+func (p *injectorPreloadPublisher) releasePreloadFile(ctx context.Context, referencePath string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.fs.Remove(referencePath); err != nil && !os.IsNotExist(err) {
 		return err
 	}
 
-	return p.fs.WriteFile(p.preloadFilePath, []byte(defaultPreloadContent), 0644)
+	references, err := p.fs.ReadDir(p.volumesPath)
+	if err != nil {
+		return err
+	}
+	used := map[string]bool{}
+	for _, reference := range references {
+		hash, err := p.fs.ReadFile(filepath.Join(p.volumesPath, reference.Name()))
+		if err != nil {
+			return err
+		}
+		used[strings.TrimSpace(string(hash))] = true
+	}
+
+	contents, err := p.fs.ReadDir(p.contentsPath)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, content := range contents {
+		if used[content.Name()] {
+			continue
+		}
+		log.InfoContext(ctx, "Removing unused preload file", "hash", content.Name())
+		if err := p.fs.Remove(filepath.Join(p.contentsPath, content.Name())); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
-func (p *injectorPreloadPublisher) Publish(req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
+func (p *injectorPreloadPublisher) Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
 	volumeCtx := req.GetVolumeContext()
 	if VolumeType(volumeCtx["type"]) != DatadogInjectorPreload {
 		return nil, nil // Not our volume
@@ -65,36 +345,95 @@ func (p *injectorPreloadPublisher) Publish(req *csi.NodePublishVolumeRequest) (*
 		return &PublisherResponse{VolumeType: DatadogInjectorPreload}, fmt.Errorf("injector preload volumes must be mounted in read-only mode")
 	}
 
+	content, err := preloadContent(volumeCtx)
+	if err != nil {
+		return &PublisherResponse{VolumeType: DatadogInjectorPreload}, err
+	}
+
+	if p.verification == PreloadVerificationEmpty || p.verification == PreloadVerificationFail {
+		verified, reason, err := p.verifyLauncher(ctx, volumeCtx, content)
+		var pending *InjectorLibraryPendingError
+		if errors.As(err, &pending) {
+			return &PublisherResponse{VolumeType: DatadogInjectorPreload}, err
+		}
+		if err != nil {
+			return &PublisherResponse{VolumeType: DatadogInjectorPreload}, fmt.Errorf("failed to verify the injector library: %w", err)
+		}
+		if !verified {
+			if p.verification == PreloadVerificationFail {
+				return &PublisherResponse{VolumeType: DatadogInjectorPreload}, fmt.Errorf("injector library is not available: %s", reason)
+			}
+			log.WarnContext(ctx, "Injector library is not available, mounting an empty preload file", "reason", reason)
+			content = ""
+		}
+	}
+
 	targetPath := req.GetTargetPath()
 
 	// Ensure the preload file exists
-	if err := p.ensurePreloadFileExists(); err != nil {
-		return &PublisherResponse{VolumeType: DatadogInjectorPreload}, err
+	preloadFilePath, err := p.ensurePreloadFile(req.GetVolumeId(), content)
+	if err != nil {
+		return &PublisherResponse{VolumeType: DatadogInjectorPreload}, fmt.Errorf("failed to write preload file: %w", err)
 	}
 
 	// Bind mount the file to the target path
-	if err := bindMount(p.fs, p.mounter, bindMountArgs{
-		hostPath:   p.preloadFilePath,
+	if err := bindMount(ctx, p.fs, p.mounter, bindMountArgs{
+		hostPath:   preloadFilePath,
 		targetPath: targetPath,
 		isFile:     true,
 		readOnly:   true,
+		options:    mountOptions(DatadogInjectorPreload),
 	}); err != nil {
-		return &PublisherResponse{VolumeType: DatadogInjectorPreload},
+		return &PublisherResponse{VolumeType: DatadogInjectorPreload, HostPath: preloadFilePath},
 			fmt.Errorf("failed to mount preload file: %w", err)
 	}
 
-	return &PublisherResponse{VolumeType: DatadogInjectorPreload, VolumePath: targetPath}, nil
+	return &PublisherResponse{VolumeType: DatadogInjectorPreload, VolumePath: targetPath, HostPath: preloadFilePath}, nil
+}
+
+// Unpublish unmounts the volume and releases its preload file. Volumes
+// published before the preload files were content-addressed have no reference
+// and are handled by unmountPublisher.
+func (p *injectorPreloadPublisher) Unpublish(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+	// We don't have VolumeContext in Unpublish, so we check if the volume has a reference
+	referencePath, err := volumeDir(p.volumesPath, req.GetVolumeId())
+	if err != nil {
+		return nil, nil // Not our volume
+	}
+	exists, err := p.fs.Exists(referencePath)
+	if err != nil || !exists {
+		return nil, nil // Not our volume
+	}
+
+	resp := &PublisherResponse{VolumeType: DatadogInjectorPreload}
+	if err := bindUnmount(ctx, p.fs, p.mounter, req.GetTargetPath()); err != nil {
+		return resp, fmt.Errorf("failed to unmount preload file: %w", err)
+	}
+	if err := p.releasePreloadFile(ctx, referencePath); err != nil {
+		return resp, fmt.Errorf("failed to release preload file: %w", err)
+	}
+	return resp, nil
 }
 
-func (p *injectorPreloadPublisher) Unpublish(req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+func (p *injectorPreloadPublisher) Stats(context.Context, *csi.NodeGetVolumeStatsRequest) (*VolumeStats, error) {
 	return nil, nil // Handled by unmountPublisher
 }
 
-func newInjectorPreloadPublisher(fs afero.Afero, mounter mount.Interface, storageBasePath string, disabled bool) Publisher {
-	return &injectorPreloadPublisher{
-		fs:              fs,
-		mounter:         mounter,
-		preloadFilePath: filepath.Join(storageBasePath, "ld.so.preload"),
-		disabled:        disabled,
+func newInjectorPreloadPublisher(fs afero.Afero, mounter mount.Interface, storageBasePath string, disabled bool,
+	verification PreloadVerification, libraryManager *librarymanager.LibraryManager, pods PodGetter, podTrusted bool) Publisher {
+	publisher := &injectorPreloadPublisher{
+		fs:           fs,
+		mounter:      mounter,
+		contentsPath: filepath.Join(storageBasePath, preloadDir, preloadContentsDir),
+		volumesPath:  filepath.Join(storageBasePath, preloadDir, preloadVolumesDir),
+		disabled:     disabled,
+		verification: verification,
+		pods:         pods,
+		podTrusted:   podTrusted,
+	}
+	// Avoid storing a typed nil in the interface
+	if libraryManager != nil {
+		publisher.libraries = libraryManager
 	}
+	return publisher
 }
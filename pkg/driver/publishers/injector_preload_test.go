@@ -6,13 +6,23 @@
 package publishers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
 	"sync"
 	"testing"
 
+	"github.com/Datadog/datadog-csi-driver/pkg/librarymanager"
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/utils/mount"
 )
 
@@ -46,7 +56,7 @@ func TestInjectorPreloadPublisher_Publish_TypeSelection(t *testing.T) {
 				VolumeContext: tc.volumeContext,
 			}
 
-			resp, err := publisher.Publish(req)
+			resp, err := publisher.Publish(context.Background(), req)
 			assert.Nil(t, resp)
 			assert.NoError(t, err)
 		})
@@ -63,7 +73,7 @@ func TestInjectorPreloadPublisher_Publish_DisabledRejectsRequest(t *testing.T) {
 		VolumeContext: map[string]string{"type": "DatadogInjectorPreload"},
 	}
 
-	resp, err := publisher.Publish(req)
+	resp, err := publisher.Publish(context.Background(), req)
 
 	assert.NotNil(t, resp, "response should be non-nil for metrics")
 	assert.Equal(t, DatadogInjectorPreload, resp.VolumeType)
@@ -81,7 +91,7 @@ func TestInjectorPreloadPublisher_Publish_RejectsNonReadOnly(t *testing.T) {
 		VolumeContext: map[string]string{"type": "DatadogInjectorPreload"},
 	}
 
-	resp, err := publisher.Publish(req)
+	resp, err := publisher.Publish(context.Background(), req)
 
 	assert.NotNil(t, resp, "response should be non-nil for metrics")
 	assert.Equal(t, DatadogInjectorPreload, resp.VolumeType)
@@ -93,7 +103,7 @@ func TestInjectorPreloadPublisher_Publish_Success(t *testing.T) {
 	fs := afero.Afero{Fs: afero.NewMemMapFs()}
 	mounter := mount.NewFakeMounter(nil)
 
-	publisher := newInjectorPreloadPublisher(fs, mounter, "/var/datadog", false)
+	publisher := newInjectorPreloadPublisher(fs, mounter, "/var/datadog", false, PreloadVerificationDisabled, nil, nil, false)
 
 	req := &csi.NodePublishVolumeRequest{
 		VolumeId:      "test-volume",
@@ -102,7 +112,7 @@ func TestInjectorPreloadPublisher_Publish_Success(t *testing.T) {
 		VolumeContext: map[string]string{"type": "DatadogInjectorPreload"},
 	}
 
-	resp, err := publisher.Publish(req)
+	resp, err := publisher.Publish(context.Background(), req)
 
 	assert.NoError(t, err)
 	require.NotNil(t, resp)
@@ -110,15 +120,17 @@ func TestInjectorPreloadPublisher_Publish_Success(t *testing.T) {
 	assert.Equal(t, "/target/ld.so.preload", resp.VolumePath)
 
 	// Verify preload file was created with correct content
-	content, err := fs.ReadFile("/var/datadog/ld.so.preload")
+	preloadFilePath := testPreloadFilePath(defaultLauncherPath + "\n")
+	assert.Equal(t, preloadFilePath, resp.HostPath)
+	content, err := fs.ReadFile(preloadFilePath)
 	assert.NoError(t, err)
-	assert.Equal(t, defaultPreloadContent, string(content))
+	assert.Equal(t, defaultLauncherPath+"\n", string(content))
 
 	// Verify mount was called
 	log := mounter.GetLog()
 	require.Len(t, log, 1)
 	assert.Equal(t, "mount", log[0].Action)
-	assert.Equal(t, "/var/datadog/ld.so.preload", log[0].Source)
+	assert.Equal(t, preloadFilePath, log[0].Source)
 	assert.Equal(t, "/target/ld.so.preload", log[0].Target)
 }
 
@@ -128,7 +140,7 @@ func TestInjectorPreloadPublisher_Publish_Idempotent(t *testing.T) {
 	fs := afero.Afero{Fs: afero.NewMemMapFs()}
 	mounter := mount.NewFakeMounter(nil)
 
-	publisher := newInjectorPreloadPublisher(fs, mounter, "/var/datadog", false)
+	publisher := newInjectorPreloadPublisher(fs, mounter, "/var/datadog", false, PreloadVerificationDisabled, nil, nil, false)
 
 	req := &csi.NodePublishVolumeRequest{
 		VolumeId:      "test-volume-1",
@@ -138,7 +150,7 @@ func TestInjectorPreloadPublisher_Publish_Idempotent(t *testing.T) {
 	}
 
 	// First publish
-	resp, err := publisher.Publish(req)
+	resp, err := publisher.Publish(context.Background(), req)
 	assert.NoError(t, err)
 	require.NotNil(t, resp)
 
@@ -150,7 +162,7 @@ func TestInjectorPreloadPublisher_Publish_Idempotent(t *testing.T) {
 		VolumeContext: map[string]string{"type": "DatadogInjectorPreload"},
 	}
 
-	resp, err = publisher.Publish(req2)
+	resp, err = publisher.Publish(context.Background(), req2)
 	assert.NoError(t, err)
 	require.NotNil(t, resp)
 
@@ -164,7 +176,7 @@ func TestInjectorPreloadPublisher_Publish_Concurrent(t *testing.T) {
 	fs := afero.Afero{Fs: afero.NewMemMapFs()}
 	mounter := mount.NewFakeMounter(nil)
 
-	publisher := newInjectorPreloadPublisher(fs, mounter, "/var/datadog", false)
+	publisher := newInjectorPreloadPublisher(fs, mounter, "/var/datadog", false, PreloadVerificationDisabled, nil, nil, false)
 
 	var wg sync.WaitGroup
 	errors := make(chan error, 10)
@@ -179,7 +191,7 @@ func TestInjectorPreloadPublisher_Publish_Concurrent(t *testing.T) {
 				Readonly:      true,
 				VolumeContext: map[string]string{"type": "DatadogInjectorPreload"},
 			}
-			_, err := publisher.Publish(req)
+			_, err := publisher.Publish(context.Background(), req)
 			if err != nil {
 				errors <- err
 			}
@@ -194,14 +206,324 @@ func TestInjectorPreloadPublisher_Publish_Concurrent(t *testing.T) {
 	}
 
 	// Verify preload file exists and has correct content
-	content, err := fs.ReadFile("/var/datadog/ld.so.preload")
+	content, err := fs.ReadFile(testPreloadFilePath(defaultLauncherPath + "\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, defaultLauncherPath+"\n", string(content))
+	contents, err := fs.ReadDir("/var/datadog/preload/contents")
+	require.NoError(t, err)
+	assert.Len(t, contents, 1, "temporary files are renamed")
+}
+
+// testPreloadFilePath returns the content-addressed preload file of a content under /var/datadog.
+func testPreloadFilePath(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "/var/datadog/preload/contents/" + hex.EncodeToString(sum[:])
+}
+
+func TestPreloadContent(t *testing.T) {
+	tests := map[string]struct {
+		volumeContext map[string]string
+		expected      string
+		expectErr     bool
+	}{
+		"default launcher": {
+			volumeContext: map[string]string{},
+			expected:      defaultLauncherPath + "\n",
+		},
+		"injector version": {
+			volumeContext: map[string]string{keyInjectorVersion: "0.35.1"},
+			expected:      "/opt/datadog-packages/datadog-apm-inject/0.35.1/inject/launcher.preload.so\n",
+		},
+		"launcher path": {
+			volumeContext: map[string]string{keyInjectorLauncherPath: "/opt/custom/launcher.preload.so"},
+			expected:      "/opt/custom/launcher.preload.so\n",
+		},
+		"version escaping the packages directory": {
+			volumeContext: map[string]string{keyInjectorVersion: ".."},
+			expectErr:     true,
+		},
+		"version with a path separator": {
+			volumeContext: map[string]string{keyInjectorVersion: "stable/../../etc"},
+			expectErr:     true,
+		},
+		"launcher path with a newline": {
+			volumeContext: map[string]string{keyInjectorLauncherPath: "/opt/a.so\n/opt/b.so"},
+			expectErr:     true,
+		},
+		"relative launcher path": {
+			volumeContext: map[string]string{keyInjectorLauncherPath: "opt/launcher.so"},
+			expectErr:     true,
+		},
+		"unclean launcher path": {
+			volumeContext: map[string]string{keyInjectorLauncherPath: "/opt/../tmp/launcher.so"},
+			expectErr:     true,
+		},
+		"launcher path and version are exclusive": {
+			volumeContext: map[string]string{keyInjectorLauncherPath: "/opt/launcher.so", keyInjectorVersion: "1.0.0"},
+			expectErr:     true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			content, err := preloadContent(tc.volumeContext)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, content)
+		})
+	}
+}
+
+func TestInjectorPreloadPublisher_ContentAddressedFiles(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	mounter := mount.NewFakeMounter(nil)
+	publisher := newInjectorPreloadPublisher(fs, mounter, "/var/datadog", false, PreloadVerificationDisabled, nil, nil, false)
+	ctx := context.Background()
+
+	publish := func(volumeID string, volumeContext map[string]string) *PublisherResponse {
+		volumeContext["type"] = string(DatadogInjectorPreload)
+		resp, err := publisher.Publish(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:      volumeID,
+			TargetPath:    "/target/" + volumeID,
+			Readonly:      true,
+			VolumeContext: volumeContext,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+	unpublish := func(volumeID string) {
+		resp, err := publisher.Unpublish(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: volumeID, TargetPath: "/target/" + volumeID})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, DatadogInjectorPreload, resp.VolumeType)
+	}
+	exists := func(path string) bool {
+		exists, err := fs.Exists(path)
+		require.NoError(t, err)
+		return exists
+	}
+
+	stable := publish("vol-1", map[string]string{})
+	pinned := publish("vol-2", map[string]string{keyInjectorVersion: "0.35.1"})
+	samePinned := publish("vol-3", map[string]string{keyInjectorVersion: "0.35.1"})
+	assert.NotEqual(t, stable.HostPath, pinned.HostPath, "each content has its own file")
+	assert.Equal(t, pinned.HostPath, samePinned.HostPath, "volumes with the same content share the file")
+
+	unpublish("vol-2")
+	assert.True(t, exists(pinned.HostPath), "the file is kept while a volume uses it")
+	unpublish("vol-3")
+	assert.False(t, exists(pinned.HostPath), "unused files are garbage-collected")
+	assert.True(t, exists(stable.HostPath))
+
+	resp, err := publisher.Unpublish(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "vol-2", TargetPath: "/target/vol-2"})
+	assert.Nil(t, resp, "volumes without a reference are handled by unmountPublisher")
 	assert.NoError(t, err)
-	assert.Equal(t, defaultPreloadContent, string(content))
 }
 
 func TestInjectorPreloadPublisher_Unpublish_DelegatesToUnmount(t *testing.T) {
 	publisher := &injectorPreloadPublisher{}
-	resp, err := publisher.Unpublish(&csi.NodeUnpublishVolumeRequest{})
+	resp, err := publisher.Unpublish(context.Background(), &csi.NodeUnpublishVolumeRequest{})
 	assert.Nil(t, resp, "injectorPreload should delegate Unpublish to unmountPublisher")
 	assert.NoError(t, err)
 }
+
+// fakePodLibraries returns the same libraries for every pod.
+type fakePodLibraries []librarymanager.PodLibrary
+
+func (f fakePodLibraries) ListPodLibraries(string) ([]librarymanager.PodLibrary, error) {
+	return f, nil
+}
+
+// fakePods returns the pods it holds, keyed by namespace/name.
+type fakePods map[string]*corev1.Pod
+
+func (f fakePods) GetPod(_ context.Context, namespace, name string) (*corev1.Pod, error) {
+	pod, found := f[namespace+"/"+name]
+	if !found {
+		return nil, fmt.Errorf("pod %s/%s not found", namespace, name)
+	}
+	return pod, nil
+}
+
+// testPod returns a pod with the given CSI volumes.
+func testPod(uid string, volumeAttributes ...map[string]string) *corev1.Pod {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web", UID: types.UID(uid)}}
+	for i, attributes := range volumeAttributes {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name:         fmt.Sprintf("volume-%d", i),
+			VolumeSource: corev1.VolumeSource{CSI: &corev1.CSIVolumeSource{Driver: "k8s.csi.datadoghq.com", VolumeAttributes: attributes}},
+		})
+	}
+	return pod
+}
+
+func TestInjectorPreloadPublisher_Publish_VerifiesLauncher(t *testing.T) {
+	injector := librarymanager.PodLibrary{VolumeID: "inject", Package: "apm-inject", Path: "/store/inject"}
+	java := librarymanager.PodLibrary{VolumeID: "java", Package: "dd-lib-java-init", Path: "/store/java"}
+	// The image of this injector sets its source path in its metadata
+	customInjector := librarymanager.PodLibrary{VolumeID: "inject", Package: "apm-inject", Path: "/store/custom", SourcePath: "/injector"}
+	pod := map[string]string{podinfo.KeyPodUID: "uid-1", podinfo.KeyPodNamespace: "shop", podinfo.KeyPodName: "web"}
+	javaVolume := map[string]string{"type": string(DatadogLibrary), keyLibraryPackage: "dd-lib-java-init"}
+	injectorVolume := map[string]string{"type": string(DatadogLibrary), keyLibraryPackage: "apm-inject"}
+	librariesVolume := map[string]string{"type": string(DatadogLibraries), keyLibraries: "gcr.io/datadoghq/apm-inject:0"}
+
+	tests := map[string]struct {
+		verification    PreloadVerification
+		libraries       fakePodLibraries
+		pods            fakePods
+		untrusted       bool
+		volumeContext   map[string]string
+		expectedContent string
+		expectErr       bool
+		expectPending   bool
+	}{
+		"launcher found": {
+			verification:    PreloadVerificationFail,
+			libraries:       fakePodLibraries{java, injector},
+			volumeContext:   pod,
+			expectedContent: defaultLauncherPath + "\n",
+		},
+		"launcher found under the source path of the image": {
+			verification:    PreloadVerificationFail,
+			libraries:       fakePodLibraries{customInjector},
+			volumeContext:   pod,
+			expectedContent: defaultLauncherPath + "\n",
+		},
+		"launcher outside the source path of the image": {
+			verification:  PreloadVerificationFail,
+			libraries:     fakePodLibraries{{VolumeID: "inject", Package: "apm-inject", Path: injector.Path, SourcePath: "/injector"}},
+			volumeContext: pod,
+			expectErr:     true,
+		},
+		"no injector library fails": {
+			verification:  PreloadVerificationFail,
+			libraries:     fakePodLibraries{java},
+			volumeContext: pod,
+			expectErr:     true,
+		},
+		"no injector library mounts an empty file": {
+			verification:    PreloadVerificationEmpty,
+			libraries:       fakePodLibraries{java},
+			volumeContext:   pod,
+			expectedContent: "",
+		},
+		"injector library without the pinned launcher": {
+			verification:    PreloadVerificationEmpty,
+			libraries:       fakePodLibraries{injector},
+			volumeContext:   map[string]string{podinfo.KeyPodUID: "uid-1", keyInjectorVersion: "0.35.1"},
+			expectedContent: "",
+		},
+		"pending injector library volume": {
+			verification:  PreloadVerificationEmpty,
+			libraries:     fakePodLibraries{java},
+			pods:          fakePods{"shop/web": testPod("uid-1", javaVolume, injectorVolume)},
+			volumeContext: pod,
+			expectPending: true,
+		},
+		"pending injector library in a DatadogLibraries volume": {
+			verification:  PreloadVerificationEmpty,
+			pods:          fakePods{"shop/web": testPod("uid-1", librariesVolume)},
+			volumeContext: pod,
+			expectPending: true,
+		},
+		"pod without injector library volume mounts an empty file": {
+			verification:    PreloadVerificationEmpty,
+			libraries:       fakePodLibraries{java},
+			pods:            fakePods{"shop/web": testPod("uid-1", javaVolume)},
+			volumeContext:   pod,
+			expectedContent: "",
+		},
+		"replaced pod": {
+			verification:  PreloadVerificationEmpty,
+			pods:          fakePods{"shop/web": testPod("uid-2", injectorVolume)},
+			volumeContext: pod,
+			expectErr:     true,
+		},
+		"untrusted pod identity mounts an empty file": {
+			verification:    PreloadVerificationEmpty,
+			libraries:       fakePodLibraries{injector},
+			untrusted:       true,
+			volumeContext:   pod,
+			expectedContent: "",
+		},
+		"unknown pod": {
+			verification:  PreloadVerificationFail,
+			libraries:     fakePodLibraries{injector},
+			volumeContext: map[string]string{},
+			expectErr:     true,
+		},
+		"verification disabled": {
+			verification:    PreloadVerificationDisabled,
+			volumeContext:   map[string]string{},
+			expectedContent: defaultLauncherPath + "\n",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			fs := afero.Afero{Fs: afero.NewMemMapFs()}
+			_, err := fs.Create(filepath.Join(injector.Path, defaultLauncherPath))
+			require.NoError(t, err)
+			_, err = fs.Create(filepath.Join(customInjector.Path, "injector/stable/inject/launcher.preload.so"))
+			require.NoError(t, err)
+			mounter := mount.NewFakeMounter(nil)
+			publisher := &injectorPreloadPublisher{
+				fs:           fs,
+				mounter:      mounter,
+				contentsPath: "/var/datadog/preload/contents",
+				volumesPath:  "/var/datadog/preload/volumes",
+				verification: tc.verification,
+				libraries:    tc.libraries,
+				podTrusted:   !tc.untrusted,
+			}
+			if tc.pods != nil {
+				publisher.pods = tc.pods
+			}
+
+			volumeContext := map[string]string{"type": string(DatadogInjectorPreload)}
+			for k, v := range tc.volumeContext {
+				volumeContext[k] = v
+			}
+			resp, err := publisher.Publish(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:      "test-volume",
+				TargetPath:    "/target/ld.so.preload",
+				Readonly:      true,
+				VolumeContext: volumeContext,
+			})
+
+			require.NotNil(t, resp)
+			if tc.expectPending {
+				var pending *InjectorLibraryPendingError
+				require.ErrorAs(t, err, &pending)
+				assert.Equal(t, "shop/web", pending.Pod)
+				assert.Empty(t, mounter.GetLog())
+				return
+			}
+			if tc.expectErr {
+				assert.Error(t, err)
+				assert.Empty(t, mounter.GetLog())
+				return
+			}
+			require.NoError(t, err)
+			content, err := fs.ReadFile(resp.HostPath)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedContent, string(content))
+		})
+	}
+}
+
+func TestParsePreloadVerification(t *testing.T) {
+	verification, err := ParsePreloadVerification("")
+	require.NoError(t, err)
+	assert.Equal(t, PreloadVerificationDisabled, verification)
+
+	verification, err = ParsePreloadVerification(" fail ")
+	require.NoError(t, err)
+	assert.Equal(t, PreloadVerificationFail, verification)
+
+	_, err = ParsePreloadVerification("warn")
+	assert.Error(t, err)
+}
@@ -0,0 +1,122 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"context"
+	"fmt"
+	log "log/slog"
+	"strings"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
+)
+
+// LegacySchemaPolicy is the enforcement level of the deprecated mode/path schema.
+type LegacySchemaPolicy string
+
+const (
+	// LegacySchemaAllow publishes legacy volumes and only logs a warning
+	LegacySchemaAllow LegacySchemaPolicy = "allow"
+	// LegacySchemaWarn publishes legacy volumes and also warns through a Kubernetes event on the pod
+	LegacySchemaWarn LegacySchemaPolicy = "warn"
+	// LegacySchemaReject fails the publish of legacy volumes with the type to migrate to
+	LegacySchemaReject LegacySchemaPolicy = "reject"
+)
+
+// ParseLegacySchemaPolicy parses a legacy schema enforcement level. An empty value allows the schema.
+func ParseLegacySchemaPolicy(value string) (LegacySchemaPolicy, error) {
+	switch policy := LegacySchemaPolicy(strings.TrimSpace(value)); policy {
+	case "":
+		return LegacySchemaAllow, nil
+	case LegacySchemaAllow, LegacySchemaWarn, LegacySchemaReject:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid legacy schema policy %q, expected %q, %q or %q",
+			value, LegacySchemaAllow, LegacySchemaWarn, LegacySchemaReject)
+	}
+}
+
+// LegacySchemaUse describes a publish using the deprecated mode/path schema.
+type LegacySchemaUse struct {
+	// Mode is the "mode" attribute of the volume, "socket" or "local".
+	Mode string
+	// Path is the "path" attribute of the volume.
+	Path string
+	// Type is the volume type to migrate to, it is empty when the path is not allowed.
+	Type VolumeType
+	// Pod is the pod the volume is published for, it is empty without podInfoOnMount.
+	Pod podinfo.PodInfo
+	// Policy is the enforcement level applied to the publish.
+	Policy LegacySchemaPolicy
+}
+
+// Migration returns the volume attributes to use instead of the legacy ones.
+func (u LegacySchemaUse) Migration() string {
+	if u.Type == "" {
+		return "a volume type such as 'type: APMSocket'"
+	}
+	return fmt.Sprintf("'type: %s'", u.Type)
+}
+
+// LegacySchemaError is returned when a publish using the legacy schema is rejected.
+type LegacySchemaError struct {
+	Use LegacySchemaUse
+}
+
+func (e *LegacySchemaError) Error() string {
+	return fmt.Sprintf("the mode/path volume attributes are no longer supported (mode %q, path %q): use %s instead",
+		e.Use.Mode, e.Use.Path, e.Use.Migration())
+}
+
+// LegacySchema is the enforcement of the deprecated mode/path schema, shared by the legacy publishers.
+type LegacySchema struct {
+	// Policy is the enforcement level, the schema is allowed when it is empty.
+	Policy LegacySchemaPolicy
+	// OnPublish is called on every publish using the legacy schema, before it is rejected. It may be nil.
+	OnPublish func(ctx context.Context, use LegacySchemaUse)
+}
+
+// enforce reports a publish using the legacy schema, and returns a LegacySchemaError if the schema is rejected.
+func (l LegacySchema) enforce(ctx context.Context, use LegacySchemaUse) error {
+	use.Policy = l.Policy
+	if use.Policy == "" {
+		use.Policy = LegacySchemaAllow
+	}
+	if pod, ok := podinfo.FromContext(ctx); ok {
+		use.Pod = pod
+	}
+	log.WarnContext(ctx, fmt.Sprintf("Using deprecated mode/path schema. Please migrate to using %s instead.", use.Migration()),
+		"mode", use.Mode, "path", use.Path, "policy", use.Policy)
+	if l.OnPublish != nil {
+		l.OnPublish(ctx, use)
+	}
+	if use.Policy == LegacySchemaReject {
+		return &LegacySchemaError{Use: use}
+	}
+	return nil
+}
+
+// legacyAllowedPaths returns the host paths of the enabled volume types, which
+// are the only paths legacy volumes may mount.
+func legacyAllowedPaths(sockets SocketRegistry, volumeTypes ...VolumeType) []string {
+	var paths []string
+	for _, volumeType := range volumeTypes {
+		if path := sockets.EnabledPath(volumeType); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// legacyMigrationType returns the first of the enabled volume types mounting hostPath, if any.
+func legacyMigrationType(sockets SocketRegistry, hostPath string, volumeTypes ...VolumeType) VolumeType {
+	for _, volumeType := range volumeTypes {
+		if path := sockets.EnabledPath(volumeType); path != "" && path == hostPath {
+			return volumeType
+		}
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/mount"
+)
+
+func TestParseLegacySchemaPolicy(t *testing.T) {
+	tests := map[string]struct {
+		value     string
+		expected  LegacySchemaPolicy
+		expectErr bool
+	}{
+		"empty allows":    {value: "", expected: LegacySchemaAllow},
+		"allow":           {value: "allow", expected: LegacySchemaAllow},
+		"warn":            {value: " warn ", expected: LegacySchemaWarn},
+		"reject":          {value: "reject", expected: LegacySchemaReject},
+		"unknown policy":  {value: "deny", expectErr: true},
+		"case sensitive":  {value: "Reject", expectErr: true},
+		"no partial name": {value: "rej", expectErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			policy, err := ParseLegacySchemaPolicy(tc.value)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, policy)
+		})
+	}
+}
+
+func TestLegacySchema(t *testing.T) {
+	const apmSocketPath, dsdSocketPath = "/var/run/datadog/apm.socket", "/var/run/datadog/dsd.socket"
+	pod := podinfo.PodInfo{Name: "web-1", Namespace: "shop", UID: "uid-1"}
+
+	tests := map[string]struct {
+		policy       LegacySchemaPolicy
+		volumeCtx    map[string]string
+		expectedUse  LegacySchemaUse
+		expectReject bool
+	}{
+		"socket allowed by default": {
+			volumeCtx:   map[string]string{"mode": modeSocket, "path": dsdSocketPath},
+			expectedUse: LegacySchemaUse{Mode: modeSocket, Path: dsdSocketPath, Type: DSDSocket, Pod: pod, Policy: LegacySchemaAllow},
+		},
+		"local warned": {
+			policy:      LegacySchemaWarn,
+			volumeCtx:   map[string]string{"mode": modeLocal, "path": "/var/run/datadog"},
+			expectedUse: LegacySchemaUse{Mode: modeLocal, Path: "/var/run/datadog", Type: APMSocketDirectory, Pod: pod, Policy: LegacySchemaWarn},
+		},
+		"socket rejected": {
+			policy:       LegacySchemaReject,
+			volumeCtx:    map[string]string{"mode": modeSocket, "path": apmSocketPath},
+			expectedUse:  LegacySchemaUse{Mode: modeSocket, Path: apmSocketPath, Type: APMSocket, Pod: pod, Policy: LegacySchemaReject},
+			expectReject: true,
+		},
+		"disallowed path rejected without migration type": {
+			policy:       LegacySchemaReject,
+			volumeCtx:    map[string]string{"mode": modeLocal, "path": "/etc"},
+			expectedUse:  LegacySchemaUse{Mode: modeLocal, Path: "/etc", Pod: pod, Policy: LegacySchemaReject},
+			expectReject: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var uses []LegacySchemaUse
+			legacySchema := LegacySchema{Policy: tc.policy, OnPublish: func(_ context.Context, use LegacySchemaUse) {
+				uses = append(uses, use)
+			}}
+			fs := afero.Afero{Fs: afero.NewMemMapFs()}
+			mounter := mount.NewFakeMounter(nil)
+			publisher := newChainPublisher(
+				newSocketLegacyPublisher(fs, mounter, testSocketRegistry(t, apmSocketPath, dsdSocketPath), legacySchema),
+				newLocalLegacyPublisher(fs, mounter, testSocketRegistry(t, apmSocketPath, dsdSocketPath), legacySchema),
+			)
+
+			ctx := podinfo.NewContext(context.Background(), pod)
+			resp, err := publisher.Publish(ctx, &csi.NodePublishVolumeRequest{
+				VolumeId:      "vol-1",
+				TargetPath:    "/target",
+				VolumeContext: tc.volumeCtx,
+			})
+			require.NotNil(t, resp)
+			assert.Equal(t, []LegacySchemaUse{tc.expectedUse}, uses)
+
+			var legacySchemaErr *LegacySchemaError
+			if tc.expectReject {
+				require.True(t, errors.As(err, &legacySchemaErr))
+				assert.Equal(t, tc.expectedUse, legacySchemaErr.Use)
+				if tc.expectedUse.Type != "" {
+					assert.Contains(t, err.Error(), "type: "+string(tc.expectedUse.Type))
+				}
+				assert.Empty(t, mounter.GetLog())
+				return
+			}
+			// The publish goes on, here up to the missing socket or directory
+			assert.False(t, errors.As(err, &legacySchemaErr))
+		})
+	}
+}
@@ -0,0 +1,259 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/librarymanager"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/spf13/afero"
+	"k8s.io/utils/mount"
+)
+
+const (
+	// keyLibraries is the VolumeContext key listing the libraries of a
+	// DatadogLibraries volume, as a comma-separated list of
+	// <registry>/<package>:<version> references.
+	keyLibraries = "dd.csi.datadog.com/libraries"
+
+	// librariesDir is the directory of the per-volume library directories, under the storage base path
+	librariesDir = "libraries"
+)
+
+// librariesPublisher handles DatadogLibraries volumes. It links all the
+// requested libraries to the volume at once and mounts a private directory
+// per volume, holding one read-only bind mount per package.
+type librariesPublisher struct {
+	fs             afero.Afero
+	mounter        mount.Interface
+	libraryManager *librarymanager.LibraryManager
+	// disabled indicates if SSI is disabled (publish requests will be rejected)
+	disabled bool
+	// allowedRegistries is the list of registries that are allowed to be used.
+	// If empty, all registries are allowed.
+	allowedRegistries []string
+	// basePath is the directory holding one private directory per volume
+	basePath string
+}
+
+// Publish downloads the libraries if needed, binds each of them into the private
+// directory of the volume and mounts that directory to the target path.
+func (s librariesPublisher) Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
+	volumeCtx := req.GetVolumeContext()
+	if VolumeType(volumeCtx["type"]) != DatadogLibraries {
+		return nil, nil // Not our volume
+	}
+	resp := &PublisherResponse{VolumeType: DatadogLibraries}
+
+	// Reject publish requests if SSI is disabled
+	if s.disabled {
+		return resp, fmt.Errorf("SSI is disabled, library volumes cannot be published")
+	}
+
+	// Defensive code: library volumes must be mounted in read-only mode to protect the shared store
+	if !req.GetReadonly() {
+		return resp, fmt.Errorf("library volumes must be mounted in read-only mode")
+	}
+
+	volumeID := req.GetVolumeId()
+	dir, err := volumeDir(s.basePath, volumeID)
+	if err != nil {
+		return resp, err
+	}
+	resp.VolumePath = dir
+	resp.HostPath = dir
+
+	libs, err := parseLibraries(volumeCtx[keyLibraries])
+	if err != nil {
+		return resp, fmt.Errorf("invalid library configuration: %w", err)
+	}
+	for _, lib := range libs {
+		if len(s.allowedRegistries) > 0 && !slices.Contains(s.allowedRegistries, lib.Registry()) {
+			return resp, fmt.Errorf("registry %q is not in the allow list", lib.Registry())
+		}
+	}
+
+	// Only what this publish creates is rolled back on failure: a volume
+	// already published may be serving a running pod.
+	published, err := publishedBefore(s.libraryManager, s.mounter, volumeID, req.GetTargetPath())
+	if err != nil {
+		return resp, err
+	}
+	dirExisted, err := s.fs.DirExists(dir)
+	if err != nil {
+		return resp, fmt.Errorf("failed to check library directory: %w", err)
+	}
+
+	basePaths, err := s.libraryManager.GetLibrariesForVolume(ctx, volumeID, libs)
+	if err != nil {
+		return resp, fmt.Errorf("failed to get libraries for volume: %w", err)
+	}
+
+	// The libraries are linked to the volume from here on: every failure of a
+	// new volume rolls back the link, since Unpublish only finds volumes by
+	// their directory.
+	err = s.mountLibraries(ctx, volumeID, dir, req.GetTargetPath(), libs, basePaths)
+	if err != nil && !published {
+		// The rollback must not be skipped when the request is cancelled. The
+		// target path is not mounted, since its bind mount is the last step.
+		rollbackCtx := context.Background()
+		var removeErrs []error
+		if !dirExisted {
+			removeErrs = append(removeErrs, clearPrivateDir(rollbackCtx, s.fs, s.mounter, dir))
+		}
+		removeErrs = append(removeErrs, s.libraryManager.RemoveVolume(rollbackCtx, volumeID))
+		if removeErr := errors.Join(removeErrs...); removeErr != nil {
+			return resp, fmt.Errorf("%w; additionally failed to roll back volume link: %v", err, removeErr)
+		}
+	}
+	return resp, err
+}
+
+// mountLibraries binds each library into the private directory of the volume and mounts that directory to the
+// target path.
+func (s librariesPublisher) mountLibraries(ctx context.Context, volumeID, dir, targetPath string, libs []*librarymanager.Library, basePaths []string) error {
+	// The source paths recorded from the image metadata are in the order of libs
+	sourcePaths, err := s.libraryManager.GetVolumeSourcePaths(volumeID)
+	if err != nil {
+		return err
+	}
+	if len(sourcePaths) != len(libs) {
+		return fmt.Errorf("volume is linked to %d libraries, expected %d", len(sourcePaths), len(libs))
+	}
+	sources := make([]string, len(libs))
+	for i, lib := range libs {
+		sources[i], err = librarySourcePath(basePaths[i], lib.Name(), sourcePaths[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := s.fs.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create library directory: %w", err)
+	}
+	for i, lib := range libs {
+		if err := bindMount(ctx, s.fs, s.mounter, bindMountArgs{
+			hostPath:   sources[i],
+			targetPath: filepath.Join(dir, lib.Name()),
+			isFile:     false,
+			readOnly:   true,
+			options:    mountOptions(DatadogLibraries),
+		}); err != nil {
+			return err
+		}
+	}
+
+	return bindMount(ctx, s.fs, s.mounter, bindMountArgs{
+		hostPath:   dir,
+		targetPath: targetPath,
+		isFile:     false,
+		readOnly:   true,
+		recursive:  true,
+		options:    mountOptions(DatadogLibraries),
+	})
+}
+
+// Unpublish unmounts the volume, removes its private directory and unlinks all its libraries at once.
+func (s librariesPublisher) Unpublish(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+	// We don't have VolumeContext in Unpublish, so we check if the volume has a private directory
+	volumeID := req.GetVolumeId()
+	dir, err := volumeDir(s.basePath, volumeID)
+	if err != nil {
+		return nil, nil // Not our volume
+	}
+	exists, err := s.fs.DirExists(dir)
+	if err != nil || !exists {
+		return nil, nil // Not our volume
+	}
+	resp := &PublisherResponse{VolumeType: DatadogLibraries, VolumePath: dir, HostPath: dir}
+
+	if err := removePrivateDir(ctx, s.fs, s.mounter, dir, req.GetTargetPath()); err != nil {
+		return resp, err
+	}
+
+	// Remove volume tracking (this will also delete the libraries from disk if no longer used)
+	if err := s.libraryManager.RemoveVolume(ctx, volumeID); err != nil {
+		return resp, fmt.Errorf("failed to remove volume tracking: %w", err)
+	}
+	return resp, nil
+}
+
+// Stats reports the usage of the libraries mounted by the volume.
+// The volume is reported as abnormal when the store entry of one of its libraries disappeared.
+func (s librariesPublisher) Stats(_ context.Context, req *csi.NodeGetVolumeStatsRequest) (*VolumeStats, error) {
+	dir, err := volumeDir(s.basePath, req.GetVolumeId())
+	if err != nil {
+		return nil, nil // Not our volume
+	}
+	exists, err := s.fs.DirExists(dir)
+	if err != nil || !exists {
+		return nil, nil // Not our volume
+	}
+
+	if _, err := s.libraryManager.GetVolumePaths(req.GetVolumeId()); err != nil {
+		if errors.Is(err, librarymanager.ErrItemNotFound) {
+			return &VolumeStats{
+				VolumeType: DatadogLibraries,
+				Abnormal:   true,
+				Message:    "a library linked to this volume is missing from the driver store, restart the pod to download it again",
+			}, nil
+		}
+		return &VolumeStats{VolumeType: DatadogLibraries}, fmt.Errorf("failed to resolve the libraries of the volume: %w", err)
+	}
+
+	usedBytes, usedInodes, err := pathUsage(s.fs, req.GetVolumePath())
+	if err != nil {
+		return &VolumeStats{VolumeType: DatadogLibraries}, fmt.Errorf("failed to compute library usage: %w", err)
+	}
+	return &VolumeStats{VolumeType: DatadogLibraries, UsedBytes: usedBytes, UsedInodes: usedInodes}, nil
+}
+
+// parseLibraries parses the libraries listed by a DatadogLibraries volume.
+// Each package can only be listed once, since it names its sub-directory in the volume.
+func parseLibraries(value string) ([]*librarymanager.Library, error) {
+	var libs []*librarymanager.Library
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		lib, err := librarymanager.ParseLibraryReference(item, true)
+		if err != nil {
+			return nil, err
+		}
+		pkg := lib.Name()
+		if pkg == "." || pkg == ".." {
+			return nil, fmt.Errorf("invalid package %q", pkg)
+		}
+		if slices.ContainsFunc(libs, func(other *librarymanager.Library) bool { return other.Name() == pkg }) {
+			return nil, fmt.Errorf("package %q is listed twice", pkg)
+		}
+		libs = append(libs, lib)
+	}
+	if len(libs) == 0 {
+		return nil, fmt.Errorf("%s must list at least one library", keyLibraries)
+	}
+	return libs, nil
+}
+
+func newLibrariesPublisher(fs afero.Afero, mounter mount.Interface, libraryManager *librarymanager.LibraryManager,
+	disabled bool, allowedRegistries []string, storageBasePath string) Publisher {
+	return librariesPublisher{
+		fs:                fs,
+		mounter:           mounter,
+		libraryManager:    libraryManager,
+		disabled:          disabled,
+		allowedRegistries: allowedRegistries,
+		basePath:          filepath.Join(storageBasePath, librariesDir),
+	}
+}
@@ -0,0 +1,247 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/librarymanager"
+	"github.com/Datadog/datadog-csi-driver/pkg/testutil"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/mount"
+)
+
+func TestParseLibraries(t *testing.T) {
+	tests := map[string]struct {
+		value     string
+		expected  []string
+		expectErr bool
+	}{
+		"single library":       {value: "gcr.io/datadoghq/apm-inject:0.35.0", expected: []string{"gcr.io/datadoghq/apm-inject:0.35.0"}},
+		"several libraries":    {value: "gcr.io/datadoghq/apm-inject:0.35.0, gcr.io/datadoghq/dd-lib-java-init:v1", expected: []string{"gcr.io/datadoghq/apm-inject:0.35.0", "gcr.io/datadoghq/dd-lib-java-init:v1"}},
+		"registry with a port": {value: "localhost:5000/apm-inject:0.35.0", expected: []string{"localhost:5000/apm-inject:0.35.0"}},
+		"digest":               {value: "gcr.io/datadoghq/apm-inject@sha256:abc", expected: []string{"gcr.io/datadoghq/apm-inject@sha256:abc"}},
+		"missing registry":     {value: "apm-inject:0.35.0", expectErr: true},
+		"missing version":      {value: "gcr.io/datadoghq/apm-inject", expectErr: true},
+		"package listed twice": {value: "gcr.io/datadoghq/apm-inject:1,docker.io/datadog/apm-inject:2", expectErr: true},
+		"invalid package":      {value: "gcr.io/..:1", expectErr: true},
+		"empty list":           {value: " , ", expectErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			libs, err := parseLibraries(tc.value)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			var images []string
+			for _, lib := range libs {
+				images = append(images, lib.Image())
+			}
+			assert.Equal(t, tc.expected, images)
+		})
+	}
+}
+
+func TestLibrariesPublisher(t *testing.T) {
+	localRegistry := testutil.NewLocalRegistry(t)
+	defer localRegistry.Stop()
+	localRegistry.AddImage(t, "../../librarymanager/testdata/image.tar", "test-image", "v1.0.0")
+	localRegistry.AddImage(t, createInjectorLibraryImage(t), injectorPackage, "v1.0.0")
+	registry := localRegistry.Registry(t)
+
+	basePath := t.TempDir()
+	fs := afero.Afero{Fs: afero.NewOsFs()}
+	lm, err := librarymanager.NewLibraryManager(basePath,
+		librarymanager.WithFilesystem(fs),
+		librarymanager.WithDownloader(librarymanager.NewDownloaderWithRoundTripper(localRegistry.GetRoundTripper(t))),
+	)
+	require.NoError(t, err)
+	defer lm.Stop()
+
+	mounter := mount.NewFakeMounter(nil)
+	publisher := newLibrariesPublisher(fs, mounter, lm, false, nil, basePath)
+	targetPath := filepath.Join(t.TempDir(), "target")
+	volumeDir := filepath.Join(basePath, librariesDir, "vol-1")
+	ctx := context.Background()
+
+	t.Run("other volume types are not supported", func(t *testing.T) {
+		resp, err := publisher.Publish(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:      "vol-1",
+			TargetPath:    targetPath,
+			Readonly:      true,
+			VolumeContext: map[string]string{"type": string(DatadogLibrary)},
+		})
+		assert.Nil(t, resp)
+		assert.NoError(t, err)
+	})
+
+	t.Run("registries must be allowed", func(t *testing.T) {
+		publisher := newLibrariesPublisher(fs, mounter, lm, false, []string{"gcr.io/datadoghq"}, basePath)
+		resp, err := publisher.Publish(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:   "vol-1",
+			TargetPath: targetPath,
+			Readonly:   true,
+			VolumeContext: map[string]string{
+				"type":       string(DatadogLibraries),
+				keyLibraries: "gcr.io/datadoghq/apm-inject:v1.0.0," + registry + "/test-image:v1.0.0",
+			},
+		})
+		require.NotNil(t, resp)
+		assert.ErrorContains(t, err, "is not in the allow list")
+		assert.Empty(t, mounter.GetLog())
+	})
+
+	t.Run("publish binds every library into the volume", func(t *testing.T) {
+		resp, err := publisher.Publish(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:   "vol-1",
+			TargetPath: targetPath,
+			Readonly:   true,
+			VolumeContext: map[string]string{
+				"type":       string(DatadogLibraries),
+				keyLibraries: registry + "/apm-inject:v1.0.0," + registry + "/test-image:v1.0.0",
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, DatadogLibraries, resp.VolumeType)
+		assert.Equal(t, volumeDir, resp.HostPath)
+
+		paths, err := lm.GetVolumePaths("vol-1")
+		require.NoError(t, err)
+		require.Len(t, paths, 2)
+
+		mountPoints, err := mounter.List()
+		require.NoError(t, err)
+		require.Len(t, mountPoints, 3)
+		assert.Equal(t, filepath.Join(paths[0], injectorLibrarySourcePath), mountPoints[0].Device)
+		assert.Equal(t, filepath.Join(volumeDir, injectorPackage), mountPoints[0].Path)
+		assert.Equal(t, filepath.Join(paths[1], languageLibrarySourcePath), mountPoints[1].Device)
+		assert.Equal(t, filepath.Join(volumeDir, "test-image"), mountPoints[1].Path)
+		assert.Equal(t, volumeDir, mountPoints[2].Device)
+		assert.Equal(t, targetPath, mountPoints[2].Path)
+		assert.Equal(t, []string{"rbind", "ro", "nosuid", "nodev"}, mountPoints[2].Opts)
+	})
+
+	t.Run("a failed republish keeps the published volume", func(t *testing.T) {
+		// The retry fails to bind the first library again, the running pod keeps its volume
+		entry := filepath.Join(volumeDir, injectorPackage)
+		mounter.MountCheckErrors = map[string]error{entry: errors.New("stat failed")}
+		t.Cleanup(func() { mounter.MountCheckErrors = nil })
+
+		resp, err := publisher.Publish(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:   "vol-1",
+			TargetPath: targetPath,
+			Readonly:   true,
+			VolumeContext: map[string]string{
+				"type":       string(DatadogLibraries),
+				keyLibraries: registry + "/apm-inject:v1.0.0," + registry + "/test-image:v1.0.0",
+			},
+		})
+		require.NotNil(t, resp)
+		assert.ErrorContains(t, err, "stat failed")
+
+		exists, err := fs.Exists(entry)
+		require.NoError(t, err)
+		assert.True(t, exists)
+		mountPoints, err := mounter.List()
+		require.NoError(t, err)
+		assert.Len(t, mountPoints, 3)
+		linked, err := lm.IsVolumeLinked("vol-1")
+		require.NoError(t, err)
+		assert.True(t, linked)
+	})
+
+	t.Run("stats report the usage of the volume", func(t *testing.T) {
+		stats, err := publisher.Stats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: "vol-1", VolumePath: targetPath})
+		require.NoError(t, err)
+		require.NotNil(t, stats)
+		assert.Equal(t, DatadogLibraries, stats.VolumeType)
+		assert.False(t, stats.Abnormal)
+	})
+
+	t.Run("unpublish unlinks every library", func(t *testing.T) {
+		resp, err := publisher.Unpublish(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "vol-1", TargetPath: targetPath})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, DatadogLibraries, resp.VolumeType)
+
+		exists, err := fs.Exists(volumeDir)
+		require.NoError(t, err)
+		assert.False(t, exists)
+		mountPoints, err := mounter.List()
+		require.NoError(t, err)
+		assert.Empty(t, mountPoints)
+		paths, err := lm.GetVolumePaths("vol-1")
+		require.NoError(t, err)
+		assert.Empty(t, paths)
+
+		resp, err = publisher.Unpublish(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "vol-1", TargetPath: targetPath})
+		assert.Nil(t, resp, "unknown volumes belong to other publishers")
+		assert.NoError(t, err)
+	})
+
+	t.Run("a failed publish rolls back the link", func(t *testing.T) {
+		// The second library cannot be bound into the volume
+		mounter.MountCheckErrors = map[string]error{filepath.Join(volumeDir, "test-image"): errors.New("stat failed")}
+		t.Cleanup(func() { mounter.MountCheckErrors = nil })
+
+		resp, err := publisher.Publish(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:   "vol-1",
+			TargetPath: targetPath,
+			Readonly:   true,
+			VolumeContext: map[string]string{
+				"type":       string(DatadogLibraries),
+				keyLibraries: registry + "/apm-inject:v1.0.0," + registry + "/test-image:v1.0.0",
+			},
+		})
+		require.NotNil(t, resp)
+		assert.ErrorContains(t, err, "stat failed")
+
+		exists, err := fs.Exists(volumeDir)
+		require.NoError(t, err)
+		assert.False(t, exists)
+		mountPoints, err := mounter.List()
+		require.NoError(t, err)
+		assert.Empty(t, mountPoints)
+		linked, err := lm.IsVolumeLinked("vol-1")
+		require.NoError(t, err)
+		assert.False(t, linked)
+	})
+}
+
+// createInjectorLibraryImage builds an image holding an empty injector package directory.
+func createInjectorLibraryImage(t *testing.T) string {
+	t.Helper()
+
+	rootfs := filepath.Join(t.TempDir(), "rootfs.tar")
+	f, err := os.Create(rootfs)
+	require.NoError(t, err)
+
+	tw := tar.NewWriter(f)
+	for _, dir := range []string{"opt", "opt/datadog-packages", "opt/datadog-packages/datadog-apm-inject"} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     dir,
+			Typeflag: tar.TypeDir,
+			Mode:     0755,
+		}))
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, f.Close())
+
+	imageTar := filepath.Join(t.TempDir(), "image.tar")
+	require.NoError(t, tarballImage(t, rootfs, imageTar))
+	return imageTar
+}
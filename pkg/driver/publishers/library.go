@@ -7,9 +7,12 @@ package publishers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/Datadog/datadog-csi-driver/pkg/librarymanager"
@@ -23,10 +26,21 @@ const (
 	keyLibraryPackage  = "dd.csi.datadog.com/library.package"
 	keyLibraryRegistry = "dd.csi.datadog.com/library.registry"
 	keyLibraryVersion  = "dd.csi.datadog.com/library.version"
+	// keyLibrarySourcePath overrides the directory of the library image that is mounted
+	keyLibrarySourcePath = "dd.csi.datadog.com/library.source-path"
+	// keyLibraryWritable mounts a writable overlay of the library instead of a read-only bind mount
+	keyLibraryWritable = "dd.csi.datadog.com/library.writable"
 
-	// Source path inside the OCI images
+	// injectorPackage is the package of the injector library
+	injectorPackage = "apm-inject"
+
+	// Default source paths inside the OCI images, for images whose metadata
+	// does not set librarymanager.SourcePathLabel
 	languageLibrarySourcePath = "/datadog-init/package"
 	injectorLibrarySourcePath = "/opt/datadog-packages/datadog-apm-inject"
+
+	// overlaysDir is the directory of the per-volume overlay layers, under the storage base path
+	overlaysDir = "overlays"
 )
 
 // libraryPublisher handles DatadogLibrary volumes.
@@ -40,10 +54,13 @@ type libraryPublisher struct {
 	// allowedRegistries is the list of registries that are allowed to be used.
 	// If empty, all registries are allowed.
 	allowedRegistries []string
+	// overlaysPath is the directory holding the upper and work directories of writable volumes
+	overlaysPath string
 }
 
 // Publish downloads the library from the OCI registry if needed and bind-mounts it to the target path.
-func (s libraryPublisher) Publish(req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
+// A writable volume is mounted as an overlay of the library instead, so that writes never reach the shared store.
+func (s libraryPublisher) Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
 	volumeCtx := req.GetVolumeContext()
 	if VolumeType(volumeCtx["type"]) != DatadogLibrary {
 		return nil, nil // Not our volume
@@ -54,38 +71,51 @@ func (s libraryPublisher) Publish(req *csi.NodePublishVolumeRequest) (*Publisher
 		return &PublisherResponse{VolumeType: DatadogLibrary}, fmt.Errorf("SSI is disabled, library volumes cannot be published")
 	}
 
-	// Defensive code: library volumes must be mounted in read-only mode to protect the shared store
-	if !req.GetReadonly() {
-		return &PublisherResponse{VolumeType: DatadogLibrary}, fmt.Errorf("library volumes must be mounted in read-only mode")
+	writable, err := isLibraryWritable(volumeCtx)
+	if err != nil {
+		return &PublisherResponse{VolumeType: DatadogLibrary}, err
+	}
+
+	// Defensive code: library volumes must be mounted in read-only mode to protect the shared store,
+	// unless they opted in to a writable overlay
+	if !req.GetReadonly() && !writable {
+		return &PublisherResponse{VolumeType: DatadogLibrary},
+			fmt.Errorf("library volumes must be mounted in read-only mode, unless %s is set", keyLibraryWritable)
 	}
 
-	registry := volumeCtx[keyLibraryRegistry]
+	registry, _, _ := LibraryFromVolumeContext(volumeCtx)
 	if !s.registryAllowed(registry) {
 		return &PublisherResponse{VolumeType: DatadogLibrary}, fmt.Errorf("registry %q is not in the allow list", registry)
 	}
 
-	libraryPath, image, err := s.getLibraryPath(volumeCtx, req.GetVolumeId())
+	libraryPath, image, err := s.getLibraryPath(ctx, volumeCtx, req.GetVolumeId(), req.GetTargetPath())
 	if err != nil {
 		return &PublisherResponse{VolumeType: DatadogLibrary, VolumePath: image}, err
 	}
 
-	err = bindMount(s.fs, s.mounter, bindMountArgs{
-		hostPath:   libraryPath,
-		targetPath: req.GetTargetPath(),
-		isFile:     false,
-		readOnly:   true,
-	})
+	// A read-only volume has no use for an overlay
+	if writable && !req.GetReadonly() {
+		err = s.mountOverlay(ctx, req.GetVolumeId(), libraryPath, req.GetTargetPath())
+	} else {
+		err = bindMount(ctx, s.fs, s.mounter, bindMountArgs{
+			hostPath:   libraryPath,
+			targetPath: req.GetTargetPath(),
+			isFile:     false,
+			readOnly:   true,
+			options:    mountOptions(DatadogLibrary),
+		})
+	}
 	if err != nil {
 		return &PublisherResponse{VolumeType: DatadogLibrary, VolumePath: image}, err
 	}
 
-	return &PublisherResponse{VolumeType: DatadogLibrary, VolumePath: image}, nil
+	return &PublisherResponse{VolumeType: DatadogLibrary, VolumePath: image, HostPath: libraryPath}, nil
 }
 
 // Unpublish unmounts the library from the target path.
 // For inline CSI volumes, Kubernetes doesn't call Unstage, so we also remove the volume
 // tracking here to ensure libraries are cleaned up when no longer used.
-func (s libraryPublisher) Unpublish(req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+func (s libraryPublisher) Unpublish(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
 	// We don't have VolumeContext in Unpublish, so we check if the volume is managed by us
 	volumeID := req.GetVolumeId()
 
@@ -100,14 +130,22 @@ func (s libraryPublisher) Unpublish(req *csi.NodeUnpublishVolumeRequest) (*Publi
 
 	// Unmount the library from the target path
 	targetPath := req.GetTargetPath()
-	err = bindUnmount(s.fs, s.mounter, targetPath)
+	err = bindUnmount(ctx, s.fs, s.mounter, targetPath)
 	if err != nil {
 		return &PublisherResponse{VolumeType: DatadogLibrary, VolumePath: ""},
 			fmt.Errorf("failed to unmount library: %w", err)
 	}
 
+	// Drop the writes of a writable volume
+	if dir, err := volumeDir(s.overlaysPath, volumeID); err == nil {
+		if err := s.fs.RemoveAll(dir); err != nil {
+			return &PublisherResponse{VolumeType: DatadogLibrary, VolumePath: ""},
+				fmt.Errorf("failed to remove library overlay: %w", err)
+		}
+	}
+
 	// Remove volume tracking (this will also delete the library from disk if no longer used)
-	err = s.libraryManager.RemoveVolume(context.Background(), volumeID)
+	err = s.libraryManager.RemoveVolume(ctx, volumeID)
 	if err != nil {
 		return &PublisherResponse{VolumeType: DatadogLibrary, VolumePath: ""},
 			fmt.Errorf("failed to remove volume tracking: %w", err)
@@ -116,44 +154,185 @@ func (s libraryPublisher) Unpublish(req *csi.NodeUnpublishVolumeRequest) (*Publi
 	return &PublisherResponse{VolumeType: DatadogLibrary, VolumePath: ""}, nil
 }
 
+// Stats reports the usage of the store entry backing a library volume, and of
+// the upper layer of writable volumes. The volume is reported as abnormal when
+// its store entry disappeared.
+func (s libraryPublisher) Stats(_ context.Context, req *csi.NodeGetVolumeStatsRequest) (*VolumeStats, error) {
+	// We don't have VolumeContext in Stats, so we resolve the volume through the library manager
+	storePath, err := s.libraryManager.GetVolumePath(req.GetVolumeId())
+	if err != nil {
+		if errors.Is(err, librarymanager.ErrItemNotFound) {
+			return &VolumeStats{
+				VolumeType: DatadogLibrary,
+				Abnormal:   true,
+				Message:    "the library linked to this volume is missing from the driver store, restart the pod to download it again",
+			}, nil
+		}
+		return &VolumeStats{VolumeType: DatadogLibrary}, fmt.Errorf("failed to resolve the library of the volume: %w", err)
+	}
+	if storePath == "" {
+		return nil, nil // Not our volume
+	}
+
+	usedBytes, usedInodes, err := pathUsage(s.fs, storePath)
+	if err != nil {
+		return &VolumeStats{VolumeType: DatadogLibrary}, fmt.Errorf("failed to compute library usage: %w", err)
+	}
+	if dir, err := volumeDir(s.overlaysPath, req.GetVolumeId()); err == nil {
+		upperDir := filepath.Join(dir, overlayUpperDir)
+		if exists, _ := s.fs.DirExists(upperDir); exists {
+			upperBytes, upperInodes, err := pathUsage(s.fs, upperDir)
+			if err != nil {
+				return &VolumeStats{VolumeType: DatadogLibrary}, fmt.Errorf("failed to compute library overlay usage: %w", err)
+			}
+			usedBytes += upperBytes
+			usedInodes += upperInodes
+		}
+	}
+	return &VolumeStats{VolumeType: DatadogLibrary, UsedBytes: usedBytes, UsedInodes: usedInodes}, nil
+}
+
+// isLibraryWritable returns whether a DatadogLibrary volume requests a writable overlay.
+func isLibraryWritable(volumeCtx map[string]string) (bool, error) {
+	value, ok := volumeCtx[keyLibraryWritable]
+	if !ok {
+		return false, nil
+	}
+	writable, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: %w", keyLibraryWritable, value, err)
+	}
+	return writable, nil
+}
+
+// mountOverlay mounts a writable overlay of a library, whose upper layer is private to the volume.
+func (s libraryPublisher) mountOverlay(ctx context.Context, volumeID, libraryPath, targetPath string) error {
+	dir, err := volumeDir(s.overlaysPath, volumeID)
+	if err != nil {
+		return err
+	}
+	return overlayMount(ctx, s.fs, s.mounter, overlayMountArgs{
+		lowerDir:   libraryPath,
+		upperDir:   filepath.Join(dir, overlayUpperDir),
+		workDir:    filepath.Join(dir, overlayWorkDir),
+		targetPath: targetPath,
+		options:    mountOptions(DatadogLibrary),
+	})
+}
+
+// LibraryFromVolumeContext returns the registry, package and version requested
+// by the volume context of a DatadogLibrary volume.
+func LibraryFromVolumeContext(volumeCtx map[string]string) (registry, pkg, version string) {
+	return volumeCtx[keyLibraryRegistry], volumeCtx[keyLibraryPackage], volumeCtx[keyLibraryVersion]
+}
+
 // getLibraryPath downloads the library if needed and returns the local path to mount.
 // The returned path includes the source subdirectory from the volume context.
 // Returns the path and the image reference for metrics.
-func (s libraryPublisher) getLibraryPath(volumeCtx map[string]string, volumeID string) (path, image string, err error) {
-	pkg := volumeCtx[keyLibraryPackage]
-	registry := volumeCtx[keyLibraryRegistry]
-	version := volumeCtx[keyLibraryVersion]
+func (s libraryPublisher) getLibraryPath(ctx context.Context, volumeCtx map[string]string, volumeID, targetPath string) (path, image string, err error) {
+	registry, pkg, version := LibraryFromVolumeContext(volumeCtx)
 
 	lib, err := librarymanager.NewLibrary(pkg, registry, version, true)
 	if err != nil {
 		return "", "", fmt.Errorf("invalid library configuration: %w", err)
 	}
+	sourcePath := volumeCtx[keyLibrarySourcePath]
+	if sourcePath != "" {
+		if err := checkSourcePath(sourcePath); err != nil {
+			return "", lib.Image(), fmt.Errorf("invalid %s: %w", keyLibrarySourcePath, err)
+		}
+	}
 
-	basePath, err := s.libraryManager.GetLibraryForVolume(context.Background(), volumeID, lib)
+	// Only a link this publish creates is rolled back on failure: a volume
+	// already published may be serving a running pod.
+	published, err := publishedBefore(s.libraryManager, s.mounter, volumeID, targetPath)
+	if err != nil {
+		return "", lib.Image(), err
+	}
+
+	basePath, err := s.libraryManager.GetLibraryForVolume(ctx, volumeID, lib)
 	if err != nil {
 		return "", lib.Image(), fmt.Errorf("failed to get library for volume: %w", err)
 	}
 
 	// Append the source path to mount only the requested subdirectory
-	source := languageLibrarySourcePath
-	if pkg == "apm-inject" {
-		source = injectorLibrarySourcePath
+	if sourcePath == "" {
+		var sourcePaths []string
+		sourcePaths, err = s.libraryManager.GetVolumeSourcePaths(volumeID)
+		if err == nil && len(sourcePaths) > 0 {
+			sourcePath = sourcePaths[0]
+		}
 	}
-
-	// Remove leading slash from source to join paths correctly
-	source = strings.TrimPrefix(source, "/")
-	path = filepath.Join(basePath, source)
-	path, err = validateLibrarySourcePath(basePath, path)
-	if err != nil {
+	if err == nil {
+		path, err = librarySourcePath(basePath, pkg, sourcePath)
+	}
+	if err != nil && !published {
+		// The rollback must not be skipped when the request is cancelled.
 		if removeErr := s.libraryManager.RemoveVolume(context.Background(), volumeID); removeErr != nil {
 			return "", lib.Image(), fmt.Errorf("%w; additionally failed to roll back volume link: %v", err, removeErr)
 		}
+	}
+	if err != nil {
 		return "", lib.Image(), err
 	}
 
 	return path, lib.Image(), nil
 }
 
+// publishedBefore returns whether a library volume is already linked or its
+// target path already mounted, e.g. when kubelet retries the publish of a
+// volume serving a running pod after a driver restart. A failed publish must
+// then leave the volume as it was instead of rolling it back.
+func publishedBefore(lm *librarymanager.LibraryManager, mounter mount.Interface, volumeID, targetPath string) (bool, error) {
+	linked, err := lm.IsVolumeLinked(volumeID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check volume tracking: %w", err)
+	}
+	if linked {
+		return true, nil
+	}
+	notMnt, err := mount.IsNotMountPoint(mounter, targetPath)
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to check target path mount: %w", err)
+	}
+	return err == nil && !notMnt, nil
+}
+
+// librarySourcePath returns the subdirectory of a downloaded library that is
+// mounted, resolved and validated by validateLibrarySourcePath. source is the
+// path given by the volume or the image metadata; when it is empty, the
+// default path of the package is used.
+func librarySourcePath(basePath, pkg, source string) (string, error) {
+	if source != "" {
+		if err := checkSourcePath(source); err != nil {
+			return "", fmt.Errorf("invalid library source path: %w", err)
+		}
+	}
+	return validateLibrarySourcePath(basePath, librarySourceDir(basePath, pkg, source))
+}
+
+// librarySourceDir returns the subdirectory of a downloaded library that is
+// mounted, without resolving it. When source is empty, the default path of the
+// package is used.
+func librarySourceDir(basePath, pkg, source string) string {
+	if source == "" && pkg == injectorPackage {
+		source = injectorLibrarySourcePath
+	} else if source == "" {
+		source = languageLibrarySourcePath
+	}
+
+	// Remove leading slash from source to join paths correctly
+	return filepath.Join(basePath, strings.TrimPrefix(source, "/"))
+}
+
+// checkSourcePath checks that a source path is a clean absolute path inside the image.
+func checkSourcePath(source string) error {
+	if !filepath.IsAbs(source) || filepath.Clean(source) != source || source == "/" {
+		return fmt.Errorf("%q must be a clean absolute path to a directory of the image", source)
+	}
+	return nil
+}
+
 // validateLibrarySourcePath resolves the bind-mount source and ensures it
 // remains inside the downloaded library directory.
 func validateLibrarySourcePath(basePath, sourcePath string) (string, error) {
@@ -175,8 +354,16 @@ func validateLibrarySourcePath(basePath, sourcePath string) (string, error) {
 	return resolvedSourcePath, nil
 }
 
-func newLibraryPublisher(fs afero.Afero, mounter mount.Interface, libraryManager *librarymanager.LibraryManager, disabled bool, allowedRegistries []string) Publisher {
-	return libraryPublisher{fs: fs, mounter: mounter, libraryManager: libraryManager, disabled: disabled, allowedRegistries: allowedRegistries}
+func newLibraryPublisher(fs afero.Afero, mounter mount.Interface, libraryManager *librarymanager.LibraryManager, disabled bool, allowedRegistries []string,
+	storageBasePath string) Publisher {
+	return libraryPublisher{
+		fs:                fs,
+		mounter:           mounter,
+		libraryManager:    libraryManager,
+		disabled:          disabled,
+		allowedRegistries: allowedRegistries,
+		overlaysPath:      filepath.Join(storageBasePath, overlaysDir),
+	}
 }
 
 // registryAllowed returns true if the registry is in libraryPublisher's list of allowed registries, or if the list is nil (allow all).
@@ -7,6 +7,7 @@ package publishers
 
 import (
 	"archive/tar"
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
@@ -63,7 +64,7 @@ func TestLibraryPublisher_Publish_TypeSelection(t *testing.T) {
 				VolumeContext: tc.volumeContext,
 			}
 
-			resp, err := publisher.Publish(req)
+			resp, err := publisher.Publish(context.Background(), req)
 			assert.Nil(t, resp)
 			assert.NoError(t, err)
 		})
@@ -85,7 +86,7 @@ func TestLibraryPublisher_Publish_DisabledRejectsRequest(t *testing.T) {
 		},
 	}
 
-	resp, err := publisher.Publish(req)
+	resp, err := publisher.Publish(context.Background(), req)
 
 	assert.NotNil(t, resp, "response should be non-nil for metrics")
 	assert.Equal(t, DatadogLibrary, resp.VolumeType)
@@ -108,7 +109,7 @@ func TestLibraryPublisher_Publish_RejectsNonReadOnly(t *testing.T) {
 		},
 	}
 
-	resp, err := publisher.Publish(req)
+	resp, err := publisher.Publish(context.Background(), req)
 
 	assert.NotNil(t, resp, "response should be non-nil for metrics")
 	assert.Equal(t, DatadogLibrary, resp.VolumeType)
@@ -151,7 +152,7 @@ func TestLibraryPublisher_Publish_InvalidLibraryConfig(t *testing.T) {
 				VolumeContext: tc.volumeContext,
 			}
 
-			resp, err := publisher.Publish(req)
+			resp, err := publisher.Publish(context.Background(), req)
 			assert.NotNil(t, resp, "response should be non-nil even on error for metrics")
 			assert.Equal(t, DatadogLibrary, resp.VolumeType)
 			assert.Error(t, err)
@@ -180,7 +181,7 @@ func TestLibraryPublisher_Publish_Success(t *testing.T) {
 
 	// Create publisher
 	mounter := mount.NewFakeMounter(nil)
-	publisher := newLibraryPublisher(fs, mounter, lm, false, nil)
+	publisher := newLibraryPublisher(fs, mounter, lm, false, nil, basePath)
 
 	// Create target directory
 	targetPath := filepath.Join(t.TempDir(), "target", "library")
@@ -197,7 +198,7 @@ func TestLibraryPublisher_Publish_Success(t *testing.T) {
 		},
 	}
 
-	resp, err := publisher.Publish(req)
+	resp, err := publisher.Publish(context.Background(), req)
 
 	require.NoError(t, err)
 	require.NotNil(t, resp)
@@ -229,7 +230,7 @@ func TestLibraryPublisher_Publish_RejectsSymlinkedLibrarySource(t *testing.T) {
 	defer lm.Stop()
 
 	mounter := mount.NewFakeMounter(nil)
-	publisher := newLibraryPublisher(fs, mounter, lm, false, nil)
+	publisher := newLibraryPublisher(fs, mounter, lm, false, nil, basePath)
 	req := &csi.NodePublishVolumeRequest{
 		VolumeId:   "test-volume-symlinked-source",
 		TargetPath: filepath.Join(t.TempDir(), "target", "library"),
@@ -242,7 +243,7 @@ func TestLibraryPublisher_Publish_RejectsSymlinkedLibrarySource(t *testing.T) {
 		},
 	}
 
-	resp, err := publisher.Publish(req)
+	resp, err := publisher.Publish(context.Background(), req)
 
 	require.NotNil(t, resp)
 	assert.Equal(t, DatadogLibrary, resp.VolumeType)
@@ -254,6 +255,107 @@ func TestLibraryPublisher_Publish_RejectsSymlinkedLibrarySource(t *testing.T) {
 	assert.False(t, hasVolume, "failed publish must not leave the volume linked")
 }
 
+func TestLibraryPublisher_Publish_SourcePath(t *testing.T) {
+	localRegistry := testutil.NewLocalRegistry(t)
+	defer localRegistry.Stop()
+	localRegistry.AddImage(t, createLabelledLibraryImage(t, "/other"), "labelled-library", "v1.0.0")
+
+	basePath := t.TempDir()
+	fs := afero.Afero{Fs: afero.NewOsFs()}
+	lm, err := librarymanager.NewLibraryManager(basePath,
+		librarymanager.WithFilesystem(fs),
+		librarymanager.WithDownloader(librarymanager.NewDownloaderWithRoundTripper(localRegistry.GetRoundTripper(t))),
+	)
+	require.NoError(t, err)
+	defer lm.Stop()
+
+	tests := map[string]struct {
+		sourcePath     string
+		expectedSource string
+		expectErr      string
+	}{
+		"image label":                   {expectedSource: "other"},
+		"volume attribute":              {sourcePath: "/datadog-init/package", expectedSource: "datadog-init/package"},
+		"relative volume attribute":     {sourcePath: "datadog-init", expectErr: "invalid " + keyLibrarySourcePath},
+		"volume attribute not clean":    {sourcePath: "/other/../datadog-init", expectErr: "invalid " + keyLibrarySourcePath},
+		"volume attribute not in image": {sourcePath: "/missing", expectErr: "library source path"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			mounter := mount.NewFakeMounter(nil)
+			publisher := newLibraryPublisher(fs, mounter, lm, false, nil, basePath)
+			volumeCtx := map[string]string{
+				"type":                                "DatadogLibrary",
+				"dd.csi.datadog.com/library.package":  "labelled-library",
+				"dd.csi.datadog.com/library.registry": localRegistry.Registry(t),
+				"dd.csi.datadog.com/library.version":  "v1.0.0",
+			}
+			if tc.sourcePath != "" {
+				volumeCtx[keyLibrarySourcePath] = tc.sourcePath
+			}
+			req := &csi.NodePublishVolumeRequest{
+				VolumeId:      "test-volume-source-path",
+				TargetPath:    filepath.Join(t.TempDir(), "target", "library"),
+				Readonly:      true,
+				VolumeContext: volumeCtx,
+			}
+
+			resp, err := publisher.Publish(context.Background(), req)
+			require.NotNil(t, resp)
+			if tc.expectErr != "" {
+				assert.ErrorContains(t, err, tc.expectErr)
+				assert.Empty(t, mounter.GetLog())
+				hasVolume, err := lm.HasVolume(req.GetVolumeId())
+				require.NoError(t, err)
+				assert.False(t, hasVolume, "failed publish must not leave the volume linked")
+				return
+			}
+			require.NoError(t, err)
+			storePath, err := lm.GetVolumePath(req.GetVolumeId())
+			require.NoError(t, err)
+			mountLog := mounter.GetLog()
+			require.Len(t, mountLog, 1)
+			assert.Equal(t, filepath.Join(storePath, tc.expectedSource), mountLog[0].Source)
+
+			_, err = publisher.Unpublish(context.Background(), &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   req.GetVolumeId(),
+				TargetPath: req.GetTargetPath(),
+			})
+			require.NoError(t, err)
+		})
+	}
+
+	t.Run("a failed republish keeps the published volume", func(t *testing.T) {
+		mounter := mount.NewFakeMounter(nil)
+		publisher := newLibraryPublisher(fs, mounter, lm, false, nil, basePath)
+		req := &csi.NodePublishVolumeRequest{
+			VolumeId:   "test-volume-republish",
+			TargetPath: filepath.Join(t.TempDir(), "target", "library"),
+			Readonly:   true,
+			VolumeContext: map[string]string{
+				"type":                                "DatadogLibrary",
+				"dd.csi.datadog.com/library.package":  "labelled-library",
+				"dd.csi.datadog.com/library.registry": localRegistry.Registry(t),
+				"dd.csi.datadog.com/library.version":  "v1.0.0",
+			},
+		}
+		_, err := publisher.Publish(context.Background(), req)
+		require.NoError(t, err)
+
+		// The retry fails after the library is resolved, the running pod keeps its volume
+		req.VolumeContext[keyLibrarySourcePath] = "/missing"
+		_, err = publisher.Publish(context.Background(), req)
+		assert.ErrorContains(t, err, "library source path")
+		hasVolume, err := lm.HasVolume(req.GetVolumeId())
+		require.NoError(t, err)
+		assert.True(t, hasVolume, "the link of a published volume must not be rolled back")
+		mountPoints, err := mounter.List()
+		require.NoError(t, err)
+		assert.Len(t, mountPoints, 1)
+	})
+}
+
 func TestRegistryAllowed(t *testing.T) {
 	tests := map[string]struct {
 		registry      string
@@ -317,6 +419,26 @@ func createSymlinkedLibrarySourceImage(t *testing.T, linkTarget string) string {
 	return imageTar
 }
 
+// createLabelledLibraryImage copies the test library image, setting the source path label of the image.
+func createLabelledLibraryImage(t *testing.T, sourcePath string) string {
+	t.Helper()
+
+	img, err := tarball.ImageFromPath("../../librarymanager/testdata/image.tar", nil)
+	require.NoError(t, err)
+	config, err := img.ConfigFile()
+	require.NoError(t, err)
+	config = config.DeepCopy()
+	config.Config.Labels = map[string]string{librarymanager.SourcePathLabel: sourcePath}
+	img, err = mutate.ConfigFile(img, config)
+	require.NoError(t, err)
+
+	ref, err := name.NewTag("test-image:latest")
+	require.NoError(t, err)
+	imageTar := filepath.Join(t.TempDir(), "image.tar")
+	require.NoError(t, tarball.WriteToFile(imageTar, ref, img))
+	return imageTar
+}
+
 func tarballImage(t *testing.T, rootfsTar, imageTar string) error {
 	t.Helper()
 
@@ -376,7 +498,7 @@ func TestLibraryPublisher_Publish_RegistryAllowList(t *testing.T) {
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			publisher := newLibraryPublisher(fs, mounter, lm, false, tc.allowedRegistries)
+			publisher := newLibraryPublisher(fs, mounter, lm, false, tc.allowedRegistries, basePath)
 			targetPath := filepath.Join(t.TempDir(), "target", "library")
 
 			req := &csi.NodePublishVolumeRequest{
@@ -391,7 +513,7 @@ func TestLibraryPublisher_Publish_RegistryAllowList(t *testing.T) {
 				},
 			}
 
-			resp, err := publisher.Publish(req)
+			resp, err := publisher.Publish(context.Background(), req)
 			if tc.expectErr {
 				assert.NotNil(t, resp, "response should be non-nil for metrics")
 				assert.Equal(t, DatadogLibrary, resp.VolumeType)
@@ -426,7 +548,7 @@ func TestLibraryPublisher_Unpublish_Success(t *testing.T) {
 
 	// Create publisher
 	mounter := mount.NewFakeMounter(nil)
-	publisher := newLibraryPublisher(fs, mounter, lm, false, nil)
+	publisher := newLibraryPublisher(fs, mounter, lm, false, nil, basePath)
 
 	// First, publish a volume
 	targetPath := filepath.Join(t.TempDir(), "target", "library")
@@ -442,7 +564,7 @@ func TestLibraryPublisher_Unpublish_Success(t *testing.T) {
 		},
 	}
 
-	_, err = publisher.Publish(publishReq)
+	_, err = publisher.Publish(context.Background(), publishReq)
 	require.NoError(t, err)
 
 	// Verify volume is tracked
@@ -456,7 +578,7 @@ func TestLibraryPublisher_Unpublish_Success(t *testing.T) {
 		TargetPath: targetPath,
 	}
 
-	resp, err := publisher.Unpublish(unpublishReq)
+	resp, err := publisher.Unpublish(context.Background(), unpublishReq)
 
 	require.NoError(t, err)
 	require.NotNil(t, resp)
@@ -473,3 +595,196 @@ func TestLibraryPublisher_Unpublish_Success(t *testing.T) {
 	require.NoError(t, err)
 	assert.Empty(t, entries, "store should be empty after last volume is unpublished")
 }
+
+func TestLibraryPublisher_Publish_Writable(t *testing.T) {
+	localRegistry := testutil.NewLocalRegistry(t)
+	defer localRegistry.Stop()
+	localRegistry.AddImage(t, "../../librarymanager/testdata/image.tar", "test-image", "v1.0.0")
+
+	basePath := t.TempDir()
+	fs := afero.Afero{Fs: afero.NewOsFs()}
+	lm, err := librarymanager.NewLibraryManager(basePath,
+		librarymanager.WithFilesystem(fs),
+		librarymanager.WithDownloader(librarymanager.NewDownloaderWithRoundTripper(localRegistry.GetRoundTripper(t))),
+	)
+	require.NoError(t, err)
+	defer lm.Stop()
+
+	mounter := mount.NewFakeMounter(nil)
+	publisher := newLibraryPublisher(fs, mounter, lm, false, nil, basePath)
+	targetPath := filepath.Join(t.TempDir(), "target", "library")
+	overlayDir := filepath.Join(basePath, overlaysDir, "test-volume-writable")
+	volumeCtx := func(writable string) map[string]string {
+		return map[string]string{
+			"type":                                "DatadogLibrary",
+			"dd.csi.datadog.com/library.package":  "test-image",
+			"dd.csi.datadog.com/library.registry": localRegistry.Registry(t),
+			"dd.csi.datadog.com/library.version":  "v1.0.0",
+			keyLibraryWritable:                    writable,
+		}
+	}
+
+	t.Run("invalid writable value", func(t *testing.T) {
+		resp, err := publisher.Publish(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:      "test-volume-writable",
+			TargetPath:    targetPath,
+			VolumeContext: volumeCtx("maybe"),
+		})
+		require.NotNil(t, resp)
+		assert.ErrorContains(t, err, keyLibraryWritable)
+		assert.Empty(t, mounter.GetLog())
+	})
+
+	t.Run("publish mounts an overlay of the library", func(t *testing.T) {
+		resp, err := publisher.Publish(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:      "test-volume-writable",
+			TargetPath:    targetPath,
+			VolumeContext: volumeCtx("true"),
+		})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, DatadogLibrary, resp.VolumeType)
+
+		storePath, err := lm.GetVolumePath("test-volume-writable")
+		require.NoError(t, err)
+		mountPoints, err := mounter.List()
+		require.NoError(t, err)
+		require.Len(t, mountPoints, 1)
+		assert.Equal(t, "overlay", mountPoints[0].Type)
+		assert.Equal(t, targetPath, mountPoints[0].Path)
+		assert.Equal(t, []string{
+			"lowerdir=" + filepath.Join(storePath, languageLibrarySourcePath),
+			"upperdir=" + filepath.Join(overlayDir, overlayUpperDir),
+			"workdir=" + filepath.Join(overlayDir, overlayWorkDir),
+			"nosuid", "nodev",
+		}, mountPoints[0].Opts)
+		exists, err := fs.DirExists(filepath.Join(overlayDir, overlayUpperDir))
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("stats include the upper layer", func(t *testing.T) {
+		storePath, err := lm.GetVolumePath("test-volume-writable")
+		require.NoError(t, err)
+		require.NoError(t, fs.WriteFile(filepath.Join(overlayDir, overlayUpperDir, "written"), []byte("data"), 0o644))
+		storeBytes, storeInodes, err := pathUsage(fs, storePath)
+		require.NoError(t, err)
+		upperBytes, upperInodes, err := pathUsage(fs, filepath.Join(overlayDir, overlayUpperDir))
+		require.NoError(t, err)
+
+		resp, err := publisher.Stats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+			VolumeId:   "test-volume-writable",
+			VolumePath: targetPath,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, storeBytes+upperBytes, resp.UsedBytes)
+		assert.Equal(t, storeInodes+upperInodes, resp.UsedInodes)
+	})
+
+	t.Run("unpublish removes the upper layer", func(t *testing.T) {
+		resp, err := publisher.Unpublish(context.Background(), &csi.NodeUnpublishVolumeRequest{
+			VolumeId:   "test-volume-writable",
+			TargetPath: targetPath,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+
+		exists, err := fs.Exists(overlayDir)
+		require.NoError(t, err)
+		assert.False(t, exists)
+		hasVolume, err := lm.HasVolume("test-volume-writable")
+		require.NoError(t, err)
+		assert.False(t, hasVolume)
+	})
+}
+
+func TestLibraryPublisher_Stats(t *testing.T) {
+	localRegistry := testutil.NewLocalRegistry(t)
+	defer localRegistry.Stop()
+	localRegistry.AddImage(t, "../../librarymanager/testdata/image.tar", "test-image", "v1.0.0")
+
+	basePath := t.TempDir()
+	fs := afero.Afero{Fs: afero.NewOsFs()}
+	lm, err := librarymanager.NewLibraryManager(basePath,
+		librarymanager.WithFilesystem(fs),
+		librarymanager.WithDownloader(librarymanager.NewDownloaderWithRoundTripper(localRegistry.GetRoundTripper(t))),
+	)
+	require.NoError(t, err)
+	defer lm.Stop()
+
+	mounter := mount.NewFakeMounter(nil)
+	publisher := newLibraryPublisher(fs, mounter, lm, false, nil, basePath)
+
+	targetPath := filepath.Join(t.TempDir(), "target", "library")
+	_, err = publisher.Publish(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:   "test-volume-stats",
+		TargetPath: targetPath,
+		Readonly:   true,
+		VolumeContext: map[string]string{
+			"type":                                "DatadogLibrary",
+			"dd.csi.datadog.com/library.package":  "test-image",
+			"dd.csi.datadog.com/library.registry": localRegistry.Registry(t),
+			"dd.csi.datadog.com/library.version":  "v1.0.0",
+		},
+	})
+	require.NoError(t, err)
+
+	t.Run("unknown volume is not supported", func(t *testing.T) {
+		resp, err := publisher.Stats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+			VolumeId:   "unknown-volume",
+			VolumePath: targetPath,
+		})
+		assert.NoError(t, err)
+		assert.Nil(t, resp)
+	})
+
+	t.Run("reports the store entry usage", func(t *testing.T) {
+		storePath, err := lm.GetVolumePath("test-volume-stats")
+		require.NoError(t, err)
+		expectedBytes, expectedInodes, err := pathUsage(fs, storePath)
+		require.NoError(t, err)
+
+		resp, err := publisher.Stats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+			VolumeId:   "test-volume-stats",
+			VolumePath: targetPath,
+		})
+
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, DatadogLibrary, resp.VolumeType)
+		assert.Positive(t, resp.UsedBytes)
+		assert.Equal(t, expectedBytes, resp.UsedBytes)
+		assert.Equal(t, expectedInodes, resp.UsedInodes)
+		assert.False(t, resp.Abnormal)
+	})
+
+	t.Run("missing store entry is abnormal", func(t *testing.T) {
+		storePath, err := lm.GetVolumePath("test-volume-stats")
+		require.NoError(t, err)
+		require.NoError(t, os.RemoveAll(storePath))
+
+		resp, err := publisher.Stats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+			VolumeId:   "test-volume-stats",
+			VolumePath: targetPath,
+		})
+
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, DatadogLibrary, resp.VolumeType)
+		assert.True(t, resp.Abnormal)
+		assert.Contains(t, resp.Message, "missing from the driver store")
+	})
+	t.Run("database errors are reported", func(t *testing.T) {
+		require.NoError(t, lm.Stop())
+
+		resp, err := publisher.Stats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+			VolumeId:   "test-volume-stats",
+			VolumePath: targetPath,
+		})
+
+		assert.ErrorContains(t, err, "failed to resolve the library of the volume")
+		require.NotNil(t, resp, "the error must not fall through to other publishers")
+		assert.Equal(t, DatadogLibrary, resp.VolumeType)
+	})
+}
@@ -6,58 +6,64 @@
 package publishers
 
 import (
+	"context"
+	"fmt"
 	log "log/slog"
-	"path/filepath"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/spf13/afero"
 	"k8s.io/utils/mount"
 )
 
-// localPublisher handles directory mounts using the "type" schema
-// (APMSocketDirectory, DSDSocketDirectory, DatadogSocketsDirectory).
+// localPublisher handles directory mounts using the "type" schema:
+// APMSocketDirectory, DSDSocketDirectory, DatadogSocketsDirectory and the
+// sockets of the registry in directory mode.
 type localPublisher struct {
-	fs            afero.Afero
-	mounter       mount.Interface
-	apmSocketPath string
-	dsdSocketPath string
+	fs      afero.Afero
+	mounter mount.Interface
+	sockets SocketRegistry
 }
 
 // Publish implements Publisher#Publish for the "type" schema.
-// It handles APMSocketDirectory, DSDSocketDirectory, and DatadogSocketsDirectory volume types.
-func (s localPublisher) Publish(req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
+// It handles the socket volume types in directory mode.
+func (s localPublisher) Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
 	volumeCtx := req.GetVolumeContext()
 
-	// Resolve the type to hostPath (parent directory of the socket)
-	var hostPath string
+	// Resolve the type to hostPath (parent directory of the socket for the built-in types)
 	volumeType := VolumeType(volumeCtx["type"])
-	switch volumeType {
-	case APMSocketDirectory:
-		hostPath = filepath.Dir(s.apmSocketPath)
-	case DSDSocketDirectory:
-		hostPath = filepath.Dir(s.dsdSocketPath)
-	case DatadogSocketsDirectory:
-		log.Warn("volume type is deprecated, preferred type is 'DSDSocketDirectory' or 'APMSocketDirectory'")
-		hostPath = filepath.Dir(s.dsdSocketPath)
-	default:
+	socket, ok := s.sockets[volumeType]
+	if !ok || socket.Mode != SocketModeDirectory {
 		return nil, nil
 	}
+	if volumeType == DatadogSocketsDirectory {
+		log.WarnContext(ctx, "volume type is deprecated, preferred type is 'DSDSocketDirectory' or 'APMSocketDirectory'")
+	}
+	hostPath := socket.Path
 
-	resp := &PublisherResponse{VolumeType: volumeType, VolumePath: hostPath}
+	resp := &PublisherResponse{VolumeType: volumeType, VolumePath: hostPath, HostPath: hostPath}
 	targetPath := req.GetTargetPath()
 
-	return resp, bindMount(s.fs, s.mounter, bindMountArgs{
+	if !socket.IsEnabled() {
+		return resp, fmt.Errorf("volume type %q is disabled", volumeType)
+	}
+
+	return resp, bindMount(ctx, s.fs, s.mounter, bindMountArgs{
 		hostPath:   hostPath,
 		targetPath: targetPath,
 		isFile:     false,
-		readOnly:   false,
+		readOnly:   req.GetReadonly(),
+		options:    mountOptions(volumeType),
 	})
 }
 
-func (s localPublisher) Unpublish(*csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+func (s localPublisher) Unpublish(context.Context, *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+	return nil, nil // Handled by unmountPublisher
+}
+
+func (s localPublisher) Stats(context.Context, *csi.NodeGetVolumeStatsRequest) (*VolumeStats, error) {
 	return nil, nil // Handled by unmountPublisher
 }
 
-func newLocalPublisher(fs afero.Afero, mounter mount.Interface, apmSocketPath, dsdSocketPath string) Publisher {
-	return localPublisher{fs: fs, mounter: mounter, apmSocketPath: apmSocketPath, dsdSocketPath: dsdSocketPath}
+func newLocalPublisher(fs afero.Afero, mounter mount.Interface, sockets SocketRegistry) Publisher {
+	return localPublisher{fs: fs, mounter: mounter, sockets: sockets}
 }
@@ -6,9 +6,8 @@
 package publishers
 
 import (
+	"context"
 	"fmt"
-	log "log/slog"
-	"path/filepath"
 	"slices"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -22,14 +21,15 @@ const modeLocal = "local"
 // This publisher is deprecated and will be removed in a future release.
 // Use the "type" schema (e.g., type: APMSocketDirectory) instead.
 type localLegacyPublisher struct {
-	fs            afero.Afero
-	mounter       mount.Interface
-	apmSocketPath string
-	dsdSocketPath string
+	fs      afero.Afero
+	mounter mount.Interface
+	// sockets resolves the APMSocketDirectory and DSDSocketDirectory types to the paths allowed by the legacy schema
+	sockets      SocketRegistry
+	legacySchema LegacySchema
 }
 
 // Publish implements Publisher#Publish for the deprecated mode/path schema.
-func (s localLegacyPublisher) Publish(req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
+func (s localLegacyPublisher) Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
 	volumeCtx := req.GetVolumeContext()
 
 	// Only handle legacy schema (mode/path without type)
@@ -44,29 +44,46 @@ func (s localLegacyPublisher) Publish(req *csi.NodePublishVolumeRequest) (*Publi
 		return nil, nil
 	}
 
-	log.Warn("Using deprecated mode/path schema. Please migrate to using 'type: APMSocketDirectory' or 'type: DSDSocketDirectory' instead.")
+	resp := &PublisherResponse{VolumeType: VolumeType(mode), VolumePath: hostPath, HostPath: hostPath}
+	if err := s.legacySchema.enforce(ctx, LegacySchemaUse{Mode: mode, Path: hostPath, Type: s.migrationType(hostPath)}); err != nil {
+		return resp, err
+	}
 
-	resp := &PublisherResponse{VolumeType: VolumeType(mode), VolumePath: hostPath}
 	targetPath := req.GetTargetPath()
 
 	// Validate that hostPath is in the allowed list (parent directories of the sockets)
-	allowedPaths := []string{filepath.Dir(s.apmSocketPath), filepath.Dir(s.dsdSocketPath)}
+	allowedPaths := s.allowedPaths()
 	if !slices.Contains(allowedPaths, hostPath) {
 		return resp, fmt.Errorf("path %q is not allowed; permitted paths are %v", hostPath, allowedPaths)
 	}
 
-	return resp, bindMount(s.fs, s.mounter, bindMountArgs{
+	return resp, bindMount(ctx, s.fs, s.mounter, bindMountArgs{
 		hostPath:   hostPath,
 		targetPath: targetPath,
 		isFile:     false,
-		readOnly:   false,
+		readOnly:   req.GetReadonly(),
+		options:    mountOptions(VolumeType(mode)),
 	})
 }
 
-func (s localLegacyPublisher) Unpublish(*csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+// allowedPaths returns the parent directories of the enabled APM and DogStatsD sockets.
+func (s localLegacyPublisher) allowedPaths() []string {
+	return legacyAllowedPaths(s.sockets, APMSocketDirectory, DSDSocketDirectory)
+}
+
+// migrationType returns the volume type mounting the same directory as a legacy volume, if any.
+func (s localLegacyPublisher) migrationType(hostPath string) VolumeType {
+	return legacyMigrationType(s.sockets, hostPath, APMSocketDirectory, DSDSocketDirectory)
+}
+
+func (s localLegacyPublisher) Unpublish(context.Context, *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+	return nil, nil // Handled by unmountPublisher
+}
+
+func (s localLegacyPublisher) Stats(context.Context, *csi.NodeGetVolumeStatsRequest) (*VolumeStats, error) {
 	return nil, nil // Handled by unmountPublisher
 }
 
-func newLocalLegacyPublisher(fs afero.Afero, mounter mount.Interface, apmSocketPath, dsdSocketPath string) Publisher {
-	return localLegacyPublisher{fs: fs, mounter: mounter, apmSocketPath: apmSocketPath, dsdSocketPath: dsdSocketPath}
+func newLocalLegacyPublisher(fs afero.Afero, mounter mount.Interface, sockets SocketRegistry, legacySchema LegacySchema) Publisher {
+	return localLegacyPublisher{fs: fs, mounter: mounter, sockets: sockets, legacySchema: legacySchema}
 }
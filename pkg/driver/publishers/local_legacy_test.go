@@ -6,6 +6,7 @@
 package publishers
 
 import (
+	"context"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -48,7 +49,7 @@ func TestLocalLegacyPublisher_Publish_ModeSelection(t *testing.T) {
 				VolumeContext: tc.volumeContext,
 			}
 
-			resp, err := publisher.Publish(req)
+			resp, err := publisher.Publish(context.Background(), req)
 			assert.Nil(t, resp)
 			assert.NoError(t, err)
 		})
@@ -74,10 +75,7 @@ func TestLocalLegacyPublisher_Publish_PathValidation(t *testing.T) {
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			publisher := localLegacyPublisher{
-				apmSocketPath: "/var/run/datadog/apm.sock",
-				dsdSocketPath: "/opt/datadog/dsd.sock",
-			}
+			publisher := localLegacyPublisher{sockets: testSocketRegistry(t, "/var/run/datadog/apm.sock", "/opt/datadog/dsd.sock")}
 
 			req := &csi.NodePublishVolumeRequest{
 				VolumeId:      "test-volume",
@@ -85,7 +83,7 @@ func TestLocalLegacyPublisher_Publish_PathValidation(t *testing.T) {
 				VolumeContext: map[string]string{"mode": "local", "path": tc.path},
 			}
 
-			resp, err := publisher.Publish(req)
+			resp, err := publisher.Publish(context.Background(), req)
 			assert.NotNil(t, resp, "local mode should be supported")
 			assert.Error(t, err)
 			assert.Contains(t, err.Error(), "not allowed")
@@ -110,7 +108,7 @@ func TestLocalLegacyPublisher_Publish_Success(t *testing.T) {
 			// Create source directory
 			require.NoError(t, fs.MkdirAll(hostPath, 0755))
 
-			publisher := newLocalLegacyPublisher(fs, mounter, apmSocketPath, dsdSocketPath)
+			publisher := newLocalLegacyPublisher(fs, mounter, testSocketRegistry(t, apmSocketPath, dsdSocketPath), LegacySchema{})
 
 			req := &csi.NodePublishVolumeRequest{
 				VolumeId:      "test-volume",
@@ -118,7 +116,7 @@ func TestLocalLegacyPublisher_Publish_Success(t *testing.T) {
 				VolumeContext: map[string]string{"mode": "local", "path": hostPath},
 			}
 
-			resp, err := publisher.Publish(req)
+			resp, err := publisher.Publish(context.Background(), req)
 
 			assert.NoError(t, err)
 			assert.NotNil(t, resp)
@@ -137,7 +135,7 @@ func TestLocalLegacyPublisher_Publish_Success(t *testing.T) {
 
 func TestLocalLegacyPublisher_Unpublish_DelegatesToUnmount(t *testing.T) {
 	publisher := localLegacyPublisher{}
-	resp, err := publisher.Unpublish(&csi.NodeUnpublishVolumeRequest{})
+	resp, err := publisher.Unpublish(context.Background(), &csi.NodeUnpublishVolumeRequest{})
 	assert.Nil(t, resp, "local legacy should delegate Unpublish to unmountPublisher")
 	assert.NoError(t, err)
 }
@@ -6,6 +6,7 @@
 package publishers
 
 import (
+	"context"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -40,10 +41,7 @@ func TestLocalPublisher_Publish_TypeSelection(t *testing.T) {
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			publisher := localPublisher{
-				apmSocketPath: "/var/run/apm.sock",
-				dsdSocketPath: "/var/run/dsd.sock",
-			}
+			publisher := localPublisher{sockets: testSocketRegistry(t, "/var/run/apm.sock", "/var/run/dsd.sock")}
 
 			req := &csi.NodePublishVolumeRequest{
 				VolumeId:      "test-volume",
@@ -51,7 +49,7 @@ func TestLocalPublisher_Publish_TypeSelection(t *testing.T) {
 				VolumeContext: tc.volumeContext,
 			}
 
-			resp, err := publisher.Publish(req)
+			resp, err := publisher.Publish(context.Background(), req)
 			assert.Nil(t, resp)
 			assert.NoError(t, err)
 		})
@@ -75,15 +73,18 @@ func TestLocalPublisher_Publish_Success(t *testing.T) {
 			// Create source directory
 			require.NoError(t, fs.MkdirAll(expectedHostPath, 0755))
 
-			publisher := newLocalPublisher(fs, mounter, apmSocketPath, dsdSocketPath)
+			sockets, err := NewSocketRegistry(apmSocketPath, dsdSocketPath, nil)
+			require.NoError(t, err)
+			publisher := newLocalPublisher(fs, mounter, sockets)
 
 			req := &csi.NodePublishVolumeRequest{
 				VolumeId:      "test-volume",
 				TargetPath:    "/target/datadog",
 				VolumeContext: map[string]string{"type": volumeType},
+				Readonly:      true,
 			}
 
-			resp, err := publisher.Publish(req)
+			resp, err := publisher.Publish(context.Background(), req)
 
 			assert.NoError(t, err)
 			assert.NotNil(t, resp)
@@ -96,6 +97,10 @@ func TestLocalPublisher_Publish_Success(t *testing.T) {
 			assert.Equal(t, "mount", log[0].Action)
 			assert.Equal(t, expectedHostPath, log[0].Source)
 			assert.Equal(t, "/target/datadog", log[0].Target)
+			mountPoints, err := mounter.List()
+			require.NoError(t, err)
+			require.Len(t, mountPoints, 1)
+			assert.Equal(t, []string{"bind", "ro", "nosuid", "nodev", "noexec"}, mountPoints[0].Opts)
 
 			// Verify target directory was created
 			exists, err := fs.DirExists("/target/datadog")
@@ -105,9 +110,35 @@ func TestLocalPublisher_Publish_Success(t *testing.T) {
 	}
 }
 
+func TestLocalPublisher_Publish_RegistryDirectory(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	mounter := mount.NewFakeMounter(nil)
+	require.NoError(t, fs.MkdirAll("/var/run/sysprobe", 0755))
+	sockets, err := NewSocketRegistry("/var/run/datadog/apm.sock", "/var/run/datadog/dsd.sock", []NamedSocket{
+		{Name: "SystemProbeSocketDirectory", Path: "/var/run/sysprobe", Mode: SocketModeDirectory},
+	})
+	require.NoError(t, err)
+	publisher := newLocalPublisher(fs, mounter, sockets)
+
+	resp, err := publisher.Publish(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:      "test-volume",
+		TargetPath:    "/target/sysprobe",
+		VolumeContext: map[string]string{"type": "SystemProbeSocketDirectory"},
+	})
+
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, VolumeType("SystemProbeSocketDirectory"), resp.VolumeType)
+	assert.Equal(t, "/var/run/sysprobe", resp.HostPath)
+	log := mounter.GetLog()
+	require.Len(t, log, 1)
+	assert.Equal(t, "/var/run/sysprobe", log[0].Source)
+	assert.Equal(t, "/target/sysprobe", log[0].Target)
+}
+
 func TestLocalPublisher_Unpublish_DelegatesToUnmount(t *testing.T) {
 	publisher := localPublisher{}
-	resp, err := publisher.Unpublish(&csi.NodeUnpublishVolumeRequest{})
+	resp, err := publisher.Unpublish(context.Background(), &csi.NodeUnpublishVolumeRequest{})
 	assert.Nil(t, resp, "local should delegate Unpublish to unmountPublisher")
 	assert.NoError(t, err)
 }
@@ -0,0 +1,45 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import "golang.org/x/sys/unix"
+
+const (
+	mountOptionReadOnly = "ro"
+	mountOptionNoSuid   = "nosuid"
+	mountOptionNoDev    = "nodev"
+	mountOptionNoExec   = "noexec"
+)
+
+// mountOptionFlags maps the supported mount options to their mount flags.
+var mountOptionFlags = map[string]uintptr{
+	mountOptionReadOnly: unix.MS_RDONLY,
+	mountOptionNoSuid:   unix.MS_NOSUID,
+	mountOptionNoDev:    unix.MS_NODEV,
+	mountOptionNoExec:   unix.MS_NOEXEC,
+}
+
+// defaultMountOptions are the hardened mount options of the volume types
+// without an entry in mountOptionsPolicy: sockets, socket directories and
+// files are never executed.
+var defaultMountOptions = []string{mountOptionNoSuid, mountOptionNoDev, mountOptionNoExec}
+
+// mountOptionsPolicy holds the mount options of the bind mounts of the volume
+// types that differ from defaultMountOptions. "ro" is added separately for
+// read-only volumes.
+var mountOptionsPolicy = map[VolumeType][]string{
+	// Libraries hold shared objects that applications map as executable
+	DatadogLibrary:   {mountOptionNoSuid, mountOptionNoDev},
+	DatadogLibraries: {mountOptionNoSuid, mountOptionNoDev},
+}
+
+// mountOptions returns the mount options of the bind mounts of a volume type.
+func mountOptions(volumeType VolumeType) []string {
+	if options, ok := mountOptionsPolicy[volumeType]; ok {
+		return options
+	}
+	return defaultMountOptions
+}
@@ -0,0 +1,83 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/afero"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/mount"
+)
+
+const (
+	// overlayUpperDir and overlayWorkDir are the directories of an overlay, under its per-volume directory
+	overlayUpperDir = "upper"
+	overlayWorkDir  = "work"
+)
+
+type overlayMountArgs struct {
+	// lowerDir is the read-only layer of the overlay
+	lowerDir string
+	// upperDir receives the writes, workDir is the scratch space of overlayfs.
+	// Both must be on the same filesystem.
+	upperDir   string
+	workDir    string
+	targetPath string
+	// options are added to the mount options, see mountOptions
+	options []string
+}
+
+// overlayMount mounts an overlay filesystem of lowerDir and upperDir to targetPath.
+// It creates the upper, work and target directories if they don't exist.
+// Returns nil if already mounted or mount succeeds.
+func overlayMount(ctx context.Context, afs afero.Afero, mounter mount.Interface, args overlayMountArgs) error {
+	slog.InfoContext(ctx, "overlayMount: mounting", "lower_dir", args.lowerDir, "upper_dir", args.upperDir, "target_path", args.targetPath, "options", args.options)
+
+	// Verify the lower layer exists before attempting mount
+	exists, err := afs.DirExists(args.lowerDir)
+	if err != nil {
+		return status.Errorf(codes.Internal, "overlayMount: failed to check if lower directory exists: %v", err)
+	}
+	if !exists {
+		return status.Errorf(codes.FailedPrecondition, "overlayMount: lower directory %q does not exist", args.lowerDir)
+	}
+
+	for _, dir := range []string{args.upperDir, args.workDir} {
+		if err := afs.MkdirAll(dir, 0o755); err != nil {
+			return status.Errorf(codes.Internal, "overlayMount: failed to create %q: %v", dir, err)
+		}
+	}
+	if err := createHostPath(afs, args.targetPath, false); err != nil {
+		return err
+	}
+
+	// Check if already mounted, see bindMount
+	notMnt, err := mounter.IsLikelyNotMountPoint(args.targetPath)
+	if err != nil && !os.IsNotExist(err) {
+		return status.Errorf(codes.Internal, "overlayMount: failed to check mount point: %v", err)
+	}
+	if !notMnt {
+		slog.InfoContext(ctx, "overlayMount: already mounted, skipping", "target_path", args.targetPath)
+		return nil
+	}
+
+	options := append([]string{
+		"lowerdir=" + args.lowerDir,
+		"upperdir=" + args.upperDir,
+		"workdir=" + args.workDir,
+	}, args.options...)
+	if err := mounter.Mount("overlay", args.targetPath, "overlay", options); err != nil {
+		slog.ErrorContext(ctx, "overlayMount: failed to mount", "error", err, "lower_dir", args.lowerDir, "target_path", args.targetPath)
+		return status.Errorf(codes.Internal, "overlayMount: failed to mount: %v", err)
+	}
+
+	slog.InfoContext(ctx, "overlayMount: successfully mounted", "lower_dir", args.lowerDir, "target_path", args.targetPath)
+	return nil
+}
@@ -6,19 +6,42 @@
 package publishers
 
 import (
+	"context"
 	log "log/slog"
 
 	"github.com/Datadog/datadog-csi-driver/pkg/librarymanager"
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/spf13/afero"
+	utilexec "k8s.io/utils/exec"
 	"k8s.io/utils/mount"
 )
 
-// PublisherResponse contains metadata about a handled request, used for metrics.
+// PublisherResponse contains metadata about a handled request, used for metrics and logging.
 // A nil response means the publisher does not support the request.
 type PublisherResponse struct {
 	VolumeType VolumeType
 	VolumePath string
+	// HostPath is the bind source of a published volume on the host. It is
+	// persisted with the publication record.
+	HostPath string
+	// Pod is the pod the volume is published for. It is persisted with the
+	// publication record so that unpublish requests are tagged with it too.
+	Pod podinfo.PodInfo
+}
+
+// VolumeStats contains the usage of a published volume, used to answer NodeGetVolumeStats.
+// A nil response means the publisher does not own the volume.
+type VolumeStats struct {
+	VolumeType VolumeType
+	// UsedBytes is the cumulative size of the regular files in the volume.
+	UsedBytes int64
+	// UsedInodes is the number of files, directories and symlinks in the volume.
+	UsedInodes int64
+	// Abnormal reports that the volume is still published but its bind source is
+	// broken, for instance because the agent recreated its socket. Message explains why.
+	Abnormal bool
+	Message  string
 }
 
 // Publisher defines logic for publishing and unpublishing volumes.
@@ -26,57 +49,140 @@ type PublisherResponse struct {
 //   - (*PublisherResponse, nil) if the operation succeeded
 //   - (*PublisherResponse, error) if the operation failed
 //   - (nil, nil) if the publisher does not support this request
+//
+// Stats follows the same convention with a *VolumeStats response.
 type Publisher interface {
 	// Publish publishes the volume
-	Publish(req *csi.NodePublishVolumeRequest) (*PublisherResponse, error)
+	Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*PublisherResponse, error)
 	// Unpublish unpublishes the volume
-	Unpublish(req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error)
+	Unpublish(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error)
+	// Stats reports the usage of a published volume
+	Stats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*VolumeStats, error)
+}
+
+// Config configures the publishers returned by GetPublishers.
+type Config struct {
+	FS      afero.Afero
+	Mounter mount.Interface
+	// StorageBasePath is the directory of the SSI storage. The SSI publishers are disabled when it is empty.
+	StorageBasePath string
+	// Sockets resolves the socket volume types to their host paths, for every publisher exposing a socket.
+	Sockets SocketRegistry
+	// SocketRebinder re-binds the socket file volumes when the agent recreates its sockets. It may be nil.
+	SocketRebinder *SocketRebinder
+	// SocketWait is how long socket file volumes wait for their socket by default.
+	SocketWait SocketWait
+	// LibraryManager stores the libraries and the publication records. It is nil without SSI storage.
+	LibraryManager *librarymanager.LibraryManager
+	// APMEnabled enables the DatadogLibrary, DatadogLibraries and DatadogInjectorPreload volumes.
+	APMEnabled bool
+	// AllowedRegistries are the registries libraries may be pulled from, all of them when empty.
+	AllowedRegistries []string
+	// PreloadVerification is applied when the launcher of a DatadogInjectorPreload volume is not found.
+	PreloadVerification PreloadVerification
+	// Pods reads the volumes of a pod, so that the preload verification waits for a pending apm-inject library
+	// volume. It may be nil.
+	Pods PodGetter
+	// PodTrusted is true when the CSIDriver object enables podInfoOnMount, so that kubelet sets the pod identity of
+	// the volume context.
+	PodTrusted bool
+	// LegacySchema enforces the deprecated mode/path schema.
+	LegacySchema LegacySchema
+	// ScratchSize bounds the size caps of the DatadogScratch volumes.
+	ScratchSize ScratchSize
 }
 
 // GetPublishers returns a chain of publishers for handling CSI volume operations.
 //
 // The chain includes:
 //   - Library publisher (for DatadogLibrary volumes)
+//   - Libraries publisher (for DatadogLibraries volumes)
 //   - InjectorPreload publisher (for ld.so.preload injection)
-//   - Socket/Local publishers (for "type" schema: APMSocket, APMSocketDirectory, etc.)
+//   - AgentEnv publisher (for DatadogAgentEnv volumes)
+//   - SocketsDirectory publisher (for AgentSocketsDirectory volumes)
+//   - Scratch publisher (for DatadogScratch volumes)
+//   - Socket/Local publishers (for "type" schema: APMSocket, APMSocketDirectory and the other sockets of the registry)
 //   - Legacy publishers (for deprecated "mode/path" schema)
 //   - Fallback unmount handler for all Unpublish requests
-func GetPublishers(
-	fs afero.Afero,
-	mounter mount.Interface,
-	apmSocketPath, dsdSocketPath, storageBasePath string,
-	libraryManager *librarymanager.LibraryManager,
-	apmEnabled bool,
-	allowedRegistries []string,
-) Publisher {
+//
+// When a library manager is available, the chain is wrapped by a routing publisher
+// which records every publish and dispatches Unpublish and Stats requests to the
+// publisher that owns the volume.
+func GetPublishers(config Config) Publisher {
+	fs, mounter := config.FS, config.Mounter
+	sockets, storageBasePath := config.Sockets, config.StorageBasePath
+	ssiDisabled := !config.APMEnabled
+
 	var publishers []Publisher
+	owners := map[VolumeType]Publisher{}
 
 	// These publishers require writable storage
 	if storageBasePath != "" {
+		library := newLibraryPublisher(fs, mounter, config.LibraryManager, ssiDisabled, config.AllowedRegistries, storageBasePath)
+		libraries := newLibrariesPublisher(fs, mounter, config.LibraryManager, ssiDisabled, config.AllowedRegistries, storageBasePath)
+		injectorPreload := newInjectorPreloadPublisher(fs, mounter, storageBasePath, ssiDisabled, config.PreloadVerification, config.LibraryManager,
+			config.Pods, config.PodTrusted)
+		agentEnv := newAgentEnvPublisher(fs, mounter, sockets, storageBasePath)
+		socketsDirectory := newSocketsDirectoryPublisher(fs, mounter, sockets, storageBasePath)
+		scratch := newScratchPublisher(fs, mounter, utilexec.New(), storageBasePath, config.ScratchSize)
 		publishers = append(publishers,
-			// SSI publishers (library and injector preload)
-			newLibraryPublisher(fs, mounter, libraryManager, !apmEnabled, allowedRegistries),
-			newInjectorPreloadPublisher(fs, mounter, storageBasePath, !apmEnabled),
+			// SSI publishers (libraries and injector preload)
+			library,
+			libraries,
+			injectorPreload,
+			// Per-volume files and directories
+			agentEnv,
+			socketsDirectory,
+			scratch,
 		)
+		owners[DatadogLibrary] = library
+		owners[DatadogLibraries] = libraries
+		owners[DatadogInjectorPreload] = injectorPreload
+		owners[DatadogAgentEnv] = agentEnv
+		owners[AgentSocketsDirectory] = socketsDirectory
+		owners[DatadogScratch] = scratch
 	} else {
 		log.Info("SSI storage publishers are disabled because storageBasePath is empty")
 	}
 
+	socket := newSocketPublisher(fs, mounter, sockets, config.SocketRebinder, config.SocketWait)
+	local := newLocalPublisher(fs, mounter, sockets)
+	socketLegacy := newSocketLegacyPublisher(fs, mounter, sockets, config.LegacySchema)
+	localLegacy := newLocalLegacyPublisher(fs, mounter, sockets, config.LegacySchema)
+	unmount := newUnmountPublisher(fs, mounter)
+
 	publishers = append(
 		publishers,
 
 		// New "type" schema publishers
-		newSocketPublisher(fs, mounter, apmSocketPath, dsdSocketPath),
-		newLocalPublisher(fs, mounter, apmSocketPath, dsdSocketPath),
+		socket,
+		local,
 
 		// Legacy "mode/path" schema publishers (deprecated)
-		newSocketLegacyPublisher(fs, mounter, apmSocketPath, dsdSocketPath),
-		newLocalLegacyPublisher(fs, mounter, apmSocketPath, dsdSocketPath),
+		socketLegacy,
+		localLegacy,
 
-		// Fallback unmount handler for most Unpublish requests
-		newUnmountPublisher(fs, mounter),
+		// Fallback unmount handler for most Unpublish and Stats requests
+		unmount,
 	)
 
+	for volumeType, namedSocket := range sockets {
+		if namedSocket.Mode == SocketModeDirectory {
+			owners[volumeType] = local
+		} else {
+			owners[volumeType] = socket
+		}
+	}
+	// Legacy publishers report their mode as the volume type
+	owners[VolumeType(modeSocket)] = socketLegacy
+	owners[VolumeType(modeLocal)] = localLegacy
+
 	// Order matters, the first publisher to return a response will stop the chain
-	return newChainPublisher(publishers...)
+	chain := newChainPublisher(publishers...)
+
+	// Publications are persisted in the library manager database, which only exists with writable storage
+	if config.LibraryManager == nil {
+		return chain
+	}
+	return newRoutingPublisher(chain, owners, unmount, config.LibraryManager)
 }
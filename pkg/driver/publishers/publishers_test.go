@@ -6,6 +6,7 @@
 package publishers
 
 import (
+	"context"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -18,10 +19,15 @@ func TestGetPublishers_SkipsSSIPublishersWhenStorageBasePathIsEmpty(t *testing.T
 	fs := afero.Afero{Fs: afero.NewMemMapFs()}
 	mounter := mount.NewFakeMounter(nil)
 
-	publisher := GetPublishers(fs, mounter, "/tmp/apm.sock", "/tmp/dsd.sock", "", nil, true, nil)
+	publisher := GetPublishers(Config{
+		FS:         fs,
+		Mounter:    mounter,
+		Sockets:    testSocketRegistry(t, "/tmp/apm.sock", "/tmp/dsd.sock"),
+		APMEnabled: true,
+	})
 
 	t.Run("library volume is ignored", func(t *testing.T) {
-		resp, err := publisher.Publish(&csi.NodePublishVolumeRequest{
+		resp, err := publisher.Publish(context.Background(), &csi.NodePublishVolumeRequest{
 			VolumeId:   "library-volume",
 			TargetPath: "/target/library",
 			Readonly:   true,
@@ -35,7 +41,7 @@ func TestGetPublishers_SkipsSSIPublishersWhenStorageBasePathIsEmpty(t *testing.T
 	})
 
 	t.Run("injector preload volume is ignored", func(t *testing.T) {
-		resp, err := publisher.Publish(&csi.NodePublishVolumeRequest{
+		resp, err := publisher.Publish(context.Background(), &csi.NodePublishVolumeRequest{
 			VolumeId:   "preload-volume",
 			TargetPath: "/target/ld.so.preload",
 			Readonly:   true,
@@ -0,0 +1,131 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"context"
+	log "log/slog"
+	"time"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/librarymanager"
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// publicationStore persists publication records. It is implemented by the library manager.
+type publicationStore interface {
+	RecordPublication(volumeID string, publication librarymanager.Publication) error
+	GetPublication(volumeID string) (librarymanager.Publication, bool, error)
+	RemovePublication(volumeID string) error
+}
+
+// routingPublisher records every successful publish, whatever the volume type, so that Unpublish and Stats
+// requests, which don't have VolumeContext, can be dispatched to the publisher that owns the volume.
+// Volumes without a record, for instance published before the driver was upgraded, go through the chain.
+type routingPublisher struct {
+	chain    Publisher
+	owners   map[VolumeType]Publisher
+	fallback Publisher
+	store    publicationStore
+}
+
+func (r routingPublisher) Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
+	resp, err := r.chain.Publish(ctx, req)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+
+	// The volume is mounted at this point: failing to record it only means its
+	// unpublish will go through the chain, so the publish is not failed.
+	publication := librarymanager.Publication{
+		VolumeType:  string(resp.VolumeType),
+		HostPath:    resp.HostPath,
+		TargetPath:  req.GetTargetPath(),
+		PublishedAt: time.Now(),
+		Pod:         resp.Pod,
+	}
+	if err := r.store.RecordPublication(req.GetVolumeId(), publication); err != nil {
+		log.ErrorContext(ctx, "failed to record volume publication", "volume_id", req.GetVolumeId(), "error", err)
+	}
+	return resp, nil
+}
+
+func (r routingPublisher) Unpublish(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+	publication, found := r.getPublication(req.GetVolumeId())
+	if !found {
+		return r.chain.Unpublish(ctx, req)
+	}
+
+	ctx = podinfo.NewContext(ctx, publication.Pod)
+	resp, err := r.owner(publication).Unpublish(ctx, req)
+	if resp == nil && err == nil {
+		resp, err = r.fallback.Unpublish(ctx, req)
+	}
+	if err == nil {
+		if err := r.store.RemovePublication(req.GetVolumeId()); err != nil {
+			log.ErrorContext(ctx, "failed to remove volume publication", "volume_id", req.GetVolumeId(), "error", err)
+		}
+	}
+	return withPublication(resp, publication), err
+}
+
+func (r routingPublisher) Stats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*VolumeStats, error) {
+	publication, found := r.getPublication(req.GetVolumeId())
+	if !found {
+		return r.chain.Stats(ctx, req)
+	}
+
+	ctx = podinfo.NewContext(ctx, publication.Pod)
+	stats, err := r.owner(publication).Stats(ctx, req)
+	if stats == nil && err == nil {
+		stats, err = r.fallback.Stats(ctx, req)
+	}
+	if stats != nil && stats.VolumeType == "" {
+		out := *stats
+		out.VolumeType = VolumeType(publication.VolumeType)
+		stats = &out
+	}
+	return stats, err
+}
+
+// getPublication returns the publication record of a volume. A lookup error is
+// treated as a missing record so the request still goes through the chain.
+func (r routingPublisher) getPublication(volumeID string) (librarymanager.Publication, bool) {
+	publication, found, err := r.store.GetPublication(volumeID)
+	if err != nil {
+		log.Warn("failed to get volume publication, falling back to the publisher chain", "volume_id", volumeID, "error", err)
+		return librarymanager.Publication{}, false
+	}
+	return publication, found
+}
+
+// owner returns the publisher owning the volume type of a publication, or the
+// fallback for types this driver no longer knows about.
+func (r routingPublisher) owner(publication librarymanager.Publication) Publisher {
+	if owner, ok := r.owners[VolumeType(publication.VolumeType)]; ok {
+		return owner
+	}
+	return r.fallback
+}
+
+// withPublication stamps the recorded volume type, host path and pod on a response,
+// which the owner may have left empty since Unpublish has no VolumeContext.
+func withPublication(resp *PublisherResponse, publication librarymanager.Publication) *PublisherResponse {
+	if resp == nil {
+		return nil
+	}
+	out := *resp
+	out.VolumeType = VolumeType(publication.VolumeType)
+	if out.HostPath == "" {
+		out.HostPath = publication.HostPath
+	}
+	out.Pod = publication.Pod
+	return &out
+}
+
+func newRoutingPublisher(chain Publisher, owners map[VolumeType]Publisher, fallback Publisher, store publicationStore) Publisher {
+	return routingPublisher{chain: chain, owners: owners, fallback: fallback, store: store}
+}
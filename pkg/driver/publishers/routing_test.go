@@ -0,0 +1,288 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/librarymanager"
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/mount"
+)
+
+// memoryPublicationStore is an in-memory publicationStore.
+type memoryPublicationStore struct {
+	publications map[string]librarymanager.Publication
+	err          error
+}
+
+func newMemoryPublicationStore() *memoryPublicationStore {
+	return &memoryPublicationStore{publications: map[string]librarymanager.Publication{}}
+}
+
+func (m *memoryPublicationStore) RecordPublication(volumeID string, publication librarymanager.Publication) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.publications[volumeID] = publication
+	return nil
+}
+
+func (m *memoryPublicationStore) GetPublication(volumeID string) (librarymanager.Publication, bool, error) {
+	if m.err != nil {
+		return librarymanager.Publication{}, false, m.err
+	}
+	publication, found := m.publications[volumeID]
+	return publication, found, nil
+}
+
+func (m *memoryPublicationStore) RemovePublication(volumeID string) error {
+	if m.err != nil {
+		return m.err
+	}
+	delete(m.publications, volumeID)
+	return nil
+}
+
+func TestRoutingPublisher_Publish_RecordsPublication(t *testing.T) {
+	store := newMemoryPublicationStore()
+	chain := mockPublisher{publishResp: &PublisherResponse{VolumeType: APMSocket, VolumePath: "/apm.socket", HostPath: "/apm.socket"}}
+	router := newRoutingPublisher(chain, nil, mockPublisher{}, store)
+
+	resp, err := router.Publish(context.Background(), &csi.NodePublishVolumeRequest{VolumeId: "vol-1", TargetPath: "/target"})
+
+	require.NoError(t, err)
+	assert.Equal(t, APMSocket, resp.VolumeType)
+	publication, found := store.publications["vol-1"]
+	require.True(t, found)
+	assert.Equal(t, string(APMSocket), publication.VolumeType)
+	assert.Equal(t, "/apm.socket", publication.HostPath)
+	assert.Equal(t, "/target", publication.TargetPath)
+	assert.False(t, publication.PublishedAt.IsZero())
+}
+
+// podCapturingPublisher records the pod found in the context of Unpublish requests.
+type podCapturingPublisher struct {
+	pod *podinfo.PodInfo
+}
+
+func (p podCapturingPublisher) Publish(context.Context, *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
+	return nil, nil
+}
+
+func (p podCapturingPublisher) Unpublish(ctx context.Context, _ *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+	*p.pod, _ = podinfo.FromContext(ctx)
+	return &PublisherResponse{}, nil
+}
+
+func (p podCapturingPublisher) Stats(context.Context, *csi.NodeGetVolumeStatsRequest) (*VolumeStats, error) {
+	return nil, nil
+}
+
+func TestRoutingPublisher_PodInfo(t *testing.T) {
+	store := newMemoryPublicationStore()
+	pod := podinfo.PodInfo{Name: "web-0", Namespace: "shop", UID: "uid-1"}
+	var unpublishedPod podinfo.PodInfo
+	router := newRoutingPublisher(
+		mockPublisher{publishResp: &PublisherResponse{VolumeType: DatadogLibrary, Pod: pod}},
+		map[VolumeType]Publisher{DatadogLibrary: podCapturingPublisher{pod: &unpublishedPod}},
+		mockPublisher{unpublishResp: &PublisherResponse{}},
+		store,
+	)
+
+	_, err := router.Publish(context.Background(), &csi.NodePublishVolumeRequest{VolumeId: "vol-1", TargetPath: "/target"})
+	require.NoError(t, err)
+	assert.Equal(t, pod, store.publications["vol-1"].Pod)
+
+	resp, err := router.Unpublish(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "vol-1", TargetPath: "/target"})
+	require.NoError(t, err)
+	assert.Equal(t, pod, resp.Pod)
+	assert.Equal(t, pod, unpublishedPod, "the owner gets the pod of the publication through the context")
+}
+
+func TestRoutingPublisher_Publish_DoesNotRecordFailures(t *testing.T) {
+	tests := map[string]mockPublisher{
+		"failed publish":      {publishResp: &PublisherResponse{VolumeType: APMSocket}, publishErr: errors.New("boom")},
+		"unsupported publish": {},
+	}
+	for name, chain := range tests {
+		t.Run(name, func(t *testing.T) {
+			store := newMemoryPublicationStore()
+			router := newRoutingPublisher(chain, nil, mockPublisher{}, store)
+
+			_, _ = router.Publish(context.Background(), &csi.NodePublishVolumeRequest{VolumeId: "vol-1", TargetPath: "/target"})
+
+			assert.Empty(t, store.publications)
+		})
+	}
+}
+
+func TestRoutingPublisher_Publish_IgnoresStoreErrors(t *testing.T) {
+	store := newMemoryPublicationStore()
+	store.err = errors.New("database closed")
+	chain := mockPublisher{publishResp: &PublisherResponse{VolumeType: APMSocket}}
+	router := newRoutingPublisher(chain, nil, mockPublisher{}, store)
+
+	resp, err := router.Publish(context.Background(), &csi.NodePublishVolumeRequest{VolumeId: "vol-1", TargetPath: "/target"})
+
+	require.NoError(t, err, "the volume is mounted, a missing record must not fail the publish")
+	assert.Equal(t, APMSocket, resp.VolumeType)
+}
+
+func TestRoutingPublisher_Unpublish(t *testing.T) {
+	ownerResp := &PublisherResponse{VolumeType: DatadogLibrary}
+	fallbackResp := &PublisherResponse{}
+	chainResp := &PublisherResponse{VolumeType: "Chain"}
+
+	tests := map[string]struct {
+		publication    *librarymanager.Publication
+		owners         map[VolumeType]Publisher
+		expectedType   VolumeType
+		expectedHost   string
+		expectErr      bool
+		expectRecorded bool
+	}{
+		"volume without a record goes through the chain": {
+			expectedType: "Chain",
+		},
+		"owner handles the volume": {
+			publication:  &librarymanager.Publication{VolumeType: string(DatadogLibrary), HostPath: "/store/lib"},
+			owners:       map[VolumeType]Publisher{DatadogLibrary: mockPublisher{unpublishResp: ownerResp}},
+			expectedType: DatadogLibrary,
+			expectedHost: "/store/lib",
+		},
+		"owner without unpublish logic falls back to unmount": {
+			publication:  &librarymanager.Publication{VolumeType: string(APMSocket), HostPath: "/apm.socket"},
+			owners:       map[VolumeType]Publisher{APMSocket: mockPublisher{}},
+			expectedType: APMSocket,
+			expectedHost: "/apm.socket",
+		},
+		"unknown volume type falls back to unmount": {
+			publication:  &librarymanager.Publication{VolumeType: "Removed"},
+			expectedType: "Removed",
+		},
+		"failed unpublish keeps the record": {
+			publication:    &librarymanager.Publication{VolumeType: string(DatadogLibrary)},
+			owners:         map[VolumeType]Publisher{DatadogLibrary: mockPublisher{unpublishResp: ownerResp, unpublishErr: errors.New("busy")}},
+			expectedType:   DatadogLibrary,
+			expectErr:      true,
+			expectRecorded: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			store := newMemoryPublicationStore()
+			if tc.publication != nil {
+				store.publications["vol-1"] = *tc.publication
+			}
+			router := newRoutingPublisher(
+				mockPublisher{unpublishResp: chainResp},
+				tc.owners,
+				mockPublisher{unpublishResp: fallbackResp},
+				store,
+			)
+
+			resp, err := router.Unpublish(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "vol-1", TargetPath: "/target"})
+
+			if tc.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			require.NotNil(t, resp)
+			assert.Equal(t, tc.expectedType, resp.VolumeType)
+			assert.Equal(t, tc.expectedHost, resp.HostPath)
+			_, recorded := store.publications["vol-1"]
+			assert.Equal(t, tc.expectRecorded, recorded)
+		})
+	}
+}
+
+func TestRoutingPublisher_Stats(t *testing.T) {
+	store := newMemoryPublicationStore()
+	store.publications["socket-volume"] = librarymanager.Publication{VolumeType: string(DSDSocket)}
+	store.publications["directory-volume"] = librarymanager.Publication{VolumeType: string(DSDSocketDirectory)}
+	router := newRoutingPublisher(
+		mockPublisher{statsResp: &VolumeStats{VolumeType: "Chain"}},
+		map[VolumeType]Publisher{
+			DSDSocket:          mockPublisher{statsResp: &VolumeStats{VolumeType: DSDSocket, Abnormal: true}},
+			DSDSocketDirectory: mockPublisher{},
+		},
+		mockPublisher{statsResp: &VolumeStats{UsedBytes: 42}},
+		store,
+	)
+
+	t.Run("owner reports the stats", func(t *testing.T) {
+		stats, err := router.Stats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "socket-volume"})
+		require.NoError(t, err)
+		assert.Equal(t, DSDSocket, stats.VolumeType)
+		assert.True(t, stats.Abnormal)
+	})
+
+	t.Run("fallback stats carry the recorded type", func(t *testing.T) {
+		stats, err := router.Stats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "directory-volume"})
+		require.NoError(t, err)
+		assert.Equal(t, DSDSocketDirectory, stats.VolumeType)
+		assert.Equal(t, int64(42), stats.UsedBytes)
+	})
+
+	t.Run("volume without a record goes through the chain", func(t *testing.T) {
+		stats, err := router.Stats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "unknown-volume"})
+		require.NoError(t, err)
+		assert.Equal(t, VolumeType("Chain"), stats.VolumeType)
+	})
+}
+
+func TestGetPublishers_RoutesUnpublishWithLibraryManager(t *testing.T) {
+	basePath := t.TempDir()
+	fs := afero.Afero{Fs: afero.NewOsFs()}
+	lm, err := librarymanager.NewLibraryManager(basePath, librarymanager.WithFilesystem(fs))
+	require.NoError(t, err)
+	defer lm.Stop()
+
+	socketDir := filepath.Join(t.TempDir(), "datadog")
+	require.NoError(t, fs.MkdirAll(socketDir, 0o755))
+	mounter := mount.NewFakeMounter(nil)
+	apmSocketPath, dsdSocketPath := filepath.Join(socketDir, "apm.socket"), filepath.Join(socketDir, "dsd.socket")
+	publisher := GetPublishers(Config{
+		FS:              fs,
+		Mounter:         mounter,
+		StorageBasePath: basePath,
+		Sockets:         testSocketRegistry(t, apmSocketPath, dsdSocketPath),
+		LibraryManager:  lm,
+		APMEnabled:      true,
+	})
+
+	targetPath := filepath.Join(t.TempDir(), "target")
+	_, err = publisher.Publish(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:      "vol-1",
+		TargetPath:    targetPath,
+		VolumeContext: map[string]string{"type": string(APMSocketDirectory)},
+	})
+	require.NoError(t, err)
+
+	publication, found, err := lm.GetPublication("vol-1")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, string(APMSocketDirectory), publication.VolumeType)
+	assert.Equal(t, socketDir, publication.HostPath)
+
+	resp, err := publisher.Unpublish(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "vol-1", TargetPath: targetPath})
+	require.NoError(t, err)
+	assert.Equal(t, APMSocketDirectory, resp.VolumeType)
+
+	_, found, err = lm.GetPublication("vol-1")
+	require.NoError(t, err)
+	assert.False(t, found, "the record is removed once the volume is unpublished")
+}
@@ -0,0 +1,259 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/spf13/afero"
+	"golang.org/x/sys/unix"
+	"k8s.io/apimachinery/pkg/api/resource"
+	utilexec "k8s.io/utils/exec"
+	"k8s.io/utils/mount"
+)
+
+const (
+	// keyScratchSize is the VolumeContext key giving the size cap of a
+	// DatadogScratch volume, as a Kubernetes quantity (e.g. "64Mi").
+	keyScratchSize = "dd.csi.datadog.com/scratch.size"
+
+	// defaultScratchSize is the size cap of the scratch volumes that do not set keyScratchSize
+	defaultScratchSize = 64 << 20
+	// defaultMaxScratchSize is the largest size cap a scratch volume may set
+	defaultMaxScratchSize = 1 << 30
+	// minScratchSize is the smallest size cap, below which the filesystem of the volume cannot be created
+	minScratchSize = 1 << 20
+
+	// scratchDir is the directory of the per-volume scratch directories, under the storage base path
+	scratchDir = "scratch"
+	// scratchDataDir is the directory mounted by a scratch volume, under its per-volume directory
+	scratchDataDir = "data"
+	// scratchImageFile is the sparse file holding the filesystem of a volume, under its per-volume directory
+	scratchImageFile = "data.img"
+	// scratchFSType is the filesystem of the scratch volumes
+	scratchFSType = "ext4"
+)
+
+// ScratchSize bounds the size caps of the scratch volumes. The scratch
+// filesystems share the storage path with the library store and the driver
+// database, so a volume may not set a size cap above Max.
+type ScratchSize struct {
+	// Default is the size cap of the volumes that do not set one, 64Mi when it is zero.
+	Default int64
+	// Max is the largest size cap of a volume, 1Gi when it is zero.
+	Max int64
+}
+
+// forVolume returns the size cap of a volume, parsed from the keyScratchSize attribute.
+func (s ScratchSize) forVolume(value string) (int64, error) {
+	if s.Default <= 0 {
+		s.Default = defaultScratchSize
+	}
+	if s.Max <= 0 {
+		s.Max = defaultMaxScratchSize
+	}
+	if value == "" {
+		return s.Default, nil
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", keyScratchSize, value, err)
+	}
+	if quantity.Value() < minScratchSize {
+		return 0, fmt.Errorf("invalid %s %q: must be at least 1Mi", keyScratchSize, value)
+	}
+	if quantity.Value() > s.Max {
+		return 0, fmt.Errorf("invalid %s %q: must be at most %s", keyScratchSize, value, resource.NewQuantity(s.Max, resource.BinarySI))
+	}
+	return quantity.Value(), nil
+}
+
+// scratchAvailableBytes returns the bytes left on the filesystem mounted at path.
+var scratchAvailableBytes = func(path string) (int64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * stat.Bsize, nil
+}
+
+// scratchPublisher handles DatadogScratch volumes. It mounts a writable
+// directory private to the volume, for tracer crash dumps, profiler spill
+// files and remote configuration caches. The directory is a filesystem held
+// in a sparse file of the size cap of the volume and loop-mounted, so that
+// writes fail with ENOSPC once the cap is reached.
+type scratchPublisher struct {
+	fs      afero.Afero
+	mounter mount.Interface
+	exec    utilexec.Interface
+	// basePath is the directory holding one scratch directory per volume
+	basePath string
+	// size bounds the size caps of the volumes
+	size ScratchSize
+}
+
+// Publish creates the scratch filesystem of the volume, mounts it, and bind-mounts it to the target path.
+func (s scratchPublisher) Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
+	volumeCtx := req.GetVolumeContext()
+	if VolumeType(volumeCtx["type"]) != DatadogScratch {
+		return nil, nil // Not our volume
+	}
+	resp := &PublisherResponse{VolumeType: DatadogScratch}
+
+	dir, err := volumeDir(s.basePath, req.GetVolumeId())
+	if err != nil {
+		return resp, err
+	}
+	sizeBytes, err := s.size.forVolume(volumeCtx[keyScratchSize])
+	if err != nil {
+		return resp, err
+	}
+
+	dataDir := filepath.Join(dir, scratchDataDir)
+	resp.VolumePath = dataDir
+	resp.HostPath = dataDir
+	if err := s.fs.MkdirAll(dataDir, 0o755); err != nil {
+		return resp, fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+	// The filesystem of a republished volume keeps its size and content
+	imagePath := filepath.Join(dir, scratchImageFile)
+	exists, err := s.fs.Exists(imagePath)
+	if err != nil {
+		return resp, fmt.Errorf("failed to create scratch filesystem: %w", err)
+	}
+	if !exists {
+		if err := s.createImage(imagePath, sizeBytes); err != nil {
+			return resp, fmt.Errorf("failed to create scratch filesystem: %w", err)
+		}
+	}
+	notMnt, err := s.mounter.IsLikelyNotMountPoint(dataDir)
+	if err != nil {
+		return resp, fmt.Errorf("failed to check scratch filesystem mount: %w", err)
+	}
+	if notMnt {
+		if err := s.mounter.Mount(imagePath, dataDir, scratchFSType, append([]string{"loop"}, mountOptions(DatadogScratch)...)); err != nil {
+			return resp, fmt.Errorf("failed to mount scratch filesystem: %w", err)
+		}
+	}
+	// Like emptyDir, the directory is writable by the containers whatever their user
+	if err := s.fs.Chmod(dataDir, 0o777); err != nil {
+		return resp, fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+
+	return resp, bindMount(ctx, s.fs, s.mounter, bindMountArgs{
+		hostPath:   dataDir,
+		targetPath: req.GetTargetPath(),
+		isFile:     false,
+		readOnly:   req.GetReadonly(),
+		options:    mountOptions(DatadogScratch),
+	})
+}
+
+// createImage creates the sparse file of the size cap of a volume and formats it.
+// The file is removed when it cannot be formatted, so that the next publish creates it again.
+func (s scratchPublisher) createImage(imagePath string, sizeBytes int64) error {
+	file, err := s.fs.Create(imagePath)
+	if err != nil {
+		return err
+	}
+	err = file.Truncate(sizeBytes)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// No blocks are reserved for root, the whole cap is usable by the containers
+		var output []byte
+		output, err = s.exec.Command("mkfs."+scratchFSType, "-F", "-q", "-m0", imagePath).CombinedOutput()
+		if err != nil {
+			err = fmt.Errorf("mkfs failed: %w: %s", err, output)
+		}
+	}
+	if err != nil {
+		_ = s.fs.Remove(imagePath)
+	}
+	return err
+}
+
+// Unpublish unmounts the volume and its scratch filesystem, and deletes it.
+func (s scratchPublisher) Unpublish(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+	// We don't have VolumeContext in Unpublish, so we check if the volume has a scratch directory
+	dir, err := volumeDir(s.basePath, req.GetVolumeId())
+	if err != nil {
+		return nil, nil // Not our volume
+	}
+	exists, err := s.fs.DirExists(dir)
+	if err != nil || !exists {
+		return nil, nil // Not our volume
+	}
+
+	dataDir := filepath.Join(dir, scratchDataDir)
+	resp := &PublisherResponse{VolumeType: DatadogScratch, VolumePath: dataDir, HostPath: dataDir}
+	if err := bindUnmount(ctx, s.fs, s.mounter, req.GetTargetPath()); err != nil {
+		return resp, fmt.Errorf("failed to unmount scratch directory: %w", err)
+	}
+	// The scratch filesystem is unmounted first, so that its image is not removed while in use
+	notMnt, err := s.mounter.IsLikelyNotMountPoint(dataDir)
+	if err != nil && !os.IsNotExist(err) {
+		return resp, fmt.Errorf("failed to check scratch filesystem mount: %w", err)
+	}
+	if err == nil && !notMnt {
+		if err := s.mounter.Unmount(dataDir); err != nil {
+			return resp, fmt.Errorf("failed to unmount scratch filesystem: %w", err)
+		}
+	}
+	if err := s.fs.RemoveAll(dir); err != nil {
+		return resp, fmt.Errorf("failed to remove scratch directory: %w", err)
+	}
+	return resp, nil
+}
+
+// Stats reports the usage of the scratch directory of a volume.
+// The volume is reported as abnormal once it is full.
+func (s scratchPublisher) Stats(_ context.Context, req *csi.NodeGetVolumeStatsRequest) (*VolumeStats, error) {
+	dir, err := volumeDir(s.basePath, req.GetVolumeId())
+	if err != nil {
+		return nil, nil // Not our volume
+	}
+	exists, err := s.fs.DirExists(dir)
+	if err != nil || !exists {
+		return nil, nil // Not our volume
+	}
+
+	dataDir := filepath.Join(dir, scratchDataDir)
+	usedBytes, usedInodes, err := pathUsage(s.fs, dataDir)
+	if err != nil {
+		return &VolumeStats{VolumeType: DatadogScratch}, fmt.Errorf("failed to compute scratch usage: %w", err)
+	}
+	stats := &VolumeStats{VolumeType: DatadogScratch, UsedBytes: usedBytes, UsedInodes: usedInodes}
+
+	availableBytes, err := scratchAvailableBytes(dataDir)
+	if err != nil {
+		return stats, fmt.Errorf("failed to compute scratch usage: %w", err)
+	}
+	if availableBytes == 0 {
+		var sizeBytes int64
+		if info, err := s.fs.Stat(filepath.Join(dir, scratchImageFile)); err == nil {
+			sizeBytes = info.Size()
+		}
+		stats.Abnormal = true
+		stats.Message = fmt.Sprintf("the volume reached its size cap of %d bytes, writes fail until files are removed", sizeBytes)
+	}
+	return stats, nil
+}
+
+func newScratchPublisher(fs afero.Afero, mounter mount.Interface, exec utilexec.Interface, storageBasePath string, size ScratchSize) Publisher {
+	return scratchPublisher{
+		fs:       fs,
+		mounter:  mounter,
+		exec:     exec,
+		basePath: filepath.Join(storageBasePath, scratchDir),
+		size:     size,
+	}
+}
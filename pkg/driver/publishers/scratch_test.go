@@ -0,0 +1,216 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	utilexec "k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+	"k8s.io/utils/mount"
+)
+
+func TestScratchSize_ForVolume(t *testing.T) {
+	tests := map[string]struct {
+		size      ScratchSize
+		value     string
+		expected  int64
+		expectErr bool
+	}{
+		"default size":                 {value: "", expected: defaultScratchSize},
+		"configured default size":      {size: ScratchSize{Default: 8 << 20}, value: "", expected: 8 << 20},
+		"binary suffix":                {value: "128Mi", expected: 128 << 20},
+		"decimal suffix":               {value: "1G", expected: 1_000_000_000},
+		"plain bytes":                  {value: "4194304", expected: 4 << 20},
+		"default maximum":              {value: "1Gi", expected: 1 << 30},
+		"above the maximum":            {value: "1Ti", expectErr: true},
+		"above the configured maximum": {size: ScratchSize{Max: 256 << 20}, value: "512Mi", expectErr: true},
+		"below the minimum":            {value: "4096", expectErr: true},
+		"invalid quantity":             {value: "lots", expectErr: true},
+		"zero":                         {value: "0", expectErr: true},
+		"negative quantity":            {value: "-1Mi", expectErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			size, err := tc.size.forVolume(tc.value)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, size)
+		})
+	}
+}
+
+// fakeMkfs scripts one mkfs command returning err, and records its arguments.
+func fakeMkfs(argv *[]string, err error) testingexec.FakeCommandAction {
+	return func(cmd string, args ...string) utilexec.Cmd {
+		*argv = append([]string{cmd}, args...)
+		return &testingexec.FakeCmd{CombinedOutputScript: []testingexec.FakeAction{
+			func() ([]byte, []byte, error) { return nil, nil, err },
+		}}
+	}
+}
+
+func TestScratchPublisher(t *testing.T) {
+	availableBytes := int64(1)
+	originalAvailableBytes := scratchAvailableBytes
+	scratchAvailableBytes = func(string) (int64, error) {
+		return availableBytes, nil
+	}
+	t.Cleanup(func() {
+		scratchAvailableBytes = originalAvailableBytes
+	})
+
+	fs := afero.Afero{Fs: afero.NewOsFs()}
+	storagePath := t.TempDir()
+	targetPath := filepath.Join(t.TempDir(), "target")
+	volumeDir := filepath.Join(storagePath, scratchDir, "vol-1")
+	dataDir := filepath.Join(volumeDir, scratchDataDir)
+	imagePath := filepath.Join(volumeDir, scratchImageFile)
+
+	var mkfsArgv []string
+	exec := &testingexec.FakeExec{CommandScript: []testingexec.FakeCommandAction{
+		fakeMkfs(&mkfsArgv, errors.New("mkfs.ext4: not found")),
+		fakeMkfs(&mkfsArgv, nil),
+	}}
+	mounter := mount.NewFakeMounter(nil)
+	publisher := newScratchPublisher(fs, mounter, exec, storagePath, ScratchSize{Max: 4 << 20})
+	ctx := context.Background()
+	publishReq := &csi.NodePublishVolumeRequest{
+		VolumeId:      "vol-1",
+		TargetPath:    targetPath,
+		VolumeContext: map[string]string{"type": string(DatadogScratch), keyScratchSize: "2Mi"},
+	}
+
+	t.Run("other volume types are not supported", func(t *testing.T) {
+		resp, err := publisher.Publish(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:      "vol-1",
+			TargetPath:    targetPath,
+			VolumeContext: map[string]string{"type": string(DatadogAgentEnv)},
+		})
+		assert.Nil(t, resp)
+		assert.NoError(t, err)
+	})
+
+	t.Run("invalid size fails the publish", func(t *testing.T) {
+		resp, err := publisher.Publish(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:      "vol-1",
+			TargetPath:    targetPath,
+			VolumeContext: map[string]string{"type": string(DatadogScratch), keyScratchSize: "lots"},
+		})
+		require.NotNil(t, resp)
+		assert.ErrorContains(t, err, keyScratchSize)
+		assert.Empty(t, mounter.GetLog())
+	})
+
+	t.Run("size above the maximum fails the publish", func(t *testing.T) {
+		resp, err := publisher.Publish(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:      "vol-1",
+			TargetPath:    targetPath,
+			VolumeContext: map[string]string{"type": string(DatadogScratch), keyScratchSize: "1Ti"},
+		})
+		require.NotNil(t, resp)
+		assert.ErrorContains(t, err, "must be at most 4Mi")
+		assert.Empty(t, mounter.GetLog())
+		exists, err := fs.Exists(imagePath)
+		require.NoError(t, err)
+		assert.False(t, exists, "no filesystem image is created")
+	})
+
+	t.Run("format failures remove the filesystem image", func(t *testing.T) {
+		resp, err := publisher.Publish(ctx, publishReq)
+		require.NotNil(t, resp)
+		assert.ErrorContains(t, err, "mkfs.ext4: not found")
+		assert.Empty(t, mounter.GetLog())
+		exists, err := fs.Exists(imagePath)
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("publish mounts a size-capped filesystem", func(t *testing.T) {
+		resp, err := publisher.Publish(ctx, publishReq)
+		require.NoError(t, err)
+		assert.Equal(t, DatadogScratch, resp.VolumeType)
+		assert.Equal(t, dataDir, resp.HostPath)
+
+		info, err := fs.Stat(imagePath)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2<<20), info.Size())
+		assert.Equal(t, []string{"mkfs.ext4", "-F", "-q", "-m0", imagePath}, mkfsArgv)
+
+		mountPoints, err := mounter.List()
+		require.NoError(t, err)
+		require.Len(t, mountPoints, 2)
+		assert.Equal(t, mount.MountPoint{Device: imagePath, Path: dataDir, Type: "ext4", Opts: []string{"loop", "nosuid", "nodev", "noexec"}}, mountPoints[0])
+		assert.Equal(t, targetPath, mountPoints[1].Path)
+		assert.Equal(t, []string{"bind", "nosuid", "nodev", "noexec"}, mountPoints[1].Opts)
+
+		info, err = fs.Stat(dataDir)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o777), info.Mode().Perm())
+	})
+
+	t.Run("republish keeps the filesystem", func(t *testing.T) {
+		require.NoError(t, fs.WriteFile(filepath.Join(dataDir, "dump"), []byte("0123456789"), 0o644))
+		_, err := publisher.Publish(ctx, publishReq)
+		require.NoError(t, err)
+		assert.Equal(t, 2, exec.CommandCalls, "the filesystem is only formatted once")
+		mountPoints, err := mounter.List()
+		require.NoError(t, err)
+		assert.Len(t, mountPoints, 2)
+		content, err := fs.ReadFile(filepath.Join(dataDir, "dump"))
+		require.NoError(t, err)
+		assert.Equal(t, "0123456789", string(content))
+	})
+
+	t.Run("stats report the usage", func(t *testing.T) {
+		stats, err := publisher.Stats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: "vol-1", VolumePath: targetPath})
+		require.NoError(t, err)
+		require.NotNil(t, stats)
+		assert.Equal(t, DatadogScratch, stats.VolumeType)
+		assert.Equal(t, int64(10), stats.UsedBytes)
+		assert.Equal(t, int64(2), stats.UsedInodes)
+		assert.False(t, stats.Abnormal)
+	})
+
+	t.Run("full volumes are abnormal", func(t *testing.T) {
+		availableBytes = 0
+		stats, err := publisher.Stats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: "vol-1", VolumePath: targetPath})
+		require.NoError(t, err)
+		require.NotNil(t, stats)
+		assert.True(t, stats.Abnormal)
+		assert.Contains(t, stats.Message, "size cap of 2097152 bytes")
+	})
+
+	t.Run("unpublish deletes the scratch filesystem", func(t *testing.T) {
+		resp, err := publisher.Unpublish(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "vol-1", TargetPath: targetPath})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, DatadogScratch, resp.VolumeType)
+
+		exists, err := fs.Exists(volumeDir)
+		require.NoError(t, err)
+		assert.False(t, exists)
+		mountPoints, err := mounter.List()
+		require.NoError(t, err)
+		assert.Empty(t, mountPoints)
+
+		resp, err = publisher.Unpublish(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "vol-1", TargetPath: targetPath})
+		assert.Nil(t, resp, "unknown volumes belong to other publishers")
+		assert.NoError(t, err)
+	})
+}
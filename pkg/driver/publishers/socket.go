@@ -6,41 +6,57 @@
 package publishers
 
 import (
+	"context"
 	"fmt"
+	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/spf13/afero"
 	"k8s.io/utils/mount"
 )
 
-// socketPublisher handles socket mounts using the "type" schema (APMSocket, DSDSocket).
+// socketPublisher handles socket mounts using the "type" schema: APMSocket,
+// DSDSocket and the sockets of the registry in file mode.
 type socketPublisher struct {
-	fs            afero.Afero
-	mounter       mount.Interface
-	apmSocketPath string
-	dsdSocketPath string
+	fs      afero.Afero
+	mounter mount.Interface
+	sockets SocketRegistry
+	// rebinder re-binds the published volumes when the agent recreates a socket, it may be nil
+	rebinder *SocketRebinder
+	// wait is the node default wait for sockets that are not ready, volumes can override it
+	wait SocketWait
 }
 
 // Publish implements Publisher#Publish for the "type" schema.
-// It handles APMSocket and DSDSocket volume types.
-func (s socketPublisher) Publish(req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
+// It handles the socket volume types in file mode.
+func (s socketPublisher) Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
 	volumeCtx := req.GetVolumeContext()
 
 	// Resolve the type to hostPath
-	var hostPath string
 	volumeType := VolumeType(volumeCtx["type"])
-	switch volumeType {
-	case APMSocket:
-		hostPath = s.apmSocketPath
-	case DSDSocket:
-		hostPath = s.dsdSocketPath
-	default:
+	socket, ok := s.sockets[volumeType]
+	if !ok || socket.Mode != SocketModeFile {
 		return nil, nil
 	}
+	hostPath := socket.Path
 
-	resp := &PublisherResponse{VolumeType: volumeType, VolumePath: hostPath}
+	resp := &PublisherResponse{VolumeType: volumeType, VolumePath: hostPath, HostPath: hostPath}
 	targetPath := req.GetTargetPath()
 
+	if !socket.IsEnabled() {
+		return resp, fmt.Errorf("volume type %q is disabled", volumeType)
+	}
+
+	wait, err := s.wait.forVolume(volumeCtx)
+	if err != nil {
+		return resp, err
+	}
+	if wait.enabled() {
+		if err := waitForSocket(ctx, s.fs, hostPath, wait); err != nil {
+			return resp, err
+		}
+	}
+
 	// Validate that hostPath is a socket
 	hostPathIsSocket, err := isSocketPath(s.fs, hostPath)
 	if err != nil {
@@ -50,18 +66,67 @@ func (s socketPublisher) Publish(req *csi.NodePublishVolumeRequest) (*PublisherR
 		return resp, fmt.Errorf("socket not found at %q", hostPath)
 	}
 
-	return resp, bindMount(s.fs, s.mounter, bindMountArgs{
+	err = bindMount(ctx, s.fs, s.mounter, bindMountArgs{
 		hostPath:   hostPath,
 		targetPath: targetPath,
 		isFile:     true,
-		readOnly:   false,
+		readOnly:   req.GetReadonly(),
+		options:    mountOptions(volumeType),
 	})
+	if err == nil {
+		s.rebinder.Track(volumeType, targetPath)
+	}
+	return resp, err
 }
 
-func (s socketPublisher) Unpublish(req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+func (s socketPublisher) Unpublish(_ context.Context, req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+	s.rebinder.Forget(req.GetTargetPath())
 	return nil, nil // Handled by unmountPublisher
 }
 
-func newSocketPublisher(fs afero.Afero, mounter mount.Interface, apmSocketPath, dsdSocketPath string) Publisher {
-	return socketPublisher{fs: fs, mounter: mounter, apmSocketPath: apmSocketPath, dsdSocketPath: dsdSocketPath}
+// Stats reports the health of socket file volumes.
+// Stats requests don't have VolumeContext, so any socket found at the volume path is considered a socket volume,
+// including the ones published with the legacy schema. A bind mount keeps pointing at the socket inode that existed
+// at publish time: when the agent recreates its socket, the volume is reported as abnormal.
+func (s socketPublisher) Stats(_ context.Context, req *csi.NodeGetVolumeStatsRequest) (*VolumeStats, error) {
+	targetPath := req.GetVolumePath()
+	targetIsSocket, err := isSocketPath(s.fs, targetPath)
+	if err != nil || !targetIsSocket {
+		return nil, nil // Not a socket volume
+	}
+
+	usedBytes, usedInodes, err := pathUsage(s.fs, targetPath)
+	if err != nil {
+		return &VolumeStats{}, err
+	}
+
+	candidates := s.sockets.socketFiles()
+	hostPaths := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		hostPaths = append(hostPaths, candidate.Path)
+		// Same validation as Publish: the bind source must still be a socket
+		hostPathIsSocket, err := isSocketPath(s.fs, candidate.Path)
+		if err != nil || !hostPathIsSocket {
+			continue
+		}
+		same, err := isSameFile(s.fs, targetPath, candidate.Path)
+		if err != nil {
+			return &VolumeStats{}, err
+		}
+		if same {
+			return &VolumeStats{VolumeType: candidate.Name, UsedBytes: usedBytes, UsedInodes: usedInodes}, nil
+		}
+	}
+
+	return &VolumeStats{
+		UsedBytes:  usedBytes,
+		UsedInodes: usedInodes,
+		Abnormal:   true,
+		Message: fmt.Sprintf("the mounted socket no longer matches any agent socket (%s), the agent was probably restarted: restart the pod to reconnect",
+			strings.Join(hostPaths, ", ")),
+	}, nil
+}
+
+func newSocketPublisher(fs afero.Afero, mounter mount.Interface, sockets SocketRegistry, rebinder *SocketRebinder, wait SocketWait) Publisher {
+	return socketPublisher{fs: fs, mounter: mounter, sockets: sockets, rebinder: rebinder, wait: wait}
 }
@@ -6,8 +6,8 @@
 package publishers
 
 import (
+	"context"
 	"fmt"
-	log "log/slog"
 	"slices"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -21,14 +21,15 @@ const modeSocket = "socket"
 // This publisher is deprecated and will be removed in a future release.
 // Use the "type" schema (e.g., type: APMSocket) instead.
 type socketLegacyPublisher struct {
-	fs            afero.Afero
-	mounter       mount.Interface
-	apmSocketPath string
-	dsdSocketPath string
+	fs      afero.Afero
+	mounter mount.Interface
+	// sockets resolves the APMSocket and DSDSocket types to the paths allowed by the legacy schema
+	sockets      SocketRegistry
+	legacySchema LegacySchema
 }
 
 // Publish implements Publisher#Publish for the deprecated mode/path schema.
-func (s socketLegacyPublisher) Publish(req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
+func (s socketLegacyPublisher) Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
 	volumeCtx := req.GetVolumeContext()
 
 	// Only handle legacy schema (mode/path without type)
@@ -43,13 +44,15 @@ func (s socketLegacyPublisher) Publish(req *csi.NodePublishVolumeRequest) (*Publ
 		return nil, nil
 	}
 
-	log.Warn("Using deprecated mode/path schema. Please migrate to using 'type: APMSocket' or 'type: DSDSocket' instead.")
+	resp := &PublisherResponse{VolumeType: VolumeType(mode), VolumePath: hostPath, HostPath: hostPath}
+	if err := s.legacySchema.enforce(ctx, LegacySchemaUse{Mode: mode, Path: hostPath, Type: s.migrationType(hostPath)}); err != nil {
+		return resp, err
+	}
 
-	resp := &PublisherResponse{VolumeType: VolumeType(mode), VolumePath: hostPath}
 	targetPath := req.GetTargetPath()
 
 	// Validate that hostPath is in the allowed list
-	allowedPaths := []string{s.apmSocketPath, s.dsdSocketPath}
+	allowedPaths := s.allowedPaths()
 	if !slices.Contains(allowedPaths, hostPath) {
 		return resp, fmt.Errorf("path %q is not allowed; permitted paths are %v", hostPath, allowedPaths)
 	}
@@ -63,18 +66,33 @@ func (s socketLegacyPublisher) Publish(req *csi.NodePublishVolumeRequest) (*Publ
 		return resp, fmt.Errorf("socket not found at %q", hostPath)
 	}
 
-	return resp, bindMount(s.fs, s.mounter, bindMountArgs{
+	return resp, bindMount(ctx, s.fs, s.mounter, bindMountArgs{
 		hostPath:   hostPath,
 		targetPath: targetPath,
 		isFile:     true,
-		readOnly:   false,
+		readOnly:   req.GetReadonly(),
+		options:    mountOptions(VolumeType(mode)),
 	})
 }
 
-func (s socketLegacyPublisher) Unpublish(*csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+// allowedPaths returns the paths of the enabled APM and DogStatsD sockets.
+func (s socketLegacyPublisher) allowedPaths() []string {
+	return legacyAllowedPaths(s.sockets, APMSocket, DSDSocket)
+}
+
+// migrationType returns the volume type mounting the same socket as a legacy volume, if any.
+func (s socketLegacyPublisher) migrationType(hostPath string) VolumeType {
+	return legacyMigrationType(s.sockets, hostPath, APMSocket, DSDSocket)
+}
+
+func (s socketLegacyPublisher) Unpublish(context.Context, *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+	return nil, nil // Handled by unmountPublisher
+}
+
+func (s socketLegacyPublisher) Stats(context.Context, *csi.NodeGetVolumeStatsRequest) (*VolumeStats, error) {
 	return nil, nil // Handled by unmountPublisher
 }
 
-func newSocketLegacyPublisher(fs afero.Afero, mounter mount.Interface, apmSocketPath, dsdSocketPath string) Publisher {
-	return socketLegacyPublisher{fs: fs, mounter: mounter, apmSocketPath: apmSocketPath, dsdSocketPath: dsdSocketPath}
+func newSocketLegacyPublisher(fs afero.Afero, mounter mount.Interface, sockets SocketRegistry, legacySchema LegacySchema) Publisher {
+	return socketLegacyPublisher{fs: fs, mounter: mounter, sockets: sockets, legacySchema: legacySchema}
 }
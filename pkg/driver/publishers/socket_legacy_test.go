@@ -6,6 +6,7 @@
 package publishers
 
 import (
+	"context"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -48,7 +49,7 @@ func TestSocketLegacyPublisher_Publish_ModeSelection(t *testing.T) {
 				VolumeContext: tc.volumeContext,
 			}
 
-			resp, err := publisher.Publish(req)
+			resp, err := publisher.Publish(context.Background(), req)
 			assert.Nil(t, resp)
 			assert.NoError(t, err)
 		})
@@ -72,10 +73,7 @@ func TestSocketLegacyPublisher_Publish_PathValidation(t *testing.T) {
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			publisher := socketLegacyPublisher{
-				apmSocketPath: "/var/run/apm.sock",
-				dsdSocketPath: "/var/run/dsd.sock",
-			}
+			publisher := socketLegacyPublisher{sockets: testSocketRegistry(t, "/var/run/apm.sock", "/var/run/dsd.sock")}
 
 			req := &csi.NodePublishVolumeRequest{
 				VolumeId:      "test-volume",
@@ -83,7 +81,7 @@ func TestSocketLegacyPublisher_Publish_PathValidation(t *testing.T) {
 				VolumeContext: map[string]string{"mode": "socket", "path": tc.path},
 			}
 
-			resp, err := publisher.Publish(req)
+			resp, err := publisher.Publish(context.Background(), req)
 			assert.NotNil(t, resp, "socket mode should be supported")
 			assert.Error(t, err)
 			assert.Contains(t, err.Error(), "not allowed")
@@ -95,7 +93,7 @@ func TestSocketLegacyPublisher_Publish_SocketNotFound(t *testing.T) {
 	fs := afero.Afero{Fs: afero.NewMemMapFs()}
 	mounter := mount.NewFakeMounter(nil)
 
-	publisher := newSocketLegacyPublisher(fs, mounter, "/var/run/apm.sock", "/var/run/dsd.sock")
+	publisher := newSocketLegacyPublisher(fs, mounter, testSocketRegistry(t, "/var/run/apm.sock", "/var/run/dsd.sock"), LegacySchema{})
 
 	req := &csi.NodePublishVolumeRequest{
 		VolumeId:      "test-volume",
@@ -103,7 +101,7 @@ func TestSocketLegacyPublisher_Publish_SocketNotFound(t *testing.T) {
 		VolumeContext: map[string]string{"mode": "socket", "path": "/var/run/apm.sock"},
 	}
 
-	resp, err := publisher.Publish(req)
+	resp, err := publisher.Publish(context.Background(), req)
 
 	// Should return a response (for metrics) and an error
 	assert.NotNil(t, resp)
@@ -122,7 +120,7 @@ func TestSocketLegacyPublisher_Publish_NotASocket(t *testing.T) {
 	_, err := fs.Create("/var/run/apm.sock")
 	require.NoError(t, err)
 
-	publisher := newSocketLegacyPublisher(fs, mounter, "/var/run/apm.sock", "/var/run/dsd.sock")
+	publisher := newSocketLegacyPublisher(fs, mounter, testSocketRegistry(t, "/var/run/apm.sock", "/var/run/dsd.sock"), LegacySchema{})
 
 	req := &csi.NodePublishVolumeRequest{
 		VolumeId:      "test-volume",
@@ -130,7 +128,7 @@ func TestSocketLegacyPublisher_Publish_NotASocket(t *testing.T) {
 		VolumeContext: map[string]string{"mode": "socket", "path": "/var/run/apm.sock"},
 	}
 
-	resp, err := publisher.Publish(req)
+	resp, err := publisher.Publish(context.Background(), req)
 
 	// Should return a response and an error because it's not a socket
 	assert.NotNil(t, resp)
@@ -138,9 +136,25 @@ func TestSocketLegacyPublisher_Publish_NotASocket(t *testing.T) {
 	assert.Contains(t, err.Error(), "socket not found")
 }
 
+func TestSocketLegacyPublisher_Publish_DisabledSocketNotAllowed(t *testing.T) {
+	disabled := false
+	sockets, err := NewSocketRegistry("/var/run/apm.sock", "/var/run/dsd.sock", []NamedSocket{{Name: APMSocket, Enabled: &disabled}})
+	require.NoError(t, err)
+	publisher := newSocketLegacyPublisher(afero.Afero{Fs: afero.NewMemMapFs()}, mount.NewFakeMounter(nil), sockets, LegacySchema{})
+
+	resp, err := publisher.Publish(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:      "test-volume",
+		TargetPath:    "/target/apm.sock",
+		VolumeContext: map[string]string{"mode": "socket", "path": "/var/run/apm.sock"},
+	})
+
+	assert.NotNil(t, resp)
+	assert.ErrorContains(t, err, "is not allowed")
+}
+
 func TestSocketLegacyPublisher_Unpublish_DelegatesToUnmount(t *testing.T) {
 	publisher := socketLegacyPublisher{}
-	resp, err := publisher.Unpublish(&csi.NodeUnpublishVolumeRequest{})
+	resp, err := publisher.Unpublish(context.Background(), &csi.NodeUnpublishVolumeRequest{})
 	assert.Nil(t, resp, "socket legacy should delegate Unpublish to unmountPublisher")
 	assert.NoError(t, err)
 }
@@ -0,0 +1,270 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"fmt"
+	log "log/slog"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/afero"
+	"k8s.io/utils/mount"
+)
+
+// SocketRebinder keeps the socket file volumes bound to the live agent sockets.
+// A bind mount keeps pointing at the socket inode that existed at publish
+// time, so when the agent restarts and recreates its socket, the pods lose
+// their connection to the agent. The rebinder watches the directories of the
+// sockets and binds the new socket over every volume of a recreated socket.
+//
+// A socket directory that does not exist yet, e.g. while the agent starts on
+// node boot, is watched through its nearest existing parent until it is created.
+//
+// Containers only see the new bind mount when their volume mount uses
+// HostToContainer mount propagation; the others keep the dead socket until
+// they restart, and NodeGetVolumeStats keeps reporting them as abnormal.
+type SocketRebinder struct {
+	fs      afero.Afero
+	mounter mount.Interface
+	sockets SocketRegistry
+	// onRebind is called after every re-bind, with its error if it failed
+	onRebind func(volumeType VolumeType, err error)
+
+	mu sync.Mutex
+	// targets maps the target path of every live socket file volume to its volume type
+	targets map[string]VolumeType
+
+	watcher *fsnotify.Watcher
+	// watched holds the socket directories watched directly, it is only used by Start and then the background loop
+	watched  map[string]bool
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewSocketRebinder creates a rebinder of the socket file volumes of the registry.
+// onRebind may be nil.
+func NewSocketRebinder(fs afero.Afero, mounter mount.Interface, sockets SocketRegistry, onRebind func(volumeType VolumeType, err error)) *SocketRebinder {
+	if onRebind == nil {
+		onRebind = func(VolumeType, error) {}
+	}
+	return &SocketRebinder{
+		fs:       fs,
+		mounter:  mounter,
+		sockets:  sockets,
+		onRebind: onRebind,
+		targets:  map[string]VolumeType{},
+		watched:  map[string]bool{},
+		done:     make(chan struct{}),
+	}
+}
+
+// Track registers a published volume. Volumes that do not mount a socket file are ignored.
+func (r *SocketRebinder) Track(volumeType VolumeType, targetPath string) {
+	if r == nil {
+		return
+	}
+	if socket, ok := r.sockets[volumeType]; !ok || socket.Mode != SocketModeFile {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.targets[targetPath] = volumeType
+}
+
+// Forget unregisters an unpublished volume.
+func (r *SocketRebinder) Forget(targetPath string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.targets, targetPath)
+}
+
+// Start watches the directories of the sockets in the background until Stop.
+func (r *SocketRebinder) Start() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("could not watch the agent sockets: %w", err)
+	}
+
+	r.watcher = watcher
+	r.watch()
+	go r.run()
+	return nil
+}
+
+// watch watches the directory of every socket, or its nearest existing parent
+// while the directory does not exist. It returns the socket directories
+// watched directly for the first time.
+func (r *SocketRebinder) watch() []string {
+	var added []string
+	for _, socket := range r.sockets.socketFiles() {
+		dir := filepath.Dir(socket.Path)
+		if r.watched[dir] {
+			continue
+		}
+		path := dir
+		for {
+			err := r.watcher.Add(path)
+			if err == nil {
+				break
+			}
+			parent := filepath.Dir(path)
+			if parent == path {
+				log.Warn("Could not watch the directory of an agent socket, its volumes will not be re-bound", "directory", dir, "error", err)
+				break
+			}
+			path = parent
+		}
+		if path == dir {
+			r.watched[dir] = true
+			added = append(added, dir)
+		}
+	}
+	return added
+}
+
+// Stop stops watching the socket directories and waits for the background loop to exit.
+func (r *SocketRebinder) Stop() {
+	if r == nil || r.watcher == nil {
+		return // Not started
+	}
+	r.stopOnce.Do(func() {
+		_ = r.watcher.Close()
+		<-r.done
+	})
+}
+
+// run re-binds the volumes of every socket created in the watched directories.
+func (r *SocketRebinder) run() {
+	defer close(r.done)
+	for {
+		select {
+		case event, ok := <-r.watcher.Events:
+			if !ok {
+				return
+			}
+			name := filepath.Clean(event.Name)
+			if event.Has(fsnotify.Remove) && r.watched[name] {
+				// The watch is gone with the directory, its parent is watched until it is created again
+				delete(r.watched, name)
+				r.watchCreatedDirs()
+				continue
+			}
+			if !event.Has(fsnotify.Create) {
+				continue
+			}
+			for _, socket := range r.sockets.socketFiles() {
+				if socket.Path == name {
+					r.rebind(socket)
+				}
+			}
+			// A created directory may be, or lead to, a socket directory that was not watched yet
+			r.watchCreatedDirs()
+		case err, ok := <-r.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warn("Error while watching the agent sockets", "error", err)
+		}
+	}
+}
+
+// watchCreatedDirs watches the socket directories created since the last
+// watch, and re-binds their sockets, which may have been created before the
+// watch was added.
+func (r *SocketRebinder) watchCreatedDirs() {
+	for _, dir := range r.watch() {
+		for _, socket := range r.sockets.socketFiles() {
+			if filepath.Dir(socket.Path) == dir {
+				r.rebind(socket)
+			}
+		}
+	}
+}
+
+// rebind binds a socket over every tracked volume still bound to a previous inode of the socket.
+func (r *SocketRebinder) rebind(socket NamedSocket) {
+	isSocket, err := isSocketPath(r.fs, socket.Path)
+	if err != nil || !isSocket {
+		return
+	}
+
+	for _, targetPath := range r.targetsOf(socket.Name) {
+		exists, err := r.fs.Exists(targetPath)
+		if err == nil && !exists {
+			// The volume was unpublished without going through the socket publisher
+			r.Forget(targetPath)
+			continue
+		}
+		if same, err := isSameFile(r.fs, targetPath, socket.Path); err == nil && same {
+			continue
+		}
+
+		err = r.rebindTarget(socket, targetPath)
+		if err != nil {
+			log.Error("Failed to re-bind the agent socket", "volume_type", socket.Name, "host_path", socket.Path, "target_path", targetPath, "error", err)
+		} else {
+			log.Info("Re-bound the recreated agent socket", "volume_type", socket.Name, "host_path", socket.Path, "target_path", targetPath)
+		}
+		r.onRebind(socket.Name, err)
+	}
+}
+
+// rebindTarget replaces the bind mount of a volume with a bind mount of the current socket,
+// keeping the volume read-only if it was.
+func (r *SocketRebinder) rebindTarget(socket NamedSocket, targetPath string) error {
+	readOnly, err := r.isReadOnly(targetPath)
+	if err != nil {
+		return err
+	}
+	options := mountOptions(socket.Name)
+	if readOnly {
+		options = append([]string{mountOptionReadOnly}, options...)
+	}
+
+	if err := r.mounter.Unmount(targetPath); err != nil {
+		return fmt.Errorf("failed to unmount the previous socket: %w", err)
+	}
+	if err := r.mounter.Mount(socket.Path, targetPath, "", append([]string{"bind"}, options...)); err != nil {
+		return fmt.Errorf("failed to mount: %w", err)
+	}
+	return nil
+}
+
+// isReadOnly returns whether the current mount of a target path is read-only.
+func (r *SocketRebinder) isReadOnly(targetPath string) (bool, error) {
+	mountPoints, err := r.mounter.List()
+	if err != nil {
+		return false, fmt.Errorf("failed to list mount points: %w", err)
+	}
+	readOnly := false
+	for _, mountPoint := range mountPoints {
+		// The last mount of the target is the visible one
+		if mountPoint.Path == targetPath {
+			readOnly = slices.Contains(mountPoint.Opts, mountOptionReadOnly)
+		}
+	}
+	return readOnly, nil
+}
+
+// targetsOf returns the target paths of the tracked volumes of a volume type.
+func (r *SocketRebinder) targetsOf(volumeType VolumeType) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var targets []string
+	for targetPath, targetType := range r.targets {
+		if targetType == volumeType {
+			targets = append(targets, targetPath)
+		}
+	}
+	slices.Sort(targets)
+	return targets
+}
@@ -0,0 +1,159 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/mount"
+)
+
+func TestSocketRebinder(t *testing.T) {
+	// Unix socket paths are limited to 108 characters, so t.TempDir() can be too long
+	dir, err := os.MkdirTemp("", "csi-socket-*")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	fs := afero.Afero{Fs: afero.NewOsFs()}
+	apmSocketPath := filepath.Join(dir, "apm.socket")
+	dsdSocketPath := filepath.Join(dir, "dsd.socket")
+	listener, err := net.Listen("unix", apmSocketPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	listenUnixSocket(t, dsdSocketPath)
+
+	mounter := &recordingMounter{FakeMounter: mount.NewFakeMounter(nil)}
+	rebinds := make(chan error, 10)
+	rebinder := NewSocketRebinder(fs, mounter, testSocketRegistry(t, apmSocketPath, dsdSocketPath), func(volumeType VolumeType, err error) {
+		assert.Equal(t, APMSocket, volumeType)
+		rebinds <- err
+	})
+	publisher := newSocketPublisher(fs, mounter, rebinder.sockets, rebinder, SocketWait{})
+	ctx := context.Background()
+
+	// The fake mounter does not bind the socket, so the targets never match the agent socket
+	readWriteTarget := filepath.Join(dir, "rw", "apm.socket")
+	readOnlyTarget := filepath.Join(dir, "ro", "apm.socket")
+	for _, req := range []*csi.NodePublishVolumeRequest{
+		{VolumeId: "vol-rw", TargetPath: readWriteTarget, VolumeContext: map[string]string{"type": string(APMSocket)}},
+		{VolumeId: "vol-ro", TargetPath: readOnlyTarget, Readonly: true, VolumeContext: map[string]string{"type": string(APMSocket)}},
+		{VolumeId: "vol-dsd", TargetPath: filepath.Join(dir, "dsd", "dsd.socket"), VolumeContext: map[string]string{"type": string(DSDSocket)}},
+	} {
+		require.NoError(t, fs.MkdirAll(filepath.Dir(req.GetTargetPath()), 0o755))
+		_, err := publisher.Publish(ctx, req)
+		require.NoError(t, err)
+	}
+	unpublishedTarget := filepath.Join(dir, "gone", "apm.socket")
+	rebinder.Track(APMSocket, unpublishedTarget)
+	rebinder.Track(DatadogLibrary, filepath.Join(dir, "library"))
+	assert.ElementsMatch(t, []string{readOnlyTarget, readWriteTarget, unpublishedTarget}, rebinder.targetsOf(APMSocket))
+
+	mounter.ResetLog()
+	mounter.mounts = nil
+	require.NoError(t, rebinder.Start())
+	defer rebinder.Stop()
+
+	// Recreate the APM socket like an agent restart
+	require.NoError(t, listener.Close())
+	listener, err = net.Listen("unix", apmSocketPath)
+	require.NoError(t, err)
+
+	for range 2 {
+		select {
+		case err := <-rebinds:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "the volumes were not re-bound")
+		}
+	}
+
+	assert.Equal(t, []mount.FakeAction{
+		{Action: mount.FakeActionUnmount, Target: readOnlyTarget},
+		{Action: mount.FakeActionMount, Target: readOnlyTarget, Source: apmSocketPath},
+		{Action: mount.FakeActionUnmount, Target: readWriteTarget},
+		{Action: mount.FakeActionMount, Target: readWriteTarget, Source: apmSocketPath},
+	}, mounter.GetLog())
+	assert.Equal(t, []recordedMount{
+		{source: apmSocketPath, target: readOnlyTarget, options: []string{"bind", "ro", "nosuid", "nodev", "noexec"}},
+		{source: apmSocketPath, target: readWriteTarget, options: []string{"bind", "nosuid", "nodev", "noexec"}},
+	}, mounter.mounts, "read-only volumes stay read-only")
+	assert.ElementsMatch(t, []string{readOnlyTarget, readWriteTarget}, rebinder.targetsOf(APMSocket),
+		"volumes whose target disappeared are forgotten")
+
+	_, err = publisher.Unpublish(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "vol-rw", TargetPath: readWriteTarget})
+	require.NoError(t, err)
+	assert.Equal(t, []string{readOnlyTarget}, rebinder.targetsOf(APMSocket))
+}
+
+func TestSocketRebinder_SocketDirectoryCreatedAfterStart(t *testing.T) {
+	// Unix socket paths are limited to 108 characters, so t.TempDir() can be too long
+	dir, err := os.MkdirTemp("", "csi-socket-*")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	fs := afero.Afero{Fs: afero.NewOsFs()}
+	socketDir := filepath.Join(dir, "run", "datadog")
+	apmSocketPath := filepath.Join(socketDir, "apm.socket")
+	mounter := mount.NewFakeMounter(nil)
+	rebinds := make(chan error, 10)
+	rebinder := NewSocketRebinder(fs, mounter, testSocketRegistry(t, apmSocketPath, filepath.Join(dir, "dsd.socket")), func(_ VolumeType, err error) {
+		rebinds <- err
+	})
+	target := filepath.Join(dir, "target", "apm.socket")
+	require.NoError(t, fs.MkdirAll(filepath.Dir(target), 0o755))
+	require.NoError(t, fs.WriteFile(target, nil, 0o644))
+	rebinder.Track(APMSocket, target)
+
+	require.NoError(t, rebinder.Start())
+	defer rebinder.Stop()
+
+	// The agent creates its socket directory and socket after the driver started
+	require.NoError(t, fs.MkdirAll(socketDir, 0o755))
+	listener, err := net.Listen("unix", apmSocketPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	select {
+	case err := <-rebinds:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the volume was not re-bound")
+	}
+	assert.Contains(t, mounter.GetLog(), mount.FakeAction{Action: mount.FakeActionMount, Target: target, Source: apmSocketPath})
+
+	// The agent recreates its socket directory
+	require.NoError(t, listener.Close())
+	require.NoError(t, fs.RemoveAll(socketDir))
+	require.NoError(t, fs.MkdirAll(socketDir, 0o755))
+	listener, err = net.Listen("unix", apmSocketPath)
+	require.NoError(t, err)
+
+	select {
+	case err := <-rebinds:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the volume was not re-bound after the directory was recreated")
+	}
+}
+
+func TestSocketRebinder_StopWithoutStart(t *testing.T) {
+	var nilRebinder *SocketRebinder
+	nilRebinder.Track(APMSocket, "/target")
+	nilRebinder.Forget("/target")
+	nilRebinder.Stop()
+
+	rebinder := NewSocketRebinder(afero.Afero{Fs: afero.NewMemMapFs()}, mount.NewFakeMounter(nil), SocketRegistry{}, nil)
+	rebinder.Stop()
+}
@@ -0,0 +1,162 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// SocketMode tells whether a named socket volume mounts a socket file or a directory.
+type SocketMode string
+
+const (
+	// SocketModeFile mounts the socket file itself
+	SocketModeFile SocketMode = "file"
+	// SocketModeDirectory mounts a directory, usually the parent directory of a socket
+	SocketModeDirectory SocketMode = "directory"
+)
+
+// NamedSocket maps a socket volume type to the host path it mounts.
+type NamedSocket struct {
+	// Name is the volume type serving the socket, e.g. OTLPSocket
+	Name VolumeType `json:"name"`
+	// Path is the host path of the socket file, or of the directory in directory mode
+	Path string `json:"path"`
+	// Mode is file, the default, or directory
+	Mode SocketMode `json:"mode,omitempty"`
+	// Enabled defaults to true. Publishing a disabled socket fails.
+	Enabled *bool `json:"enabled,omitempty"`
+}
+
+// IsEnabled returns whether the socket volume type can be published.
+func (s NamedSocket) IsEnabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+// socketRegistryFile is the format of the socket registry file.
+type socketRegistryFile struct {
+	Sockets []NamedSocket `json:"sockets"`
+}
+
+// SocketRegistry holds the socket volume types served by the socket and
+// local publishers, indexed by volume type.
+type SocketRegistry map[VolumeType]NamedSocket
+
+// reservedVolumeTypes are the volume types served by other publishers, which
+// cannot be registered as sockets.
+var reservedVolumeTypes = []VolumeType{
+	DatadogLibrary,
+	DatadogLibraries,
+	DatadogInjectorPreload,
+	AgentSocketsDirectory,
+	DatadogAgentEnv,
+	DatadogScratch,
+	VolumeType(modeSocket),
+	VolumeType(modeLocal),
+}
+
+// LoadSockets reads the named sockets of a socket registry file.
+func LoadSockets(path string) ([]NamedSocket, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read socket registry file: %w", err)
+	}
+	var file socketRegistryFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("could not parse socket registry file %q: %w", path, err)
+	}
+	return file.Sockets, nil
+}
+
+// NewSocketRegistry returns the registry of the APM and DogStatsD socket types
+// and of the configured sockets. A configured socket named after a built-in
+// type overrides it, which allows disabling it or changing its path.
+func NewSocketRegistry(apmSocketPath, dsdSocketPath string, configured []NamedSocket) (SocketRegistry, error) {
+	registry := SocketRegistry{
+		APMSocket:          {Name: APMSocket, Path: apmSocketPath, Mode: SocketModeFile},
+		DSDSocket:          {Name: DSDSocket, Path: dsdSocketPath, Mode: SocketModeFile},
+		APMSocketDirectory: {Name: APMSocketDirectory, Path: socketDir(apmSocketPath), Mode: SocketModeDirectory},
+		DSDSocketDirectory: {Name: DSDSocketDirectory, Path: socketDir(dsdSocketPath), Mode: SocketModeDirectory},
+		// Deprecated, mounts the same directory as DSDSocketDirectory
+		DatadogSocketsDirectory: {Name: DatadogSocketsDirectory, Path: socketDir(dsdSocketPath), Mode: SocketModeDirectory},
+	}
+
+	seen := map[VolumeType]bool{}
+	for _, socket := range configured {
+		if socket.Name == "" {
+			return nil, fmt.Errorf("socket %q has no name", socket.Path)
+		}
+		if seen[socket.Name] {
+			return nil, fmt.Errorf("socket %q is defined twice", socket.Name)
+		}
+		seen[socket.Name] = true
+		if slices.Contains(reservedVolumeTypes, socket.Name) {
+			return nil, fmt.Errorf("socket %q uses the name of a volume type that is not a socket", socket.Name)
+		}
+
+		if builtin, ok := registry[socket.Name]; ok {
+			if socket.Path == "" {
+				socket.Path = builtin.Path
+			}
+			if socket.Mode == "" {
+				socket.Mode = builtin.Mode
+			}
+			if socket.Mode != builtin.Mode {
+				return nil, fmt.Errorf("socket %q must use the %s mode", socket.Name, builtin.Mode)
+			}
+		}
+		if socket.Mode == "" {
+			socket.Mode = SocketModeFile
+		}
+		if socket.Mode != SocketModeFile && socket.Mode != SocketModeDirectory {
+			return nil, fmt.Errorf("socket %q has an invalid mode %q, expected %q or %q", socket.Name, socket.Mode, SocketModeFile, SocketModeDirectory)
+		}
+		if !filepath.IsAbs(socket.Path) {
+			return nil, fmt.Errorf("socket %q must have an absolute path, got %q", socket.Name, socket.Path)
+		}
+		socket.Path = filepath.Clean(socket.Path)
+		registry[socket.Name] = socket
+	}
+	return registry, nil
+}
+
+// socketDir returns the parent directory of a socket, or an empty string when the socket is not configured.
+func socketDir(socketPath string) string {
+	if socketPath == "" {
+		return ""
+	}
+	return filepath.Dir(socketPath)
+}
+
+// EnabledPath returns the host path mounted by a socket volume type, or an
+// empty string when the type is disabled or not configured.
+func (r SocketRegistry) EnabledPath(volumeType VolumeType) string {
+	socket, ok := r[volumeType]
+	if !ok || !socket.IsEnabled() {
+		return ""
+	}
+	return socket.Path
+}
+
+// socketFiles returns the enabled sockets in file mode, sorted by volume type.
+func (r SocketRegistry) socketFiles() []NamedSocket {
+	var sockets []NamedSocket
+	for _, socket := range r {
+		if socket.Mode == SocketModeFile && socket.IsEnabled() {
+			sockets = append(sockets, socket)
+		}
+	}
+	slices.SortFunc(sockets, func(a, b NamedSocket) int {
+		return strings.Compare(string(a.Name), string(b.Name))
+	})
+	return sockets
+}
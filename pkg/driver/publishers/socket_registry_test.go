@@ -0,0 +1,116 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSocketRegistry returns the registry of the built-in socket types.
+func testSocketRegistry(t *testing.T, apmSocketPath, dsdSocketPath string) SocketRegistry {
+	t.Helper()
+	sockets, err := NewSocketRegistry(apmSocketPath, dsdSocketPath, nil)
+	require.NoError(t, err)
+	return sockets
+}
+
+func TestNewSocketRegistry(t *testing.T) {
+	disabled := false
+
+	tests := map[string]struct {
+		configured []NamedSocket
+		expected   map[VolumeType]NamedSocket
+		expectErr  bool
+	}{
+		"built-in types": {
+			expected: map[VolumeType]NamedSocket{
+				APMSocket:               {Name: APMSocket, Path: "/var/run/datadog/apm.socket", Mode: SocketModeFile},
+				DSDSocketDirectory:      {Name: DSDSocketDirectory, Path: "/var/run/datadog", Mode: SocketModeDirectory},
+				DatadogSocketsDirectory: {Name: DatadogSocketsDirectory, Path: "/var/run/datadog", Mode: SocketModeDirectory},
+			},
+		},
+		"new socket defaults to file mode": {
+			configured: []NamedSocket{{Name: "OTLPSocket", Path: "/var/run/datadog/otlp.socket/"}},
+			expected: map[VolumeType]NamedSocket{
+				"OTLPSocket": {Name: "OTLPSocket", Path: "/var/run/datadog/otlp.socket", Mode: SocketModeFile},
+			},
+		},
+		"built-in type can be disabled": {
+			configured: []NamedSocket{{Name: APMSocketDirectory, Enabled: &disabled}},
+			expected: map[VolumeType]NamedSocket{
+				APMSocketDirectory: {Name: APMSocketDirectory, Path: "/var/run/datadog", Mode: SocketModeDirectory, Enabled: &disabled},
+			},
+		},
+		"built-in type keeps its mode": {
+			configured: []NamedSocket{{Name: APMSocket, Mode: SocketModeDirectory}},
+			expectErr:  true,
+		},
+		"missing name": {
+			configured: []NamedSocket{{Path: "/var/run/datadog/otlp.socket"}},
+			expectErr:  true,
+		},
+		"duplicate name": {
+			configured: []NamedSocket{{Name: "OTLPSocket", Path: "/a.socket"}, {Name: "OTLPSocket", Path: "/b.socket"}},
+			expectErr:  true,
+		},
+		"reserved name": {
+			configured: []NamedSocket{{Name: DatadogLibrary, Path: "/var/run/datadog/lib.socket"}},
+			expectErr:  true,
+		},
+		"relative path": {
+			configured: []NamedSocket{{Name: "OTLPSocket", Path: "otlp.socket"}},
+			expectErr:  true,
+		},
+		"invalid mode": {
+			configured: []NamedSocket{{Name: "OTLPSocket", Path: "/otlp.socket", Mode: "fifo"}},
+			expectErr:  true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			registry, err := NewSocketRegistry("/var/run/datadog/apm.socket", "/var/run/datadog/dsd.socket", tc.configured)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			for volumeType, expected := range tc.expected {
+				assert.Equal(t, expected, registry[volumeType])
+			}
+		})
+	}
+}
+
+func TestLoadSockets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sockets.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+sockets:
+  - name: OTLPSocket
+    path: /var/run/datadog/otlp.socket
+  - name: SystemProbeSocketDirectory
+    path: /var/run/sysprobe
+    mode: directory
+    enabled: false
+`), 0o644))
+
+	sockets, err := LoadSockets(path)
+
+	require.NoError(t, err)
+	require.Len(t, sockets, 2)
+	assert.Equal(t, NamedSocket{Name: "OTLPSocket", Path: "/var/run/datadog/otlp.socket"}, sockets[0])
+	assert.Equal(t, SocketModeDirectory, sockets[1].Mode)
+	assert.False(t, sockets[1].IsEnabled())
+
+	require.NoError(t, os.WriteFile(path, []byte("sockets:\n  - name: OTLPSocket\n    hostPath: /otlp.socket\n"), 0o644))
+	_, err = LoadSockets(path)
+	assert.Error(t, err, "unknown fields are rejected")
+}
@@ -6,6 +6,10 @@
 package publishers
 
 import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -40,10 +44,7 @@ func TestSocketPublisher_Publish_TypeSelection(t *testing.T) {
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			publisher := socketPublisher{
-				apmSocketPath: "/var/run/apm.sock",
-				dsdSocketPath: "/var/run/dsd.sock",
-			}
+			publisher := socketPublisher{sockets: testSocketRegistry(t, "/var/run/apm.sock", "/var/run/dsd.sock")}
 
 			req := &csi.NodePublishVolumeRequest{
 				VolumeId:      "test-volume",
@@ -51,7 +52,7 @@ func TestSocketPublisher_Publish_TypeSelection(t *testing.T) {
 				VolumeContext: tc.volumeContext,
 			}
 
-			resp, err := publisher.Publish(req)
+			resp, err := publisher.Publish(context.Background(), req)
 			assert.Nil(t, resp)
 			assert.NoError(t, err)
 		})
@@ -62,7 +63,7 @@ func TestSocketPublisher_Publish_SocketNotFound(t *testing.T) {
 	fs := afero.Afero{Fs: afero.NewMemMapFs()}
 	mounter := mount.NewFakeMounter(nil)
 
-	publisher := newSocketPublisher(fs, mounter, "/var/run/apm.sock", "/var/run/dsd.sock")
+	publisher := newSocketPublisher(fs, mounter, testSocketRegistry(t, "/var/run/apm.sock", "/var/run/dsd.sock"), nil, SocketWait{})
 
 	req := &csi.NodePublishVolumeRequest{
 		VolumeId:      "test-volume",
@@ -70,7 +71,7 @@ func TestSocketPublisher_Publish_SocketNotFound(t *testing.T) {
 		VolumeContext: map[string]string{"type": "APMSocket"},
 	}
 
-	resp, err := publisher.Publish(req)
+	resp, err := publisher.Publish(context.Background(), req)
 
 	// Should return a response (for metrics) and an error
 	assert.NotNil(t, resp)
@@ -89,7 +90,7 @@ func TestSocketPublisher_Publish_NotASocket(t *testing.T) {
 	_, err := fs.Create("/var/run/apm.sock")
 	require.NoError(t, err)
 
-	publisher := newSocketPublisher(fs, mounter, "/var/run/apm.sock", "/var/run/dsd.sock")
+	publisher := newSocketPublisher(fs, mounter, testSocketRegistry(t, "/var/run/apm.sock", "/var/run/dsd.sock"), nil, SocketWait{})
 
 	req := &csi.NodePublishVolumeRequest{
 		VolumeId:      "test-volume",
@@ -97,7 +98,7 @@ func TestSocketPublisher_Publish_NotASocket(t *testing.T) {
 		VolumeContext: map[string]string{"type": "APMSocket"},
 	}
 
-	resp, err := publisher.Publish(req)
+	resp, err := publisher.Publish(context.Background(), req)
 
 	// Should return a response and an error because it's not a socket
 	assert.NotNil(t, resp)
@@ -113,6 +114,7 @@ func TestSocketPublisher_Publish_ReturnsCorrectResponse(t *testing.T) {
 	}{
 		{"APMSocket", "APMSocket", "/var/run/apm.sock"},
 		{"DSDSocket", "DSDSocket", "/var/run/dsd.sock"},
+		{"registry socket", "OTLPSocket", "/var/run/datadog/otlp.sock"},
 	}
 
 	for _, tc := range tests {
@@ -120,7 +122,11 @@ func TestSocketPublisher_Publish_ReturnsCorrectResponse(t *testing.T) {
 			fs := afero.Afero{Fs: afero.NewMemMapFs()}
 			mounter := mount.NewFakeMounter(nil)
 
-			publisher := newSocketPublisher(fs, mounter, "/var/run/apm.sock", "/var/run/dsd.sock")
+			sockets, err := NewSocketRegistry("/var/run/apm.sock", "/var/run/dsd.sock", []NamedSocket{
+				{Name: "OTLPSocket", Path: "/var/run/datadog/otlp.sock"},
+			})
+			require.NoError(t, err)
+			publisher := newSocketPublisher(fs, mounter, sockets, nil, SocketWait{})
 
 			req := &csi.NodePublishVolumeRequest{
 				VolumeId:      "test-volume",
@@ -128,7 +134,7 @@ func TestSocketPublisher_Publish_ReturnsCorrectResponse(t *testing.T) {
 				VolumeContext: map[string]string{"type": tc.volumeType},
 			}
 
-			resp, _ := publisher.Publish(req)
+			resp, _ := publisher.Publish(context.Background(), req)
 
 			// Verify response has correct metadata (even if mount fails)
 			assert.NotNil(t, resp)
@@ -138,9 +144,89 @@ func TestSocketPublisher_Publish_ReturnsCorrectResponse(t *testing.T) {
 	}
 }
 
+func TestSocketPublisher_Publish_DisabledSocket(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	mounter := mount.NewFakeMounter(nil)
+	disabled := false
+	sockets, err := NewSocketRegistry("/var/run/apm.sock", "/var/run/dsd.sock", []NamedSocket{
+		{Name: APMSocket, Enabled: &disabled},
+	})
+	require.NoError(t, err)
+	publisher := newSocketPublisher(fs, mounter, sockets, nil, SocketWait{})
+
+	resp, err := publisher.Publish(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:      "test-volume",
+		TargetPath:    "/target/socket",
+		VolumeContext: map[string]string{"type": string(APMSocket)},
+	})
+
+	require.NotNil(t, resp)
+	assert.ErrorContains(t, err, "disabled")
+	assert.Empty(t, mounter.GetLog())
+}
+
 func TestSocketPublisher_Unpublish_DelegatesToUnmount(t *testing.T) {
 	publisher := socketPublisher{}
-	resp, err := publisher.Unpublish(&csi.NodeUnpublishVolumeRequest{})
+	resp, err := publisher.Unpublish(context.Background(), &csi.NodeUnpublishVolumeRequest{})
 	assert.Nil(t, resp, "socket should delegate Unpublish to unmountPublisher")
 	assert.NoError(t, err)
 }
+
+// listenUnixSocket creates a listening unix socket at path and closes it at the end of the test.
+func listenUnixSocket(t *testing.T, path string) {
+	t.Helper()
+	listener, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+}
+
+func TestSocketPublisher_Stats(t *testing.T) {
+	// Unix socket paths are limited to 108 characters, so t.TempDir() can be too long
+	dir, err := os.MkdirTemp("", "csi-socket-*")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	fs := afero.Afero{Fs: afero.NewOsFs()}
+	apmSocketPath := filepath.Join(dir, "apm.socket")
+	dsdSocketPath := filepath.Join(dir, "dsd.socket")
+	listenUnixSocket(t, apmSocketPath)
+	listenUnixSocket(t, dsdSocketPath)
+
+	publisher := newSocketPublisher(fs, mount.NewFakeMounter(nil), testSocketRegistry(t, apmSocketPath, dsdSocketPath), nil, SocketWait{})
+
+	t.Run("non socket target is not supported", func(t *testing.T) {
+		resp, err := publisher.Stats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "test-volume", VolumePath: dir})
+		assert.NoError(t, err)
+		assert.Nil(t, resp)
+	})
+
+	t.Run("target matching the agent socket is healthy", func(t *testing.T) {
+		// A hard link shares the inode of the socket, like a bind mount does
+		targetPath := filepath.Join(dir, "target-dsd.socket")
+		require.NoError(t, os.Link(dsdSocketPath, targetPath))
+
+		resp, err := publisher.Stats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "test-volume", VolumePath: targetPath})
+
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, DSDSocket, resp.VolumeType)
+		assert.Equal(t, int64(1), resp.UsedInodes)
+		assert.False(t, resp.Abnormal)
+	})
+
+	t.Run("target of a recreated socket is abnormal", func(t *testing.T) {
+		targetPath := filepath.Join(dir, "target-apm.socket")
+		require.NoError(t, os.Link(apmSocketPath, targetPath))
+
+		// Simulate an agent restart: the socket is unlinked and created again
+		require.NoError(t, os.Remove(apmSocketPath))
+		listenUnixSocket(t, apmSocketPath)
+
+		resp, err := publisher.Stats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "test-volume", VolumePath: targetPath})
+
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		assert.True(t, resp.Abnormal)
+		assert.Contains(t, resp.Message, "no longer matches any agent socket")
+	})
+}
@@ -0,0 +1,160 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"context"
+	"fmt"
+	log "log/slog"
+	"net"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/afero"
+)
+
+const (
+	// keySocketWaitTimeout is the VolumeContext key giving how long a socket
+	// file volume waits for its agent socket, as a Go duration (e.g. "30s").
+	// "0" does not wait. It overrides the node default.
+	keySocketWaitTimeout = "dd.csi.datadog.com/socket.wait-timeout"
+	// keySocketWaitLiveness is the VolumeContext key enabling the liveness
+	// check of the agent socket ("true" or "false"). It overrides the node default.
+	keySocketWaitLiveness = "dd.csi.datadog.com/socket.wait-liveness"
+
+	// maxSocketWaitTimeout bounds the wait, well below the 2 minute deadline kubelet sets on NodePublishVolume
+	maxSocketWaitTimeout = 90 * time.Second
+	// socketWaitDeadlineMargin is left between the end of a wait and the deadline of the publish, so that the
+	// publish fails with Unavailable before kubelet gives up on it
+	socketWaitDeadlineMargin = 5 * time.Second
+	// socketDialTimeout bounds a single liveness check
+	socketDialTimeout = time.Second
+)
+
+// socketWaitPollInterval is the delay between two checks of a socket during a
+// wait, on top of the file system notifications. It catches the sockets that
+// exist but do not accept connections yet, and the directories created after
+// the wait started.
+var socketWaitPollInterval = time.Second
+
+// SocketWait is the wait applied when publishing a socket file volume whose
+// agent socket is not ready, e.g. while the agent pod starts after a node boot.
+type SocketWait struct {
+	// Timeout is the maximum duration of the wait. The publish does not wait when it is zero.
+	Timeout time.Duration
+	// CheckLiveness also waits for the socket to accept connections.
+	CheckLiveness bool
+}
+
+// enabled returns whether the publish checks the socket through waitForSocket.
+func (w SocketWait) enabled() bool {
+	return w.Timeout > 0 || w.CheckLiveness
+}
+
+// forVolume applies the overrides of the volume context to the node default.
+func (w SocketWait) forVolume(volumeCtx map[string]string) (SocketWait, error) {
+	if value, ok := volumeCtx[keySocketWaitTimeout]; ok {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return w, fmt.Errorf("invalid %s %q: %w", keySocketWaitTimeout, value, err)
+		}
+		if timeout < 0 || timeout > maxSocketWaitTimeout {
+			return w, fmt.Errorf("invalid %s %q: must be between 0 and %s", keySocketWaitTimeout, value, maxSocketWaitTimeout)
+		}
+		w.Timeout = timeout
+	}
+	if value, ok := volumeCtx[keySocketWaitLiveness]; ok {
+		checkLiveness, err := strconv.ParseBool(value)
+		if err != nil {
+			return w, fmt.Errorf("invalid %s %q: %w", keySocketWaitLiveness, value, err)
+		}
+		w.CheckLiveness = checkLiveness
+	}
+	return w, nil
+}
+
+// SocketUnavailableError is returned when an agent socket is still not ready at the end of the wait of a publish.
+type SocketUnavailableError struct {
+	// Path is the host path of the socket.
+	Path string
+	// Waited is the duration of the wait.
+	Waited time.Duration
+	// Reason tells why the socket is not ready.
+	Reason string
+}
+
+func (e *SocketUnavailableError) Error() string {
+	return fmt.Sprintf("agent socket %s is not ready after waiting %s: %s, retry later", e.Path, e.Waited.Round(time.Millisecond), e.Reason)
+}
+
+// waitForSocket waits until the socket at path exists, and accepts connections
+// if the liveness check is enabled. It returns a SocketUnavailableError once
+// the timeout is over, or the context error if the publish is cancelled first.
+// The timeout is capped by maxSocketWaitTimeout and ends before the deadline of
+// the context.
+func waitForSocket(ctx context.Context, fs afero.Afero, path string, wait SocketWait) error {
+	start := time.Now()
+	reason := socketNotReadyReason(ctx, fs, path, wait.CheckLiveness)
+	if reason == "" {
+		return nil
+	}
+	timeout := min(wait.Timeout, maxSocketWaitTimeout)
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = min(timeout, time.Until(deadline)-socketWaitDeadlineMargin)
+	}
+	if timeout <= 0 {
+		return &SocketUnavailableError{Path: path, Reason: reason}
+	}
+	log.InfoContext(ctx, "Waiting for the agent socket", "host_path", path, "timeout", timeout, "reason", reason)
+
+	// Without notifications, e.g. when the directory does not exist yet, the wait falls back to polling
+	var events <-chan fsnotify.Event
+	if watcher, err := fsnotify.NewWatcher(); err == nil {
+		defer watcher.Close()
+		if err := watcher.Add(filepath.Dir(path)); err == nil {
+			events = watcher.Events
+		}
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(socketWaitPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("stopped waiting for agent socket %s (%s): %w", path, reason, ctx.Err())
+		case <-timer.C:
+			return &SocketUnavailableError{Path: path, Waited: time.Since(start), Reason: reason}
+		case <-events:
+		case <-ticker.C:
+		}
+		if reason = socketNotReadyReason(ctx, fs, path, wait.CheckLiveness); reason == "" {
+			log.InfoContext(ctx, "Agent socket is ready", "host_path", path, "waited", time.Since(start))
+			return nil
+		}
+	}
+}
+
+// socketNotReadyReason returns why the socket at path is not ready, or an empty string if it is.
+func socketNotReadyReason(ctx context.Context, fs afero.Afero, path string, checkLiveness bool) string {
+	isSocket, err := isSocketPath(fs, path)
+	if err != nil || !isSocket {
+		return "socket not found"
+	}
+	if !checkLiveness {
+		return ""
+	}
+	dialer := net.Dialer{Timeout: socketDialTimeout}
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return fmt.Sprintf("socket does not accept connections: %v", err)
+	}
+	_ = conn.Close()
+	return ""
+}
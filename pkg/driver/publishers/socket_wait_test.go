@@ -0,0 +1,162 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/mount"
+)
+
+func TestSocketWait_ForVolume(t *testing.T) {
+	nodeDefault := SocketWait{Timeout: 10 * time.Second}
+
+	tests := map[string]struct {
+		volumeCtx map[string]string
+		expected  SocketWait
+		expectErr bool
+	}{
+		"node default":        {volumeCtx: map[string]string{}, expected: nodeDefault},
+		"timeout override":    {volumeCtx: map[string]string{keySocketWaitTimeout: "30s"}, expected: SocketWait{Timeout: 30 * time.Second}},
+		"wait disabled":       {volumeCtx: map[string]string{keySocketWaitTimeout: "0"}, expected: SocketWait{}},
+		"liveness override":   {volumeCtx: map[string]string{keySocketWaitLiveness: "true"}, expected: SocketWait{Timeout: 10 * time.Second, CheckLiveness: true}},
+		"invalid timeout":     {volumeCtx: map[string]string{keySocketWaitTimeout: "soon"}, expectErr: true},
+		"negative timeout":    {volumeCtx: map[string]string{keySocketWaitTimeout: "-1s"}, expectErr: true},
+		"unbounded timeout":   {volumeCtx: map[string]string{keySocketWaitTimeout: "1h"}, expectErr: true},
+		"invalid liveness":    {volumeCtx: map[string]string{keySocketWaitLiveness: "maybe"}, expectErr: true},
+		"both overrides used": {volumeCtx: map[string]string{keySocketWaitTimeout: "1s", keySocketWaitLiveness: "1"}, expected: SocketWait{Timeout: time.Second, CheckLiveness: true}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			wait, err := nodeDefault.forVolume(tc.volumeCtx)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, wait)
+		})
+	}
+}
+
+func TestSocketPublisher_Wait(t *testing.T) {
+	originalPollInterval := socketWaitPollInterval
+	socketWaitPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() {
+		socketWaitPollInterval = originalPollInterval
+	})
+
+	// Unix socket paths are limited to 108 characters, so t.TempDir() can be too long
+	dir, err := os.MkdirTemp("", "csi-socket-*")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	fs := afero.Afero{Fs: afero.NewOsFs()}
+	apmSocketPath := filepath.Join(dir, "apm.socket")
+	dsdSocketPath := filepath.Join(dir, "dsd.socket")
+	mounter := mount.NewFakeMounter(nil)
+	publisher := newSocketPublisher(fs, mounter, testSocketRegistry(t, apmSocketPath, dsdSocketPath), nil, SocketWait{Timeout: 50 * time.Millisecond})
+	ctx := context.Background()
+	publish := func(volumeCtx map[string]string) error {
+		volumeCtx["type"] = string(APMSocket)
+		_, err := publisher.Publish(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:      "vol-1",
+			TargetPath:    filepath.Join(dir, "target"),
+			VolumeContext: volumeCtx,
+		})
+		return err
+	}
+
+	t.Run("missing socket is unavailable after the wait", func(t *testing.T) {
+		start := time.Now()
+		err := publish(map[string]string{})
+		var unavailable *SocketUnavailableError
+		require.True(t, errors.As(err, &unavailable))
+		assert.Equal(t, apmSocketPath, unavailable.Path)
+		assert.Contains(t, unavailable.Error(), "socket not found")
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+		assert.Empty(t, mounter.GetLog())
+	})
+
+	t.Run("invalid wait attribute fails the publish", func(t *testing.T) {
+		err := publish(map[string]string{keySocketWaitTimeout: "soon"})
+		assert.ErrorContains(t, err, keySocketWaitTimeout)
+		assert.Empty(t, mounter.GetLog())
+	})
+
+	t.Run("stale socket fails the liveness check", func(t *testing.T) {
+		listener, err := net.Listen("unix", apmSocketPath)
+		require.NoError(t, err)
+		// Keep the socket file without a listener, like after an agent crash
+		listener.(*net.UnixListener).SetUnlinkOnClose(false)
+		require.NoError(t, listener.Close())
+		t.Cleanup(func() { _ = os.Remove(apmSocketPath) })
+
+		err = publish(map[string]string{keySocketWaitTimeout: "0", keySocketWaitLiveness: "true"})
+		var unavailable *SocketUnavailableError
+		require.True(t, errors.As(err, &unavailable))
+		assert.Contains(t, unavailable.Reason, "does not accept connections")
+		assert.Empty(t, mounter.GetLog())
+		require.NoError(t, os.Remove(apmSocketPath))
+	})
+
+	t.Run("publish waits for the socket to be created", func(t *testing.T) {
+		listening := make(chan struct{})
+		go func() {
+			defer close(listening)
+			time.Sleep(20 * time.Millisecond)
+			listener, err := net.Listen("unix", apmSocketPath)
+			if assert.NoError(t, err) {
+				t.Cleanup(func() { _ = listener.Close() })
+			}
+		}()
+
+		err := publish(map[string]string{keySocketWaitTimeout: "5s", keySocketWaitLiveness: "true"})
+		<-listening
+		require.NoError(t, err)
+		mountPoints, err := mounter.List()
+		require.NoError(t, err)
+		require.Len(t, mountPoints, 1)
+		assert.Equal(t, apmSocketPath, mountPoints[0].Device)
+	})
+
+	t.Run("wait ends before the publish deadline", func(t *testing.T) {
+		withDeadline, cancel := context.WithTimeout(ctx, socketWaitDeadlineMargin+50*time.Millisecond)
+		defer cancel()
+		err := waitForSocket(withDeadline, fs, dsdSocketPath, SocketWait{Timeout: time.Minute})
+		var unavailable *SocketUnavailableError
+		require.True(t, errors.As(err, &unavailable))
+		assert.Less(t, unavailable.Waited, socketWaitDeadlineMargin)
+		assert.NoError(t, withDeadline.Err())
+	})
+
+	t.Run("publish too close to its deadline does not wait", func(t *testing.T) {
+		withDeadline, cancel := context.WithTimeout(ctx, socketWaitDeadlineMargin)
+		defer cancel()
+		err := waitForSocket(withDeadline, fs, dsdSocketPath, SocketWait{Timeout: time.Minute})
+		var unavailable *SocketUnavailableError
+		require.True(t, errors.As(err, &unavailable))
+		assert.Zero(t, unavailable.Waited)
+	})
+
+	t.Run("cancelled publish stops waiting", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		err := waitForSocket(cancelled, fs, dsdSocketPath, SocketWait{Timeout: time.Minute})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -0,0 +1,222 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/spf13/afero"
+	"k8s.io/utils/mount"
+)
+
+const (
+	// keySockets is the VolumeContext key selecting the sockets of an
+	// AgentSocketsDirectory volume, as a comma-separated list of socketAPM and socketDSD.
+	keySockets = "dd.csi.datadog.com/sockets"
+
+	socketAPM = "apm"
+	socketDSD = "dsd"
+
+	// socketsDirectoryDir is the directory of the per-volume socket directories, under the storage base path
+	socketsDirectoryDir = "sockets"
+)
+
+// socketsDirectoryPublisher handles AgentSocketsDirectory volumes. Instead of
+// the whole parent directory of the sockets, it mounts a private directory per
+// volume that only holds bind mounts of the selected socket files.
+type socketsDirectoryPublisher struct {
+	fs      afero.Afero
+	mounter mount.Interface
+	// sockets resolves the APMSocket and DSDSocket types to their host paths
+	sockets SocketRegistry
+	// basePath is the directory holding one private directory per volume
+	basePath string
+}
+
+// agentSocket is a socket file exposed in a private socket directory.
+type agentSocket struct {
+	name     string
+	hostPath string
+}
+
+// Publish creates the private directory of the volume, binds the selected sockets into it and mounts it to the target path.
+func (s socketsDirectoryPublisher) Publish(ctx context.Context, req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
+	volumeCtx := req.GetVolumeContext()
+	if VolumeType(volumeCtx["type"]) != AgentSocketsDirectory {
+		return nil, nil // Not our volume
+	}
+	resp := &PublisherResponse{VolumeType: AgentSocketsDirectory}
+
+	dir, err := volumeDir(s.basePath, req.GetVolumeId())
+	if err != nil {
+		return resp, err
+	}
+	sockets, err := s.selectSockets(volumeCtx[keySockets])
+	if err != nil {
+		return resp, err
+	}
+	resp.VolumePath = dir
+	resp.HostPath = dir
+
+	for _, socket := range sockets {
+		// Same validation as the socket publisher: the bind source must be a socket
+		isSocket, err := isSocketPath(s.fs, socket.hostPath)
+		if err != nil {
+			return resp, fmt.Errorf("failed to check if %q is a socket path: %w", socket.hostPath, err)
+		}
+		if !isSocket {
+			return resp, fmt.Errorf("socket not found at %q", socket.hostPath)
+		}
+	}
+
+	if err := s.fs.MkdirAll(dir, 0o755); err != nil {
+		return resp, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	for _, socket := range sockets {
+		if err := bindMount(ctx, s.fs, s.mounter, bindMountArgs{
+			hostPath:   socket.hostPath,
+			targetPath: filepath.Join(dir, socket.name),
+			isFile:     true,
+			readOnly:   false,
+			options:    mountOptions(AgentSocketsDirectory),
+		}); err != nil {
+			return resp, err
+		}
+	}
+
+	// The directory is read-only so that pods cannot drop files into it, sockets stay connectable
+	return resp, bindMount(ctx, s.fs, s.mounter, bindMountArgs{
+		hostPath:   dir,
+		targetPath: req.GetTargetPath(),
+		isFile:     false,
+		readOnly:   true,
+		recursive:  true,
+		options:    mountOptions(AgentSocketsDirectory),
+	})
+}
+
+// Unpublish unmounts the volume and removes its private directory.
+func (s socketsDirectoryPublisher) Unpublish(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+	// We don't have VolumeContext in Unpublish, so we check if the volume has a private directory
+	dir, err := volumeDir(s.basePath, req.GetVolumeId())
+	if err != nil {
+		return nil, nil // Not our volume
+	}
+	exists, err := s.fs.DirExists(dir)
+	if err != nil || !exists {
+		return nil, nil // Not our volume
+	}
+	resp := &PublisherResponse{VolumeType: AgentSocketsDirectory, VolumePath: dir, HostPath: dir}
+
+	if err := removePrivateDir(ctx, s.fs, s.mounter, dir, req.GetTargetPath()); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// Stats reports the usage of the private directory of the volume. The volume
+// is reported as abnormal when an agent socket was recreated after publish,
+// since the bind mounts keep pointing at the previous socket.
+func (s socketsDirectoryPublisher) Stats(_ context.Context, req *csi.NodeGetVolumeStatsRequest) (*VolumeStats, error) {
+	dir, err := volumeDir(s.basePath, req.GetVolumeId())
+	if err != nil {
+		return nil, nil // Not our volume
+	}
+	exists, err := s.fs.DirExists(dir)
+	if err != nil || !exists {
+		return nil, nil // Not our volume
+	}
+
+	usedBytes, usedInodes, err := pathUsage(s.fs, req.GetVolumePath())
+	if err != nil {
+		return &VolumeStats{VolumeType: AgentSocketsDirectory}, err
+	}
+	stats := &VolumeStats{VolumeType: AgentSocketsDirectory, UsedBytes: usedBytes, UsedInodes: usedInodes}
+
+	names, err := privateDirEntries(s.fs, dir)
+	if err != nil {
+		return stats, err
+	}
+	for _, socket := range s.configuredSockets() {
+		if !slices.Contains(names, socket.name) {
+			continue
+		}
+		same, err := isSameFile(s.fs, filepath.Join(dir, socket.name), socket.hostPath)
+		if err != nil || !same {
+			stats.Abnormal = true
+			stats.Message = fmt.Sprintf("the mounted socket %s no longer matches the agent socket %s, the agent was probably restarted: restart the pod to reconnect",
+				socket.name, socket.hostPath)
+			break
+		}
+	}
+	return stats, nil
+}
+
+// configuredSockets returns the sockets enabled in the socket registry.
+func (s socketsDirectoryPublisher) configuredSockets() []agentSocket {
+	var sockets []agentSocket
+	for _, volumeType := range []VolumeType{APMSocket, DSDSocket} {
+		if hostPath := s.sockets.EnabledPath(volumeType); hostPath != "" {
+			sockets = append(sockets, agentSocket{name: filepath.Base(hostPath), hostPath: hostPath})
+		}
+	}
+	return sockets
+}
+
+// selectSockets returns the sockets selected by the volume context, all the enabled sockets by default.
+func (s socketsDirectoryPublisher) selectSockets(selection string) ([]agentSocket, error) {
+	if selection == "" {
+		var configured []string
+		if s.sockets.EnabledPath(APMSocket) != "" {
+			configured = append(configured, socketAPM)
+		}
+		if s.sockets.EnabledPath(DSDSocket) != "" {
+			configured = append(configured, socketDSD)
+		}
+		if len(configured) == 0 {
+			return nil, fmt.Errorf("no socket is enabled on the driver")
+		}
+		selection = strings.Join(configured, ",")
+	}
+
+	var sockets []agentSocket
+	for _, item := range strings.Split(selection, ",") {
+		var hostPath string
+		switch strings.TrimSpace(item) {
+		case socketAPM:
+			hostPath = s.sockets.EnabledPath(APMSocket)
+		case socketDSD:
+			hostPath = s.sockets.EnabledPath(DSDSocket)
+		default:
+			return nil, fmt.Errorf("invalid socket %q in %s, expected %q or %q", item, keySockets, socketAPM, socketDSD)
+		}
+		if hostPath == "" {
+			return nil, fmt.Errorf("socket %q is disabled or not configured on the driver", strings.TrimSpace(item))
+		}
+		socket := agentSocket{name: filepath.Base(hostPath), hostPath: hostPath}
+		i := slices.IndexFunc(sockets, func(other agentSocket) bool { return other.name == socket.name })
+		if i < 0 {
+			sockets = append(sockets, socket)
+		} else if sockets[i].hostPath != socket.hostPath {
+			return nil, fmt.Errorf("sockets %q and %q have the same file name", sockets[i].hostPath, socket.hostPath)
+		}
+	}
+	return sockets, nil
+}
+
+func newSocketsDirectoryPublisher(fs afero.Afero, mounter mount.Interface, sockets SocketRegistry, storageBasePath string) Publisher {
+	return socketsDirectoryPublisher{
+		fs:       fs,
+		mounter:  mounter,
+		sockets:  sockets,
+		basePath: filepath.Join(storageBasePath, socketsDirectoryDir),
+	}
+}
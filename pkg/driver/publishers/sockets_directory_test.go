@@ -0,0 +1,149 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/mount"
+)
+
+func TestSocketsDirectoryPublisher_SelectSockets(t *testing.T) {
+	publisher := socketsDirectoryPublisher{sockets: testSocketRegistry(t, "/var/run/datadog/apm.socket", "/var/run/datadog/dsd.socket")}
+	apm := agentSocket{name: "apm.socket", hostPath: "/var/run/datadog/apm.socket"}
+	dsd := agentSocket{name: "dsd.socket", hostPath: "/var/run/datadog/dsd.socket"}
+	disabled := false
+	apmDisabled, err := NewSocketRegistry(apm.hostPath, dsd.hostPath, []NamedSocket{{Name: APMSocket, Enabled: &disabled}})
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		publisher socketsDirectoryPublisher
+		selection string
+		expected  []agentSocket
+		expectErr bool
+	}{
+		"all sockets by default":          {publisher: publisher, expected: []agentSocket{apm, dsd}},
+		"single socket":                   {publisher: publisher, selection: "dsd", expected: []agentSocket{dsd}},
+		"duplicates are ignored":          {publisher: publisher, selection: "apm, apm", expected: []agentSocket{apm}},
+		"unknown socket":                  {publisher: publisher, selection: "apm,logs", expectErr: true},
+		"default skips missing sockets":   {publisher: socketsDirectoryPublisher{sockets: testSocketRegistry(t, "", dsd.hostPath)}, expected: []agentSocket{dsd}},
+		"selected socket is missing":      {publisher: socketsDirectoryPublisher{sockets: testSocketRegistry(t, "", dsd.hostPath)}, selection: "apm", expectErr: true},
+		"default skips disabled sockets":  {publisher: socketsDirectoryPublisher{sockets: apmDisabled}, expected: []agentSocket{dsd}},
+		"selected socket is disabled":     {publisher: socketsDirectoryPublisher{sockets: apmDisabled}, selection: "apm", expectErr: true},
+		"no socket configured":            {publisher: socketsDirectoryPublisher{}, expectErr: true},
+		"sockets with the same file name": {publisher: socketsDirectoryPublisher{sockets: testSocketRegistry(t, "/apm/socket", "/dsd/socket")}, expectErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			sockets, err := tc.publisher.selectSockets(tc.selection)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, sockets)
+		})
+	}
+}
+
+func TestSocketsDirectoryPublisher(t *testing.T) {
+	// Unix socket paths are limited to 108 characters, so t.TempDir() can be too long
+	dir, err := os.MkdirTemp("", "csi-socket-*")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	fs := afero.Afero{Fs: afero.NewOsFs()}
+	apmSocketPath := filepath.Join(dir, "apm.socket")
+	dsdSocketPath := filepath.Join(dir, "dsd.socket")
+	listenUnixSocket(t, apmSocketPath)
+	storagePath := filepath.Join(dir, "storage")
+	targetPath := filepath.Join(dir, "target")
+	volumeDir := filepath.Join(storagePath, socketsDirectoryDir, "vol-1")
+
+	mounter := mount.NewFakeMounter(nil)
+	publisher := newSocketsDirectoryPublisher(fs, mounter, testSocketRegistry(t, apmSocketPath, dsdSocketPath), storagePath)
+	ctx := context.Background()
+
+	t.Run("other volume types are not supported", func(t *testing.T) {
+		resp, err := publisher.Publish(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:      "vol-1",
+			TargetPath:    targetPath,
+			VolumeContext: map[string]string{"type": string(DSDSocketDirectory)},
+		})
+		assert.Nil(t, resp)
+		assert.NoError(t, err)
+	})
+
+	t.Run("missing socket fails the publish", func(t *testing.T) {
+		resp, err := publisher.Publish(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:      "vol-1",
+			TargetPath:    targetPath,
+			VolumeContext: map[string]string{"type": string(AgentSocketsDirectory), keySockets: "dsd"},
+		})
+		require.NotNil(t, resp)
+		assert.Error(t, err)
+		assert.Empty(t, mounter.GetLog())
+		exists, err := fs.Exists(volumeDir)
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("publish only binds the selected sockets", func(t *testing.T) {
+		resp, err := publisher.Publish(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:      "vol-1",
+			TargetPath:    targetPath,
+			VolumeContext: map[string]string{"type": string(AgentSocketsDirectory), keySockets: "apm"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, AgentSocketsDirectory, resp.VolumeType)
+		assert.Equal(t, volumeDir, resp.HostPath)
+
+		mountPoints, err := mounter.List()
+		require.NoError(t, err)
+		require.Len(t, mountPoints, 2)
+		assert.Equal(t, apmSocketPath, mountPoints[0].Device)
+		assert.Equal(t, filepath.Join(volumeDir, "apm.socket"), mountPoints[0].Path)
+		assert.Equal(t, volumeDir, mountPoints[1].Device)
+		assert.Equal(t, targetPath, mountPoints[1].Path)
+		assert.Equal(t, []string{"rbind", "ro", "nosuid", "nodev", "noexec"}, mountPoints[1].Opts)
+	})
+
+	t.Run("stats report sockets recreated after publish", func(t *testing.T) {
+		// The fake mounter does not bind the socket, so it never matches the agent socket
+		stats, err := publisher.Stats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: "vol-1", VolumePath: targetPath})
+		require.NoError(t, err)
+		require.NotNil(t, stats)
+		assert.Equal(t, AgentSocketsDirectory, stats.VolumeType)
+		assert.True(t, stats.Abnormal)
+		assert.Contains(t, stats.Message, "apm.socket")
+	})
+
+	t.Run("unpublish removes the private directory", func(t *testing.T) {
+		resp, err := publisher.Unpublish(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "vol-1", TargetPath: targetPath})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, AgentSocketsDirectory, resp.VolumeType)
+
+		exists, err := fs.Exists(volumeDir)
+		require.NoError(t, err)
+		assert.False(t, exists)
+		mountPoints, err := mounter.List()
+		require.NoError(t, err)
+		assert.Empty(t, mountPoints)
+
+		resp, err = publisher.Unpublish(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "vol-1", TargetPath: targetPath})
+		assert.Nil(t, resp, "unknown volumes belong to other publishers")
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,33 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"os"
+
+	"github.com/spf13/afero"
+)
+
+// pathUsage walks path and returns the cumulative size of its regular files and
+// the number of inodes (files, directories, symlinks, sockets) it contains.
+// Symlinks are counted but not followed, so a volume never reports usage from
+// outside of it. A path that is not a directory counts as a single inode.
+func pathUsage(fs afero.Afero, path string) (usedBytes, usedInodes int64, err error) {
+	err = fs.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		usedInodes++
+		if info.Mode().IsRegular() {
+			usedBytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return usedBytes, usedInodes, nil
+}
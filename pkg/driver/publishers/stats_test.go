@@ -0,0 +1,72 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package publishers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathUsage_Directory(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewOsFs()}
+	dir := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("world!"), 0644))
+	// Symlinks are counted as inodes but never followed
+	require.NoError(t, os.Symlink("/etc", filepath.Join(dir, "link")))
+
+	usedBytes, usedInodes, err := pathUsage(fs, dir)
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), usedBytes)
+	// dir, sub, a.txt, sub/b.txt, link
+	assert.Equal(t, int64(5), usedInodes)
+}
+
+func TestPathUsage_File(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	require.NoError(t, fs.WriteFile("/target/file", []byte("content"), 0644))
+
+	usedBytes, usedInodes, err := pathUsage(fs, "/target/file")
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), usedBytes)
+	assert.Equal(t, int64(1), usedInodes)
+}
+
+func TestPathUsage_MissingPath(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+
+	_, _, err := pathUsage(fs, "/does/not/exist")
+
+	assert.Error(t, err)
+}
+
+func TestUnmountPublisher_Stats_ReportsTargetUsage(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	require.NoError(t, fs.WriteFile("/target/dir/dsd.socket", []byte{}, 0644))
+	require.NoError(t, fs.WriteFile("/target/dir/other", []byte("abc"), 0644))
+
+	publisher := newUnmountPublisher(fs, nil)
+	resp, err := publisher.Stats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+		VolumeId:   "test-volume",
+		VolumePath: "/target/dir",
+	})
+
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, int64(3), resp.UsedBytes)
+	assert.Equal(t, int64(3), resp.UsedInodes)
+}
@@ -6,6 +6,8 @@
 package publishers
 
 import (
+	"context"
+
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/spf13/afero"
 	"k8s.io/utils/mount"
@@ -15,18 +17,29 @@ import (
 // Since NodeUnpublishVolumeRequest doesn't include VolumeContext, we cannot
 // determine which publisher originally handled the Publish. The unmount logic
 // is identical for all bind mounts, so this publisher acts as the final handler.
+// The same applies to NodeGetVolumeStatsRequest, for which it reports the usage
+// of the bind-mount target.
 type unmountPublisher struct {
 	fs      afero.Afero
 	mounter mount.Interface
 }
 
-func (s unmountPublisher) Publish(req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
+func (s unmountPublisher) Publish(_ context.Context, req *csi.NodePublishVolumeRequest) (*PublisherResponse, error) {
 	return nil, nil
 }
 
-func (s unmountPublisher) Unpublish(req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
+func (s unmountPublisher) Unpublish(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*PublisherResponse, error) {
 	// Unpublish doesn't have VolumeContext, so we return an empty response
-	return &PublisherResponse{}, bindUnmount(s.fs, s.mounter, req.GetTargetPath())
+	return &PublisherResponse{}, bindUnmount(ctx, s.fs, s.mounter, req.GetTargetPath())
+}
+
+func (s unmountPublisher) Stats(_ context.Context, req *csi.NodeGetVolumeStatsRequest) (*VolumeStats, error) {
+	// Stats doesn't have VolumeContext either, so the volume type is unknown
+	usedBytes, usedInodes, err := pathUsage(s.fs, req.GetVolumePath())
+	if err != nil {
+		return &VolumeStats{}, err
+	}
+	return &VolumeStats{UsedBytes: usedBytes, UsedInodes: usedInodes}, nil
 }
 
 func newUnmountPublisher(fs afero.Afero, mounter mount.Interface) Publisher {
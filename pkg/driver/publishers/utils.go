@@ -6,12 +6,17 @@
 package publishers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	log "log/slog"
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/afero"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/utils/mount"
 )
 
 func createHostPath(fs afero.Afero, pathname string, isFile bool) error {
@@ -66,3 +71,106 @@ func isSocketPath(fs afero.Afero, path string) (bool, error) {
 	}
 	return fileInfo.Mode().Type() == os.ModeSocket, nil
 }
+
+// isSameFile checks if two paths point to the same inode, which is the case of a bind mount and its source.
+func isSameFile(fs afero.Afero, path, otherPath string) (bool, error) {
+	fileInfo, err := fs.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	otherFileInfo, err := fs.Stat(otherPath)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(fileInfo, otherFileInfo), nil
+}
+
+// volumeDir returns the directory of a volume under basePath. The volume ID
+// must be a single path element so that it cannot escape basePath.
+func volumeDir(basePath, volumeID string) (string, error) {
+	if volumeID == "" || volumeID != filepath.Base(volumeID) || volumeID == "." || volumeID == ".." {
+		return "", fmt.Errorf("invalid volume ID %q", volumeID)
+	}
+	return filepath.Join(basePath, volumeID), nil
+}
+
+// privateDirEntries returns the names of the bind mount points in the private
+// directory of a volume.
+func privateDirEntries(fs afero.Afero, dir string) ([]string, error) {
+	entries, err := fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+// unmountPrivateDirEntry unmounts a bind mount of a private directory, if any.
+func unmountPrivateDirEntry(ctx context.Context, fs afero.Afero, mounter mount.Interface, path string) {
+	exists, err := fs.Exists(path)
+	if err != nil || !exists {
+		return
+	}
+	if err := mounter.Unmount(path); err != nil {
+		log.DebugContext(ctx, "Path is not mounted", "path", path, "error", err)
+	}
+}
+
+// removePrivateDir unmounts the private directory of a volume from its target
+// path, along with the bind mounts it holds, and removes it. Nothing is done
+// when the directory does not exist.
+func removePrivateDir(ctx context.Context, fs afero.Afero, mounter mount.Interface, dir, targetPath string) error {
+	exists, err := fs.DirExists(dir)
+	if err != nil || !exists {
+		return err
+	}
+	names, err := privateDirEntries(fs, dir)
+	if err != nil {
+		return fmt.Errorf("failed to list volume directory: %w", err)
+	}
+
+	// The entries are bound under the target path by the recursive bind
+	// mount, they must be unmounted before the target path itself
+	for _, name := range names {
+		unmountPrivateDirEntry(ctx, fs, mounter, filepath.Join(targetPath, name))
+	}
+	if err := bindUnmount(ctx, fs, mounter, targetPath); err != nil {
+		return fmt.Errorf("failed to unmount volume directory: %w", err)
+	}
+	return clearPrivateDir(ctx, fs, mounter, dir)
+}
+
+// clearPrivateDir unmounts the bind mounts held by the private directory of a
+// volume and removes it, when it is not mounted to a target path. Nothing is
+// done when the directory does not exist.
+func clearPrivateDir(ctx context.Context, fs afero.Afero, mounter mount.Interface, dir string) error {
+	exists, err := fs.DirExists(dir)
+	if err != nil || !exists {
+		return err
+	}
+	names, err := privateDirEntries(fs, dir)
+	if err != nil {
+		return fmt.Errorf("failed to list volume directory: %w", err)
+	}
+
+	// Mount points are removed one by one rather than recursively, so that
+	// the content of a source that is still mounted is never deleted
+	var errs []error
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		unmountPrivateDirEntry(ctx, fs, mounter, path)
+		if err := fs.Remove(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to remove volume directory: %w", err)
+	}
+	if err := fs.Remove(dir); err != nil {
+		return fmt.Errorf("failed to remove volume directory: %w", err)
+	}
+	return nil
+}
@@ -21,6 +21,14 @@ const (
 	DatadogSocketsDirectory VolumeType = "DatadogSocketsDirectory"
 	// DatadogLibrary mounts a Datadog instrumentation library from an OCI image
 	DatadogLibrary VolumeType = "DatadogLibrary"
+	// DatadogLibraries mounts several Datadog instrumentation libraries, one sub-directory per package
+	DatadogLibraries VolumeType = "DatadogLibraries"
 	// DatadogInjectorPreload mounts the ld.so.preload file
 	DatadogInjectorPreload VolumeType = "DatadogInjectorPreload"
+	// AgentSocketsDirectory mounts a private directory only holding the selected agent sockets
+	AgentSocketsDirectory VolumeType = "AgentSocketsDirectory"
+	// DatadogAgentEnv mounts a read-only file of agent connection settings
+	DatadogAgentEnv VolumeType = "DatadogAgentEnv"
+	// DatadogScratch mounts a writable directory private to the volume, with a size cap
+	DatadogScratch VolumeType = "DatadogScratch"
 )
@@ -0,0 +1,137 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package driver
+
+import (
+	"fmt"
+	log "log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/metrics"
+	"github.com/spf13/afero"
+)
+
+const (
+	// defaultSelfCheckInterval is the default delay between two runs of the readiness checks.
+	defaultSelfCheckInterval = 30 * time.Second
+)
+
+// selfCheck is a named readiness check. A nil error means the check passed.
+type selfCheck struct {
+	name  string
+	check func() error
+}
+
+// selfChecker runs the driver readiness checks in a background loop and caches
+// their outcome, so Probe answers immediately instead of touching the disk.
+type selfChecker struct {
+	checks   []selfCheck
+	interval time.Duration
+
+	mu       sync.RWMutex
+	failures map[string]error
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newSelfChecker(interval time.Duration, checks ...selfCheck) *selfChecker {
+	return &selfChecker{
+		checks:   checks,
+		interval: interval,
+		failures: map[string]error{},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// start runs the checks once synchronously, so the first Probe reflects the
+// startup state, then keeps re-running them in the background until stopLoop.
+func (c *selfChecker) start() {
+	c.run()
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.run()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopLoop stops the background loop and waits for it to exit.
+func (c *selfChecker) stopLoop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		<-c.done
+	})
+}
+
+// run executes every check and publishes the results.
+func (c *selfChecker) run() {
+	failures := map[string]error{}
+	for _, check := range c.checks {
+		err := check.check()
+		if err != nil {
+			failures[check.name] = err
+		}
+		metrics.SetSelfCheckStatus(check.name, err == nil)
+	}
+	metrics.SetReady(len(failures) == 0)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, err := range failures {
+		if _, failing := c.failures[name]; !failing {
+			log.Warn("Self-check failed", "check", name, "error", err)
+		}
+	}
+	for name := range c.failures {
+		if _, failing := failures[name]; !failing {
+			log.Info("Self-check recovered", "check", name)
+		}
+	}
+	c.failures = failures
+}
+
+// ready returns whether all checks passed on their last run. When they did
+// not, the reason lists every failing check.
+func (c *selfChecker) ready() (bool, string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.failures) == 0 {
+		return true, ""
+	}
+	reasons := make([]string, 0, len(c.failures))
+	for name, err := range c.failures {
+		reasons = append(reasons, fmt.Sprintf("%s: %v", name, err))
+	}
+	sort.Strings(reasons)
+	return false, strings.Join(reasons, "; ")
+}
+
+// checkSocket verifies that the socket a socket volume type bind-mounts exists.
+func checkSocket(fs afero.Afero, socketPath string) error {
+	fileInfo, err := fs.Stat(socketPath)
+	if err != nil {
+		return fmt.Errorf("socket %q is not available: %w", socketPath, err)
+	}
+	if fileInfo.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%q is not a socket", socketPath)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package driver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfChecker_ReportsFailingChecks(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+
+	checker := newSelfChecker(time.Hour,
+		selfCheck{name: "ok", check: func() error { return nil }},
+		selfCheck{name: "toggle", check: func() error {
+			if failing.Load() {
+				return fmt.Errorf("boom")
+			}
+			return nil
+		}},
+		selfCheck{name: "another", check: func() error { return fmt.Errorf("bang") }},
+	)
+
+	checker.run()
+	ready, reason := checker.ready()
+	assert.False(t, ready)
+	assert.Equal(t, "another: bang; toggle: boom", reason)
+
+	failing.Store(false)
+	checker.run()
+	ready, reason = checker.ready()
+	assert.False(t, ready)
+	assert.Equal(t, "another: bang", reason)
+}
+
+func TestSelfChecker_BackgroundLoopRecovers(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+
+	checker := newSelfChecker(10*time.Millisecond, selfCheck{name: "toggle", check: func() error {
+		if failing.Load() {
+			return fmt.Errorf("boom")
+		}
+		return nil
+	}})
+	checker.start()
+	defer checker.stopLoop()
+
+	ready, _ := checker.ready()
+	require.False(t, ready, "the first run happens synchronously in start")
+
+	failing.Store(false)
+	assert.Eventually(t, func() bool {
+		ready, _ := checker.ready()
+		return ready
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSelfChecker_StopLoopIsIdempotent(t *testing.T) {
+	checker := newSelfChecker(time.Hour)
+	checker.start()
+
+	checker.stopLoop()
+	checker.stopLoop()
+
+	ready, reason := checker.ready()
+	assert.True(t, ready)
+	assert.Empty(t, reason)
+}
+
+func TestCheckSocket(t *testing.T) {
+	// Unix socket paths are limited to 108 characters, so t.TempDir() can be too long
+	dir, err := os.MkdirTemp("", "csi-selfcheck-*")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	fs := afero.Afero{Fs: afero.NewOsFs()}
+	socketPath := filepath.Join(dir, "apm.socket")
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	regularPath := filepath.Join(dir, "regular")
+	require.NoError(t, fs.WriteFile(regularPath, []byte{}, 0o644))
+
+	assert.NoError(t, checkSocket(fs, socketPath))
+	assert.ErrorContains(t, checkSocket(fs, regularPath), "is not a socket")
+	assert.ErrorContains(t, checkSocket(fs, filepath.Join(dir, "missing")), "is not available")
+}
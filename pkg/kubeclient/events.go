@@ -0,0 +1,71 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package kubeclient
+
+import (
+	"fmt"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+)
+
+// PodEvents records Kubernetes events on the pods volumes are published for.
+type PodEvents struct {
+	recorder    record.EventRecorder
+	broadcaster record.EventBroadcaster
+}
+
+// NewPodEvents returns a PodEvents recording events with the given recorder.
+func NewPodEvents(recorder record.EventRecorder) *PodEvents {
+	return &PodEvents{recorder: recorder}
+}
+
+// NewInClusterPodEvents returns a PodEvents using the service account of the driver pod.
+// The service account must be allowed to create events. The events are sent in
+// the background until Stop.
+func NewInClusterPodEvents(component string) (*PodEvents, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load in-cluster configuration: %w", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component})
+	return &PodEvents{recorder: recorder, broadcaster: broadcaster}, nil
+}
+
+// Warn records a warning event on a pod. Pods without a name or a namespace,
+// e.g. when the CSIDriver object does not set podInfoOnMount, are ignored.
+// A nil PodEvents records nothing.
+func (e *PodEvents) Warn(pod podinfo.PodInfo, reason, message string) {
+	if e == nil || pod.Name == "" || pod.Namespace == "" {
+		return
+	}
+	e.recorder.Event(&corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  pod.Namespace,
+		Name:       pod.Name,
+		UID:        types.UID(pod.UID),
+	}, corev1.EventTypeWarning, reason, message)
+}
+
+// Stop flushes the events in flight and stops sending events.
+func (e *PodEvents) Stop() {
+	if e != nil && e.broadcaster != nil {
+		e.broadcaster.Shutdown()
+	}
+}
@@ -0,0 +1,30 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package kubeclient
+
+import (
+	"testing"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestPodEvents(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	events := NewPodEvents(recorder)
+
+	events.Warn(podinfo.PodInfo{Name: "web-1", Namespace: "shop", UID: "uid-1"}, "DeprecatedVolumeSchema", "use type: APMSocket")
+	// Pods are unknown without podInfoOnMount
+	events.Warn(podinfo.PodInfo{}, "DeprecatedVolumeSchema", "use type: APMSocket")
+	events.Stop()
+	var nilEvents *PodEvents
+	nilEvents.Warn(podinfo.PodInfo{Name: "web-1", Namespace: "shop"}, "DeprecatedVolumeSchema", "use type: APMSocket")
+	nilEvents.Stop()
+
+	require.Len(t, recorder.Events, 1)
+	require.Equal(t, "Warning DeprecatedVolumeSchema use type: APMSocket", <-recorder.Events)
+}
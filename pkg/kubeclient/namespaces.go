@@ -0,0 +1,86 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+// Package kubeclient reads the Kubernetes objects the driver needs from the API server.
+package kubeclient
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// DefaultNamespaceLabelsTTL is how long the labels of a namespace are cached.
+const DefaultNamespaceLabelsTTL = time.Minute
+
+type namespaceLabelsEntry struct {
+	labels    map[string]string
+	fetchedAt time.Time
+}
+
+// NamespaceLabels returns the labels of namespaces, cached for a TTL to avoid
+// a request to the API server on every publish.
+type NamespaceLabels struct {
+	client kubernetes.Interface
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]namespaceLabelsEntry
+}
+
+// NewNamespaceLabels returns a NamespaceLabels reading namespaces with the given client.
+func NewNamespaceLabels(client kubernetes.Interface, ttl time.Duration) *NamespaceLabels {
+	return &NamespaceLabels{
+		client: client,
+		ttl:    ttl,
+		now:    time.Now,
+		cache:  map[string]namespaceLabelsEntry{},
+	}
+}
+
+// NewInClusterNamespaceLabels returns a NamespaceLabels using the service account of the driver pod.
+// The service account must be allowed to get namespaces.
+func NewInClusterNamespaceLabels(ttl time.Duration) (*NamespaceLabels, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load in-cluster configuration: %w", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	return NewNamespaceLabels(client, ttl), nil
+}
+
+// NamespaceLabels returns the labels of the namespace. The returned map must not be modified.
+func (n *NamespaceLabels) NamespaceLabels(ctx context.Context, namespace string) (map[string]string, error) {
+	n.mu.Lock()
+	entry, found := n.cache[namespace]
+	n.mu.Unlock()
+	if found && n.now().Sub(entry.fetchedAt) < n.ttl {
+		return entry.labels, nil
+	}
+
+	ns, err := n.client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get namespace %q: %w", namespace, err)
+	}
+	labels := maps.Clone(ns.Labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+
+	n.mu.Lock()
+	n.cache[namespace] = namespaceLabelsEntry{labels: labels, fetchedAt: n.now()}
+	n.mu.Unlock()
+	return labels, nil
+}
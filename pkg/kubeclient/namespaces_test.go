@@ -0,0 +1,50 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package kubeclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNamespaceLabels(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Labels: map[string]string{"team": "platform"}},
+	})
+	now := time.Now()
+	labeler := NewNamespaceLabels(client, time.Minute)
+	labeler.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	labels, err := labeler.NamespaceLabels(ctx, "shop")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"team": "platform"}, labels)
+
+	// Labels are cached until the TTL expires
+	ns, err := client.CoreV1().Namespaces().Get(ctx, "shop", metav1.GetOptions{})
+	require.NoError(t, err)
+	ns.Labels = map[string]string{"team": "web"}
+	_, err = client.CoreV1().Namespaces().Update(ctx, ns, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	labels, err = labeler.NamespaceLabels(ctx, "shop")
+	require.NoError(t, err)
+	require.Equal(t, "platform", labels["team"])
+
+	now = now.Add(time.Minute)
+	labels, err = labeler.NamespaceLabels(ctx, "shop")
+	require.NoError(t, err)
+	require.Equal(t, "web", labels["team"])
+
+	_, err = labeler.NamespaceLabels(ctx, "unknown")
+	require.Error(t, err)
+}
@@ -0,0 +1,50 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package kubeclient
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Pods reads the pods volumes are published for. Pods are not cached, since
+// they are only read while a publish waits for another volume of the pod.
+type Pods struct {
+	client kubernetes.Interface
+}
+
+// NewPods returns a Pods reading pods with the given client.
+func NewPods(client kubernetes.Interface) *Pods {
+	return &Pods{client: client}
+}
+
+// NewInClusterPods returns a Pods using the service account of the driver pod.
+// The service account must be allowed to get pods.
+func NewInClusterPods() (*Pods, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load in-cluster configuration: %w", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	return NewPods(client), nil
+}
+
+// GetPod returns the pod.
+func (p *Pods) GetPod(ctx context.Context, namespace, name string) (*corev1.Pod, error) {
+	pod, err := p.client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod %s/%s: %w", namespace, name, err)
+	}
+	return pod, nil
+}
@@ -0,0 +1,30 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package kubeclient
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPods(t *testing.T) {
+	pods := NewPods(fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web", UID: "uid-1"},
+	}))
+	ctx := context.Background()
+
+	pod, err := pods.GetPod(ctx, "shop", "web")
+	require.NoError(t, err)
+	require.Equal(t, "uid-1", string(pod.UID))
+
+	_, err = pods.GetPod(ctx, "shop", "unknown")
+	require.Error(t, err)
+}
@@ -22,6 +22,9 @@ const (
 	ResolutionCacheHit   ResolutionResult = "cache_hit"
 	ResolutionDownloaded ResolutionResult = "downloaded"
 	ResolutionFailed     ResolutionResult = "failed"
+	// ResolutionPending means the library is still being downloaded in the
+	// background and the volume will be resolved on a later retry.
+	ResolutionPending ResolutionResult = "pending"
 )
 
 // CleanupStatus enumerates the outcomes of a cleanup attempt for a library
@@ -33,6 +36,42 @@ const (
 	CleanupSuccess      CleanupStatus = "success"
 	CleanupFailed       CleanupStatus = "failed"
 	CleanupSkippedInUse CleanupStatus = "skipped_in_use"
+	// CleanupSkippedPinned means the library is pinned by the prefetch list
+	// and stays in the store even without volumes.
+	CleanupSkippedPinned CleanupStatus = "skipped_pinned"
+)
+
+// PrefetchResult enumerates the outcomes of prefetching a pinned library.
+// Underlying string values match the driver metric labels for the same reason
+// as ResolutionResult.
+type PrefetchResult string
+
+const (
+	// PrefetchCached means the library was already in the store.
+	PrefetchCached PrefetchResult = "cached"
+	// PrefetchDownloaded means the library was downloaded into the store.
+	PrefetchDownloaded PrefetchResult = "downloaded"
+	// PrefetchFailed means the tag could not be resolved or the library could
+	// not be downloaded. It is retried on the next prefetch.
+	PrefetchFailed PrefetchResult = "failed"
+)
+
+// ReconcileAction enumerates the corrective actions taken by the volume
+// reconciler when the database and the host mount table disagree. Underlying
+// string values match the driver metric labels for the same reason as
+// ResolutionResult.
+type ReconcileAction string
+
+const (
+	// ReconcileUnlinked means a volume whose target is no longer mounted was
+	// unlinked from its library.
+	ReconcileUnlinked ReconcileAction = "unlinked"
+	// ReconcileUnmounted means a leaked bind mount of a library, whose volume
+	// is unknown to the database, was unmounted.
+	ReconcileUnmounted ReconcileAction = "unmounted"
+	// ReconcileFailed means one of the above actions failed. It is retried on
+	// the next reconciliation.
+	ReconcileFailed ReconcileAction = "failed"
 )
 
 // Snapshot is a consistent view of every aggregate the listener needs to
@@ -102,6 +141,25 @@ type Listener interface {
 	// after the unlink (zero when the last volume is gone).
 	OnVolumeUnlinked(library string, volumeLinks int)
 
+	// OnVolumeReconciled is called for every corrective action taken by the
+	// volume reconciler. Volume links gauges are updated separately through
+	// OnVolumeUnlinked.
+	OnVolumeReconciled(library string, action ReconcileAction)
+
+	// OnDownloadQueueDepth is called whenever a download enters or leaves
+	// the queue of downloads waiting for a free slot. depth is the number of
+	// waiting downloads, suitable for a Gauge.Set.
+	OnDownloadQueueDepth(depth int)
+
+	// OnDownloadDequeued is called when a download gets a slot, with the
+	// time it waited in the queue (zero when a slot was free right away).
+	// Not called for downloads that gave up while queued.
+	OnDownloadDequeued(library string, wait time.Duration)
+
+	// OnLibraryPrefetched is called for every library of the prefetch list,
+	// each time the list is prefetched, with the outcome for that library.
+	OnLibraryPrefetched(library string, result PrefetchResult)
+
 	// OnSnapshot is called once at LibraryManager construction so the
 	// listener can seed its gauges with the persisted state and avoid the
 	// cold-start gap until the next event.
@@ -119,4 +177,8 @@ func (NoopListener) OnLibraryCached(string, int, int64)              {}
 func (NoopListener) OnLibraryEvicted(string, int, int64)             {}
 func (NoopListener) OnVolumeLinked(string, int)                      {}
 func (NoopListener) OnVolumeUnlinked(string, int)                    {}
+func (NoopListener) OnVolumeReconciled(string, ReconcileAction)      {}
+func (NoopListener) OnDownloadQueueDepth(int)                        {}
+func (NoopListener) OnDownloadDequeued(string, time.Duration)        {}
+func (NoopListener) OnLibraryPrefetched(string, PrefetchResult)      {}
 func (NoopListener) OnSnapshot(Snapshot)                             {}
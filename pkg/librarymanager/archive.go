@@ -60,7 +60,7 @@ func (fp *ArchiveExtractor) Extract(ctx context.Context, reader io.Reader) (int6
 	fp.root = root
 	defer func() { fp.root = nil }()
 
-	if err := fp.format.Extract(ctx, reader, fp.processFile); err != nil {
+	if err := fp.format.Extract(ctx, contextReader{ctx: ctx, reader: reader}, fp.processFile); err != nil {
 		return 0, err
 	}
 	return fp.bytesExtracted, nil
@@ -135,3 +135,17 @@ func (fp *ArchiveExtractor) processFile(ctx context.Context, f archives.FileInfo
 		return nil
 	}
 }
+
+// contextReader is a reader that fails with the context error once the context is done, so that the copy of a large
+// file stops on cancellation instead of running to completion.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
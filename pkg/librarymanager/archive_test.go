@@ -288,6 +288,23 @@ func TestExtractSymlinkNoTarget(t *testing.T) {
 	require.Contains(t, err.Error(), "has no target")
 }
 
+func TestExtractCanceled(t *testing.T) {
+	f, err := os.Open("testdata/rootfs.tar")
+	require.NoError(t, err)
+	defer f.Close()
+
+	tsd := testutil.NewTempScratchDirectory(t)
+	defer tsd.Cleanup(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ae, err := librarymanager.NewArchiveExtractor("/", tsd.Path(t))
+	require.NoError(t, err)
+
+	_, err = ae.Extract(ctx, f)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
 // createTarFromDir creates a tar archive from a directory, preserving symlinks.
 func createTarFromDir(t *testing.T, srcDir, archivePath string) {
 	t.Helper()
@@ -7,6 +7,7 @@ package librarymanager
 
 import (
 	log "log/slog"
+	"sort"
 	"sync"
 	"time"
 )
@@ -144,3 +145,257 @@ func (s *DelayedCleanupStrategy) Stop() {
 	}
 	s.pending = make(map[string]*pendingCleanup)
 }
+
+// libraryCatalog lists the libraries of the store with their size, volume
+// count and last use. It is implemented by Database.
+type libraryCatalog interface {
+	ListLibraries() (map[string]LibraryInfo, error)
+}
+
+// catalogCleanupStrategy is implemented by the strategies that need the
+// library catalog. The library manager hands it the catalog once the database
+// is open, then lets the strategy clean up the libraries left over by a
+// previous run.
+type catalogCleanupStrategy interface {
+	CleanupStrategy
+	setCatalog(catalog libraryCatalog)
+	triggerCleanup(cleanupFunc CleanupFunc)
+}
+
+// LRUCleanupStrategy keeps unused libraries in the store as long as the
+// store fits in a byte budget. Once the budget is exceeded, unused libraries
+// are evicted least recently used first until the store fits again. Libraries
+// still linked to volumes and pinned libraries count towards the budget but
+// are never evicted. Libraries used within the grace period are not evicted
+// either, so that a library downloaded in the background is still in the
+// store when the publish that needs it is retried: their eviction is
+// postponed until the grace period is over.
+//
+// Evictions run in a single background goroutine, so that unpublishing a
+// volume never waits for unrelated libraries to be removed from the store.
+type LRUCleanupStrategy struct {
+	budgetBytes int64
+	gracePeriod time.Duration
+
+	mu          sync.Mutex
+	catalog     libraryCatalog
+	cleanupFunc CleanupFunc
+	retry       *time.Timer
+	started     bool
+	stopped     bool
+	now         func() time.Time
+
+	wakeCh chan struct{}
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// NewLRUCleanupStrategy creates a new LRU cleanup strategy.
+// The budgetBytes parameter is the size the store is allowed to grow to before
+// unused libraries are evicted, and gracePeriod is how long a library is kept
+// after its last use whatever the size of the store.
+func NewLRUCleanupStrategy(budgetBytes int64, gracePeriod time.Duration) *LRUCleanupStrategy {
+	return &LRUCleanupStrategy{
+		budgetBytes: budgetBytes,
+		gracePeriod: gracePeriod,
+		now:         time.Now,
+		wakeCh:      make(chan struct{}, 1),
+		stopCh:      make(chan struct{}),
+		doneCh:      make(chan struct{}),
+	}
+}
+
+// setCatalog hands the catalog to the strategy and starts the evictor.
+func (s *LRUCleanupStrategy) setCatalog(catalog libraryCatalog) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.catalog = catalog
+	if !s.started && !s.stopped {
+		s.started = true
+		go s.run()
+	}
+}
+
+func (s *LRUCleanupStrategy) ScheduleCleanup(libraryID string, cleanupFunc CleanupFunc) {
+	log.Debug("LRUCleanup: library unused, checking store budget", "library_id", libraryID)
+	s.triggerCleanup(cleanupFunc)
+}
+
+// triggerCleanup wakes the evictor up for a pass over the store. Triggers
+// received while a pass is pending are merged into it.
+func (s *LRUCleanupStrategy) triggerCleanup(cleanupFunc CleanupFunc) {
+	s.mu.Lock()
+	if s.stopped || s.catalog == nil {
+		s.mu.Unlock()
+		return
+	}
+	s.cleanupFunc = cleanupFunc
+	s.mu.Unlock()
+	s.wake()
+}
+
+// wake requests a pass of the evictor, unless one is already pending.
+func (s *LRUCleanupStrategy) wake() {
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// run is the evictor: it runs a pass every time it is woken up, until the
+// strategy is stopped.
+func (s *LRUCleanupStrategy) run() {
+	defer close(s.doneCh)
+	for {
+		select {
+		case <-s.wakeCh:
+			s.mu.Lock()
+			cleanupFunc := s.cleanupFunc
+			s.mu.Unlock()
+			if cleanupFunc != nil {
+				s.cleanupStore(cleanupFunc)
+			}
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// cleanupStore evicts unused libraries, least recently used first, until the
+// store fits in the budget or no unused library is left. When the libraries
+// left are within their grace period, another pass is scheduled for the end
+// of the earliest one. The lock is not held while libraries are removed.
+func (s *LRUCleanupStrategy) cleanupStore(cleanupFunc CleanupFunc) {
+	// Libraries that could not be evicted are not retried in this pass,
+	// e.g. when they got linked again or their removal failed.
+	tried := map[string]bool{}
+	for {
+		s.mu.Lock()
+		catalog, stopped := s.catalog, s.stopped
+		s.mu.Unlock()
+		if stopped || catalog == nil {
+			return
+		}
+
+		libraries, err := catalog.ListLibraries()
+		if err != nil {
+			log.Error("LRUCleanup: could not list libraries", "error", err)
+			return
+		}
+
+		var storeBytes int64
+		for _, info := range libraries {
+			storeBytes += info.SizeBytes
+		}
+		if storeBytes <= s.budgetBytes {
+			return
+		}
+
+		usedBefore := s.now().Add(-s.gracePeriod)
+		libraryID, ok := leastRecentlyUsed(libraries, tried, usedBefore)
+		if !ok {
+			if graceEnd, found := earliestGraceEnd(libraries, tried, s.gracePeriod); found {
+				log.Debug("LRUCleanup: store exceeds its budget, postponing eviction of recently used libraries",
+					"store_bytes", storeBytes, "budget_bytes", s.budgetBytes, "retry_at", graceEnd)
+				s.scheduleRetry(graceEnd.Sub(s.now()))
+				return
+			}
+			log.Warn("LRUCleanup: store exceeds its budget but no unused library is left to evict",
+				"store_bytes", storeBytes, "budget_bytes", s.budgetBytes)
+			return
+		}
+		tried[libraryID] = true
+
+		log.Debug("LRUCleanup: evicting library", "library_id", libraryID,
+			"last_used_at", libraries[libraryID].LastUsedAt, "store_bytes", storeBytes, "budget_bytes", s.budgetBytes)
+		if err := cleanupFunc(libraryID); err != nil {
+			log.Error("LRUCleanup: cleanup failed", "library_id", libraryID, "error", err)
+		}
+	}
+}
+
+// scheduleRetry wakes the evictor up after the given delay, replacing the
+// wake-up already scheduled.
+func (s *LRUCleanupStrategy) scheduleRetry(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	if s.retry != nil {
+		s.retry.Stop()
+	}
+	s.retry = time.AfterFunc(delay, s.wake)
+}
+
+// evictable returns whether a library can be evicted: it is unused, unpinned
+// and not skipped.
+func evictable(libraryID string, info LibraryInfo, skip map[string]bool) bool {
+	return info.VolumeCount == 0 && !info.Pinned && !skip[libraryID]
+}
+
+// leastRecentlyUsed returns the evictable library with the oldest last use
+// before usedBefore. Libraries never marked as used come first, and ties are
+// broken by library ID so evictions are deterministic.
+func leastRecentlyUsed(libraries map[string]LibraryInfo, skip map[string]bool, usedBefore time.Time) (string, bool) {
+	candidates := make([]string, 0, len(libraries))
+	for libraryID, info := range libraries {
+		if evictable(libraryID, info, skip) && info.LastUsedAt.Before(usedBefore) {
+			candidates = append(candidates, libraryID)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := libraries[candidates[i]].LastUsedAt, libraries[candidates[j]].LastUsedAt
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return candidates[i] < candidates[j]
+	})
+	return candidates[0], true
+}
+
+// earliestGraceEnd returns when the grace period of the first evictable
+// library ends.
+func earliestGraceEnd(libraries map[string]LibraryInfo, skip map[string]bool, gracePeriod time.Duration) (time.Time, bool) {
+	var earliest time.Time
+	found := false
+	for libraryID, info := range libraries {
+		if !evictable(libraryID, info, skip) {
+			continue
+		}
+		if end := info.LastUsedAt.Add(gracePeriod); !found || end.Before(earliest) {
+			earliest, found = end, true
+		}
+	}
+	return earliest, found
+}
+
+func (s *LRUCleanupStrategy) Name() string {
+	return "lru"
+}
+
+// Stop stops evicting libraries, including the postponed evictions, and waits
+// for the library being evicted, if any. Unused libraries are kept in the
+// store for the next run, which evicts them if the store still exceeds the
+// budget.
+func (s *LRUCleanupStrategy) Stop() {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.stopped = true
+	if s.retry != nil {
+		s.retry.Stop()
+	}
+	close(s.stopCh)
+	started := s.started
+	s.mu.Unlock()
+
+	if started {
+		<-s.doneCh
+	}
+}
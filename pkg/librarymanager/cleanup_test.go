@@ -6,11 +6,14 @@
 package librarymanager
 
 import (
+	"errors"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestImmediateCleanupStrategy_ExecutesImmediately(t *testing.T) {
@@ -164,3 +167,183 @@ func TestDelayedCleanupStrategy_ConcurrentAccess(t *testing.T) {
 	// Should execute exactly once (last schedule wins, timer resets)
 	assert.Equal(t, int32(1), executeCount.Load(), "cleanup should be executed exactly once")
 }
+
+// fakeLibraryCatalog is an in-memory libraryCatalog whose libraries are
+// removed by the cleanup function it returns.
+type fakeLibraryCatalog struct {
+	mu        sync.Mutex
+	libraries map[string]LibraryInfo
+	cleaned   []string
+}
+
+func (c *fakeLibraryCatalog) ListLibraries() (map[string]LibraryInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	libraries := make(map[string]LibraryInfo, len(c.libraries))
+	for libraryID, info := range c.libraries {
+		libraries[libraryID] = info
+	}
+	return libraries, nil
+}
+
+func (c *fakeLibraryCatalog) cleanup(libraryID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cleaned = append(c.cleaned, libraryID)
+	delete(c.libraries, libraryID)
+	return nil
+}
+
+func (c *fakeLibraryCatalog) getCleaned() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.cleaned...)
+}
+
+func TestLRUCleanupStrategy_EvictsLeastRecentlyUsedOverBudget(t *testing.T) {
+	now := time.Now()
+	catalog := &fakeLibraryCatalog{libraries: map[string]LibraryInfo{
+		"lib-recent": {SizeBytes: 100, LastUsedAt: now},
+		"lib-old":    {SizeBytes: 100, LastUsedAt: now.Add(-2 * time.Hour)},
+		"lib-older":  {SizeBytes: 100, LastUsedAt: now.Add(-3 * time.Hour)},
+		"lib-in-use": {SizeBytes: 100, VolumeCount: 1, LastUsedAt: now.Add(-4 * time.Hour)},
+		// Libraries that predate the last use tracking are evicted first
+		"lib-unknown": {SizeBytes: 50},
+	}}
+	strategy := NewLRUCleanupStrategy(250, 0)
+	defer strategy.Stop()
+
+	// Nothing happens until the strategy has a catalog
+	strategy.ScheduleCleanup("lib-recent", catalog.cleanup)
+	assert.Empty(t, catalog.cleaned)
+
+	strategy.setCatalog(catalog)
+	strategy.ScheduleCleanup("lib-recent", catalog.cleanup)
+	assert.Eventually(t, func() bool {
+		return len(catalog.getCleaned()) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"lib-unknown", "lib-older", "lib-old"}, catalog.getCleaned())
+
+	// Within the budget, unused libraries are kept
+	libraries, err := catalog.ListLibraries()
+	require.NoError(t, err)
+	assert.Len(t, libraries, 2)
+	strategy.cleanupStore(catalog.cleanup)
+	assert.Len(t, catalog.getCleaned(), 3)
+}
+
+func TestLRUCleanupStrategy_ScheduleCleanupDoesNotWaitForEvictions(t *testing.T) {
+	catalog := &fakeLibraryCatalog{libraries: map[string]LibraryInfo{
+		"lib-1": {SizeBytes: 100},
+	}}
+	strategy := NewLRUCleanupStrategy(50, 0)
+	strategy.setCatalog(catalog)
+
+	evicting := make(chan struct{})
+	release := make(chan struct{})
+	cleanup := func(libraryID string) error {
+		close(evicting)
+		<-release
+		return catalog.cleanup(libraryID)
+	}
+
+	scheduled := make(chan struct{})
+	go func() {
+		strategy.ScheduleCleanup("lib-1", cleanup)
+		// Triggers received during a pass are merged, they do not wait either.
+		strategy.ScheduleCleanup("lib-1", cleanup)
+		close(scheduled)
+	}()
+	select {
+	case <-scheduled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ScheduleCleanup waited for the eviction")
+	}
+
+	<-evicting
+	close(release)
+	// Stop waits for the library being evicted.
+	strategy.Stop()
+	assert.Equal(t, []string{"lib-1"}, catalog.getCleaned())
+}
+
+func TestLRUCleanupStrategy_KeepsLibrariesInUseAndPinned(t *testing.T) {
+	catalog := &fakeLibraryCatalog{libraries: map[string]LibraryInfo{
+		"lib-in-use": {SizeBytes: 300, VolumeCount: 2},
+		"lib-pinned": {SizeBytes: 100, Pinned: true},
+		"lib-unused": {SizeBytes: 10, LastUsedAt: time.Now()},
+	}}
+	strategy := NewLRUCleanupStrategy(100, 0)
+	defer strategy.Stop()
+	strategy.setCatalog(catalog)
+
+	// The unused library is evicted, then the store stays over budget
+	strategy.cleanupStore(catalog.cleanup)
+	assert.Equal(t, []string{"lib-unused"}, catalog.cleaned)
+	assert.Contains(t, catalog.libraries, "lib-in-use")
+	assert.Contains(t, catalog.libraries, "lib-pinned")
+}
+
+func TestLRUCleanupStrategy_DoesNotRetryFailedCleanups(t *testing.T) {
+	catalog := &fakeLibraryCatalog{libraries: map[string]LibraryInfo{
+		"lib-1": {SizeBytes: 100},
+		"lib-2": {SizeBytes: 100},
+	}}
+	strategy := NewLRUCleanupStrategy(50, 0)
+	defer strategy.Stop()
+	strategy.setCatalog(catalog)
+
+	var attempts []string
+	strategy.cleanupStore(func(libraryID string) error {
+		attempts = append(attempts, libraryID)
+		return errors.New("still mounted")
+	})
+	assert.Equal(t, []string{"lib-1", "lib-2"}, attempts)
+}
+
+func TestLRUCleanupStrategy_StopKeepsLibraries(t *testing.T) {
+	catalog := &fakeLibraryCatalog{libraries: map[string]LibraryInfo{
+		"lib-1": {SizeBytes: 100},
+	}}
+	strategy := NewLRUCleanupStrategy(50, 0)
+	strategy.setCatalog(catalog)
+	strategy.Stop()
+
+	strategy.ScheduleCleanup("lib-1", catalog.cleanup)
+	assert.Empty(t, catalog.cleaned)
+	assert.Equal(t, "lru", strategy.Name())
+}
+
+func TestLRUCleanupStrategy_PostponesEvictionWithinGracePeriod(t *testing.T) {
+	now := time.Now()
+	catalog := &fakeLibraryCatalog{libraries: map[string]LibraryInfo{
+		"lib-in-use": {SizeBytes: 100, VolumeCount: 1, LastUsedAt: now.Add(-time.Hour)},
+		// Just downloaded in the background, not linked yet
+		"lib-new": {SizeBytes: 100, LastUsedAt: now},
+	}}
+	strategy := NewLRUCleanupStrategy(150, 100*time.Millisecond)
+	defer strategy.Stop()
+	strategy.setCatalog(catalog)
+
+	strategy.ScheduleCleanup("lib-new", catalog.cleanup)
+	assert.Empty(t, catalog.getCleaned(), "a library within its grace period must be kept")
+
+	// The eviction runs once the grace period is over
+	assert.Eventually(t, func() bool {
+		return len(catalog.getCleaned()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"lib-new"}, catalog.getCleaned())
+}
+
+func TestLRUCleanupStrategy_StopCancelsPostponedEviction(t *testing.T) {
+	catalog := &fakeLibraryCatalog{libraries: map[string]LibraryInfo{
+		"lib-new": {SizeBytes: 100, LastUsedAt: time.Now()},
+	}}
+	strategy := NewLRUCleanupStrategy(50, 50*time.Millisecond)
+	strategy.setCatalog(catalog)
+
+	strategy.ScheduleCleanup("lib-new", catalog.cleanup)
+	strategy.Stop()
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, catalog.getCleaned())
+}
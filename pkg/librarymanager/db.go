@@ -9,9 +9,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/Datadog/datadog-csi-driver/pkg/libraryevents"
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
 	"go.etcd.io/bbolt"
 )
 
@@ -19,15 +21,20 @@ const (
 	// DatabaseFileName is the name of the database file created by bbolt.
 	DatabaseFileName = "datadog-csi-driver.db"
 
-	// VolumesBucket maps a volume to the library it uses. Key = volume ID,
-	// value = JSON volumeRecord. The relationship is 1:1 (a volume mounts
-	// exactly one library), so a flat bucket is all that is needed.
+	// VolumesBucket maps a volume to the libraries it uses. Key = volume ID,
+	// value = JSON volumeRecord. A DatadogLibrary volume mounts exactly one
+	// library and a DatadogLibraries volume a fixed list of them, so a flat
+	// bucket is all that is needed.
 	VolumesBucket = "volumes"
 	// LibrariesBucket holds one record per cached library. Key = library ID
 	// (the image digest), value = JSON libraryRecord. It is the single
 	// source of truth for the package label, the on-disk size and the number
 	// of volumes currently using the library.
 	LibrariesBucket = "libraries"
+	// PublicationsBucket holds one record per published volume, whatever its
+	// type. Key = volume ID, value = JSON publicationRecord. It lets the
+	// driver route an unpublish to the publisher that owns the volume.
+	PublicationsBucket = "publications"
 
 	// The following buckets belong to the legacy nested-bucket schema and are
 	// only referenced by migrate() to upgrade existing databases in place.
@@ -40,14 +47,37 @@ const (
 // than a bare library ID) so that fields can be added later without breaking
 // existing databases.
 type volumeRecord struct {
-	// LibraryID is the ID of the library the volume is mounted from.
+	// LibraryID is the ID of the library the volume is mounted from. It is
+	// empty for volumes mounting several libraries.
 	LibraryID string `json:"library_id"`
+	// LibraryIDs are the IDs of the libraries of a volume mounting several
+	// of them, in the order they were requested.
+	LibraryIDs []string `json:"library_ids,omitempty"`
 	// CreatedAt is when the link was recorded. Records migrated from the
 	// legacy schema have a zero value.
 	CreatedAt time.Time `json:"created_at,omitempty"`
 	// FromCache reports whether the publish that created this link reused an
 	// already-cached library (true) or had to download it first (false).
 	FromCache bool `json:"from_cache,omitempty"`
+	// Pod is the pod the volume was published for. It is empty when the
+	// CSIDriver object does not set podInfoOnMount.
+	Pod podinfo.PodInfo `json:"pod,omitzero"`
+}
+
+// libraryIDs returns the IDs of every library the volume is mounted from.
+func (rec volumeRecord) libraryIDs() []string {
+	if len(rec.LibraryIDs) > 0 {
+		return rec.LibraryIDs
+	}
+	if rec.LibraryID == "" {
+		return nil
+	}
+	return []string{rec.LibraryID}
+}
+
+// info returns the public view of the record.
+func (rec volumeRecord) info() VolumeInfo {
+	return VolumeInfo{LibraryID: rec.LibraryID, LibraryIDs: rec.libraryIDs(), CreatedAt: rec.CreatedAt, Pod: rec.Pod}
 }
 
 // libraryRecord is the value stored in LibrariesBucket.
@@ -62,6 +92,44 @@ type libraryRecord struct {
 	// VolumeCount is the number of volumes currently linked to this library.
 	// It replaces the per-library volume sub-bucket of the legacy schema.
 	VolumeCount int `json:"volume_count,omitempty"`
+	// SourcePath is the directory to mount given by the image metadata. It is
+	// empty when the image does not set it.
+	SourcePath string `json:"source_path,omitempty"`
+	// LastUsedAt is when the library was last added, linked to a volume or
+	// unlinked from one. It orders the evictions of LRUCleanupStrategy, and is
+	// zero for records that predate it.
+	LastUsedAt time.Time `json:"last_used_at,omitzero"`
+	// Pinned is set on the libraries of the prefetch list. Pinned libraries
+	// are never removed by a cleanup strategy, even without volumes.
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// publicationRecord is the value stored in PublicationsBucket.
+type publicationRecord struct {
+	// VolumeType is the type of the published volume (e.g. "APMSocket").
+	VolumeType string `json:"volume_type"`
+	// HostPath is the bind source of the volume on the host.
+	HostPath string `json:"host_path,omitempty"`
+	// TargetPath is the path the volume was published to.
+	TargetPath string `json:"target_path"`
+	// PublishedAt is when the volume was published.
+	PublishedAt time.Time `json:"published_at"`
+	// Pod is the pod the volume was published for.
+	Pod podinfo.PodInfo `json:"pod,omitzero"`
+}
+
+// Publication is the public view of a publication record.
+type Publication struct {
+	// VolumeType is the type of the published volume (e.g. "APMSocket").
+	VolumeType string
+	// HostPath is the bind source of the volume on the host.
+	HostPath string
+	// TargetPath is the path the volume was published to.
+	TargetPath string
+	// PublishedAt is when the volume was published.
+	PublishedAt time.Time
+	// Pod is the pod the volume was published for.
+	Pod podinfo.PodInfo
 }
 
 // LibraryInfo is the public, read-only view of a library record returned by
@@ -74,6 +142,29 @@ type LibraryInfo struct {
 	SizeBytes int64
 	// VolumeCount is the number of volumes currently linked to the library.
 	VolumeCount int
+	// SourcePath is the directory to mount given by the image metadata, if any.
+	SourcePath string
+	// LastUsedAt is when the library was last added, linked or unlinked. It
+	// is zero for records that predate it.
+	LastUsedAt time.Time
+	// Pinned is true for the libraries of the prefetch list, which are never cleaned up.
+	Pinned bool
+}
+
+// VolumeInfo is the public, read-only view of a volume record returned by
+// ListVolumes and GetVolume.
+type VolumeInfo struct {
+	// LibraryID is the ID of the library the volume is mounted from. It is
+	// empty for volumes mounting several libraries.
+	LibraryID string
+	// LibraryIDs are the IDs of every library the volume is mounted from,
+	// including LibraryID.
+	LibraryIDs []string
+	// CreatedAt is when the link was recorded. It is zero for records
+	// migrated from the legacy schema.
+	CreatedAt time.Time
+	// Pod is the pod the volume was published for.
+	Pod podinfo.PodInfo
 }
 
 // Database is a thin wrapper around bbolt.
@@ -128,6 +219,9 @@ func migrate(tx *bbolt.Tx) error {
 	if err != nil {
 		return fmt.Errorf("could not create bucket %s: %w", LibrariesBucket, err)
 	}
+	if _, err := tx.CreateBucketIfNotExists([]byte(PublicationsBucket)); err != nil {
+		return fmt.Errorf("could not create bucket %s: %w", PublicationsBucket, err)
+	}
 
 	// Seed library records from the legacy metadata bucket.
 	if metaBkt := tx.Bucket([]byte(legacyLibraryMetadataBucket)); metaBkt != nil {
@@ -203,11 +297,25 @@ func (db *Database) Close() error {
 	return db.bbolt.Close()
 }
 
-// AddLibrary records a freshly-cached library by persisting its package name
-// and on-disk size. It is idempotent and preserves the volume count of an
-// existing record, so it can safely be called again (for instance when the
-// size changed) without disturbing the link bookkeeping.
-func (db *Database) AddLibrary(libraryID, packageName string, sizeBytes int64) error {
+// Ping verifies that the database can still be read and that its buckets
+// exist. It is used by the driver self-checks.
+func (db *Database) Ping() error {
+	return db.bbolt.View(func(tx *bbolt.Tx) error {
+		for _, name := range []string{VolumesBucket, LibrariesBucket, PublicationsBucket} {
+			if tx.Bucket([]byte(name)) == nil {
+				return fmt.Errorf("bucket %s is missing", name)
+			}
+		}
+		return nil
+	})
+}
+
+// AddLibrary records a freshly-cached library by persisting its package name,
+// on-disk size and the source path from its image metadata, and marks it as
+// used. It is idempotent and preserves the volume count of an existing record,
+// so it can safely be called again (for instance when the size changed)
+// without disturbing the link bookkeeping.
+func (db *Database) AddLibrary(libraryID, packageName string, sizeBytes int64, sourcePath string) error {
 	if libraryID == "" {
 		return fmt.Errorf("library ID cannot be blank")
 	}
@@ -226,10 +334,78 @@ func (db *Database) AddLibrary(libraryID, packageName string, sizeBytes int64) e
 		}
 		rec.Package = packageName
 		rec.SizeBytes = sizeBytes
+		rec.SourcePath = sourcePath
+		rec.LastUsedAt = time.Now().UTC()
 		return putLibrary(bkt, libraryID, rec)
 	})
 }
 
+// PinLibrary marks a library as pinned, so that it is never cleaned up. The
+// record is created when the library is unknown.
+func (db *Database) PinLibrary(libraryID string) error {
+	if libraryID == "" {
+		return fmt.Errorf("library ID cannot be blank")
+	}
+
+	return db.bbolt.Update(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(LibrariesBucket))
+		if bkt == nil {
+			return fmt.Errorf("libraries bucket does not exist")
+		}
+		rec, err := getLibrary(bkt, libraryID)
+		if err != nil {
+			return err
+		}
+		if rec.Pinned {
+			return nil
+		}
+		rec.Pinned = true
+		return putLibrary(bkt, libraryID, rec)
+	})
+}
+
+// UnpinLibrariesExcept unpins every pinned library but the given ones, in a
+// single transaction. It returns the IDs of the libraries it unpinned.
+func (db *Database) UnpinLibrariesExcept(keep []string) ([]string, error) {
+	var unpinned []string
+	err := db.bbolt.Update(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(LibrariesBucket))
+		if bkt == nil {
+			return fmt.Errorf("libraries bucket does not exist")
+		}
+
+		// The bucket cannot be modified while iterating over it.
+		records := map[string]libraryRecord{}
+		err := bkt.ForEach(func(k, v []byte) error {
+			var rec libraryRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("could not unmarshal library record: %w", err)
+			}
+			if rec.Pinned && !slices.Contains(keep, string(k)) {
+				records[string(k)] = rec
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for libraryID, rec := range records {
+			rec.Pinned = false
+			if err := putLibrary(bkt, libraryID, rec); err != nil {
+				return err
+			}
+			unpinned = append(unpinned, libraryID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(unpinned)
+	return unpinned, nil
+}
+
 // RemoveLibrary deletes the record for a library. It is a no-op when the
 // library is unknown.
 func (db *Database) RemoveLibrary(libraryID string) error {
@@ -249,7 +425,7 @@ func (db *Database) RemoveLibrary(libraryID string) error {
 // LinkVolume records that volumeID uses libraryID and increments the
 // library's volume count. fromCache notes whether the publish reused an
 // already-cached library or had to download it; it is persisted on the record
-// together with a creation timestamp.
+// together with a creation timestamp and the pod the volume is published for.
 //
 // A volume maps to exactly one library for its whole lifetime: callers resolve
 // an already-linked volume from its existing record instead of re-resolving the
@@ -257,10 +433,35 @@ func (db *Database) RemoveLibrary(libraryID string) error {
 // Linking a volume that is already tracked is therefore treated as an
 // idempotent no-op rather than re-pointing it, which keeps the per-library
 // counts from drifting even if the function is called twice.
-func (db *Database) LinkVolume(libraryID, volumeID string, fromCache bool) error {
+func (db *Database) LinkVolume(libraryID, volumeID string, fromCache bool, pod podinfo.PodInfo) error {
 	if libraryID == "" {
 		return fmt.Errorf("library ID cannot be blank")
 	}
+	return db.linkVolume(volumeID, volumeRecord{LibraryID: libraryID, FromCache: fromCache, Pod: pod})
+}
+
+// LinkVolumeLibraries is LinkVolume for a volume mounting several libraries.
+// Every library is linked in the same transaction, so the volume is either
+// linked to all of them or to none. fromCache reports whether every library
+// was already cached.
+func (db *Database) LinkVolumeLibraries(libraryIDs []string, volumeID string, fromCache bool, pod podinfo.PodInfo) error {
+	if len(libraryIDs) == 0 {
+		return fmt.Errorf("library IDs cannot be empty")
+	}
+	for i, libraryID := range libraryIDs {
+		if libraryID == "" {
+			return fmt.Errorf("library ID cannot be blank")
+		}
+		if slices.Contains(libraryIDs[:i], libraryID) {
+			return fmt.Errorf("library ID %s is listed twice", libraryID)
+		}
+	}
+	return db.linkVolume(volumeID, volumeRecord{LibraryIDs: libraryIDs, FromCache: fromCache, Pod: pod})
+}
+
+// linkVolume writes the record of a volume that is not linked yet,
+// increments the volume count of each of its libraries and marks them as used.
+func (db *Database) linkVolume(volumeID string, rec volumeRecord) error {
 	if volumeID == "" {
 		return fmt.Errorf("volume ID cannot be blank")
 	}
@@ -281,34 +482,47 @@ func (db *Database) LinkVolume(libraryID, volumeID string, fromCache bool) error
 			return nil
 		}
 
-		if err := putVolume(volumesBkt, volumeID, volumeRecord{
-			LibraryID: libraryID,
-			CreatedAt: time.Now().UTC(),
-			FromCache: fromCache,
-		}); err != nil {
+		rec.CreatedAt = time.Now().UTC()
+		if err := putVolume(volumesBkt, volumeID, rec); err != nil {
 			return err
 		}
-		rec, err := getLibrary(librariesBkt, libraryID)
-		if err != nil {
-			return err
+		for _, libraryID := range rec.libraryIDs() {
+			libRec, err := getLibrary(librariesBkt, libraryID)
+			if err != nil {
+				return err
+			}
+			libRec.VolumeCount++
+			libRec.LastUsedAt = rec.CreatedAt
+			if err := putLibrary(librariesBkt, libraryID, libRec); err != nil {
+				return err
+			}
 		}
-		rec.VolumeCount++
-		return putLibrary(librariesBkt, libraryID, rec)
+		return nil
 	})
 }
 
-// UnlinkVolume removes the link for a volume and decrements the owning
-// library's volume count. It returns the library ID and package name the
-// volume was linked to (both empty when the volume was not tracked, in which
-// case it is a no-op). The package is read off the library record that is
-// loaded to decrement the count, so callers get the metric label for free
-// without an extra lookup.
-func (db *Database) UnlinkVolume(volumeID string) (libraryID, packageName string, err error) {
+// LinkedLibrary is a library a volume was linked to.
+type LinkedLibrary struct {
+	// LibraryID is the ID of the library.
+	LibraryID string
+	// Package is the package name of the library. It is empty for legacy
+	// entries that predate per-library metadata.
+	Package string
+}
+
+// UnlinkVolume removes the link for a volume, decrements the volume count of
+// each of its libraries and marks them as used, in a single transaction. It returns the libraries
+// the volume was linked to (none when the volume was not tracked, in which
+// case it is a no-op). The package names are read off the library records
+// that are loaded to decrement the counts, so callers get the metric labels
+// for free without an extra lookup.
+func (db *Database) UnlinkVolume(volumeID string) ([]LinkedLibrary, error) {
 	if volumeID == "" {
-		return "", "", fmt.Errorf("volume ID cannot be blank")
+		return nil, fmt.Errorf("volume ID cannot be blank")
 	}
 
-	err = db.bbolt.Update(func(tx *bbolt.Tx) error {
+	var libraries []LinkedLibrary
+	err := db.bbolt.Update(func(tx *bbolt.Tx) error {
 		volumesBkt := tx.Bucket([]byte(VolumesBucket))
 		librariesBkt := tx.Bucket([]byte(LibrariesBucket))
 		if volumesBkt == nil || librariesBkt == nil {
@@ -322,19 +536,22 @@ func (db *Database) UnlinkVolume(volumeID string) (libraryID, packageName string
 		if !linked {
 			return nil
 		}
-		libraryID = rec.LibraryID
 
 		if err := volumesBkt.Delete([]byte(volumeID)); err != nil {
 			return fmt.Errorf("could not delete volume record %s: %w", volumeID, err)
 		}
 
-		libRec, err := getLibrary(librariesBkt, libraryID)
-		if err != nil {
-			return err
-		}
-		packageName = libRec.Package
-		if libRec.VolumeCount > 0 {
-			libRec.VolumeCount--
+		now := time.Now().UTC()
+		for _, libraryID := range rec.libraryIDs() {
+			libRec, err := getLibrary(librariesBkt, libraryID)
+			if err != nil {
+				return err
+			}
+			libraries = append(libraries, LinkedLibrary{LibraryID: libraryID, Package: libRec.Package})
+			if libRec.VolumeCount > 0 {
+				libRec.VolumeCount--
+			}
+			libRec.LastUsedAt = now
 			if err := putLibrary(librariesBkt, libraryID, libRec); err != nil {
 				return err
 			}
@@ -342,9 +559,9 @@ func (db *Database) UnlinkVolume(volumeID string) (libraryID, packageName string
 		return nil
 	})
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-	return libraryID, packageName, nil
+	return libraries, nil
 }
 
 // GetLibraryForVolume returns the library ID a volume is linked to, or an
@@ -370,6 +587,56 @@ func (db *Database) GetLibraryForVolume(volumeID string) (string, error) {
 	return libraryID, err
 }
 
+// GetVolume returns the stored information for a volume. The boolean is false
+// when the volume is not tracked.
+func (db *Database) GetVolume(volumeID string) (VolumeInfo, bool, error) {
+	if volumeID == "" {
+		return VolumeInfo{}, false, fmt.Errorf("volume ID cannot be blank")
+	}
+
+	var (
+		info  VolumeInfo
+		found bool
+	)
+	err := db.bbolt.View(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(VolumesBucket))
+		if bkt == nil {
+			return fmt.Errorf("volumes bucket does not exist")
+		}
+		rec, ok, err := getVolume(bkt, volumeID)
+		if err != nil {
+			return err
+		}
+		found = ok
+		info = rec.info()
+		return nil
+	})
+	return info, found, err
+}
+
+// ListVolumes returns every tracked volume, keyed by volume ID.
+func (db *Database) ListVolumes() (map[string]VolumeInfo, error) {
+	volumes := map[string]VolumeInfo{}
+	err := db.bbolt.View(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(VolumesBucket))
+		if bkt == nil {
+			return fmt.Errorf("volumes bucket does not exist")
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			var rec volumeRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("could not unmarshal volume record: %w", err)
+			}
+			volumes[string(k)] = rec.info()
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return volumes, nil
+}
+
 // GetLibrary returns the stored information for a library. The boolean is
 // false when the library has no record (for instance a legacy entry on disk
 // that was never tracked with metadata).
@@ -402,6 +669,125 @@ func (db *Database) GetLibrary(libraryID string) (LibraryInfo, bool, error) {
 	return info, found, err
 }
 
+// ListLibraries returns the stored information of every library, keyed by library ID.
+func (db *Database) ListLibraries() (map[string]LibraryInfo, error) {
+	libraries := map[string]LibraryInfo{}
+	err := db.bbolt.View(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(LibrariesBucket))
+		if bkt == nil {
+			return fmt.Errorf("libraries bucket does not exist")
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			var rec libraryRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("could not unmarshal library record: %w", err)
+			}
+			libraries[string(k)] = LibraryInfo(rec)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return libraries, nil
+}
+
+// PutPublication records the publication of a volume, replacing any previous
+// record for the same volume.
+func (db *Database) PutPublication(volumeID string, publication Publication) error {
+	if volumeID == "" {
+		return fmt.Errorf("volume ID cannot be blank")
+	}
+	if publication.VolumeType == "" {
+		return fmt.Errorf("volume type cannot be blank")
+	}
+
+	encoded, err := json.Marshal(publicationRecord(publication))
+	if err != nil {
+		return fmt.Errorf("could not marshal publication record: %w", err)
+	}
+	return db.bbolt.Update(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(PublicationsBucket))
+		if bkt == nil {
+			return fmt.Errorf("publications bucket does not exist")
+		}
+		if err := bkt.Put([]byte(volumeID), encoded); err != nil {
+			return fmt.Errorf("could not write publication record: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetPublication returns the publication record of a volume. The boolean is
+// false when the volume has no record, for instance because it was published
+// by a driver version that predates the publications bucket.
+func (db *Database) GetPublication(volumeID string) (Publication, bool, error) {
+	if volumeID == "" {
+		return Publication{}, false, fmt.Errorf("volume ID cannot be blank")
+	}
+
+	var (
+		publication Publication
+		found       bool
+	)
+	err := db.bbolt.View(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(PublicationsBucket))
+		if bkt == nil {
+			return fmt.Errorf("publications bucket does not exist")
+		}
+		raw := bkt.Get([]byte(volumeID))
+		if raw == nil {
+			return nil
+		}
+		var rec publicationRecord
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return fmt.Errorf("could not unmarshal publication record: %w", err)
+		}
+		found = true
+		publication = Publication(rec)
+		return nil
+	})
+	return publication, found, err
+}
+
+// ListPublications returns every publication record, keyed by volume ID.
+func (db *Database) ListPublications() (map[string]Publication, error) {
+	publications := map[string]Publication{}
+	err := db.bbolt.View(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(PublicationsBucket))
+		if bkt == nil {
+			return fmt.Errorf("publications bucket does not exist")
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			var rec publicationRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("could not unmarshal publication record: %w", err)
+			}
+			publications[string(k)] = Publication(rec)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return publications, nil
+}
+
+// RemovePublication deletes the publication record of a volume. Removing a
+// record that does not exist is a no-op.
+func (db *Database) RemovePublication(volumeID string) error {
+	if volumeID == "" {
+		return fmt.Errorf("volume ID cannot be blank")
+	}
+	return db.bbolt.Update(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(PublicationsBucket))
+		if bkt == nil {
+			return fmt.Errorf("publications bucket does not exist")
+		}
+		return bkt.Delete([]byte(volumeID))
+	})
+}
+
 // Snapshot derives the per-package aggregates the metrics listener needs by
 // scanning LibrariesBucket. It is cheap because a node only ever caches a
 // small number of libraries. Libraries without a package label (legacy
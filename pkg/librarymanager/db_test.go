@@ -9,8 +9,10 @@ import (
 	"encoding/json"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/Datadog/datadog-csi-driver/pkg/librarymanager"
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
 	"github.com/Datadog/datadog-csi-driver/pkg/testutil"
 	"github.com/stretchr/testify/require"
 	"go.etcd.io/bbolt"
@@ -28,7 +30,7 @@ func volumeCount(t *testing.T, db *librarymanager.Database, libraryID string) in
 // link is a small helper to link a volume and assert success.
 func link(t *testing.T, db *librarymanager.Database, libraryID, volumeID string) {
 	t.Helper()
-	require.NoError(t, db.LinkVolume(libraryID, volumeID, false))
+	require.NoError(t, db.LinkVolume(libraryID, volumeID, false, podinfo.PodInfo{}))
 }
 
 func TestDatabase(t *testing.T) {
@@ -52,13 +54,12 @@ func TestDatabase(t *testing.T) {
 	require.Equal(t, 0, volumeCount(t, db, libraryID), "there should be no volumes linked")
 
 	// Ensure that an unlink does not produce an error if it has not been linked.
-	unlinked, pkg, err := db.UnlinkVolume(volumeID)
+	unlinked, err := db.UnlinkVolume(volumeID)
 	require.NoError(t, err)
 	require.Empty(t, unlinked, "unlinking an unknown volume reports no library")
-	require.Empty(t, pkg, "unlinking an unknown volume reports no package")
 
 	// Ensure a linked volume is linked.
-	err = db.LinkVolume(libraryID, volumeID, false)
+	err = db.LinkVolume(libraryID, volumeID, false, podinfo.PodInfo{})
 	require.NoError(t, err)
 	lib, err = db.GetLibraryForVolume(volumeID)
 	require.NoError(t, err)
@@ -66,7 +67,7 @@ func TestDatabase(t *testing.T) {
 	require.Equal(t, 1, volumeCount(t, db, libraryID), "there should be one volume linked")
 
 	// Ensure a second call to link the same volume does nothing.
-	err = db.LinkVolume(libraryID, volumeID, false)
+	err = db.LinkVolume(libraryID, volumeID, false, podinfo.PodInfo{})
 	require.NoError(t, err)
 	lib, err = db.GetLibraryForVolume(volumeID)
 	require.NoError(t, err)
@@ -75,7 +76,7 @@ func TestDatabase(t *testing.T) {
 
 	// Ensure a second linked volume shows both.
 	secondVolumeID := "test-volume-id-two"
-	err = db.LinkVolume(libraryID, secondVolumeID, false)
+	err = db.LinkVolume(libraryID, secondVolumeID, false, podinfo.PodInfo{})
 	require.NoError(t, err)
 	lib, err = db.GetLibraryForVolume(volumeID)
 	require.NoError(t, err)
@@ -83,18 +84,18 @@ func TestDatabase(t *testing.T) {
 	require.Equal(t, 2, volumeCount(t, db, libraryID), "there should be two volumes linked")
 
 	// Ensure an unlinked volume only has one volume linked.
-	unlinked, _, err = db.UnlinkVolume(secondVolumeID)
+	unlinked, err = db.UnlinkVolume(secondVolumeID)
 	require.NoError(t, err)
-	require.Equal(t, libraryID, unlinked, "unlink reports the library the volume used")
+	require.Equal(t, []librarymanager.LinkedLibrary{{LibraryID: libraryID}}, unlinked, "unlink reports the library the volume used")
 	lib, err = db.GetLibraryForVolume(volumeID)
 	require.NoError(t, err)
 	require.Equal(t, libraryID, lib, "there should be a library linked")
 	require.Equal(t, 1, volumeCount(t, db, libraryID), "there should be one volume linked")
 
 	// Ensure all unlinks completely zeros out the count.
-	unlinked, _, err = db.UnlinkVolume(volumeID)
+	unlinked, err = db.UnlinkVolume(volumeID)
 	require.NoError(t, err)
-	require.Equal(t, libraryID, unlinked)
+	require.Equal(t, []librarymanager.LinkedLibrary{{LibraryID: libraryID}}, unlinked)
 	require.Equal(t, 0, volumeCount(t, db, libraryID), "there should be no volumes linked")
 	lib, err = db.GetLibraryForVolume(volumeID)
 	require.NoError(t, err)
@@ -117,8 +118,8 @@ func TestDatabaseLibraryMetadata(t *testing.T) {
 	require.Equal(t, int64(0), info.SizeBytes)
 
 	// Two versions of the same package aggregate together in the snapshot.
-	require.NoError(t, db.AddLibrary("lib-id-1", "dd-lib-java-init", 100))
-	require.NoError(t, db.AddLibrary("lib-id-2", "dd-lib-java-init", 200))
+	require.NoError(t, db.AddLibrary("lib-id-1", "dd-lib-java-init", 100, ""))
+	require.NoError(t, db.AddLibrary("lib-id-2", "dd-lib-java-init", 200, ""))
 	snap, err := db.Snapshot()
 	require.NoError(t, err)
 	require.Equal(t, 2, snap.CachedCountByLibrary["dd-lib-java-init"])
@@ -132,14 +133,14 @@ func TestDatabaseLibraryMetadata(t *testing.T) {
 
 	// AddLibrary on the same library ID is idempotent: it overwrites the
 	// previous record without double-counting.
-	require.NoError(t, db.AddLibrary("lib-id-1", "dd-lib-java-init", 150))
+	require.NoError(t, db.AddLibrary("lib-id-1", "dd-lib-java-init", 150, ""))
 	snap, err = db.Snapshot()
 	require.NoError(t, err)
 	require.Equal(t, 2, snap.CachedCountByLibrary["dd-lib-java-init"])
 	require.Equal(t, int64(350), snap.CachedBytesByLibrary["dd-lib-java-init"])
 
 	// A different package is tracked independently.
-	require.NoError(t, db.AddLibrary("php-id", "dd-lib-php-init", 42))
+	require.NoError(t, db.AddLibrary("php-id", "dd-lib-php-init", 42, ""))
 	snap, err = db.Snapshot()
 	require.NoError(t, err)
 	require.Equal(t, 2, snap.CachedCountByLibrary["dd-lib-java-init"])
@@ -171,7 +172,7 @@ func TestDatabaseMetadataPersistsAcrossRestart(t *testing.T) {
 
 	db, err := librarymanager.NewDatabase(tsd.Path(t))
 	require.NoError(t, err)
-	require.NoError(t, db.AddLibrary("lib-id-1", "dd-lib-java-init", 1024))
+	require.NoError(t, db.AddLibrary("lib-id-1", "dd-lib-java-init", 1024, "/opt/java"))
 	require.NoError(t, db.Close())
 
 	// Reopen and verify the aggregates were rebuilt from the persisted state.
@@ -183,6 +184,10 @@ func TestDatabaseMetadataPersistsAcrossRestart(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 1, snap.CachedCountByLibrary["dd-lib-java-init"])
 	require.Equal(t, int64(1024), snap.CachedBytesByLibrary["dd-lib-java-init"])
+	info, found, err := db2.GetLibrary("lib-id-1")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "/opt/java", info.SourcePath)
 }
 
 func TestDatabaseValidatesBlankInputsForMetadata(t *testing.T) {
@@ -193,12 +198,12 @@ func TestDatabaseValidatesBlankInputsForMetadata(t *testing.T) {
 	require.NoError(t, err)
 	defer db.Close()
 
-	require.Error(t, db.AddLibrary("", "pkg", 0))
-	require.Error(t, db.AddLibrary("lib", "", 0))
+	require.Error(t, db.AddLibrary("", "pkg", 0, ""))
+	require.Error(t, db.AddLibrary("lib", "", 0, ""))
 	require.Error(t, db.RemoveLibrary(""))
 	_, _, err = db.GetLibrary("")
 	require.Error(t, err)
-	_, _, err = db.UnlinkVolume("")
+	_, err = db.UnlinkVolume("")
 	require.Error(t, err)
 	_, err = db.GetLibraryForVolume("")
 	require.Error(t, err)
@@ -225,8 +230,8 @@ func TestDatabaseVolumeLinksAggregateByPackage(t *testing.T) {
 	require.Equal(t, 0, snap.VolumeLinksByLibrary["dd-lib-java-init"])
 
 	// Once metadata is recorded, the aggregate reflects the links.
-	require.NoError(t, db.AddLibrary("lib-id-1", "dd-lib-java-init", 100))
-	require.NoError(t, db.AddLibrary("lib-id-2", "dd-lib-java-init", 200))
+	require.NoError(t, db.AddLibrary("lib-id-1", "dd-lib-java-init", 100, ""))
+	require.NoError(t, db.AddLibrary("lib-id-2", "dd-lib-java-init", 200, ""))
 	link(t, db, "lib-id-2", "vol-3")
 	snap, err = db.Snapshot()
 	require.NoError(t, err)
@@ -240,17 +245,17 @@ func TestDatabaseVolumeLinksAggregateByPackage(t *testing.T) {
 
 	// Unlinks decrement and eventually drop the package entry. The unlink
 	// also reports the package the volume used.
-	_, unlinkedPkg, err := db.UnlinkVolume("vol-1")
+	unlinked, err := db.UnlinkVolume("vol-1")
 	require.NoError(t, err)
-	require.Equal(t, "dd-lib-java-init", unlinkedPkg)
-	_, _, err = db.UnlinkVolume("vol-3")
+	require.Equal(t, []librarymanager.LinkedLibrary{{LibraryID: "lib-id-1", Package: "dd-lib-java-init"}}, unlinked)
+	_, err = db.UnlinkVolume("vol-3")
 	require.NoError(t, err)
 	snap, err = db.Snapshot()
 	require.NoError(t, err)
 	require.Equal(t, 0, snap.VolumeLinksByLibrary["dd-lib-java-init"])
 
 	// Unlinking an unknown volume is a no-op for the aggregate.
-	_, _, err = db.UnlinkVolume("never-existed")
+	_, err = db.UnlinkVolume("never-existed")
 	require.NoError(t, err)
 	snap, err = db.Snapshot()
 	require.NoError(t, err)
@@ -263,7 +268,7 @@ func TestDatabaseVolumeLinksReloadedFromDisk(t *testing.T) {
 
 	db, err := librarymanager.NewDatabase(tsd.Path(t))
 	require.NoError(t, err)
-	require.NoError(t, db.AddLibrary("lib-id-1", "dd-lib-java-init", 100))
+	require.NoError(t, db.AddLibrary("lib-id-1", "dd-lib-java-init", 100, ""))
 	link(t, db, "lib-id-1", "vol-1")
 	link(t, db, "lib-id-1", "vol-2")
 	require.NoError(t, db.Close())
@@ -426,3 +431,222 @@ func TestDatabaseMigratesLegacySchema(t *testing.T) {
 		return nil
 	}))
 }
+
+func TestDatabasePing(t *testing.T) {
+	tsd := testutil.NewTempScratchDirectory(t)
+	defer tsd.Cleanup(t)
+
+	db, err := librarymanager.NewDatabase(tsd.Path(t))
+	require.NoError(t, err)
+
+	require.NoError(t, db.Ping())
+
+	require.NoError(t, db.Close())
+	require.Error(t, db.Ping(), "a closed database must fail the self-check")
+}
+
+func TestDatabaseListAndGetVolumes(t *testing.T) {
+	tsd := testutil.NewTempScratchDirectory(t)
+	defer tsd.Cleanup(t)
+
+	db, err := librarymanager.NewDatabase(tsd.Path(t))
+	require.NoError(t, err)
+	defer db.Close()
+
+	volumes, err := db.ListVolumes()
+	require.NoError(t, err)
+	require.Empty(t, volumes)
+
+	link(t, db, "lib-a", "vol-1")
+	pod := podinfo.PodInfo{Name: "web-0", Namespace: "shop", UID: "uid-1"}
+	require.NoError(t, db.LinkVolume("lib-b", "vol-2", false, pod))
+
+	volumes, err = db.ListVolumes()
+	require.NoError(t, err)
+	require.Len(t, volumes, 2)
+	require.Equal(t, "lib-a", volumes["vol-1"].LibraryID)
+	require.Equal(t, "lib-b", volumes["vol-2"].LibraryID)
+	require.False(t, volumes["vol-1"].CreatedAt.IsZero())
+	require.True(t, volumes["vol-1"].Pod.IsZero())
+	require.Equal(t, pod, volumes["vol-2"].Pod)
+
+	info, found, err := db.GetVolume("vol-1")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, volumes["vol-1"].LibraryID, info.LibraryID)
+	require.True(t, volumes["vol-1"].CreatedAt.Equal(info.CreatedAt))
+
+	_, found, err = db.GetVolume("unknown")
+	require.NoError(t, err)
+	require.False(t, found)
+}
+
+func TestDatabaseVolumeLibraries(t *testing.T) {
+	tsd := testutil.NewTempScratchDirectory(t)
+	defer tsd.Cleanup(t)
+
+	db, err := librarymanager.NewDatabase(tsd.Path(t))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.Error(t, db.LinkVolumeLibraries(nil, "vol-1", false, podinfo.PodInfo{}))
+	require.Error(t, db.LinkVolumeLibraries([]string{"lib-a", ""}, "vol-1", false, podinfo.PodInfo{}))
+	require.Error(t, db.LinkVolumeLibraries([]string{"lib-a", "lib-a"}, "vol-1", false, podinfo.PodInfo{}))
+
+	require.NoError(t, db.AddLibrary("lib-a", "apm-inject", 100, ""))
+	require.NoError(t, db.AddLibrary("lib-b", "dd-lib-java-init", 200, ""))
+	link(t, db, "lib-a", "vol-1")
+	require.NoError(t, db.LinkVolumeLibraries([]string{"lib-b", "lib-a"}, "vol-2", false, podinfo.PodInfo{}))
+	require.Equal(t, 2, volumeCount(t, db, "lib-a"))
+	require.Equal(t, 1, volumeCount(t, db, "lib-b"))
+
+	// Linking the volume again is a no-op.
+	require.NoError(t, db.LinkVolumeLibraries([]string{"lib-b", "lib-a"}, "vol-2", false, podinfo.PodInfo{}))
+	require.Equal(t, 2, volumeCount(t, db, "lib-a"))
+
+	info, found, err := db.GetVolume("vol-2")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Empty(t, info.LibraryID)
+	require.Equal(t, []string{"lib-b", "lib-a"}, info.LibraryIDs)
+	info, _, err = db.GetVolume("vol-1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"lib-a"}, info.LibraryIDs)
+
+	// Unlinking the volume releases every library at once.
+	unlinked, err := db.UnlinkVolume("vol-2")
+	require.NoError(t, err)
+	require.Equal(t, []librarymanager.LinkedLibrary{
+		{LibraryID: "lib-b", Package: "dd-lib-java-init"},
+		{LibraryID: "lib-a", Package: "apm-inject"},
+	}, unlinked)
+	require.Equal(t, 1, volumeCount(t, db, "lib-a"))
+	require.Equal(t, 0, volumeCount(t, db, "lib-b"))
+}
+
+func TestDatabasePublications(t *testing.T) {
+	tsd := testutil.NewTempScratchDirectory(t)
+	defer tsd.Cleanup(t)
+
+	db, err := librarymanager.NewDatabase(tsd.Path(t))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, found, err := db.GetPublication("vol-1")
+	require.NoError(t, err)
+	require.False(t, found)
+
+	publishedAt := time.Now().UTC().Truncate(time.Second)
+	publication := librarymanager.Publication{
+		VolumeType:  "APMSocket",
+		HostPath:    "/var/run/datadog/apm.socket",
+		TargetPath:  "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/apm/mount",
+		PublishedAt: publishedAt,
+		Pod:         podinfo.PodInfo{Name: "web-0", Namespace: "shop", UID: "uid-1"},
+	}
+	require.NoError(t, db.PutPublication("vol-1", publication))
+
+	got, found, err := db.GetPublication("vol-1")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, publication.VolumeType, got.VolumeType)
+	require.Equal(t, publication.HostPath, got.HostPath)
+	require.Equal(t, publication.TargetPath, got.TargetPath)
+	require.True(t, publishedAt.Equal(got.PublishedAt))
+	require.Equal(t, publication.Pod, got.Pod)
+
+	all, err := db.ListPublications()
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	require.Contains(t, all, "vol-1")
+
+	require.NoError(t, db.RemovePublication("vol-1"))
+	require.NoError(t, db.RemovePublication("vol-1"), "removing a missing record is a no-op")
+	_, found, err = db.GetPublication("vol-1")
+	require.NoError(t, err)
+	require.False(t, found)
+
+	require.Error(t, db.PutPublication("", publication))
+	require.Error(t, db.PutPublication("vol-2", librarymanager.Publication{}))
+}
+
+func TestDatabaseListLibrariesTracksLastUse(t *testing.T) {
+	tsd := testutil.NewTempScratchDirectory(t)
+	defer tsd.Cleanup(t)
+
+	db, err := librarymanager.NewDatabase(tsd.Path(t))
+	require.NoError(t, err)
+	defer db.Close()
+
+	libraries, err := db.ListLibraries()
+	require.NoError(t, err)
+	require.Empty(t, libraries)
+
+	require.NoError(t, db.AddLibrary("lib-a", "dd-lib-java-init", 100, ""))
+	require.NoError(t, db.AddLibrary("lib-b", "dd-lib-python-init", 200, "/opt/python"))
+	libraries, err = db.ListLibraries()
+	require.NoError(t, err)
+	require.Len(t, libraries, 2)
+	require.Equal(t, int64(200), libraries["lib-b"].SizeBytes)
+	require.Equal(t, "/opt/python", libraries["lib-b"].SourcePath)
+	added := libraries["lib-a"].LastUsedAt
+	require.False(t, added.IsZero())
+
+	// Linking and unlinking a volume both mark the library as used
+	time.Sleep(time.Millisecond)
+	link(t, db, "lib-a", "vol-1")
+	linked, _, err := db.GetLibrary("lib-a")
+	require.NoError(t, err)
+	require.True(t, linked.LastUsedAt.After(added))
+	require.Equal(t, 1, linked.VolumeCount)
+
+	time.Sleep(time.Millisecond)
+	_, err = db.UnlinkVolume("vol-1")
+	require.NoError(t, err)
+	unlinked, _, err := db.GetLibrary("lib-a")
+	require.NoError(t, err)
+	require.True(t, unlinked.LastUsedAt.After(linked.LastUsedAt))
+	require.Equal(t, 0, unlinked.VolumeCount)
+
+	// The other library is untouched
+	libraries, err = db.ListLibraries()
+	require.NoError(t, err)
+	require.True(t, libraries["lib-b"].LastUsedAt.Before(unlinked.LastUsedAt))
+}
+
+func TestDatabasePinLibraries(t *testing.T) {
+	tsd := testutil.NewTempScratchDirectory(t)
+	defer tsd.Cleanup(t)
+
+	db, err := librarymanager.NewDatabase(tsd.Path(t))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.Error(t, db.PinLibrary(""))
+	require.NoError(t, db.AddLibrary("lib-a", "apm-inject", 100, ""))
+	require.NoError(t, db.AddLibrary("lib-b", "apm-inject", 100, ""))
+	require.NoError(t, db.AddLibrary("lib-c", "dd-lib-java-init", 100, ""))
+	require.NoError(t, db.PinLibrary("lib-a"))
+	require.NoError(t, db.PinLibrary("lib-a"))
+	require.NoError(t, db.PinLibrary("lib-b"))
+
+	// AddLibrary keeps the pin of an existing record
+	require.NoError(t, db.AddLibrary("lib-a", "apm-inject", 150, ""))
+	info, _, err := db.GetLibrary("lib-a")
+	require.NoError(t, err)
+	require.True(t, info.Pinned)
+	require.Equal(t, int64(150), info.SizeBytes)
+
+	unpinned, err := db.UnpinLibrariesExcept([]string{"lib-a"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"lib-b"}, unpinned)
+	libraries, err := db.ListLibraries()
+	require.NoError(t, err)
+	require.True(t, libraries["lib-a"].Pinned)
+	require.False(t, libraries["lib-b"].Pinned)
+	require.False(t, libraries["lib-c"].Pinned)
+
+	unpinned, err = db.UnpinLibrariesExcept(nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"lib-a"}, unpinned)
+}
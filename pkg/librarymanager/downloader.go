@@ -21,6 +21,10 @@ import (
 const (
 	// userAgent is used during the crane HTTP operations to identify the Datadog CSI Driver.
 	userAgent = "datadog-csi-driver"
+
+	// SourcePathLabel is the image label, or manifest annotation, giving the
+	// directory of the image that library volumes mount.
+	SourcePathLabel = "com.datadoghq.csi.source-path"
 )
 
 // Downloader enables downloading and extracting directories from container images.
@@ -54,9 +58,18 @@ func newDownloader(roundTripper http.RoundTripper, keychain authn.Keychain) *Dow
 	}
 }
 
+// DownloadResult describes a downloaded image.
+type DownloadResult struct {
+	// SizeBytes is the cumulative size of the regular files written.
+	SizeBytes int64
+	// SourcePath is the directory to mount given by the SourcePathLabel of
+	// the image. It is empty when the image does not set it.
+	SourcePath string
+}
+
 // Download will stream a container image and extract the source directory from inside of the image to the destination
-// directory on disk. Returns the cumulative size of the regular files written.
-func (d *Downloader) Download(ctx context.Context, image string, dst string) (int64, error) {
+// directory on disk.
+func (d *Downloader) Download(ctx context.Context, image string, dst string) (DownloadResult, error) {
 	img, err := crane.Pull(image,
 		crane.WithContext(ctx),
 		crane.WithAuthFromKeychain(d.keychain),
@@ -65,25 +78,48 @@ func (d *Downloader) Download(ctx context.Context, image string, dst string) (in
 		crane.WithPlatform(&v1.Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}),
 	)
 	if err != nil {
-		return 0, fmt.Errorf("could not pull %s: %w", image, err)
+		return DownloadResult{}, fmt.Errorf("could not pull %s: %w", image, err)
+	}
+	sourcePath, err := imageSourcePath(img)
+	if err != nil {
+		return DownloadResult{}, fmt.Errorf("could not read metadata of %s: %w", image, err)
 	}
 
 	pr, pw := io.Pipe()
 	go func() {
 		pw.CloseWithError(crane.Export(img, pw))
 	}()
+	// Unblock the export when the extraction stops early, e.g. on cancellation.
+	defer func() { _ = pr.Close() }()
 
 	// Extract the entire image content
 	fp, err := NewArchiveExtractor("/", dst)
 	if err != nil {
-		return 0, fmt.Errorf("could not setup archive extractor: %w", err)
+		return DownloadResult{}, fmt.Errorf("could not setup archive extractor: %w", err)
 	}
 	bytes, err := fp.Extract(ctx, pr)
 	if err != nil {
-		return 0, fmt.Errorf("could not extract archive: %w", err)
+		return DownloadResult{}, fmt.Errorf("could not extract archive: %w", err)
 	}
 
-	return bytes, nil
+	return DownloadResult{SizeBytes: bytes, SourcePath: sourcePath}, nil
+}
+
+// imageSourcePath returns the SourcePathLabel of an image, read from its
+// config labels first and then from its manifest annotations.
+func imageSourcePath(img v1.Image) (string, error) {
+	config, err := img.ConfigFile()
+	if err != nil {
+		return "", err
+	}
+	if sourcePath := config.Config.Labels[SourcePathLabel]; sourcePath != "" {
+		return sourcePath, nil
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return "", err
+	}
+	return manifest.Annotations[SourcePathLabel], nil
 }
 
 // FetchDigest will fetch a sha256 sum of the image and return it.
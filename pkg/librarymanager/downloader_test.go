@@ -15,6 +15,9 @@ import (
 	"github.com/Datadog/datadog-csi-driver/pkg/librarymanager"
 	"github.com/Datadog/datadog-csi-driver/pkg/registryauth"
 	"github.com/Datadog/datadog-csi-driver/pkg/testutil"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/stretchr/testify/require"
 )
 
@@ -94,3 +97,42 @@ func TestDownloadWithDriverScopedAuthentication(t *testing.T) {
 	_, err = downloader.Download(ctx, image, scratch.Path(t))
 	require.NoError(t, err)
 }
+
+func TestDownloadRecordsSourcePathLabel(t *testing.T) {
+	localRegistry := testutil.NewLocalRegistry(t)
+	defer localRegistry.Stop()
+	plain := localRegistry.AddImage(t, "testdata/image.tar", "plain", "latest")
+	labelled := localRegistry.AddImage(t, labelImage(t, "testdata/image.tar", "/other"), "labelled", "latest")
+
+	d := librarymanager.NewDownloaderWithRoundTripper(localRegistry.GetRoundTripper(t))
+	ctx := context.Background()
+
+	result, err := d.Download(ctx, labelled, t.TempDir())
+	require.NoError(t, err)
+	require.Equal(t, "/other", result.SourcePath)
+	require.Positive(t, result.SizeBytes)
+
+	result, err = d.Download(ctx, plain, t.TempDir())
+	require.NoError(t, err)
+	require.Empty(t, result.SourcePath)
+}
+
+// labelImage copies an image tarball, setting the source path label of the image.
+func labelImage(t *testing.T, imageTar, sourcePath string) string {
+	t.Helper()
+
+	img, err := tarball.ImageFromPath(imageTar, nil)
+	require.NoError(t, err)
+	config, err := img.ConfigFile()
+	require.NoError(t, err)
+	config = config.DeepCopy()
+	config.Config.Labels = map[string]string{librarymanager.SourcePathLabel: sourcePath}
+	img, err = mutate.ConfigFile(img, config)
+	require.NoError(t, err)
+
+	ref, err := name.NewTag("labelled-image:latest")
+	require.NoError(t, err)
+	labelledTar := filepath.Join(t.TempDir(), "image.tar")
+	require.NoError(t, tarball.WriteToFile(labelledTar, ref, img))
+	return labelledTar
+}
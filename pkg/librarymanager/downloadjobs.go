@@ -0,0 +1,170 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package librarymanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"sync"
+	"time"
+)
+
+// DownloadInProgressError is returned when the library of a volume is still being downloaded in the background.
+type DownloadInProgressError struct {
+	// Image is the image of the library being downloaded.
+	Image string
+	// Elapsed is the time since the download started.
+	Elapsed time.Duration
+}
+
+func (e *DownloadInProgressError) Error() string {
+	return fmt.Sprintf("library %s is still downloading (started %s ago), retry later", e.Image, e.Elapsed.Round(time.Second))
+}
+
+// downloadJob is a library download running in the background. err is only
+// set once done is closed.
+type downloadJob struct {
+	image   string
+	started time.Time
+	done    chan struct{}
+	err     error
+}
+
+// downloadJobs runs library downloads in the background, at most one per
+// library digest. The downloads are not bound to the request that started
+// them, only to the lifetime of the library manager.
+type downloadJobs struct {
+	wait   time.Duration
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	jobs    map[string]*downloadJob
+	stopped bool
+}
+
+func newDownloadJobs(wait time.Duration) *downloadJobs {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &downloadJobs{
+		wait:   wait,
+		ctx:    ctx,
+		cancel: cancel,
+		jobs:   map[string]*downloadJob{},
+	}
+}
+
+// start returns the running download of a library, or starts it with the
+// given function. A finished job is forgotten, so that a failed download is
+// started again on the next call.
+func (d *downloadJobs) start(libraryID, image string, download func(ctx context.Context) error) *downloadJob {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if job, ok := d.jobs[libraryID]; ok {
+		return job
+	}
+	job := &downloadJob{image: image, started: time.Now(), done: make(chan struct{})}
+	if d.stopped {
+		job.err = fmt.Errorf("library manager is stopped")
+		close(job.done)
+		return job
+	}
+
+	d.jobs[libraryID] = job
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		job.err = download(d.ctx)
+
+		d.mu.Lock()
+		delete(d.jobs, libraryID)
+		d.mu.Unlock()
+		close(job.done)
+	}()
+	return job
+}
+
+// await waits for a job for at most the configured wait, or until the context
+// is done. It returns a DownloadInProgressError if the job is still running.
+func (d *downloadJobs) await(ctx context.Context, job *downloadJob) error {
+	timer := time.NewTimer(d.wait)
+	defer timer.Stop()
+
+	select {
+	case <-job.done:
+		return job.err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return &DownloadInProgressError{Image: job.image, Elapsed: time.Since(job.started)}
+	}
+}
+
+// stop cancels the running downloads and waits for them to return.
+func (d *downloadJobs) stop() {
+	d.mu.Lock()
+	d.stopped = true
+	d.mu.Unlock()
+
+	d.cancel()
+	d.wg.Wait()
+}
+
+// awaitDownload makes sure a library is in the store, downloading it in the
+// background if it is not. It returns true if the library was downloaded
+// while waiting, and a DownloadInProgressError if the download is still
+// running once the wait is over. The caller must not hold the library lock,
+// which the download takes to add the library to the store.
+func (lm *LibraryManager) awaitDownload(ctx context.Context, libraryID string, lib *Library) (bool, error) {
+	// Cache hits must not start a job. The caller checks the store again
+	// under the library lock, so a library removed in between is downloaded
+	// synchronously.
+	if path, err := lm.store.Get(libraryID); err == nil && path != "" {
+		return false, nil
+	}
+
+	job := lm.downloads.start(libraryID, lib.Image(), func(ctx context.Context) error {
+		downloaded, err := lm.downloadIfMissing(ctx, libraryID, lib)
+		if err != nil {
+			log.Error("Background library download failed", "image", lib.Image(), "error", err)
+			return err
+		}
+		if downloaded {
+			// No volume is linked yet: hand the library to the cleanup strategy
+			// so it is not kept forever if no retry comes to link it.
+			lm.cleanupStrategy.ScheduleCleanup(libraryID, lm.tryCleanupLibrary)
+		}
+		return nil
+	})
+	if err := lm.downloads.await(ctx, job); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// downloadIfMissing downloads a library into the store under the library lock,
+// unless it is already there.
+func (lm *LibraryManager) downloadIfMissing(ctx context.Context, libraryID string, lib *Library) (bool, error) {
+	if err := lm.locker.LockContext(ctx, libraryID); err != nil {
+		return false, err
+	}
+	defer lm.locker.Unlock(libraryID)
+
+	path, err := lm.store.Get(libraryID)
+	if err != nil && !errors.Is(err, ErrItemNotFound) {
+		return false, err
+	}
+	if path != "" {
+		return false, nil
+	}
+	if _, err := lm.downloadLibrary(ctx, libraryID, lib); err != nil {
+		return false, err
+	}
+	return true, nil
+}
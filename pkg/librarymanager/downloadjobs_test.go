@@ -0,0 +1,197 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package librarymanager_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/libraryevents"
+	"github.com/Datadog/datadog-csi-driver/pkg/librarymanager"
+	"github.com/Datadog/datadog-csi-driver/pkg/testutil"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+// gatedRoundTripper holds image layer requests until its gate is opened, like a slow registry.
+type gatedRoundTripper struct {
+	next http.RoundTripper
+	gate chan struct{}
+}
+
+func (g gatedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.Contains(req.URL.Path, "/blobs/") {
+		select {
+		case <-g.gate:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	return g.next.RoundTrip(req)
+}
+
+func TestLibraryManagerBackgroundDownloads(t *testing.T) {
+	localRegistry := testutil.NewLocalRegistry(t)
+	defer localRegistry.Stop()
+	localRegistry.AddImage(t, "testdata/image.tar", "test-image", "latest")
+
+	gate := make(chan struct{})
+	rec := &recordingListener{}
+	lm, err := librarymanager.NewLibraryManager(t.TempDir(),
+		librarymanager.WithFilesystem(afero.Afero{Fs: afero.NewOsFs()}),
+		librarymanager.WithDownloader(librarymanager.NewDownloaderWithRoundTripper(gatedRoundTripper{next: localRegistry.GetRoundTripper(t), gate: gate})),
+		librarymanager.WithEventListener(rec),
+		librarymanager.WithCleanupStrategy(librarymanager.NewDelayedCleanupStrategy(time.Hour)),
+		librarymanager.WithBackgroundDownloads(0),
+	)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, lm.Stop()) }()
+
+	lib, err := librarymanager.NewLibrary("test-image", localRegistry.Registry(t), "latest", false)
+	require.NoError(t, err)
+	ctx := context.Background()
+	rec.drain()
+
+	// The registry is slow: the publish returns right away while the download goes on.
+	_, err = lm.GetLibraryForVolume(ctx, "vol-1", lib)
+	var inProgress *librarymanager.DownloadInProgressError
+	require.ErrorAs(t, err, &inProgress)
+	require.Equal(t, lib.Image(), inProgress.Image)
+	require.Equal(t, libraryevents.ResolutionPending, singleEvent(t, rec.drain(), "resolved").result)
+	hasVolume, err := lm.HasVolume("vol-1")
+	require.NoError(t, err)
+	require.False(t, hasVolume, "a pending volume must not be linked")
+
+	// Retries join the running download instead of starting another one.
+	_, err = lm.GetLibraryForVolume(ctx, "vol-1", lib)
+	require.ErrorAs(t, err, &inProgress)
+
+	close(gate)
+	require.Eventually(t, func() bool {
+		return len(eventsOfKind(rec.drain(), "cached")) > 0
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// The download is done: the retry is served from the store.
+	path, err := lm.GetLibraryForVolume(ctx, "vol-1", lib)
+	require.NoError(t, err)
+	require.NotEmpty(t, path)
+	events := rec.drain()
+	require.Equal(t, libraryevents.ResolutionCacheHit, singleEvent(t, events, "resolved").result)
+	require.Empty(t, eventsOfKind(events, "download"))
+	require.Equal(t, 1, singleEvent(t, events, "linked").links)
+}
+
+// failingRoundTripper fails image layer requests while fail is set, like a flaky registry.
+type failingRoundTripper struct {
+	next http.RoundTripper
+	fail *atomic.Bool
+}
+
+func (f failingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if f.fail.Load() && strings.Contains(req.URL.Path, "/blobs/") {
+		return nil, errors.New("connection reset by peer")
+	}
+	return f.next.RoundTrip(req)
+}
+
+func TestLibraryManagerBackgroundDownloadWithinWait(t *testing.T) {
+	localRegistry := testutil.NewLocalRegistry(t)
+	defer localRegistry.Stop()
+	localRegistry.AddImage(t, "testdata/image.tar", "test-image", "latest")
+
+	fail := &atomic.Bool{}
+	fail.Store(true)
+	rec := &recordingListener{}
+	lm, err := librarymanager.NewLibraryManager(t.TempDir(),
+		librarymanager.WithFilesystem(afero.Afero{Fs: afero.NewOsFs()}),
+		librarymanager.WithDownloader(librarymanager.NewDownloaderWithRoundTripper(failingRoundTripper{next: localRegistry.GetRoundTripper(t), fail: fail})),
+		librarymanager.WithEventListener(rec),
+		librarymanager.WithCleanupStrategy(librarymanager.NewDelayedCleanupStrategy(time.Hour)),
+		librarymanager.WithBackgroundDownloads(10*time.Second),
+	)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, lm.Stop()) }()
+
+	lib, err := librarymanager.NewLibrary("test-image", localRegistry.Registry(t), "latest", false)
+	require.NoError(t, err)
+	ctx := context.Background()
+	rec.drain()
+
+	// A download that fails within the wait is reported to the caller.
+	_, err = lm.GetLibraryForVolume(ctx, "vol-1", lib)
+	require.Error(t, err)
+	var inProgress *librarymanager.DownloadInProgressError
+	require.False(t, errors.As(err, &inProgress))
+	require.Equal(t, libraryevents.ResolutionFailed, singleEvent(t, rec.drain(), "resolved").result)
+
+	// The failed download is forgotten, so the retry starts a new one, which
+	// ends within the wait and links the volume right away.
+	fail.Store(false)
+	path, err := lm.GetLibraryForVolume(ctx, "vol-1", lib)
+	require.NoError(t, err)
+	require.NotEmpty(t, path)
+	events := rec.drain()
+	require.Equal(t, libraryevents.ResolutionDownloaded, singleEvent(t, events, "resolved").result)
+	require.Equal(t, 1, singleEvent(t, events, "linked").links)
+}
+
+func TestLibraryManagerBackgroundDownloadOverStoreBudget(t *testing.T) {
+	localRegistry := testutil.NewLocalRegistry(t)
+	defer localRegistry.Stop()
+	localRegistry.AddImage(t, "testdata/image.tar", "test-image", "latest")
+
+	// The store budget only fits the library already linked to a running pod.
+	basePath := t.TempDir()
+	dbDir := filepath.Join(basePath, librarymanager.DatabaseDirectory)
+	require.NoError(t, os.MkdirAll(dbDir, 0o755))
+	db, err := librarymanager.NewDatabase(dbDir)
+	require.NoError(t, err)
+	require.NoError(t, db.AddLibrary("linked-library-id", "dd-lib-java-init", 1000, ""))
+	link(t, db, "linked-library-id", "running-volume")
+	require.NoError(t, db.Close())
+
+	gate := make(chan struct{})
+	rec := &recordingListener{}
+	lm, err := librarymanager.NewLibraryManager(basePath,
+		librarymanager.WithFilesystem(afero.Afero{Fs: afero.NewOsFs()}),
+		librarymanager.WithDownloader(librarymanager.NewDownloaderWithRoundTripper(gatedRoundTripper{next: localRegistry.GetRoundTripper(t), gate: gate})),
+		librarymanager.WithEventListener(rec),
+		librarymanager.WithCleanupStrategy(librarymanager.NewLRUCleanupStrategy(1000, time.Hour)),
+		librarymanager.WithBackgroundDownloads(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, lm.Stop()) }()
+
+	lib, err := librarymanager.NewLibrary("test-image", localRegistry.Registry(t), "latest", false)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = lm.GetLibraryForVolume(ctx, "vol-1", lib)
+	var inProgress *librarymanager.DownloadInProgressError
+	require.ErrorAs(t, err, &inProgress)
+	close(gate)
+	var events []recordedEvent
+	require.Eventually(t, func() bool {
+		events = append(events, rec.drain()...)
+		return len(eventsOfKind(events, "cached")) > 0
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// The new library is over the budget, but it is kept for the retry.
+	path, err := lm.GetLibraryForVolume(ctx, "vol-1", lib)
+	require.NoError(t, err)
+	require.NotEmpty(t, path)
+	events = append(events, rec.drain()...)
+	require.Empty(t, eventsOfKind(events, "evicted"))
+	require.Len(t, eventsOfKind(events, "download"), 1)
+	require.Equal(t, libraryevents.ResolutionCacheHit, eventsOfKind(events, "resolved")[1].result)
+}
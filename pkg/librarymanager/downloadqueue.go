@@ -0,0 +1,184 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package librarymanager
+
+import (
+	"context"
+	log "log/slog"
+	"sync"
+	"time"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/libraryevents"
+)
+
+// DownloadQueueConfig configures the node-wide limit on concurrent library downloads.
+type DownloadQueueConfig struct {
+	// MaxConcurrent is the maximum number of libraries downloaded and extracted at once. The limit is disabled when it
+	// is zero or less.
+	MaxConcurrent int
+	// PrioritizeDemand hands a free slot to the queued library needed by the most volumes instead of the oldest one.
+	// A volume counts while it retries its publish, even after its own request returned. Ties are broken in FIFO
+	// order.
+	PrioritizeDemand bool
+}
+
+// demandDecay is how long a volume counts in the demand for a library after
+// its last publish attempt. It outlasts the longest kubelet retry backoff, so
+// that a volume retrying while the library is queued keeps counting.
+const demandDecay = 3 * time.Minute
+
+// queuedDownload is a download waiting for a free slot. granted is closed
+// once the slot is handed over.
+type queuedDownload struct {
+	libraryID string
+	queuedAt  time.Time
+	granted   chan struct{}
+}
+
+// downloadQueue limits the number of concurrent library downloads. Downloads
+// beyond the limit wait in a queue until a running one releases its slot.
+// A nil queue does not limit anything.
+type downloadQueue struct {
+	config   DownloadQueueConfig
+	listener libraryevents.Listener
+
+	mu      sync.Mutex
+	running int
+	waiting []*queuedDownload
+	// demand holds the last publish attempt of each volume waiting for a
+	// library, keyed by library ID then volume ID. It is kept while the
+	// download is queued in the background, beyond the request that queued it.
+	demand map[string]map[string]time.Time
+	now    func() time.Time
+}
+
+func newDownloadQueue(config DownloadQueueConfig, listener libraryevents.Listener) *downloadQueue {
+	if config.MaxConcurrent <= 0 {
+		return nil
+	}
+	return &downloadQueue{
+		config:   config,
+		listener: listener,
+		demand:   map[string]map[string]time.Time{},
+		now:      time.Now,
+	}
+}
+
+// want records that a volume is waiting for a library. The volume counts in
+// the demand for the library until the download starts, or until demandDecay
+// elapses without another publish attempt of the volume.
+func (q *downloadQueue) want(libraryID, volumeID string) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	volumes, found := q.demand[libraryID]
+	if !found {
+		volumes = map[string]time.Time{}
+		q.demand[libraryID] = volumes
+	}
+	volumes[volumeID] = q.now()
+}
+
+// demandFor returns the number of volumes waiting for a library, and forgets
+// the ones that stopped retrying. The caller must hold the queue lock.
+func (q *downloadQueue) demandFor(libraryID string) int {
+	volumes := q.demand[libraryID]
+	for volumeID, wantedAt := range volumes {
+		if q.now().Sub(wantedAt) >= demandDecay {
+			delete(volumes, volumeID)
+		}
+	}
+	if len(volumes) == 0 {
+		delete(q.demand, libraryID)
+	}
+	return len(volumes)
+}
+
+// acquire waits for a free download slot, or until the context is done. The
+// caller MUST call the returned function to release the slot if and only if
+// no error is returned.
+func (q *downloadQueue) acquire(ctx context.Context, libraryID, library string) (func(), error) {
+	if q == nil {
+		return func() {}, nil
+	}
+
+	q.mu.Lock()
+	if q.running < q.config.MaxConcurrent && len(q.waiting) == 0 {
+		q.running++
+		delete(q.demand, libraryID)
+		q.mu.Unlock()
+		q.listener.OnDownloadDequeued(library, 0)
+		return q.release, nil
+	}
+	d := &queuedDownload{libraryID: libraryID, queuedAt: time.Now(), granted: make(chan struct{})}
+	q.waiting = append(q.waiting, d)
+	depth := len(q.waiting)
+	q.listener.OnDownloadQueueDepth(depth)
+	q.mu.Unlock()
+	log.Info("Library download queued", "library", library, "library_id", libraryID, "queue_depth", depth)
+
+	select {
+	case <-d.granted:
+		q.listener.OnDownloadDequeued(library, time.Since(d.queuedAt))
+		return q.release, nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		removed := q.remove(d)
+		q.mu.Unlock()
+		if !removed {
+			// The slot was granted while giving up: hand it to the next download.
+			q.release()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// release frees a download slot and hands it to the next queued download, if any.
+func (q *downloadQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.running--
+	if len(q.waiting) == 0 {
+		return
+	}
+	d := q.waiting[q.next()]
+	q.remove(d)
+	q.running++
+	delete(q.demand, d.libraryID)
+	close(d.granted)
+}
+
+// next returns the index of the queued download to start next. The caller
+// must hold the queue lock and the queue must not be empty.
+func (q *downloadQueue) next() int {
+	next := 0
+	if !q.config.PrioritizeDemand {
+		return next
+	}
+	nextDemand := q.demandFor(q.waiting[next].libraryID)
+	for i, d := range q.waiting {
+		if demand := q.demandFor(d.libraryID); demand > nextDemand {
+			next, nextDemand = i, demand
+		}
+	}
+	return next
+}
+
+// remove takes a download out of the queue and reports whether it was still
+// queued. The caller must hold the queue lock.
+func (q *downloadQueue) remove(d *queuedDownload) bool {
+	for i, queued := range q.waiting {
+		if queued == d {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			q.listener.OnDownloadQueueDepth(len(q.waiting))
+			return true
+		}
+	}
+	return false
+}
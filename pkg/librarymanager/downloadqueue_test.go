@@ -0,0 +1,201 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package librarymanager
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/libraryevents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// queueListener records the download queue events.
+type queueListener struct {
+	libraryevents.NoopListener
+
+	mu       sync.Mutex
+	depth    int
+	dequeued []string
+}
+
+func (l *queueListener) OnDownloadQueueDepth(depth int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.depth = depth
+}
+
+func (l *queueListener) OnDownloadDequeued(library string, _ time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.dequeued = append(l.dequeued, library)
+}
+
+func (l *queueListener) getDepth() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.depth
+}
+
+// queueDownloads queues a download per library, in order, and returns the
+// channel on which the libraries are sent once they get a slot.
+func queueDownloads(t *testing.T, q *downloadQueue, libraries ...string) <-chan string {
+	t.Helper()
+	started := make(chan string, len(libraries))
+	for i, library := range libraries {
+		go func() {
+			release, err := q.acquire(context.Background(), library, library)
+			if err != nil {
+				return
+			}
+			started <- library
+			release()
+		}()
+		// Wait for the download to be queued to keep the FIFO order deterministic.
+		require.Eventually(t, func() bool {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			return len(q.waiting) == i+1
+		}, time.Second, time.Millisecond)
+	}
+	return started
+}
+
+func TestNewDownloadQueue_DisabledWithoutLimit(t *testing.T) {
+	q := newDownloadQueue(DownloadQueueConfig{}, libraryevents.NoopListener{})
+	require.Nil(t, q)
+
+	release, err := q.acquire(context.Background(), "lib-a", "lib-a")
+	require.NoError(t, err)
+	release()
+	q.want("lib-a", "vol-1")
+}
+
+func TestDownloadQueue_LimitsConcurrentDownloads(t *testing.T) {
+	listener := &queueListener{}
+	q := newDownloadQueue(DownloadQueueConfig{MaxConcurrent: 2}, listener)
+
+	releaseA, err := q.acquire(context.Background(), "lib-a", "lib-a")
+	require.NoError(t, err)
+	releaseB, err := q.acquire(context.Background(), "lib-b", "lib-b")
+	require.NoError(t, err)
+
+	started := queueDownloads(t, q, "lib-c")
+	assert.Equal(t, 1, listener.getDepth())
+	select {
+	case <-started:
+		t.Fatal("a download should not start while all the slots are taken")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	releaseA()
+	assert.Equal(t, "lib-c", <-started)
+	assert.Equal(t, 0, listener.getDepth())
+	releaseB()
+	assert.Equal(t, []string{"lib-a", "lib-b", "lib-c"}, listener.dequeued)
+}
+
+func TestDownloadQueue_Order(t *testing.T) {
+	tests := map[string]struct {
+		prioritizeDemand bool
+		expected         []string
+	}{
+		"first in first out": {
+			expected: []string{"lib-a", "lib-b", "lib-c"},
+		},
+		"most wanted library first": {
+			prioritizeDemand: true,
+			expected:         []string{"lib-b", "lib-a", "lib-c"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := newDownloadQueue(DownloadQueueConfig{MaxConcurrent: 1, PrioritizeDemand: tc.prioritizeDemand}, libraryevents.NoopListener{})
+			release, err := q.acquire(context.Background(), "running", "running")
+			require.NoError(t, err)
+
+			// Retries of the same volume count once.
+			q.want("lib-a", "vol-1")
+			q.want("lib-a", "vol-1")
+			q.want("lib-a", "vol-1")
+			q.want("lib-b", "vol-2")
+			q.want("lib-b", "vol-3")
+			started := queueDownloads(t, q, "lib-a", "lib-b", "lib-c")
+			release()
+
+			var order []string
+			for range tc.expected {
+				order = append(order, <-started)
+			}
+			assert.Equal(t, tc.expected, order)
+		})
+	}
+}
+
+func TestDownloadQueue_DemandDecays(t *testing.T) {
+	now := time.Now()
+	q := newDownloadQueue(DownloadQueueConfig{MaxConcurrent: 1, PrioritizeDemand: true}, libraryevents.NoopListener{})
+	q.now = func() time.Time { return now }
+	release, err := q.acquire(context.Background(), "running", "running")
+	require.NoError(t, err)
+
+	// The volumes waiting for lib-b stopped retrying, the one waiting for lib-a still retries.
+	q.want("lib-b", "vol-2")
+	q.want("lib-b", "vol-3")
+	now = now.Add(demandDecay / 2)
+	q.want("lib-a", "vol-1")
+	now = now.Add(demandDecay / 2)
+	q.mu.Lock()
+	assert.Equal(t, 1, q.demandFor("lib-a"))
+	assert.Equal(t, 0, q.demandFor("lib-b"))
+	q.mu.Unlock()
+
+	started := queueDownloads(t, q, "lib-b", "lib-a")
+	release()
+	assert.Equal(t, "lib-a", <-started)
+}
+
+func TestDownloadQueue_DemandOutlivesRequests(t *testing.T) {
+	q := newDownloadQueue(DownloadQueueConfig{MaxConcurrent: 1, PrioritizeDemand: true}, libraryevents.NoopListener{})
+	release, err := q.acquire(context.Background(), "running", "running")
+	require.NoError(t, err)
+
+	// The requests returned while the downloads are queued in the background.
+	q.want("lib-b", "vol-2")
+	q.want("lib-b", "vol-3")
+	started := queueDownloads(t, q, "lib-a", "lib-b")
+	release()
+	assert.Equal(t, "lib-b", <-started)
+
+	// The demand is forgotten once the download starts.
+	q.mu.Lock()
+	assert.Equal(t, 0, q.demandFor("lib-b"))
+	q.mu.Unlock()
+}
+
+func TestDownloadQueue_GivesUpWithContext(t *testing.T) {
+	listener := &queueListener{}
+	q := newDownloadQueue(DownloadQueueConfig{MaxConcurrent: 1}, listener)
+	release, err := q.acquire(context.Background(), "lib-a", "lib-a")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = q.acquire(ctx, "lib-b", "lib-b")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 0, listener.getDepth())
+
+	// The slot is still usable once released.
+	release()
+	release, err = q.acquire(context.Background(), "lib-c", "lib-c")
+	require.NoError(t, err)
+	release()
+	assert.Equal(t, []string{"lib-a", "lib-c"}, listener.dequeued)
+}
@@ -34,6 +34,8 @@ type recordedEvent struct {
 	count    int
 	bytes    int64
 	links    int
+	action   libraryevents.ReconcileAction
+	prefetch libraryevents.PrefetchResult
 	snapshot libraryevents.Snapshot
 }
 
@@ -91,6 +93,22 @@ func (r *recordingListener) OnVolumeUnlinked(library string, links int) {
 	r.record(recordedEvent{kind: "unlinked", library: library, links: links})
 }
 
+func (r *recordingListener) OnVolumeReconciled(library string, action libraryevents.ReconcileAction) {
+	r.record(recordedEvent{kind: "reconciled", library: library, action: action})
+}
+
+func (r *recordingListener) OnDownloadQueueDepth(depth int) {
+	r.record(recordedEvent{kind: "queue_depth", count: depth})
+}
+
+func (r *recordingListener) OnDownloadDequeued(library string, wait time.Duration) {
+	r.record(recordedEvent{kind: "dequeued", library: library, duration: wait})
+}
+
+func (r *recordingListener) OnLibraryPrefetched(library string, result libraryevents.PrefetchResult) {
+	r.record(recordedEvent{kind: "prefetched", library: library, prefetch: result})
+}
+
 func (r *recordingListener) OnSnapshot(s libraryevents.Snapshot) {
 	r.record(recordedEvent{kind: "snapshot", snapshot: s})
 }
@@ -38,6 +38,28 @@ func NewLibrary(name string, registry string, version string, pull bool) (*Libra
 	}, nil
 }
 
+// ParseLibraryReference parses a library given as a <registry>/<package>:<version> reference, where the version is a
+// tag, a digest (<package>@sha256:...) or both.
+func ParseLibraryReference(ref string, pull bool) (*Library, error) {
+	// The version starts at the first ':' or '@' of the last path element,
+	// since the registry may hold a port
+	slash := strings.LastIndex(ref, "/")
+	if slash < 0 {
+		return nil, fmt.Errorf("library %q must be a <registry>/<package>:<version> reference", ref)
+	}
+	registry, nameAndVersion := ref[:slash], ref[slash+1:]
+	name, version := nameAndVersion, ""
+	if i := strings.IndexAny(nameAndVersion, ":@"); i >= 0 {
+		name, version = nameAndVersion[:i], nameAndVersion[i+1:]
+	}
+
+	lib, err := NewLibrary(name, registry, version, pull)
+	if err != nil {
+		return nil, fmt.Errorf("library %q: %w", ref, err)
+	}
+	return lib, nil
+}
+
 // Pull returns if this library should be pulled or not based on the pull policy.
 func (l *Library) Pull() bool {
 	return l.pull
@@ -99,3 +99,21 @@ func TestNewLibrary(t *testing.T) {
 		})
 	}
 }
+
+func TestParseLibraryReference(t *testing.T) {
+	lib, err := librarymanager.ParseLibraryReference("localhost:5000/datadog/apm-inject:0@sha256:abc", true)
+	require.NoError(t, err)
+	require.Equal(t, "apm-inject", lib.Name())
+	require.Equal(t, "localhost:5000/datadog", lib.Registry())
+	require.Equal(t, "localhost:5000/datadog/apm-inject:0@sha256:abc", lib.Image())
+	require.True(t, lib.Pull())
+
+	lib, err = librarymanager.ParseLibraryReference("gcr.io/datadoghq/dd-lib-java-init@sha256:abc", false)
+	require.NoError(t, err)
+	require.Equal(t, "gcr.io/datadoghq/dd-lib-java-init@sha256:abc", lib.Image())
+
+	for _, ref := range []string{"apm-inject:0", "gcr.io/datadoghq/apm-inject", "gcr.io/datadoghq/:0"} {
+		_, err := librarymanager.ParseLibraryReference(ref, true)
+		require.Error(t, err, ref)
+	}
+}
@@ -11,10 +11,13 @@ import (
 	"fmt"
 	log "log/slog"
 	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/Datadog/datadog-csi-driver/pkg/libraryevents"
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
 	"github.com/spf13/afero"
+	"k8s.io/utils/mount"
 )
 
 const (
@@ -54,6 +57,25 @@ type LibraryManager struct {
 	// audit, etc.); the manager itself stays free of any dependency on a
 	// concrete backend.
 	listener libraryevents.Listener
+	// mounter is used by the reconciler to unmount leaked library bind
+	// mounts. Leaked mounts are left alone when it is nil.
+	mounter mount.Interface
+	// reconciler is the optional background reconciliation of volume links
+	// against the host mount table. It is nil unless WithReconciler is used.
+	reconciler *reconciler
+	// queueConfig configures the limit on concurrent downloads, which is
+	// enforced by queue.
+	queueConfig DownloadQueueConfig
+	// queue limits the number of concurrent downloads. It is nil, which does
+	// not limit anything, unless WithDownloadQueue is used.
+	queue *downloadQueue
+	// downloads tracks the libraries being downloaded in the background. It
+	// is nil unless WithBackgroundDownloads is used: downloads then run in the
+	// request that needs the library.
+	downloads *downloadJobs
+	// prefetcher downloads and pins the libraries of the prefetch list in
+	// the background. It is nil unless WithPrefetch is used.
+	prefetcher *prefetcher
 }
 
 // LibraryManagerOption is a functional option for configuring a LibraryManager.
@@ -93,6 +115,49 @@ func WithEventListener(l libraryevents.Listener) LibraryManagerOption {
 	}
 }
 
+// WithMounter sets the mounter used by the reconciler to unmount leaked library bind mounts.
+func WithMounter(m mount.Interface) LibraryManagerOption {
+	return func(lm *LibraryManager) {
+		lm.mounter = m
+	}
+}
+
+// WithReconciler enables the reconciliation of volume links against the host mount table. It runs once when the
+// manager is created, then periodically.
+func WithReconciler(config ReconcilerConfig) LibraryManagerOption {
+	return func(lm *LibraryManager) {
+		lm.reconciler = newReconciler(config)
+	}
+}
+
+// WithBackgroundDownloads runs library downloads in the background, keyed by library digest. A resolution waits up
+// to wait for the download of its library, then fails with a DownloadInProgressError so that the caller can retry
+// later instead of holding its request until the download is over. A library downloaded in the background is handed
+// to the cleanup strategy until a volume links it, so the strategy should leave retries time to do so, as
+// DelayedCleanupStrategy does.
+func WithBackgroundDownloads(wait time.Duration) LibraryManagerOption {
+	return func(lm *LibraryManager) {
+		lm.downloads = newDownloadJobs(wait)
+	}
+}
+
+// WithDownloadQueue limits the number of libraries downloaded and extracted at once. Downloads beyond the limit wait
+// for a free slot in a queue.
+func WithDownloadQueue(config DownloadQueueConfig) LibraryManagerOption {
+	return func(lm *LibraryManager) {
+		lm.queueConfig = config
+	}
+}
+
+// WithPrefetch downloads a list of libraries in the background when the manager is created, then again every
+// interval, and pins them in the store so that no cleanup strategy removes them. The first volumes of a node then
+// find the libraries in the store instead of downloading them.
+func WithPrefetch(config PrefetchConfig) LibraryManagerOption {
+	return func(lm *LibraryManager) {
+		lm.prefetcher = newPrefetcher(config)
+	}
+}
+
 // NewLibraryManager creates a new library manager with all of the required dependencies.
 // The basePath is required as an absolute path (rather than using afero.NewBasePathFs) because
 // bind mounts need absolute paths.
@@ -143,6 +208,9 @@ func NewLibraryManager(basePath string, opts ...LibraryManagerOption) (*LibraryM
 	// Setup cache.
 	lm.cache = NewImageCache(lm.downloader, DefaultImageCacheTTL)
 
+	// Setup download queue.
+	lm.queue = newDownloadQueue(lm.queueConfig, lm.listener)
+
 	// Seed listener gauges from the persisted state, so dashboards reflect
 	// reality immediately after a driver restart instead of waiting for the
 	// first event.
@@ -152,6 +220,30 @@ func NewLibraryManager(basePath string, opts ...LibraryManagerOption) (*LibraryM
 		lm.listener.OnSnapshot(snap)
 	}
 
+	// Reconcile volume links with the host before serving any request, so
+	// links leaked while the driver was down are released right away.
+	if lm.reconciler != nil {
+		if err := lm.Reconcile(); err != nil {
+			log.Error("could not reconcile volumes with the host mount table", "error", err)
+		}
+		lm.reconciler.start(lm.Reconcile)
+	}
+
+	// Prefetch the pinned libraries in the background. Without a prefetch
+	// list, the libraries pinned by a previous run are released right away.
+	if lm.prefetcher != nil {
+		lm.prefetcher.start(lm.Prefetch)
+	} else if err := lm.Prefetch(context.Background()); err != nil {
+		log.Error("could not release pinned libraries", "error", err)
+	}
+
+	// Strategies sized on the whole store read it from the database, and
+	// enforce their limits on what the previous run left behind.
+	if strategy, ok := lm.cleanupStrategy.(catalogCleanupStrategy); ok {
+		strategy.setCatalog(lm.db)
+		strategy.triggerCleanup(lm.tryCleanupLibrary)
+	}
+
 	return lm, nil
 }
 
@@ -170,10 +262,44 @@ func (lm *LibraryManager) packageStats(library string) (cachedCount int, cachedB
 
 // Stop ensures all dependencies are stopped correctly.
 func (lm *LibraryManager) Stop() error {
+	if lm.reconciler != nil {
+		lm.reconciler.stop()
+	}
+	if lm.prefetcher != nil {
+		lm.prefetcher.stop()
+	}
+	if lm.downloads != nil {
+		lm.downloads.stop()
+	}
 	lm.cleanupStrategy.Stop()
 	return lm.db.Close()
 }
 
+// CheckDatabase verifies that the library manager database is open and readable.
+func (lm *LibraryManager) CheckDatabase() error {
+	return lm.db.Ping()
+}
+
+// RecordPublication persists the publication of a volume, whatever its type.
+func (lm *LibraryManager) RecordPublication(volumeID string, publication Publication) error {
+	return lm.db.PutPublication(volumeID, publication)
+}
+
+// GetPublication returns the publication record of a volume. The boolean is false when the volume has no record.
+func (lm *LibraryManager) GetPublication(volumeID string) (Publication, bool, error) {
+	return lm.db.GetPublication(volumeID)
+}
+
+// ListPublications returns every publication record, keyed by volume ID.
+func (lm *LibraryManager) ListPublications() (map[string]Publication, error) {
+	return lm.db.ListPublications()
+}
+
+// RemovePublication deletes the publication record of a volume.
+func (lm *LibraryManager) RemovePublication(volumeID string) error {
+	return lm.db.RemovePublication(volumeID)
+}
+
 // HasVolume returns true if the volume is managed by the library manager.
 func (lm *LibraryManager) HasVolume(volumeID string) (bool, error) {
 	libraryID, err := lm.db.GetLibraryForVolume(volumeID)
@@ -183,6 +309,114 @@ func (lm *LibraryManager) HasVolume(volumeID string) (bool, error) {
 	return libraryID != "", nil
 }
 
+// IsVolumeLinked returns true if the volume is linked to one library or more, whether it mounts a single library or
+// several of them.
+func (lm *LibraryManager) IsVolumeLinked(volumeID string) (bool, error) {
+	_, found, err := lm.db.GetVolume(volumeID)
+	return found, err
+}
+
+// GetVolumePath returns the store path of the library linked to a volume, or an empty string when the volume is not
+// managed by the library manager. ErrItemNotFound is returned when the volume is linked to a library that is no
+// longer in the store.
+func (lm *LibraryManager) GetVolumePath(volumeID string) (string, error) {
+	libraryID, err := lm.db.GetLibraryForVolume(volumeID)
+	if err != nil || libraryID == "" {
+		return "", err
+	}
+	return lm.store.Get(libraryID)
+}
+
+// GetVolumePaths returns the store paths of the libraries linked to a volume, in the order they were requested, or
+// nil when the volume is not managed by the library manager. ErrItemNotFound is returned when one of the libraries is
+// no longer in the store.
+func (lm *LibraryManager) GetVolumePaths(volumeID string) ([]string, error) {
+	volume, found, err := lm.db.GetVolume(volumeID)
+	if err != nil || !found {
+		return nil, err
+	}
+	paths := make([]string, 0, len(volume.LibraryIDs))
+	for _, libraryID := range volume.LibraryIDs {
+		path, err := lm.store.Get(libraryID)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// GetVolumeSourcePaths returns the source paths recorded from the image metadata of the libraries linked to a volume,
+// in the order they were requested. A path is empty when the image of the library does not set one, and the result
+// is nil when the volume is not managed by the library manager.
+func (lm *LibraryManager) GetVolumeSourcePaths(volumeID string) ([]string, error) {
+	volume, found, err := lm.db.GetVolume(volumeID)
+	if err != nil || !found {
+		return nil, err
+	}
+	sourcePaths := make([]string, 0, len(volume.LibraryIDs))
+	for _, libraryID := range volume.LibraryIDs {
+		library, _, err := lm.db.GetLibrary(libraryID)
+		if err != nil {
+			return nil, err
+		}
+		sourcePaths = append(sourcePaths, library.SourcePath)
+	}
+	return sourcePaths, nil
+}
+
+// PodLibrary is a library linked to a volume of a pod.
+type PodLibrary struct {
+	// VolumeID is the ID of the volume linked to the library.
+	VolumeID string
+	// Package is the package name of the library, e.g. apm-inject.
+	Package string
+	// Path is the store path of the library.
+	Path string
+	// SourcePath is the directory of the image that is mounted, recorded from the image metadata. It is empty when
+	// the image does not set one.
+	SourcePath string
+}
+
+// ListPodLibraries returns the libraries linked to the volumes of a pod, identified by its UID. Libraries missing from
+// the store are left out.
+func (lm *LibraryManager) ListPodLibraries(podUID string) ([]PodLibrary, error) {
+	if podUID == "" {
+		return nil, fmt.Errorf("pod UID cannot be blank")
+	}
+	volumes, err := lm.db.ListVolumes()
+	if err != nil {
+		return nil, err
+	}
+
+	var libraries []PodLibrary
+	for volumeID, volume := range volumes {
+		if volume.Pod.UID != podUID {
+			continue
+		}
+		for _, libraryID := range volume.LibraryIDs {
+			library, _, err := lm.db.GetLibrary(libraryID)
+			if err != nil {
+				return nil, err
+			}
+			path, err := lm.store.Get(libraryID)
+			if errors.Is(err, ErrItemNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			libraries = append(libraries, PodLibrary{
+				VolumeID:   volumeID,
+				Package:    library.Package,
+				Path:       path,
+				SourcePath: library.SourcePath,
+			})
+		}
+	}
+	return libraries, nil
+}
+
 // GetLibraryForVolume fetches the remote library if it doesn't exist, records its usage, and returns the path on disk
 // that can be mounted for the volume.
 func (lm *LibraryManager) GetLibraryForVolume(ctx context.Context, volumeID string, lib *Library) (string, error) {
@@ -212,7 +446,12 @@ func (lm *LibraryManager) GetLibraryForVolume(ctx context.Context, volumeID stri
 	// different digests would otherwise lock different libraries, both link,
 	// and leave the volume mounting one library while the DB records the other.
 	// The key is namespaced so it never collides with a library digest lock.
-	lm.locker.Lock(volumeLockKey(volumeID))
+	// Waiting for a lock gives up with the request: kubelet retries the publish
+	// once its deadline expires, and the abandoned call would otherwise hold
+	// its place in the queue.
+	if err := lm.locker.LockContext(ctx, volumeLockKey(volumeID)); err != nil {
+		return "", err
+	}
 	defer lm.locker.Unlock(volumeLockKey(volumeID))
 
 	// A volume is published once and keeps the same library for its whole
@@ -225,42 +464,9 @@ func (lm *LibraryManager) GetLibraryForVolume(ctx context.Context, volumeID stri
 		return "", err
 	}
 	if existingLibraryID != "" {
-		lm.locker.Lock(existingLibraryID)
-		defer lm.locker.Unlock(existingLibraryID)
-
-		// The volume is already linked: reuse its library instead of
-		// re-resolving the image.
-		path, err := lm.store.Get(existingLibraryID)
-		if err != nil && !errors.Is(err, ErrItemNotFound) {
-			return "", err
-		}
-		if path != "" {
-			log.Info("Reusing library already linked to volume", "image", lib.Image(), "path", path)
-			result = libraryevents.ResolutionCacheHit
-			return path, nil
-		}
-
-		// The link points at a library that is no longer on disk. Cleanup only
-		// removes a library once it has no links, so this means the store was
-		// emptied out from under us (host disk cleanup or corruption while the
-		// DB file survived). Re-download the exact same content by digest so
-		// the volume keeps the library it is linked to; the DB still references
-		// it, so no metadata or link needs to change.
-		image := fmt.Sprintf("%s/%s@sha256:%s", lib.Registry(), lib.Name(), existingLibraryID)
-		log.Warn("Linked library missing from store, redownloading", "library_id", existingLibraryID, "image", image)
-		storePath, _, err := lm.downloadToStore(ctx, existingLibraryID, lib, image)
-		if err != nil {
-			return "", err
-		}
-		// We intentionally do not rewrite the library record here: it already
-		// exists (the volume is linked), so its metadata is left as-is. Known
-		// limitation: if this record was migrated from the legacy schema it has
-		// no package/size, and this recovery path does not backfill it, so it
-		// stays absent from the cached gauges and is cleaned up with an empty
-		// label. This only affects a legacy library that also lost its store
-		// entry and was republished, which is not worth the extra bookkeeping.
-		result = libraryevents.ResolutionDownloaded
-		return storePath, nil
+		var path string
+		path, result, err = lm.linkedLibraryPath(ctx, existingLibraryID, lib)
+		return path, err
 	}
 
 	// Fetch the library ID based on the image digest.
@@ -268,11 +474,32 @@ func (lm *LibraryManager) GetLibraryForVolume(ctx context.Context, volumeID stri
 	if err != nil {
 		return "", fmt.Errorf("could not determine library ID: %w", err)
 	}
+	// Count this volume in the demand for the library, so that the download
+	// queue can serve the most wanted libraries first. The demand outlives the
+	// request, since the download may continue in the background.
+	lm.queue.want(libraryID, volumeID)
+
+	// With background downloads, a missing library is downloaded without
+	// holding the request: the caller gets a DownloadInProgressError and
+	// finds the library in the store when it retries.
+	downloaded := false
+	if lm.downloads != nil {
+		downloaded, err = lm.awaitDownload(ctx, libraryID, lib)
+		var inProgress *DownloadInProgressError
+		if errors.As(err, &inProgress) {
+			result = libraryevents.ResolutionPending
+		}
+		if err != nil {
+			return "", err
+		}
+	}
 
 	// Lock the package. The locker prevents cleanup from running while we
 	// resolve, so we can defer LinkVolume to after we have confirmed the
 	// library is on disk and recorded in the metadata bucket.
-	lm.locker.Lock(libraryID)
+	if err := lm.locker.LockContext(ctx, libraryID); err != nil {
+		return "", err
+	}
 	defer lm.locker.Unlock(libraryID)
 
 	// If the library already exists, return it.
@@ -281,8 +508,15 @@ func (lm *LibraryManager) GetLibraryForVolume(ctx context.Context, volumeID stri
 		return "", err
 	}
 	if path != "" {
-		log.Info("Library already cached", "image", lib.Image(), "path", path)
-		if err = lm.linkVolume(libraryID, volumeID, lib.Name(), true); err != nil {
+		if downloaded {
+			if err = lm.linkVolume(ctx, libraryID, volumeID, lib.Name(), false); err != nil {
+				return "", err
+			}
+			result = libraryevents.ResolutionDownloaded
+			return path, nil
+		}
+		log.InfoContext(ctx, "Library already cached", "image", lib.Image(), "path", path)
+		if err = lm.linkVolume(ctx, libraryID, volumeID, lib.Name(), true); err != nil {
 			return "", err
 		}
 		result = libraryevents.ResolutionCacheHit
@@ -290,22 +524,12 @@ func (lm *LibraryManager) GetLibraryForVolume(ctx context.Context, volumeID stri
 	}
 
 	// Otherwise, download the library and copy it into the store.
-	storePath, sizeBytes, err := lm.downloadToStore(ctx, libraryID, lib, lib.Image())
+	storePath, err := lm.downloadLibrary(ctx, libraryID, lib)
 	if err != nil {
 		return "", err
 	}
 
-	// Record the library so its package name and on-disk size are persisted.
-	// AddLibrary is the canonical writer for the per-library record; it must
-	// run before LinkVolume so the library record exists when the volume
-	// count is incremented.
-	if err := lm.db.AddLibrary(libraryID, lib.Name(), sizeBytes); err != nil {
-		return "", fmt.Errorf("could not record library metadata: %w", err)
-	}
-	count, totalBytes, _ := lm.packageStats(lib.Name())
-	lm.listener.OnLibraryCached(lib.Name(), count, totalBytes)
-
-	if err = lm.linkVolume(libraryID, volumeID, lib.Name(), false); err != nil {
+	if err = lm.linkVolume(ctx, libraryID, volumeID, lib.Name(), false); err != nil {
 		return "", err
 	}
 
@@ -313,47 +537,251 @@ func (lm *LibraryManager) GetLibraryForVolume(ctx context.Context, volumeID stri
 	return storePath, nil
 }
 
+// linkedLibraryPath returns the store path of a library already linked to a
+// volume, along with the resolution result to report for it.
+func (lm *LibraryManager) linkedLibraryPath(ctx context.Context, libraryID string, lib *Library) (string, libraryevents.ResolutionResult, error) {
+	if err := lm.locker.LockContext(ctx, libraryID); err != nil {
+		return "", libraryevents.ResolutionFailed, err
+	}
+	defer lm.locker.Unlock(libraryID)
+
+	// The volume is already linked: reuse its library instead of
+	// re-resolving the image.
+	path, err := lm.store.Get(libraryID)
+	if err != nil && !errors.Is(err, ErrItemNotFound) {
+		return "", libraryevents.ResolutionFailed, err
+	}
+	if path != "" {
+		log.InfoContext(ctx, "Reusing library already linked to volume", "image", lib.Image(), "path", path)
+		return path, libraryevents.ResolutionCacheHit, nil
+	}
+
+	// The link points at a library that is no longer on disk. Cleanup only
+	// removes a library once it has no links, so this means the store was
+	// emptied out from under us (host disk cleanup or corruption while the
+	// DB file survived). Re-download the exact same content by digest so
+	// the volume keeps the library it is linked to; the DB still references
+	// it, so no metadata or link needs to change.
+	image := fmt.Sprintf("%s/%s@sha256:%s", lib.Registry(), lib.Name(), libraryID)
+	log.WarnContext(ctx, "Linked library missing from store, redownloading", "library_id", libraryID, "image", image)
+	storePath, _, err := lm.downloadToStore(ctx, libraryID, lib, image)
+	if err != nil {
+		return "", libraryevents.ResolutionFailed, err
+	}
+	// We intentionally do not rewrite the library record here: it already
+	// exists (the volume is linked), so its metadata is left as-is. Known
+	// limitation: if this record was migrated from the legacy schema it has
+	// no package/size, and this recovery path does not backfill it, so it
+	// stays absent from the cached gauges and is cleaned up with an empty
+	// label. This only affects a legacy library that also lost its store
+	// entry and was republished, which is not worth the extra bookkeeping.
+	return storePath, libraryevents.ResolutionDownloaded, nil
+}
+
+// GetLibrariesForVolume is GetLibraryForVolume for a volume mounting several libraries. It fetches the libraries
+// that are missing from the store, then links all of them to the volume in a single transaction, so the volume is
+// never linked to only part of its libraries. It returns the paths on disk of the libraries, in the order of libs.
+func (lm *LibraryManager) GetLibrariesForVolume(ctx context.Context, volumeID string, libs []*Library) ([]string, error) {
+	// Report one resolution outcome per library, "failed" unless overwritten.
+	results := make([]libraryevents.ResolutionResult, len(libs))
+	for i := range results {
+		results[i] = libraryevents.ResolutionFailed
+	}
+	defer func() {
+		for i, lib := range libs {
+			if lib != nil {
+				lm.listener.OnLibraryResolved(lib.Name(), results[i])
+			}
+		}
+	}()
+
+	// Validate the input.
+	if volumeID == "" {
+		return nil, fmt.Errorf("volume ID cannot be empty")
+	}
+	if len(libs) == 0 {
+		return nil, fmt.Errorf("libraries cannot be empty")
+	}
+	if slices.Contains(libs, nil) {
+		return nil, fmt.Errorf("library cannot be nil")
+	}
+
+	// Serialize the whole sequence per volume, see GetLibraryForVolume.
+	if err := lm.locker.LockContext(ctx, volumeLockKey(volumeID)); err != nil {
+		return nil, err
+	}
+	defer lm.locker.Unlock(volumeLockKey(volumeID))
+
+	paths := make([]string, len(libs))
+
+	// An already linked volume keeps its libraries, see GetLibraryForVolume.
+	existing, linked, err := lm.db.GetVolume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	if linked {
+		if len(existing.LibraryIDs) != len(libs) {
+			return nil, fmt.Errorf("volume %s is already linked to %d libraries, not %d", volumeID, len(existing.LibraryIDs), len(libs))
+		}
+		for i, libraryID := range existing.LibraryIDs {
+			paths[i], results[i], err = lm.linkedLibraryPath(ctx, libraryID, libs[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return paths, nil
+	}
+
+	// Fetch the library IDs based on the image digests.
+	libraryIDs := make([]string, len(libs))
+	for i, lib := range libs {
+		libraryID, err := lm.cache.FetchDigest(ctx, lib.Image(), lib.Pull())
+		if err != nil {
+			return nil, fmt.Errorf("could not determine library ID of %s: %w", lib.Image(), err)
+		}
+		if slices.Contains(libraryIDs[:i], libraryID) {
+			return nil, fmt.Errorf("library %s is requested twice", lib.Image())
+		}
+		libraryIDs[i] = libraryID
+		lm.queue.want(libraryID, volumeID)
+	}
+
+	// With background downloads, every missing library is downloaded in the
+	// background before any error is returned, so that they all make progress
+	// while the caller retries.
+	downloaded := make([]bool, len(libs))
+	if lm.downloads != nil {
+		var errs []error
+		for i, lib := range libs {
+			downloaded[i], err = lm.awaitDownload(ctx, libraryIDs[i], lib)
+			var inProgress *DownloadInProgressError
+			if errors.As(err, &inProgress) {
+				results[i] = libraryevents.ResolutionPending
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) > 0 {
+			return nil, errs[0]
+		}
+	}
+
+	// Lock the libraries until the volume is linked, so that cleanup cannot
+	// remove one of them in between. They are locked in a fixed order so that
+	// two volumes sharing libraries never deadlock.
+	lockOrder := slices.Clone(libraryIDs)
+	slices.Sort(lockOrder)
+	for _, libraryID := range lockOrder {
+		if err := lm.locker.LockContext(ctx, libraryID); err != nil {
+			return nil, err
+		}
+		defer lm.locker.Unlock(libraryID)
+	}
+
+	fromCache := true
+	for i, lib := range libs {
+		path, err := lm.store.Get(libraryIDs[i])
+		if err != nil && !errors.Is(err, ErrItemNotFound) {
+			return nil, err
+		}
+		if path == "" {
+			path, err = lm.downloadLibrary(ctx, libraryIDs[i], lib)
+			if err != nil {
+				return nil, err
+			}
+			downloaded[i] = true
+		} else if !downloaded[i] {
+			log.InfoContext(ctx, "Library already cached", "image", lib.Image(), "path", path)
+		}
+		fromCache = fromCache && !downloaded[i]
+		paths[i] = path
+	}
+
+	pod, _ := podinfo.FromContext(ctx)
+	if err := lm.db.LinkVolumeLibraries(libraryIDs, volumeID, fromCache, pod); err != nil {
+		return nil, err
+	}
+	for i, lib := range libs {
+		_, _, volumeLinks := lm.packageStats(lib.Name())
+		lm.listener.OnVolumeLinked(lib.Name(), volumeLinks)
+		results[i] = libraryevents.ResolutionCacheHit
+		if downloaded[i] {
+			results[i] = libraryevents.ResolutionDownloaded
+		}
+	}
+	return paths, nil
+}
+
 // volumeLockKey namespaces a volume ID so its serialization lock can never
 // collide with a library lock, which is keyed by the raw image digest.
 func volumeLockKey(volumeID string) string {
 	return "volume:" + volumeID
 }
 
+// downloadLibrary downloads a library into the store and records it, without
+// linking any volume. The caller must hold the library lock.
+func (lm *LibraryManager) downloadLibrary(ctx context.Context, libraryID string, lib *Library) (string, error) {
+	storePath, download, err := lm.downloadToStore(ctx, libraryID, lib, lib.Image())
+	if err != nil {
+		return "", err
+	}
+
+	// Record the library so its package name, on-disk size and source path are persisted.
+	// AddLibrary is the canonical writer for the per-library record; it must
+	// run before LinkVolume so the library record exists when the volume
+	// count is incremented.
+	if err := lm.db.AddLibrary(libraryID, lib.Name(), download.SizeBytes, download.SourcePath); err != nil {
+		return "", fmt.Errorf("could not record library metadata: %w", err)
+	}
+	count, totalBytes, _ := lm.packageStats(lib.Name())
+	lm.listener.OnLibraryCached(lib.Name(), count, totalBytes)
+	return storePath, nil
+}
+
 // downloadToStore pulls image into a fresh scratch directory and copies it into
-// the store under libraryID, returning the resulting store path and on-disk
-// size. The caller must hold the library lock. It emits the download event but
+// the store under libraryID, returning the resulting store path and the
+// download result. The download waits for a free slot of the download queue first. The
+// caller must hold the library lock. It emits the download event but
 // performs no metadata or link bookkeeping, so it is shared by the cache-miss
 // download path and the recovery path that restores a linked library whose
 // store entry disappeared.
-func (lm *LibraryManager) downloadToStore(ctx context.Context, libraryID string, lib *Library, image string) (string, int64, error) {
+func (lm *LibraryManager) downloadToStore(ctx context.Context, libraryID string, lib *Library, image string) (string, DownloadResult, error) {
 	scratch, err := afero.TempDir(lm.fs, lm.scratchDir, "datadog-csi-driver-*")
 	if err != nil {
-		return "", 0, fmt.Errorf("could not create scratch directory: %w", err)
+		return "", DownloadResult{}, fmt.Errorf("could not create scratch directory: %w", err)
 	}
 	defer func() { _ = lm.fs.RemoveAll(scratch) }()
 
-	log.Info("Downloading library", "image", image)
+	release, err := lm.queue.acquire(ctx, libraryID, lib.Name())
+	if err != nil {
+		return "", DownloadResult{}, err
+	}
+	log.InfoContext(ctx, "Downloading library", "image", image)
 	downloadStart := time.Now()
-	sizeBytes, err := lm.downloader.Download(ctx, image, scratch)
+	download, err := lm.downloader.Download(ctx, image, scratch)
+	release()
 	if err != nil {
-		return "", 0, err
+		return "", DownloadResult{}, err
 	}
 	lm.listener.OnLibraryDownload(lib.Name(), lib.Registry(), time.Since(downloadStart))
 
 	storePath, err := lm.store.Add(libraryID, scratch)
 	if err != nil {
-		return "", 0, err
+		return "", DownloadResult{}, err
 	}
-	log.Info("Library downloaded and stored", "image", image, "path", storePath)
-	return storePath, sizeBytes, nil
+	log.InfoContext(ctx, "Library downloaded and stored", "image", image, "path", storePath, "source_path", download.SourcePath)
+	return storePath, download, nil
 }
 
 // linkVolume persists the library/volume link and notifies the listener with
 // the resulting per-library volume count. It is intentionally a tiny helper:
 // keeping the listener invocation paired with the LinkVolume call avoids the
-// easy mistake of forgetting one of the two.
-func (lm *LibraryManager) linkVolume(libraryID, volumeID, library string, fromCache bool) error {
-	if err := lm.db.LinkVolume(libraryID, volumeID, fromCache); err != nil {
+// easy mistake of forgetting one of the two. The pod carried by ctx, if any,
+// is stored with the link.
+func (lm *LibraryManager) linkVolume(ctx context.Context, libraryID, volumeID, library string, fromCache bool) error {
+	pod, _ := podinfo.FromContext(ctx)
+	if err := lm.db.LinkVolume(libraryID, volumeID, fromCache, pod); err != nil {
 		return err
 	}
 
@@ -374,30 +802,40 @@ func (lm *LibraryManager) RemoveVolume(_ context.Context, volumeID string) error
 	lm.locker.Lock(volumeLockKey(volumeID))
 	defer lm.locker.Unlock(volumeLockKey(volumeID))
 
-	// Unlink the volume. UnlinkVolume returns both the library it was linked
-	// to and that library's package name (read off the persisted record while
-	// decrementing the count), so we get the gauge label without a separate
+	_, err := lm.removeVolumeLocked(volumeID)
+	return err
+}
+
+// removeVolumeLocked unlinks a volume and schedules the cleanup of its
+// libraries. It returns the package names of the libraries the volume was
+// linked to. The caller must hold the volume lock.
+func (lm *LibraryManager) removeVolumeLocked(volumeID string) ([]string, error) {
+	// Unlink the volume. UnlinkVolume returns both the libraries it was linked
+	// to and their package names (read off the persisted records while
+	// decrementing the counts), so we get the gauge labels without a separate
 	// lookup before the link is wiped. tryCleanupLibrary acquires the library
 	// lock before checking and removing (ordering is always volume -> library,
 	// so the two locks never deadlock).
-	libraryID, library, err := lm.db.UnlinkVolume(volumeID)
+	libraries, err := lm.db.UnlinkVolume(volumeID)
 	if err != nil {
-		return fmt.Errorf("could not unlink volume ID %s: %w", volumeID, err)
-	}
-	if libraryID == "" {
-		// Nothing to do: the volume was never linked or has already been
-		// removed. We return nil here to keep idempotency.
-		return nil
-	}
-	if library != "" {
-		_, _, volumeLinks := lm.packageStats(library)
-		lm.listener.OnVolumeUnlinked(library, volumeLinks)
+		return nil, fmt.Errorf("could not unlink volume ID %s: %w", volumeID, err)
 	}
+	// Nothing is left to do when the volume was never linked or has already
+	// been removed, which keeps idempotency.
 
-	// Schedule cleanup - tryCleanupLibrary will check if the library is still in use
-	lm.cleanupStrategy.ScheduleCleanup(libraryID, lm.tryCleanupLibrary)
+	packages := make([]string, 0, len(libraries))
+	for _, library := range libraries {
+		if library.Package != "" {
+			_, _, volumeLinks := lm.packageStats(library.Package)
+			lm.listener.OnVolumeUnlinked(library.Package, volumeLinks)
+		}
+		packages = append(packages, library.Package)
 
-	return nil
+		// Schedule cleanup - tryCleanupLibrary will check if the library is still in use
+		lm.cleanupStrategy.ScheduleCleanup(library.LibraryID, lm.tryCleanupLibrary)
+	}
+
+	return packages, nil
 }
 
 // tryCleanupLibrary attempts to remove a library from disk if it's no longer in use.
@@ -425,6 +863,11 @@ func (lm *LibraryManager) tryCleanupLibrary(libraryID string) error {
 		lm.listener.OnLibraryCleanup(info.Package, libraryevents.CleanupSkippedInUse, strategy)
 		return nil
 	}
+	if info.Pinned {
+		log.Debug("Library pinned by the prefetch list, skipping cleanup", "library_id", libraryID)
+		lm.listener.OnLibraryCleanup(info.Package, libraryevents.CleanupSkippedPinned, strategy)
+		return nil
+	}
 	log.Info("Removing library from disk", "library_id", libraryID)
 
 	if err := lm.store.Remove(libraryID); err != nil {
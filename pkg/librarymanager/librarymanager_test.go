@@ -11,6 +11,7 @@ import (
 	"testing"
 
 	"github.com/Datadog/datadog-csi-driver/pkg/librarymanager"
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
 	"github.com/Datadog/datadog-csi-driver/pkg/testutil"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/require"
@@ -166,3 +167,85 @@ func createTestLibrary(t *testing.T, tl *testVolume, registry string) *libraryma
 	require.NoError(t, err)
 	return lib
 }
+
+func TestLibraryManagerListPodLibraries(t *testing.T) {
+	localRegistry := testutil.NewLocalRegistry(t)
+	defer localRegistry.Stop()
+	localRegistry.AddImage(t, "testdata/image.tar", "test-image", "latest")
+
+	tsd := testutil.NewTempScratchDirectory(t)
+	defer tsd.Cleanup(t)
+
+	lm, err := librarymanager.NewLibraryManager(tsd.Path(t),
+		librarymanager.WithFilesystem(afero.Afero{Fs: afero.NewOsFs()}),
+		librarymanager.WithDownloader(librarymanager.NewDownloaderWithRoundTripper(localRegistry.GetRoundTripper(t))),
+	)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, lm.Stop()) }()
+
+	lib, err := librarymanager.NewLibrary("test-image", localRegistry.Registry(t), "latest", false)
+	require.NoError(t, err)
+	ctx := podinfo.NewContext(context.Background(), podinfo.PodInfo{Name: "app", Namespace: "shop", UID: "uid-1"})
+	path, err := lm.GetLibraryForVolume(ctx, "vol-1", lib)
+	require.NoError(t, err)
+	_, err = lm.GetLibraryForVolume(podinfo.NewContext(context.Background(), podinfo.PodInfo{UID: "uid-2"}), "vol-2", lib)
+	require.NoError(t, err)
+
+	libraries, err := lm.ListPodLibraries("uid-1")
+	require.NoError(t, err)
+	require.Equal(t, []librarymanager.PodLibrary{{VolumeID: "vol-1", Package: "test-image", Path: path}}, libraries)
+
+	libraries, err = lm.ListPodLibraries("uid-3")
+	require.NoError(t, err)
+	require.Empty(t, libraries)
+}
+
+func TestLibraryManagerGetLibrariesForVolume(t *testing.T) {
+	localRegistry := testutil.NewLocalRegistry(t)
+	defer localRegistry.Stop()
+	localRegistry.AddImage(t, "testdata/image.tar", "test-image", "latest")
+	localRegistry.AddImage(t, "testdata/image.tar", "other-image", "latest")
+
+	tsd := testutil.NewTempScratchDirectory(t)
+	defer tsd.Cleanup(t)
+
+	lm, err := librarymanager.NewLibraryManager(tsd.Path(t),
+		librarymanager.WithFilesystem(afero.Afero{Fs: afero.NewOsFs()}),
+		librarymanager.WithDownloader(librarymanager.NewDownloaderWithRoundTripper(localRegistry.GetRoundTripper(t))),
+	)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, lm.Stop()) }()
+
+	lib, err := librarymanager.NewLibrary("test-image", localRegistry.Registry(t), "latest", false)
+	require.NoError(t, err)
+	other, err := librarymanager.NewLibrary("other-image", localRegistry.Registry(t), "latest", false)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// Both images have the same digest, so they resolve to the same library.
+	_, err = lm.GetLibrariesForVolume(ctx, "vol-1", []*librarymanager.Library{lib, other})
+	require.ErrorContains(t, err, "requested twice")
+	hasVolume, err := lm.HasVolume("vol-1")
+	require.NoError(t, err)
+	require.False(t, hasVolume)
+
+	paths, err := lm.GetLibrariesForVolume(ctx, "vol-1", []*librarymanager.Library{lib})
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+	volumePaths, err := lm.GetVolumePaths("vol-1")
+	require.NoError(t, err)
+	require.Equal(t, paths, volumePaths)
+
+	// An already linked volume keeps its libraries.
+	again, err := lm.GetLibrariesForVolume(ctx, "vol-1", []*librarymanager.Library{lib})
+	require.NoError(t, err)
+	require.Equal(t, paths, again)
+	_, err = lm.GetLibrariesForVolume(ctx, "vol-1", []*librarymanager.Library{lib, other})
+	require.Error(t, err)
+
+	require.NoError(t, lm.RemoveVolume(ctx, "vol-1"))
+	volumePaths, err = lm.GetVolumePaths("vol-1")
+	require.NoError(t, err)
+	require.Empty(t, volumePaths)
+	require.NoDirExists(t, paths[0], "the library is removed once unlinked")
+}
@@ -5,10 +5,14 @@
 
 package librarymanager
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 type lockEntry struct {
-	mu   sync.Mutex
+	// sem is a one-slot semaphore rather than a mutex so that waiters can give up when their context is done.
+	sem  chan struct{}
 	refs int
 }
 
@@ -27,35 +31,52 @@ func NewLocker() *Locker {
 
 // Lock will acquire a lock for the given ID. The caller MUST call unlock.
 func (l *Locker) Lock(id string) {
+	_ = l.LockContext(context.Background(), id)
+}
+
+// LockContext will acquire a lock for the given ID, or give up and return the context error when the context is done
+// first. The caller MUST call unlock if and only if no error is returned.
+func (l *Locker) LockContext(ctx context.Context, id string) error {
 	// Get the entry.
 	l.mu.Lock()
 	entry, ok := l.entries[id]
 	if !ok {
-		entry = &lockEntry{}
+		entry = &lockEntry{sem: make(chan struct{}, 1)}
 		l.entries[id] = entry
 	}
 	entry.refs++
 	l.mu.Unlock()
 
 	// Lock the entry.
-	entry.mu.Lock()
+	select {
+	case entry.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		l.release(id, entry)
+		return ctx.Err()
+	}
 }
 
 // Unlock will release a lock for the given ID.
 func (l *Locker) Unlock(id string) {
-	// Get the entry and remove it from the map if there are no more references.
 	l.mu.Lock()
 	entry, ok := l.entries[id]
+	l.mu.Unlock()
 	if !ok {
-		l.mu.Unlock()
 		return
 	}
+	l.release(id, entry)
+
+	// Unlock the entry.
+	<-entry.sem
+}
+
+// release drops a reference to the entry and removes it from the map if there are no more references.
+func (l *Locker) release(id string, entry *lockEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	entry.refs--
 	if entry.refs == 0 {
 		delete(l.entries, id)
 	}
-	l.mu.Unlock()
-
-	// Unlock the entry.
-	entry.mu.Unlock()
 }
@@ -6,8 +6,10 @@
 package librarymanager_test
 
 import (
+	"context"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/Datadog/datadog-csi-driver/pkg/librarymanager"
 	"github.com/stretchr/testify/require"
@@ -34,3 +36,21 @@ func TestLocker(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestLockerLockContext(t *testing.T) {
+	l := librarymanager.NewLocker()
+	l.Lock("same-key")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, l.LockContext(ctx, "same-key"), context.DeadlineExceeded, "a held lock should not be acquired")
+	require.NoError(t, l.LockContext(context.Background(), "other-key"), "unrelated keys should not be blocked")
+	l.Unlock("other-key")
+
+	// The waiter that gave up must not leave the lock in an inconsistent state.
+	l.Unlock("same-key")
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, l.LockContext(ctx, "same-key"))
+	l.Unlock("same-key")
+}
@@ -0,0 +1,162 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package librarymanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/libraryevents"
+)
+
+// PrefetchConfig configures the libraries downloaded ahead of the volumes that need them.
+type PrefetchConfig struct {
+	// Libraries are the libraries to prefetch and pin in the store.
+	Libraries []*Library
+	// Interval is the delay between two prefetches, which resolve the tags of the libraries again. Defaults to
+	// DefaultImageCacheTTL, so that the libraries follow their tags as soon as publishes would.
+	Interval time.Duration
+}
+
+// prefetcher runs the prefetch in a background loop.
+type prefetcher struct {
+	config PrefetchConfig
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+	doneCh   chan struct{}
+}
+
+func newPrefetcher(config PrefetchConfig) *prefetcher {
+	if config.Interval <= 0 {
+		config.Interval = DefaultImageCacheTTL
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &prefetcher{
+		config: config,
+		ctx:    ctx,
+		cancel: cancel,
+		doneCh: make(chan struct{}),
+	}
+}
+
+// start runs prefetch right away, then on every interval until stop is called.
+func (p *prefetcher) start(prefetch func(ctx context.Context) error) {
+	go func() {
+		defer close(p.doneCh)
+		ticker := time.NewTicker(p.config.Interval)
+		defer ticker.Stop()
+		for {
+			if err := prefetch(p.ctx); err != nil && p.ctx.Err() == nil {
+				log.Error("could not prefetch libraries", "error", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stop cancels a running prefetch, stops the background loop and waits for it to return.
+func (p *prefetcher) stop() {
+	p.stopOnce.Do(func() {
+		p.cancel()
+		<-p.doneCh
+	})
+}
+
+// Prefetch resolves the tags of the libraries of the prefetch list, downloads the ones missing from the store and
+// pins them, so that no cleanup strategy removes them. Libraries pinned before, whose tag moved or which left the
+// list, are unpinned and handed to the cleanup strategy. The pins of a library that cannot be resolved are kept until
+// a later prefetch succeeds. Without a prefetch list, every library is unpinned.
+func (lm *LibraryManager) Prefetch(ctx context.Context) error {
+	var libs []*Library
+	if lm.prefetcher != nil {
+		libs = lm.prefetcher.config.Libraries
+	}
+
+	var keep []string
+	var failedPackages []string
+	var errs []error
+	for _, lib := range libs {
+		libraryID, result, err := lm.prefetchLibrary(ctx, lib)
+		lm.listener.OnLibraryPrefetched(lib.Name(), result)
+		if err != nil {
+			failedPackages = append(failedPackages, lib.Name())
+			errs = append(errs, fmt.Errorf("could not prefetch %s: %w", lib.Image(), err))
+			continue
+		}
+		keep = append(keep, libraryID)
+	}
+	// A prefetch interrupted by a shutdown leaves the pins alone.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if len(failedPackages) > 0 {
+		libraries, err := lm.db.ListLibraries()
+		if err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		for libraryID, info := range libraries {
+			if info.Pinned && slices.Contains(failedPackages, info.Package) {
+				keep = append(keep, libraryID)
+			}
+		}
+	}
+
+	unpinned, err := lm.db.UnpinLibrariesExcept(keep)
+	if err != nil {
+		return errors.Join(append(errs, fmt.Errorf("could not unpin libraries: %w", err))...)
+	}
+	for _, libraryID := range unpinned {
+		log.Info("Library is no longer pinned", "library_id", libraryID)
+		lm.cleanupStrategy.ScheduleCleanup(libraryID, lm.tryCleanupLibrary)
+	}
+	return errors.Join(errs...)
+}
+
+// prefetchLibrary resolves the tag of a library, downloads it unless it is already in the store, and pins it. It
+// returns the library ID and the prefetch result.
+func (lm *LibraryManager) prefetchLibrary(ctx context.Context, lib *Library) (string, libraryevents.PrefetchResult, error) {
+	// The tag is always resolved again, which also refreshes the digest cached for the publishes.
+	libraryID, err := lm.cache.FetchDigest(ctx, lib.Image(), true)
+	if err != nil {
+		return "", libraryevents.PrefetchFailed, fmt.Errorf("could not determine library ID: %w", err)
+	}
+
+	// Pin the library under its lock, so that cleanup cannot remove it between
+	// the download and the pin.
+	if err := lm.locker.LockContext(ctx, libraryID); err != nil {
+		return "", libraryevents.PrefetchFailed, err
+	}
+	defer lm.locker.Unlock(libraryID)
+
+	result := libraryevents.PrefetchCached
+	path, err := lm.store.Get(libraryID)
+	if err != nil && !errors.Is(err, ErrItemNotFound) {
+		return "", libraryevents.PrefetchFailed, err
+	}
+	if path == "" {
+		if _, err := lm.downloadLibrary(ctx, libraryID, lib); err != nil {
+			return "", libraryevents.PrefetchFailed, err
+		}
+		result = libraryevents.PrefetchDownloaded
+	}
+	if err := lm.db.PinLibrary(libraryID); err != nil {
+		return "", libraryevents.PrefetchFailed, fmt.Errorf("could not pin library: %w", err)
+	}
+	log.InfoContext(ctx, "Library prefetched", "image", lib.Image(), "library_id", libraryID, "result", result)
+	return libraryID, result, nil
+}
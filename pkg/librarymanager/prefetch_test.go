@@ -0,0 +1,116 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package librarymanager_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/libraryevents"
+	"github.com/Datadog/datadog-csi-driver/pkg/librarymanager"
+	"github.com/Datadog/datadog-csi-driver/pkg/testutil"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLibraryManagerPrefetch(t *testing.T) {
+	localRegistry := testutil.NewLocalRegistry(t)
+	defer localRegistry.Stop()
+	localRegistry.AddImage(t, "testdata/image.tar", "test-image", "latest")
+
+	basePath := t.TempDir()
+	lib, err := librarymanager.NewLibrary("test-image", localRegistry.Registry(t), "latest", false)
+	require.NoError(t, err)
+	newLibraryManager := func(rec *recordingListener, opts ...librarymanager.LibraryManagerOption) *librarymanager.LibraryManager {
+		lm, err := librarymanager.NewLibraryManager(basePath, append([]librarymanager.LibraryManagerOption{
+			librarymanager.WithFilesystem(afero.Afero{Fs: afero.NewOsFs()}),
+			librarymanager.WithDownloader(librarymanager.NewDownloaderWithRoundTripper(localRegistry.GetRoundTripper(t))),
+			librarymanager.WithEventListener(rec),
+		}, opts...)...)
+		require.NoError(t, err)
+		return lm
+	}
+	ctx := context.Background()
+
+	// The library is downloaded in the background at startup.
+	rec := &recordingListener{}
+	lm := newLibraryManager(rec, librarymanager.WithPrefetch(librarymanager.PrefetchConfig{
+		Libraries: []*librarymanager.Library{lib},
+		Interval:  time.Hour,
+	}))
+	var events []recordedEvent
+	require.Eventually(t, func() bool {
+		events = append(events, rec.drain()...)
+		return len(eventsOfKind(events, "prefetched")) > 0
+	}, 10*time.Second, 10*time.Millisecond)
+	prefetched := singleEvent(t, events, "prefetched")
+	require.Equal(t, "test-image", prefetched.library)
+	require.Equal(t, libraryevents.PrefetchDownloaded, prefetched.prefetch)
+	require.Len(t, eventsOfKind(events, "download"), 1)
+
+	// The first volume finds the library in the store.
+	path, err := lm.GetLibraryForVolume(ctx, "vol-1", lib)
+	require.NoError(t, err)
+	events = rec.drain()
+	require.Equal(t, libraryevents.ResolutionCacheHit, singleEvent(t, events, "resolved").result)
+	require.Empty(t, eventsOfKind(events, "download"))
+
+	// The pinned library outlives its last volume, even with immediate cleanups.
+	require.NoError(t, lm.RemoveVolume(ctx, "vol-1"))
+	require.Equal(t, libraryevents.CleanupSkippedPinned, singleEvent(t, rec.drain(), "cleanup").status)
+	require.DirExists(t, path)
+
+	// Prefetching again finds the library in the store.
+	require.NoError(t, lm.Prefetch(ctx))
+	require.Equal(t, libraryevents.PrefetchCached, singleEvent(t, rec.drain(), "prefetched").prefetch)
+	require.NoError(t, lm.Stop())
+
+	// Once the library leaves the prefetch list, it is unpinned and cleaned up.
+	rec = &recordingListener{}
+	lm = newLibraryManager(rec)
+	defer func() { require.NoError(t, lm.Stop()) }()
+	events = rec.drain()
+	require.Empty(t, eventsOfKind(events, "prefetched"))
+	require.Equal(t, libraryevents.CleanupSuccess, singleEvent(t, events, "cleanup").status)
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestLibraryManagerPrefetchKeepsPinsOfUnresolvedLibraries(t *testing.T) {
+	localRegistry := testutil.NewLocalRegistry(t)
+	localRegistry.AddImage(t, "testdata/image.tar", "test-image", "latest")
+
+	basePath := t.TempDir()
+	lib, err := librarymanager.NewLibrary("test-image", localRegistry.Registry(t), "latest", false)
+	require.NoError(t, err)
+	rec := &recordingListener{}
+	lm, err := librarymanager.NewLibraryManager(basePath,
+		librarymanager.WithFilesystem(afero.Afero{Fs: afero.NewOsFs()}),
+		librarymanager.WithDownloader(librarymanager.NewDownloaderWithRoundTripper(localRegistry.GetRoundTripper(t))),
+		librarymanager.WithEventListener(rec),
+		librarymanager.WithPrefetch(librarymanager.PrefetchConfig{Libraries: []*librarymanager.Library{lib}, Interval: time.Hour}),
+	)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, lm.Stop()) }()
+	require.Eventually(t, func() bool {
+		return len(eventsOfKind(rec.drain(), "prefetched")) > 0
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// The registry is gone: the tag cannot be resolved, and the library it
+	// pointed to stays pinned.
+	localRegistry.Stop()
+	ctx := context.Background()
+	require.Error(t, lm.Prefetch(ctx))
+	require.Equal(t, libraryevents.PrefetchFailed, singleEvent(t, rec.drain(), "prefetched").prefetch)
+
+	path, err := lm.GetLibraryForVolume(ctx, "vol-1", lib)
+	require.NoError(t, err)
+	require.NoError(t, lm.RemoveVolume(ctx, "vol-1"))
+	require.Equal(t, libraryevents.CleanupSkippedPinned, singleEvent(t, rec.drain(), "cleanup").status)
+	require.DirExists(t, path)
+}
@@ -0,0 +1,364 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package librarymanager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/libraryevents"
+	"github.com/spf13/afero"
+	"k8s.io/utils/mount"
+)
+
+const (
+	// DefaultMountInfoPath is the mount table read by the reconciler.
+	DefaultMountInfoPath = "/proc/self/mountinfo"
+	// DefaultReconcileGracePeriod is how long a freshly linked volume is left alone by the reconciler. A publish links
+	// the volume before bind mounting it, so a recent link without a mount is most likely a publish in progress.
+	DefaultReconcileGracePeriod = 5 * time.Minute
+)
+
+// ReconcilerConfig configures the reconciliation of volume links against the host mount table.
+type ReconcilerConfig struct {
+	// KubeletRootDir is the kubelet root directory, holding the pods directory.
+	KubeletRootDir string
+	// DriverName is the CSI driver name recorded by kubelet for the volumes of this driver.
+	DriverName string
+	// Interval is the delay between two reconciliations. The reconciliation only runs at startup when it is zero.
+	Interval time.Duration
+	// GracePeriod is how long a freshly linked volume is left alone. Defaults to DefaultReconcileGracePeriod.
+	GracePeriod time.Duration
+	// MountInfoPath is the mount table to read. Defaults to DefaultMountInfoPath.
+	MountInfoPath string
+	// OnPublicationRemoved is called with every publication record removed by the reconciliation, e.g. to stop
+	// tracking the target of the volume. It may be nil.
+	OnPublicationRemoved func(volumeID string, publication Publication)
+}
+
+// volData is the subset of the vol_data.json file written by kubelet next to the target of every CSI volume.
+type volData struct {
+	DriverName   string `json:"driverName"`
+	VolumeHandle string `json:"volumeHandle"`
+}
+
+// reconciler runs the reconciliation in a background loop.
+type reconciler struct {
+	config ReconcilerConfig
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+func newReconciler(config ReconcilerConfig) *reconciler {
+	if config.GracePeriod <= 0 {
+		config.GracePeriod = DefaultReconcileGracePeriod
+	}
+	if config.MountInfoPath == "" {
+		config.MountInfoPath = DefaultMountInfoPath
+	}
+	return &reconciler{
+		config: config,
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+}
+
+// start runs reconcile on every interval until stop is called.
+func (r *reconciler) start(reconcile func() error) {
+	if r.config.Interval <= 0 {
+		close(r.doneCh)
+		return
+	}
+	go func() {
+		defer close(r.doneCh)
+		ticker := time.NewTicker(r.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := reconcile(); err != nil {
+					log.Error("could not reconcile volumes with the host mount table", "error", err)
+				}
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops the background loop and waits for a running reconciliation to finish.
+func (r *reconciler) stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+		<-r.doneCh
+	})
+}
+
+// Reconcile repairs the state left behind when kubelet tore down pods while the driver was not running, so
+// NodeUnpublishVolume was never called:
+//   - volumes linked in the database whose target is no longer mounted are unlinked, which lets their library be
+//     cleaned up;
+//   - bind mounts of a stored library whose volume is unknown to the database are unmounted;
+//   - publication records whose target is no longer a volume target known to kubelet are removed.
+//
+// Nothing is unlinked when the kubelet pods directory is missing, or when it holds no volume of the driver while the
+// database has volumes to check: an error is returned instead.
+//
+// It is a no-op when the reconciler is not enabled.
+func (lm *LibraryManager) Reconcile() error {
+	if lm.reconciler == nil {
+		return nil
+	}
+	config := lm.reconciler.config
+
+	// The mount table is read before the database: a publish links the volume
+	// before mounting it, so every library mount seen here has its link in
+	// the snapshot read below unless the volume was unpublished meanwhile.
+	mountInfos, err := mount.ParseMountInfo(config.MountInfoPath)
+	if err != nil {
+		return fmt.Errorf("could not read mount table %s: %w", config.MountInfoPath, err)
+	}
+	mountPoints := make(map[string]bool, len(mountInfos))
+	for _, mi := range mountInfos {
+		mountPoints[mi.MountPoint] = true
+	}
+
+	targets, err := lm.csiVolumeTargets(config)
+	if err != nil {
+		return err
+	}
+
+	volumes, err := lm.db.ListVolumes()
+	if err != nil {
+		return fmt.Errorf("could not list volumes: %w", err)
+	}
+
+	publications, err := lm.db.ListPublications()
+	if err != nil {
+		return fmt.Errorf("could not list publications: %w", err)
+	}
+
+	var candidates []string
+	for volumeID, info := range volumes {
+		if time.Since(info.CreatedAt) >= config.GracePeriod {
+			candidates = append(candidates, volumeID)
+		}
+	}
+	var stalePublications []string
+	for volumeID, publication := range publications {
+		if _, known := targets[publication.TargetPath]; !known && time.Since(publication.PublishedAt) >= config.GracePeriod {
+			stalePublications = append(stalePublications, volumeID)
+		}
+	}
+	// Without any target of this driver, the kubelet directory is most likely
+	// not the one the volumes are published under, e.g. a kubelet root moved
+	// by the distribution or not mounted in the driver container. Unlinking
+	// would delete libraries still mounted by running pods.
+	if len(targets) == 0 && len(candidates)+len(stalePublications) > 0 {
+		return fmt.Errorf("no volume of driver %s found under %s while %d volumes are linked and %d are published, check the kubelet root directory",
+			config.DriverName, config.KubeletRootDir, len(candidates), len(stalePublications))
+	}
+
+	var errs []error
+	for _, volumeID := range candidates {
+		if isVolumeMounted(targets, mountPoints, volumeID) {
+			continue
+		}
+		if err := lm.unlinkStaleVolume(volumeID, volumes[volumeID]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, volumeID := range stalePublications {
+		if err := lm.removeStalePublication(volumeID, publications[volumeID], config.OnPublicationRemoved); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if lm.mounter == nil {
+		return errors.Join(errs...)
+	}
+	for _, mi := range mountInfos {
+		volumeID, ours := targets[mi.MountPoint]
+		if !ours {
+			continue
+		}
+		libraryID := lm.storedLibraryID(mi.Root)
+		if libraryID == "" {
+			// Not a library mount, e.g. a socket volume.
+			continue
+		}
+		if volumeID != "" {
+			if _, tracked := volumes[volumeID]; tracked {
+				continue
+			}
+		}
+		if err := lm.unmountLeakedMount(volumeID, libraryID, mi.MountPoint); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// csiVolumeTargets maps the target path of every CSI volume kubelet knows about on this node to its volume ID, as
+// recorded in the vol_data.json file kubelet writes next to the target. Targets of other drivers are left out; the
+// volume ID is empty when the vol_data.json file is missing, since the target may still be a mount of this driver. It
+// fails when the pods directory does not exist.
+func (lm *LibraryManager) csiVolumeTargets(config ReconcilerConfig) (map[string]string, error) {
+	// A missing pods directory would look like a node without any volume.
+	podsDir := filepath.Join(config.KubeletRootDir, "pods")
+	exists, err := lm.fs.DirExists(podsDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not check kubelet pods directory %s: %w", podsDir, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("kubelet pods directory %s does not exist, check the kubelet root directory", podsDir)
+	}
+
+	volumeDirs, err := afero.Glob(lm.fs, filepath.Join(config.KubeletRootDir, "pods", "*", "volumes", "kubernetes.io~csi", "*"))
+	if err != nil {
+		return nil, fmt.Errorf("could not list kubelet CSI volumes: %w", err)
+	}
+
+	targets := make(map[string]string, len(volumeDirs))
+	for _, volumeDir := range volumeDirs {
+		target := filepath.Join(volumeDir, "mount")
+		raw, err := lm.fs.ReadFile(filepath.Join(volumeDir, "vol_data.json"))
+		if err != nil {
+			targets[target] = ""
+			continue
+		}
+		var data volData
+		if err := json.Unmarshal(raw, &data); err != nil {
+			log.Warn("could not parse kubelet volume data", "path", volumeDir, "error", err)
+			targets[target] = ""
+			continue
+		}
+		if data.DriverName != config.DriverName {
+			continue
+		}
+		targets[target] = data.VolumeHandle
+	}
+	return targets, nil
+}
+
+// isVolumeMounted returns whether any target of the volume is mounted.
+func isVolumeMounted(targets map[string]string, mountPoints map[string]bool, volumeID string) bool {
+	for target, id := range targets {
+		if id == volumeID && mountPoints[target] {
+			return true
+		}
+	}
+	return false
+}
+
+// storedLibraryID returns the ID of the stored library a mount root points into, or an empty string when the root
+// is not inside the store. Mount roots are relative to the filesystem holding the store, so the store directory is
+// looked up by path segment rather than by prefix.
+func (lm *LibraryManager) storedLibraryID(root string) string {
+	segments := strings.Split(filepath.Clean(root), string(filepath.Separator))
+	for i := 0; i+1 < len(segments); i++ {
+		if segments[i] != StoreDirectory || segments[i+1] == "" {
+			continue
+		}
+		if exists, err := lm.store.Exists(segments[i+1]); err == nil && exists {
+			return segments[i+1]
+		}
+	}
+	return ""
+}
+
+// unlinkStaleVolume unlinks a volume whose target is no longer mounted. The record is checked again under the volume
+// lock so a volume re-published since the reconciliation started is left alone.
+func (lm *LibraryManager) unlinkStaleVolume(volumeID string, info VolumeInfo) error {
+	lm.locker.Lock(volumeLockKey(volumeID))
+	defer lm.locker.Unlock(volumeLockKey(volumeID))
+
+	current, found, err := lm.db.GetVolume(volumeID)
+	if err != nil {
+		lm.listener.OnVolumeReconciled("", libraryevents.ReconcileFailed)
+		return fmt.Errorf("could not get volume %s: %w", volumeID, err)
+	}
+	if !found || !slices.Equal(current.LibraryIDs, info.LibraryIDs) || !current.CreatedAt.Equal(info.CreatedAt) {
+		return nil
+	}
+
+	log.Info("Unlinking volume whose target is no longer mounted", "volume_id", volumeID, "library_id", strings.Join(info.LibraryIDs, ","))
+	libraries, err := lm.removeVolumeLocked(volumeID)
+	if err != nil {
+		lm.listener.OnVolumeReconciled("", libraryevents.ReconcileFailed)
+		return err
+	}
+	for _, library := range libraries {
+		lm.listener.OnVolumeReconciled(library, libraryevents.ReconcileUnlinked)
+	}
+	return nil
+}
+
+// removeStalePublication removes the publication record of a volume whose target is no longer known to kubelet. The
+// record is checked again under the volume lock so a volume re-published since the reconciliation started is left
+// alone.
+func (lm *LibraryManager) removeStalePublication(volumeID string, publication Publication, onRemoved func(string, Publication)) error {
+	lm.locker.Lock(volumeLockKey(volumeID))
+	defer lm.locker.Unlock(volumeLockKey(volumeID))
+
+	current, found, err := lm.db.GetPublication(volumeID)
+	if err != nil {
+		return fmt.Errorf("could not get publication of volume %s: %w", volumeID, err)
+	}
+	if !found || current.TargetPath != publication.TargetPath || !current.PublishedAt.Equal(publication.PublishedAt) {
+		return nil
+	}
+
+	log.Info("Removing publication whose target is no longer known to kubelet", "volume_id", volumeID,
+		"volume_type", publication.VolumeType, "target_path", publication.TargetPath)
+	if err := lm.db.RemovePublication(volumeID); err != nil {
+		return fmt.Errorf("could not remove publication of volume %s: %w", volumeID, err)
+	}
+	if onRemoved != nil {
+		onRemoved(volumeID, publication)
+	}
+	return nil
+}
+
+// unmountLeakedMount unmounts a bind mount of a stored library whose volume is unknown to the database. The database
+// is checked again under the volume lock so a volume published since the reconciliation started is left alone.
+func (lm *LibraryManager) unmountLeakedMount(volumeID, libraryID, target string) error {
+	if volumeID != "" {
+		lm.locker.Lock(volumeLockKey(volumeID))
+		defer lm.locker.Unlock(volumeLockKey(volumeID))
+
+		_, found, err := lm.db.GetVolume(volumeID)
+		if err != nil {
+			lm.listener.OnVolumeReconciled("", libraryevents.ReconcileFailed)
+			return fmt.Errorf("could not get volume %s: %w", volumeID, err)
+		}
+		if found {
+			return nil
+		}
+	}
+
+	var library string
+	if info, _, err := lm.db.GetLibrary(libraryID); err == nil {
+		library = info.Package
+	}
+
+	log.Info("Unmounting leaked library mount", "volume_id", volumeID, "library_id", libraryID, "target", target)
+	if err := lm.mounter.Unmount(target); err != nil {
+		lm.listener.OnVolumeReconciled(library, libraryevents.ReconcileFailed)
+		return fmt.Errorf("could not unmount leaked mount %s: %w", target, err)
+	}
+	lm.listener.OnVolumeReconciled(library, libraryevents.ReconcileUnmounted)
+	return nil
+}
@@ -0,0 +1,340 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package librarymanager_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/libraryevents"
+	"github.com/Datadog/datadog-csi-driver/pkg/librarymanager"
+	"github.com/Datadog/datadog-csi-driver/pkg/testutil"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/mount"
+)
+
+const testDriverName = "k8s.csi.datadoghq.com"
+
+// reconcileHost is a fake node: a kubelet root directory holding CSI volume
+// directories, and a mountinfo file listing the mounts of those volumes.
+type reconcileHost struct {
+	kubeletRootDir string
+	mountInfoPath  string
+	mounts         []string
+}
+
+func newReconcileHost(t *testing.T) *reconcileHost {
+	t.Helper()
+	dir := t.TempDir()
+	h := &reconcileHost{
+		kubeletRootDir: filepath.Join(dir, "kubelet"),
+		mountInfoPath:  filepath.Join(dir, "mountinfo"),
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(h.kubeletRootDir, "pods"), 0o755))
+	h.writeMountInfo(t)
+	return h
+}
+
+// addVolume creates the kubelet volume directory of a pod and returns its
+// target path. The vol_data.json file is only written when driverName is set.
+func (h *reconcileHost) addVolume(t *testing.T, podUID, driverName, volumeID string) string {
+	t.Helper()
+	volumeDir := filepath.Join(h.kubeletRootDir, "pods", podUID, "volumes", "kubernetes.io~csi", "datadog")
+	require.NoError(t, os.MkdirAll(filepath.Join(volumeDir, "mount"), 0o755))
+	if driverName != "" {
+		raw, err := json.Marshal(map[string]string{"driverName": driverName, "volumeHandle": volumeID})
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(volumeDir, "vol_data.json"), raw, 0o644))
+	}
+	return filepath.Join(volumeDir, "mount")
+}
+
+// mount records a bind mount of root on target in the mountinfo file.
+func (h *reconcileHost) mount(t *testing.T, root, target string) {
+	t.Helper()
+	h.mounts = append(h.mounts, fmt.Sprintf("%d 1 8:1 %s %s rw,relatime shared:1 - ext4 /dev/sda1 rw", 100+len(h.mounts), root, target))
+	h.writeMountInfo(t)
+}
+
+// unmount removes the mounts of target from the mountinfo file.
+func (h *reconcileHost) unmount(t *testing.T, target string) {
+	t.Helper()
+	var mounts []string
+	for _, m := range h.mounts {
+		if strings.Fields(m)[4] != target {
+			mounts = append(mounts, m)
+		}
+	}
+	h.mounts = mounts
+	h.writeMountInfo(t)
+}
+
+func (h *reconcileHost) writeMountInfo(t *testing.T) {
+	t.Helper()
+	lines := append([]string{"1 0 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw"}, h.mounts...)
+	require.NoError(t, os.WriteFile(h.mountInfoPath, []byte(strings.Join(lines, "\n")+"\n"), 0o644))
+}
+
+func (h *reconcileHost) config(gracePeriod time.Duration) librarymanager.ReconcilerConfig {
+	return librarymanager.ReconcilerConfig{
+		KubeletRootDir: h.kubeletRootDir,
+		DriverName:     testDriverName,
+		GracePeriod:    gracePeriod,
+		MountInfoPath:  h.mountInfoPath,
+	}
+}
+
+func TestLibraryManagerReconcile(t *testing.T) {
+	localRegistry := testutil.NewLocalRegistry(t)
+	defer localRegistry.Stop()
+	localRegistry.AddImage(t, "testdata/image.tar", "test-image", "latest")
+
+	host := newReconcileHost(t)
+	basePath := t.TempDir()
+	ctx := context.Background()
+	mounter := mount.NewFakeMounter(nil)
+	rec := &recordingListener{}
+
+	lm, err := librarymanager.NewLibraryManager(basePath,
+		librarymanager.WithFilesystem(afero.Afero{Fs: afero.NewOsFs()}),
+		librarymanager.WithDownloader(librarymanager.NewDownloaderWithRoundTripper(localRegistry.GetRoundTripper(t))),
+		librarymanager.WithEventListener(rec),
+		librarymanager.WithMounter(mounter),
+		librarymanager.WithReconciler(host.config(time.Nanosecond)),
+	)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, lm.Stop()) }()
+
+	lib, err := librarymanager.NewLibrary("test-image", localRegistry.Registry(t), "latest", false)
+	require.NoError(t, err)
+
+	// A live volume: its target is mounted.
+	livePath, err := lm.GetLibraryForVolume(ctx, "live-volume", lib)
+	require.NoError(t, err)
+	host.mount(t, livePath, host.addVolume(t, "live-pod", testDriverName, "live-volume"))
+
+	// A stale volume: the pod directory is still there but the target was unmounted.
+	_, err = lm.GetLibraryForVolume(ctx, "stale-volume", lib)
+	require.NoError(t, err)
+	host.addVolume(t, "stale-pod", testDriverName, "stale-volume")
+
+	// A stale volume whose pod directory was removed.
+	_, err = lm.GetLibraryForVolume(ctx, "removed-volume", lib)
+	require.NoError(t, err)
+
+	// A leaked library mount whose volume is unknown to the database.
+	leakedTarget := host.addVolume(t, "leaked-pod", testDriverName, "leaked-volume")
+	host.mount(t, filepath.Join(livePath, "datadog-init", "package"), leakedTarget)
+
+	// A leaked library mount whose vol_data.json file is already gone.
+	orphanTarget := host.addVolume(t, "orphan-pod", "", "")
+	host.mount(t, livePath, orphanTarget)
+
+	// Mounts that are not library mounts of this driver are left alone.
+	host.mount(t, "/var/run/datadog/apm.socket", host.addVolume(t, "socket-pod", testDriverName, "socket-volume"))
+	host.mount(t, livePath, host.addVolume(t, "other-driver-pod", "other.csi.k8s.io", "other-volume"))
+
+	rec.drain()
+	require.NoError(t, lm.Reconcile())
+	events := rec.drain()
+
+	for _, volumeID := range []string{"stale-volume", "removed-volume"} {
+		hasVolume, err := lm.HasVolume(volumeID)
+		require.NoError(t, err)
+		require.False(t, hasVolume, "%s should be unlinked", volumeID)
+	}
+	hasVolume, err := lm.HasVolume("live-volume")
+	require.NoError(t, err)
+	require.True(t, hasVolume, "a mounted volume must stay linked")
+	storePath, err := lm.GetVolumePath("live-volume")
+	require.NoError(t, err)
+	require.Equal(t, livePath, storePath, "the library of the live volume must stay in the store")
+
+	var unmounted []string
+	for _, action := range mounter.GetLog() {
+		require.Equal(t, mount.FakeActionUnmount, action.Action)
+		unmounted = append(unmounted, action.Target)
+	}
+	require.ElementsMatch(t, []string{leakedTarget, orphanTarget}, unmounted)
+
+	var actions []libraryevents.ReconcileAction
+	for _, e := range eventsOfKind(events, "reconciled") {
+		require.Equal(t, "test-image", e.library)
+		actions = append(actions, e.action)
+	}
+	require.ElementsMatch(t, []libraryevents.ReconcileAction{
+		libraryevents.ReconcileUnlinked,
+		libraryevents.ReconcileUnlinked,
+		libraryevents.ReconcileUnmounted,
+		libraryevents.ReconcileUnmounted,
+	}, actions)
+	require.Len(t, eventsOfKind(events, "unlinked"), 2)
+
+	// Reconciling again once the unmounts are reflected in the mount table is a no-op.
+	for _, target := range unmounted {
+		host.unmount(t, target)
+	}
+	require.NoError(t, lm.Reconcile())
+	require.Empty(t, eventsOfKind(rec.drain(), "reconciled"))
+}
+
+func TestLibraryManagerReconcileSkipsRecentVolumes(t *testing.T) {
+	localRegistry := testutil.NewLocalRegistry(t)
+	defer localRegistry.Stop()
+	localRegistry.AddImage(t, "testdata/image.tar", "test-image", "latest")
+
+	host := newReconcileHost(t)
+	lm, err := librarymanager.NewLibraryManager(t.TempDir(),
+		librarymanager.WithFilesystem(afero.Afero{Fs: afero.NewOsFs()}),
+		librarymanager.WithDownloader(librarymanager.NewDownloaderWithRoundTripper(localRegistry.GetRoundTripper(t))),
+		librarymanager.WithReconciler(host.config(time.Hour)),
+	)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, lm.Stop()) }()
+
+	lib, err := librarymanager.NewLibrary("test-image", localRegistry.Registry(t), "latest", false)
+	require.NoError(t, err)
+	// Linked but not mounted yet, like a publish in progress.
+	_, err = lm.GetLibraryForVolume(context.Background(), "new-volume", lib)
+	require.NoError(t, err)
+
+	require.NoError(t, lm.Reconcile())
+
+	hasVolume, err := lm.HasVolume("new-volume")
+	require.NoError(t, err)
+	require.True(t, hasVolume, "a volume within the grace period must stay linked")
+}
+
+func TestLibraryManagerReconcilesAtStartup(t *testing.T) {
+	host := newReconcileHost(t)
+	basePath := t.TempDir()
+
+	// Seed a link left behind by a previous driver run.
+	dbDir := filepath.Join(basePath, librarymanager.DatabaseDirectory)
+	require.NoError(t, os.MkdirAll(dbDir, 0o755))
+	db, err := librarymanager.NewDatabase(dbDir)
+	require.NoError(t, err)
+	require.NoError(t, db.AddLibrary("test-library-id", "test-image", 42, ""))
+	link(t, db, "test-library-id", "leaked-volume")
+	require.NoError(t, db.Close())
+	// Another volume of the driver is still published on the node.
+	host.mount(t, "/var/run/datadog/apm.socket", host.addVolume(t, "socket-pod", testDriverName, "socket-volume"))
+
+	rec := &recordingListener{}
+	lm, err := librarymanager.NewLibraryManager(basePath,
+		librarymanager.WithFilesystem(afero.Afero{Fs: afero.NewOsFs()}),
+		librarymanager.WithEventListener(rec),
+		librarymanager.WithReconciler(host.config(time.Nanosecond)),
+	)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, lm.Stop()) }()
+
+	hasVolume, err := lm.HasVolume("leaked-volume")
+	require.NoError(t, err)
+	require.False(t, hasVolume, "the leaked link should be released at startup")
+	reconciled := singleEvent(t, rec.drain(), "reconciled")
+	require.Equal(t, "test-image", reconciled.library)
+	require.Equal(t, libraryevents.ReconcileUnlinked, reconciled.action)
+}
+
+func TestLibraryManagerReconcileWithoutKubeletVolumes(t *testing.T) {
+	tests := map[string]struct {
+		setup         func(t *testing.T, host *reconcileHost)
+		expectedError string
+	}{
+		"missing pods directory": {
+			setup: func(t *testing.T, host *reconcileHost) {
+				require.NoError(t, os.RemoveAll(filepath.Join(host.kubeletRootDir, "pods")))
+			},
+			expectedError: "does not exist",
+		},
+		"no volume of the driver": {
+			setup: func(t *testing.T, host *reconcileHost) {
+				host.mount(t, "/data", host.addVolume(t, "other-driver-pod", "other.csi.k8s.io", "other-volume"))
+			},
+			expectedError: "no volume of driver",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			host := newReconcileHost(t)
+			tc.setup(t, host)
+			basePath := t.TempDir()
+
+			dbDir := filepath.Join(basePath, librarymanager.DatabaseDirectory)
+			require.NoError(t, os.MkdirAll(dbDir, 0o755))
+			db, err := librarymanager.NewDatabase(dbDir)
+			require.NoError(t, err)
+			require.NoError(t, db.AddLibrary("test-library-id", "test-image", 42, ""))
+			link(t, db, "test-library-id", "running-volume")
+			require.NoError(t, db.Close())
+
+			rec := &recordingListener{}
+			lm, err := librarymanager.NewLibraryManager(basePath,
+				librarymanager.WithFilesystem(afero.Afero{Fs: afero.NewOsFs()}),
+				librarymanager.WithEventListener(rec),
+				librarymanager.WithReconciler(host.config(time.Nanosecond)),
+			)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, lm.Stop()) }()
+
+			require.ErrorContains(t, lm.Reconcile(), tc.expectedError)
+			hasVolume, err := lm.HasVolume("running-volume")
+			require.NoError(t, err)
+			require.True(t, hasVolume, "volumes must stay linked when the kubelet volumes cannot be found")
+			require.Empty(t, eventsOfKind(rec.drain(), "reconciled"))
+		})
+	}
+}
+
+func TestLibraryManagerReconcileRemovesStalePublications(t *testing.T) {
+	host := newReconcileHost(t)
+	liveTarget := host.addVolume(t, "live-pod", testDriverName, "live-volume")
+	host.mount(t, "/var/run/datadog/apm.socket", liveTarget)
+
+	config := host.config(time.Hour)
+	var removed []string
+	config.OnPublicationRemoved = func(volumeID string, publication librarymanager.Publication) {
+		removed = append(removed, volumeID+" "+publication.TargetPath)
+	}
+	lm, err := librarymanager.NewLibraryManager(t.TempDir(),
+		librarymanager.WithFilesystem(afero.Afero{Fs: afero.NewOsFs()}),
+		librarymanager.WithReconciler(config),
+	)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, lm.Stop()) }()
+
+	old := time.Now().Add(-2 * time.Hour)
+	staleTarget := filepath.Join(host.kubeletRootDir, "pods", "deleted-pod", "volumes", "kubernetes.io~csi", "datadog", "mount")
+	publications := map[string]librarymanager.Publication{
+		// Its target is still known to kubelet.
+		"live-volume": {VolumeType: "APMSocket", TargetPath: liveTarget, PublishedAt: old},
+		// Its pod was deleted while the driver was not running.
+		"stale-volume": {VolumeType: "APMSocket", TargetPath: staleTarget, PublishedAt: old},
+		// Published within the grace period, kubelet may not have written its directory yet.
+		"new-volume": {VolumeType: "APMSocket", TargetPath: staleTarget, PublishedAt: time.Now()},
+	}
+	for volumeID, publication := range publications {
+		require.NoError(t, lm.RecordPublication(volumeID, publication))
+	}
+
+	require.NoError(t, lm.Reconcile())
+
+	remaining, err := lm.ListPublications()
+	require.NoError(t, err)
+	require.Contains(t, remaining, "live-volume")
+	require.Contains(t, remaining, "new-volume")
+	require.NotContains(t, remaining, "stale-volume")
+	require.Equal(t, []string{"stale-volume " + staleTarget}, removed)
+}
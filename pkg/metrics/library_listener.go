@@ -71,6 +71,26 @@ func (*LibraryListener) OnVolumeUnlinked(library string, volumeLinks int) {
 	SetLibraryVolumeLinksForLibrary(library, volumeLinks)
 }
 
+// OnVolumeReconciled publishes the reconciliation action counter.
+func (*LibraryListener) OnVolumeReconciled(library string, action libraryevents.ReconcileAction) {
+	RecordVolumeReconciliation(library, action)
+}
+
+// OnDownloadQueueDepth updates the download queue depth gauge.
+func (*LibraryListener) OnDownloadQueueDepth(depth int) {
+	SetLibraryDownloadQueueDepth(depth)
+}
+
+// OnDownloadDequeued observes the download queue wait histogram.
+func (*LibraryListener) OnDownloadDequeued(library string, wait time.Duration) {
+	ObserveLibraryDownloadQueueWait(library, wait)
+}
+
+// OnLibraryPrefetched publishes the prefetch outcome counter.
+func (*LibraryListener) OnLibraryPrefetched(library string, result libraryevents.PrefetchResult) {
+	RecordLibraryPrefetch(library, result)
+}
+
 // OnSnapshot seeds the per-library gauges from the persisted state. Reset
 // is used so libraries that disappeared between two driver runs are not
 // stuck reporting stale values.
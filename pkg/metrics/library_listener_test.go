@@ -96,3 +96,39 @@ func TestLibraryListenerOnSnapshotSeedsGauges(t *testing.T) {
 	require.Equal(t, 2, testutil.CollectAndCount(librariesCached), "stale series should be evicted")
 	require.Equal(t, 1, testutil.CollectAndCount(libraryVolumeLinks), "stale series should be evicted")
 }
+
+func TestLibraryListenerOnVolumeReconciledCountsActions(t *testing.T) {
+	volumeReconciliations.Reset()
+
+	l := NewLibraryListener()
+	l.OnVolumeReconciled("dd-lib-java-init", libraryevents.ReconcileUnlinked)
+	l.OnVolumeReconciled("dd-lib-java-init", libraryevents.ReconcileUnlinked)
+	l.OnVolumeReconciled("", libraryevents.ReconcileUnmounted)
+
+	require.Equal(t, float64(2), testutil.ToFloat64(volumeReconciliations.WithLabelValues("dd-lib-java-init", string(libraryevents.ReconcileUnlinked))))
+	require.Equal(t, float64(1), testutil.ToFloat64(volumeReconciliations.WithLabelValues("", string(libraryevents.ReconcileUnmounted))))
+}
+
+func TestLibraryListenerPublishesDownloadQueue(t *testing.T) {
+	libraryDownloadQueueDepth.Reset()
+	libraryDownloadQueueWait.Reset()
+
+	l := NewLibraryListener()
+	l.OnDownloadQueueDepth(3)
+	l.OnDownloadDequeued("dd-lib-java-init", 2*time.Second)
+
+	require.Equal(t, float64(3), testutil.ToFloat64(libraryDownloadQueueDepth.WithLabelValues()))
+	require.Equal(t, 1, testutil.CollectAndCount(libraryDownloadQueueWait))
+}
+
+func TestLibraryListenerOnLibraryPrefetchedCountsResults(t *testing.T) {
+	libraryPrefetches.Reset()
+
+	l := NewLibraryListener()
+	l.OnLibraryPrefetched("apm-inject", libraryevents.PrefetchDownloaded)
+	l.OnLibraryPrefetched("apm-inject", libraryevents.PrefetchCached)
+	l.OnLibraryPrefetched("apm-inject", libraryevents.PrefetchCached)
+
+	require.Equal(t, float64(1), testutil.ToFloat64(libraryPrefetches.WithLabelValues("apm-inject", string(libraryevents.PrefetchDownloaded))))
+	require.Equal(t, float64(2), testutil.ToFloat64(libraryPrefetches.WithLabelValues("apm-inject", string(libraryevents.PrefetchCached))))
+}
@@ -28,6 +28,22 @@ const (
 	StatusFailed = "failed"
 	// StatusUnsupported represents an operation not supported by any publisher
 	StatusUnsupported = "unsupported"
+	// StatusInProgress represents an operation still running in the background, to be retried
+	StatusInProgress = "in_progress"
+	// StatusDenied represents an operation denied by the admission policy
+	StatusDenied = "denied"
+)
+
+// AdmissionDecision represents the outcome of the evaluation of the admission policy
+type AdmissionDecision string
+
+const (
+	// AdmissionAllowed means every rule of the policy admitted the volume
+	AdmissionAllowed AdmissionDecision = "allowed"
+	// AdmissionDenied means a rule of the policy denied the volume
+	AdmissionDenied AdmissionDecision = "denied"
+	// AdmissionError means the policy could not be evaluated, e.g. the namespace labels could not be fetched
+	AdmissionError AdmissionDecision = "error"
 )
 
 // downloadDurationBuckets covers the range from very fast cached/local downloads
@@ -60,13 +76,14 @@ var nodeVolumeMountAttempts = newCounterVec(
 	"node_publish_volume_attempts",
 	"Counts the number of publish volume requests received by the csi node server",
 	"type",
-	"path",
+	"namespace",
 	"status",
 )
 
 var nodeVolumeUnmountAttempts = newCounterVec(
 	"node_unpublish_volume_attempts",
 	"Counts the number of unpublish volume requests received by the csi node server",
+	"type",
 	"status",
 )
 
@@ -111,6 +128,66 @@ var libraryVolumeLinks = newGaugeVec(
 	"library",
 )
 
+var volumeReconciliations = newCounterVec(
+	"volume_reconciliations_total",
+	"Counts the corrective actions taken by the reconciliation of volume links against the host mount table",
+	"library",
+	"action",
+)
+
+var libraryDownloadQueueDepth = newGaugeVec(
+	"library_download_queue_depth",
+	"Number of library downloads waiting for a free download slot",
+)
+
+var libraryDownloadQueueWait = newHistogramVec(
+	"library_download_queue_wait_seconds",
+	"Time a library download waited for a free download slot",
+	downloadDurationBuckets,
+	"library",
+)
+
+var libraryPrefetches = newCounterVec(
+	"library_prefetches_total",
+	"Counts the outcome of prefetching the libraries pinned in the store",
+	"library",
+	"result",
+)
+
+var admissionDecisions = newCounterVec(
+	"volume_admission_decisions_total",
+	"Counts the decisions of the volume admission policy evaluated on publish",
+	"type",
+	"namespace",
+	"decision",
+	"rule",
+)
+
+var legacySchemaPublishes = newCounterVec(
+	"legacy_schema_publishes_total",
+	"Counts the publishes of volumes using the deprecated mode/path schema",
+	"namespace",
+	"mode",
+)
+
+var socketRebinds = newCounterVec(
+	"socket_rebinds_total",
+	"Counts the re-binds of socket file volumes to the socket recreated by the agent",
+	"type",
+	"status",
+)
+
+var driverReady = newGaugeVec(
+	"ready",
+	"Whether all the driver self-checks passed on their last run (1) or not (0)",
+)
+
+var selfCheckStatus = newGaugeVec(
+	"self_check_status",
+	"Outcome of the last run of each driver self-check, 1 when it passed and 0 when it failed",
+	"check",
+)
+
 func init() {
 	prometheus.MustRegister(nodeVolumeMountAttempts)
 	prometheus.MustRegister(nodeVolumeUnmountAttempts)
@@ -120,16 +197,28 @@ func init() {
 	prometheus.MustRegister(librariesCached)
 	prometheus.MustRegister(librariesCachedBytes)
 	prometheus.MustRegister(libraryVolumeLinks)
+	prometheus.MustRegister(volumeReconciliations)
+	prometheus.MustRegister(libraryDownloadQueueDepth)
+	prometheus.MustRegister(libraryDownloadQueueWait)
+	prometheus.MustRegister(libraryPrefetches)
+	prometheus.MustRegister(admissionDecisions)
+	prometheus.MustRegister(legacySchemaPublishes)
+	prometheus.MustRegister(socketRebinds)
+	prometheus.MustRegister(driverReady)
+	prometheus.MustRegister(selfCheckStatus)
 }
 
-// RecordVolumeMountAttempt records a volume mount attempt
-func RecordVolumeMountAttempt(volumeType, path string, status Status) {
-	nodeVolumeMountAttempts.WithLabelValues(volumeType, path, string(status)).Inc()
+// RecordVolumeMountAttempt records a volume mount attempt.
+// The namespace label is the namespace of the pod the volume is published for; it is
+// empty when the CSIDriver object does not set podInfoOnMount.
+func RecordVolumeMountAttempt(volumeType, namespace string, status Status) {
+	nodeVolumeMountAttempts.WithLabelValues(volumeType, namespace, string(status)).Inc()
 }
 
-// RecordVolumeUnMountAttempt records a volume unmount attempt
-func RecordVolumeUnMountAttempt(status Status) {
-	nodeVolumeUnmountAttempts.WithLabelValues(string(status)).Inc()
+// RecordVolumeUnMountAttempt records a volume unmount attempt.
+// The type label is empty when the volume was published without a publication record.
+func RecordVolumeUnMountAttempt(volumeType string, status Status) {
+	nodeVolumeUnmountAttempts.WithLabelValues(volumeType, string(status)).Inc()
 }
 
 // RecordLibraryResolution records the outcome of an attempt to resolve a library for a volume.
@@ -169,3 +258,60 @@ func SetLibrariesCachedBytesForLibrary(library string, bytes int64) {
 func SetLibraryVolumeLinksForLibrary(library string, links int) {
 	libraryVolumeLinks.WithLabelValues(library).Set(float64(links))
 }
+
+// RecordVolumeReconciliation records a corrective action taken by the volume reconciler.
+// The library label is the package name; it may be empty for legacy entries or
+// when the library of a leaked mount has no record.
+func RecordVolumeReconciliation(library string, action libraryevents.ReconcileAction) {
+	volumeReconciliations.WithLabelValues(library, string(action)).Inc()
+}
+
+// SetLibraryDownloadQueueDepth sets the number of library downloads waiting for a free download slot.
+func SetLibraryDownloadQueueDepth(depth int) {
+	libraryDownloadQueueDepth.WithLabelValues().Set(float64(depth))
+}
+
+// ObserveLibraryDownloadQueueWait records the time a library download waited for a free download slot.
+func ObserveLibraryDownloadQueueWait(library string, d time.Duration) {
+	libraryDownloadQueueWait.WithLabelValues(library).Observe(d.Seconds())
+}
+
+// RecordLibraryPrefetch records the outcome of prefetching a pinned library.
+func RecordLibraryPrefetch(library string, result libraryevents.PrefetchResult) {
+	libraryPrefetches.WithLabelValues(library, string(result)).Inc()
+}
+
+// RecordAdmissionDecision records a decision of the volume admission policy.
+// The rule label is the name of the rule that denied the volume; it is empty
+// when the volume was admitted or when the policy could not be evaluated.
+func RecordAdmissionDecision(volumeType, namespace string, decision AdmissionDecision, rule string) {
+	admissionDecisions.WithLabelValues(volumeType, namespace, string(decision), rule).Inc()
+}
+
+// RecordLegacySchemaPublish records a publish of a volume using the deprecated mode/path schema,
+// rejected or not. The namespace label is empty when the CSIDriver object does not set podInfoOnMount.
+func RecordLegacySchemaPublish(namespace, mode string) {
+	legacySchemaPublishes.WithLabelValues(namespace, mode).Inc()
+}
+
+// RecordSocketRebind records a re-bind of a socket file volume to the socket recreated by the agent.
+func RecordSocketRebind(volumeType string, status Status) {
+	socketRebinds.WithLabelValues(volumeType, string(status)).Inc()
+}
+
+// SetReady records whether the driver reports itself as ready through the Probe RPC.
+func SetReady(ready bool) {
+	driverReady.WithLabelValues().Set(boolToFloat(ready))
+}
+
+// SetSelfCheckStatus records the outcome of the last run of a driver self-check.
+func SetSelfCheckStatus(check string, passed bool) {
+	selfCheckStatus.WithLabelValues(check).Set(boolToFloat(passed))
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
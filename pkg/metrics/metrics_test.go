@@ -72,6 +72,71 @@ func TestObserveLibraryDownloadDuration(t *testing.T) {
 	}
 }
 
+func TestRecordVolumeMountAttempt(t *testing.T) {
+	nodeVolumeMountAttempts.Reset()
+
+	RecordVolumeMountAttempt("DatadogLibrary", "shop", StatusSuccess)
+	RecordVolumeMountAttempt("DatadogLibrary", "shop", StatusSuccess)
+	RecordVolumeMountAttempt("DatadogLibrary", "", StatusFailed)
+
+	require.Equal(t, float64(2), testutil.ToFloat64(nodeVolumeMountAttempts.WithLabelValues("DatadogLibrary", "shop", string(StatusSuccess))))
+	require.Equal(t, float64(1), testutil.ToFloat64(nodeVolumeMountAttempts.WithLabelValues("DatadogLibrary", "", string(StatusFailed))))
+}
+
+func TestRecordVolumeUnMountAttempt(t *testing.T) {
+	nodeVolumeUnmountAttempts.Reset()
+
+	RecordVolumeUnMountAttempt("DatadogLibrary", StatusSuccess)
+	RecordVolumeUnMountAttempt("DatadogLibrary", StatusSuccess)
+	RecordVolumeUnMountAttempt("APMSocket", StatusFailed)
+	RecordVolumeUnMountAttempt("", StatusUnsupported)
+
+	require.Equal(t, float64(2), testutil.ToFloat64(nodeVolumeUnmountAttempts.WithLabelValues("DatadogLibrary", string(StatusSuccess))))
+	require.Equal(t, float64(1), testutil.ToFloat64(nodeVolumeUnmountAttempts.WithLabelValues("APMSocket", string(StatusFailed))))
+	require.Equal(t, float64(1), testutil.ToFloat64(nodeVolumeUnmountAttempts.WithLabelValues("", string(StatusUnsupported))))
+}
+
+func TestRecordAdmissionDecision(t *testing.T) {
+	admissionDecisions.Reset()
+
+	RecordAdmissionDecision("DSDSocketDirectory", "shop", AdmissionAllowed, "")
+	RecordAdmissionDecision("DSDSocketDirectory", "shop", AdmissionDenied, "platform-only")
+	RecordAdmissionDecision("DSDSocketDirectory", "shop", AdmissionDenied, "platform-only")
+
+	require.Equal(t, float64(1), testutil.ToFloat64(admissionDecisions.WithLabelValues("DSDSocketDirectory", "shop", string(AdmissionAllowed), "")))
+	require.Equal(t, float64(2), testutil.ToFloat64(admissionDecisions.WithLabelValues("DSDSocketDirectory", "shop", string(AdmissionDenied), "platform-only")))
+}
+
+func TestRecordLegacySchemaPublish(t *testing.T) {
+	legacySchemaPublishes.Reset()
+
+	RecordLegacySchemaPublish("shop", "socket")
+	RecordLegacySchemaPublish("shop", "socket")
+	RecordLegacySchemaPublish("", "local")
+
+	require.Equal(t, float64(2), testutil.ToFloat64(legacySchemaPublishes.WithLabelValues("shop", "socket")))
+	require.Equal(t, float64(1), testutil.ToFloat64(legacySchemaPublishes.WithLabelValues("", "local")))
+}
+
+func TestSetReadyAndSelfCheckStatus(t *testing.T) {
+	driverReady.Reset()
+	selfCheckStatus.Reset()
+
+	SetSelfCheckStatus("storage", true)
+	SetSelfCheckStatus("apm_socket", false)
+	SetReady(false)
+
+	require.Equal(t, float64(1), testutil.ToFloat64(selfCheckStatus.WithLabelValues("storage")))
+	require.Equal(t, float64(0), testutil.ToFloat64(selfCheckStatus.WithLabelValues("apm_socket")))
+	require.Equal(t, float64(0), testutil.ToFloat64(driverReady.WithLabelValues()))
+
+	SetSelfCheckStatus("apm_socket", true)
+	SetReady(true)
+
+	require.Equal(t, float64(1), testutil.ToFloat64(selfCheckStatus.WithLabelValues("apm_socket")))
+	require.Equal(t, float64(1), testutil.ToFloat64(driverReady.WithLabelValues()))
+}
+
 // histogramCountAndSum returns the observed sample count and sum for the given
 // (library, registry) series of a HistogramVec. This intentionally ignores
 // bucket layout so the test stays robust when buckets evolve.
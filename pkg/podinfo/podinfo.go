@@ -0,0 +1,109 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+// Package podinfo describes the pod a volume is published for. Kubelet passes
+// the pod identity in the volume context of NodePublishVolume when the
+// CSIDriver object sets podInfoOnMount. The package is dependency-free so that
+// the publishers, the library manager and the logger can all share it.
+package podinfo
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Volume context keys set by kubelet when the CSIDriver object sets podInfoOnMount.
+const (
+	// KeyPrefix is the prefix of the volume context keys reserved for kubelet.
+	KeyPrefix = "csi.storage.k8s.io/"
+
+	KeyPodName            = "csi.storage.k8s.io/pod.name"
+	KeyPodNamespace       = "csi.storage.k8s.io/pod.namespace"
+	KeyPodUID             = "csi.storage.k8s.io/pod.uid"
+	KeyServiceAccountName = "csi.storage.k8s.io/serviceAccount.name"
+)
+
+// PodInfo is the identity of the pod a volume is published for. Its fields are
+// empty when the CSIDriver object does not set podInfoOnMount.
+type PodInfo struct {
+	Name           string `json:"name,omitempty"`
+	Namespace      string `json:"namespace,omitempty"`
+	UID            string `json:"uid,omitempty"`
+	ServiceAccount string `json:"service_account,omitempty"`
+}
+
+// FromVolumeContext parses the pod identity from the volume context of a publish request.
+func FromVolumeContext(volumeCtx map[string]string) PodInfo {
+	return PodInfo{
+		Name:           volumeCtx[KeyPodName],
+		Namespace:      volumeCtx[KeyPodNamespace],
+		UID:            volumeCtx[KeyPodUID],
+		ServiceAccount: volumeCtx[KeyServiceAccountName],
+	}
+}
+
+// IsZero returns true if no pod identity is known.
+func (p PodInfo) IsZero() bool {
+	return p == PodInfo{}
+}
+
+// LogAttrs returns the non-empty fields of the pod identity as log attributes.
+func (p PodInfo) LogAttrs() []slog.Attr {
+	var attrs []slog.Attr
+	for _, field := range []struct{ key, value string }{
+		{"pod_name", p.Name},
+		{"pod_namespace", p.Namespace},
+		{"pod_uid", p.UID},
+		{"service_account", p.ServiceAccount},
+	} {
+		if field.value != "" {
+			attrs = append(attrs, slog.String(field.key, field.value))
+		}
+	}
+	return attrs
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the pod identity. The context is
+// returned as is when the identity is unknown.
+func NewContext(ctx context.Context, pod PodInfo) context.Context {
+	if pod.IsZero() {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, pod)
+}
+
+// FromContext returns the pod identity carried by ctx, if any.
+func FromContext(ctx context.Context) (PodInfo, bool) {
+	pod, ok := ctx.Value(contextKey{}).(PodInfo)
+	return pod, ok
+}
+
+// logHandler adds the pod identity carried by the context to every record.
+type logHandler struct {
+	slog.Handler
+}
+
+// NewLogHandler wraps a log handler so that the records logged with a context
+// carrying a pod identity, e.g. through slog.InfoContext, are tagged with it.
+func NewLogHandler(handler slog.Handler) slog.Handler {
+	return logHandler{Handler: handler}
+}
+
+func (h logHandler) Handle(ctx context.Context, record slog.Record) error {
+	if pod, ok := FromContext(ctx); ok {
+		record.AddAttrs(pod.LogAttrs()...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return logHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h logHandler) WithGroup(name string) slog.Handler {
+	return logHandler{Handler: h.Handler.WithGroup(name)}
+}
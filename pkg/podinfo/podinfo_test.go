@@ -0,0 +1,56 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package podinfo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromVolumeContext(t *testing.T) {
+	pod := FromVolumeContext(map[string]string{
+		"type":                "DatadogLibrary",
+		KeyPodName:            "web-7d9f",
+		KeyPodNamespace:       "shop",
+		KeyPodUID:             "0b7e6c1a",
+		KeyServiceAccountName: "web",
+	})
+
+	assert.Equal(t, PodInfo{Name: "web-7d9f", Namespace: "shop", UID: "0b7e6c1a", ServiceAccount: "web"}, pod)
+	assert.False(t, pod.IsZero())
+	assert.True(t, FromVolumeContext(map[string]string{"type": "DatadogLibrary"}).IsZero())
+}
+
+func TestContext(t *testing.T) {
+	_, ok := FromContext(NewContext(context.Background(), PodInfo{}))
+	assert.False(t, ok, "an unknown pod identity is not carried")
+
+	pod := PodInfo{Name: "web-7d9f", Namespace: "shop"}
+	got, ok := FromContext(NewContext(context.Background(), pod))
+	require.True(t, ok)
+	assert.Equal(t, pod, got)
+}
+
+func TestLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewLogHandler(slog.NewJSONHandler(&buf, nil))).With("component", "test")
+	ctx := NewContext(context.Background(), PodInfo{Name: "web-7d9f", Namespace: "shop"})
+
+	logger.InfoContext(ctx, "publishing volume")
+
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "web-7d9f", line["pod_name"])
+	assert.Equal(t, "shop", line["pod_namespace"])
+	assert.Equal(t, "test", line["component"])
+	assert.NotContains(t, line, "pod_uid", "empty fields are not logged")
+}
@@ -0,0 +1,287 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+// Package policy evaluates the volume admission policy: a list of CEL rules
+// that every NodePublishVolume request must satisfy to be published.
+//
+// Each rule is a CEL expression returning a bool, evaluated with the
+// following variables:
+//
+//   - volume: the "type", the full "context" and the "readOnly" flag of the volume
+//   - pod: the "name", "namespace", "uid" and "serviceAccount" of the pod, set
+//     when the CSIDriver object enables podInfoOnMount
+//   - namespaceObject: the "metadata" of the pod namespace, holding its "name"
+//     and "labels", as in Kubernetes ValidatingAdmissionPolicies
+//   - library: the "registry", "package" and "version" of a DatadogLibrary volume
+//
+// A volume is admitted when every rule evaluates to true. A rule that fails to
+// evaluate, for example on a missing volume context key, denies the volume.
+//
+// Kubelet only overrides the pod identity keys of the volume context when the
+// CSIDriver object enables podInfoOnMount. Otherwise the author of an inline
+// volume can set them, so the identity is not trusted: pod and namespaceObject
+// are unknown, a rule whose result depends on them denies the volume, and the
+// keys are removed from the volume context.
+package policy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/ext"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	varVolume    = "volume"
+	varPod       = "pod"
+	varNamespace = "namespaceObject"
+	varLibrary   = "library"
+
+	// costLimit bounds the evaluation of a single rule, so that a faulty rule
+	// cannot stall the publish requests.
+	costLimit = 100000
+)
+
+// Rule is a named CEL expression that must evaluate to true for a volume to be admitted.
+type Rule struct {
+	// Name identifies the rule in denials, logs and metrics.
+	Name string `json:"name"`
+	// Expression is the CEL expression of the rule.
+	Expression string `json:"expression"`
+	// Message is an optional explanation returned to the user on denial.
+	Message string `json:"message,omitempty"`
+}
+
+// File is the content of a policy file.
+type File struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Library is the library requested by a DatadogLibrary volume.
+type Library struct {
+	Registry string
+	Package  string
+	Version  string
+}
+
+// Input is the volume a decision is made for.
+type Input struct {
+	VolumeType    string
+	VolumeContext map[string]string
+	ReadOnly      bool
+	Pod           podinfo.PodInfo
+	// PodTrusted is true when the pod identity was set by kubelet, i.e. when
+	// the CSIDriver object enables podInfoOnMount.
+	PodTrusted bool
+	Library    Library
+}
+
+// Decision is the outcome of the evaluation of a policy.
+type Decision struct {
+	// Allowed is true when every rule admitted the volume.
+	Allowed bool
+	// Rule is the name of the rule that denied the volume.
+	Rule string
+	// Reason explains the denial.
+	Reason string
+}
+
+// NamespaceLabeler returns the labels of a namespace.
+type NamespaceLabeler interface {
+	NamespaceLabels(ctx context.Context, namespace string) (map[string]string, error)
+}
+
+type compiledRule struct {
+	Rule
+	program cel.Program
+}
+
+// Policy is a compiled admission policy. It is safe for concurrent use.
+type Policy struct {
+	rules          []compiledRule
+	needsNamespace bool
+	labeler        NamespaceLabeler
+}
+
+// Load reads and compiles the policy file at path.
+func Load(path string) (*Policy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	var file File
+	if err := yaml.UnmarshalStrict(content, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %q: %w", path, err)
+	}
+	return New(file.Rules)
+}
+
+// New compiles the given rules into a policy.
+func New(rules []Rule) (*Policy, error) {
+	env, err := cel.NewEnv(
+		cel.Variable(varVolume, cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable(varPod, cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable(varNamespace, cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable(varLibrary, cel.MapType(cel.StringType, cel.StringType)),
+		ext.Strings(),
+		cel.OptionalTypes(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CEL environment: %w", err)
+	}
+
+	policy := &Policy{}
+	names := map[string]bool{}
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule with expression %q has no name", rule.Expression)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %q is defined more than once", rule.Name)
+		}
+		names[rule.Name] = true
+
+		ast, issues := env.Compile(rule.Expression)
+		if issues != nil && issues.Err() != nil {
+			return nil, fmt.Errorf("failed to compile rule %q: %w", rule.Name, issues.Err())
+		}
+		if !ast.OutputType().IsExactType(cel.BoolType) {
+			return nil, fmt.Errorf("rule %q must evaluate to a bool, not %s", rule.Name, ast.OutputType())
+		}
+		// Partial evaluation lets a rule leave out the untrusted pod identity.
+		program, err := env.Program(ast, cel.CostLimit(costLimit), cel.EvalOptions(cel.OptPartialEval))
+		if err != nil {
+			return nil, fmt.Errorf("failed to build rule %q: %w", rule.Name, err)
+		}
+		for _, ref := range ast.NativeRep().ReferenceMap() {
+			if ref.Name == varNamespace {
+				policy.needsNamespace = true
+			}
+		}
+		policy.rules = append(policy.rules, compiledRule{Rule: rule, program: program})
+	}
+	return policy, nil
+}
+
+// NeedsNamespaceLabels returns true when a rule reads the namespaceObject variable,
+// which requires a NamespaceLabeler.
+func (p *Policy) NeedsNamespaceLabels() bool {
+	return p.needsNamespace
+}
+
+// SetNamespaceLabeler sets the source of the namespace labels. Without one,
+// the namespace labels are empty.
+func (p *Policy) SetNamespaceLabeler(labeler NamespaceLabeler) {
+	p.labeler = labeler
+}
+
+// Rules returns the names of the rules of the policy, in evaluation order.
+func (p *Policy) Rules() []string {
+	names := make([]string, 0, len(p.rules))
+	for _, rule := range p.rules {
+		names = append(names, rule.Name)
+	}
+	return names
+}
+
+// Evaluate evaluates the rules in order against the input and returns the
+// decision of the first rule denying the volume. An error is returned when
+// the input could not be built, e.g. when the namespace labels could not be
+// fetched: no decision was made and the request should be retried.
+func (p *Policy) Evaluate(ctx context.Context, input Input) (Decision, error) {
+	vars, err := p.activation(ctx, input)
+	if err != nil {
+		return Decision{}, err
+	}
+	var activation any = vars
+	if !input.PodTrusted {
+		activation, err = cel.PartialVars(vars, cel.AttributePattern(varPod), cel.AttributePattern(varNamespace))
+		if err != nil {
+			return Decision{}, fmt.Errorf("failed to build the rule variables: %w", err)
+		}
+	}
+
+	for _, rule := range p.rules {
+		out, _, err := rule.program.ContextEval(ctx, activation)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return Decision{}, ctxErr
+			}
+			return Decision{Rule: rule.Name, Reason: fmt.Sprintf("rule failed to evaluate: %v", err)}, nil
+		}
+		if types.IsUnknown(out) {
+			return Decision{Rule: rule.Name, Reason: "rule depends on the pod identity, which is only trusted when the CSIDriver object enables podInfoOnMount"}, nil
+		}
+		allowed, ok := out.Value().(bool)
+		if !ok {
+			return Decision{Rule: rule.Name, Reason: fmt.Sprintf("rule evaluated to %v instead of a bool", out.Value())}, nil
+		}
+		if !allowed {
+			reason := rule.Message
+			if reason == "" {
+				reason = fmt.Sprintf("%s evaluated to false", strings.TrimSpace(rule.Expression))
+			}
+			return Decision{Rule: rule.Name, Reason: reason}, nil
+		}
+	}
+	return Decision{Allowed: true}, nil
+}
+
+func (p *Policy) activation(ctx context.Context, input Input) (map[string]any, error) {
+	// An untrusted pod identity is left out, including from the volume context.
+	volumeCtx := map[string]string{}
+	for key, value := range input.VolumeContext {
+		if input.PodTrusted || !strings.HasPrefix(key, podinfo.KeyPrefix) {
+			volumeCtx[key] = value
+		}
+	}
+	pod := input.Pod
+	if !input.PodTrusted {
+		pod = podinfo.PodInfo{}
+	}
+
+	labels := map[string]string{}
+	if p.needsNamespace && p.labeler != nil && pod.Namespace != "" {
+		var err error
+		labels, err = p.labeler.NamespaceLabels(ctx, pod.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the labels of namespace %q: %w", pod.Namespace, err)
+		}
+		if labels == nil {
+			labels = map[string]string{}
+		}
+	}
+
+	return map[string]any{
+		varVolume: map[string]any{
+			"type":     input.VolumeType,
+			"context":  volumeCtx,
+			"readOnly": input.ReadOnly,
+		},
+		varPod: map[string]string{
+			"name":           pod.Name,
+			"namespace":      pod.Namespace,
+			"uid":            pod.UID,
+			"serviceAccount": pod.ServiceAccount,
+		},
+		varNamespace: map[string]any{
+			"metadata": map[string]any{
+				"name":   pod.Namespace,
+				"labels": labels,
+			},
+		},
+		varLibrary: map[string]string{
+			"registry": input.Library.Registry,
+			"package":  input.Library.Package,
+			"version":  input.Library.Version,
+		},
+	}, nil
+}
@@ -0,0 +1,210 @@
+// Datadog datadog-csi driver
+// Copyright 2025-present Datadog, Inc.
+//
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+
+package policy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Datadog/datadog-csi-driver/pkg/podinfo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticLabeler struct {
+	labels map[string]map[string]string
+	err    error
+	calls  int
+}
+
+func (s *staticLabeler) NamespaceLabels(_ context.Context, namespace string) (map[string]string, error) {
+	s.calls++
+	return s.labels[namespace], s.err
+}
+
+func TestNew_Errors(t *testing.T) {
+	tests := map[string][]Rule{
+		"missing name":       {{Expression: "true"}},
+		"duplicate name":     {{Name: "a", Expression: "true"}, {Name: "a", Expression: "true"}},
+		"syntax error":       {{Name: "a", Expression: "volume.type =="}},
+		"unknown variable":   {{Name: "a", Expression: "node.name == 'x'"}},
+		"non bool result":    {{Name: "a", Expression: "volume.type"}},
+		"wrong type compare": {{Name: "a", Expression: "pod.name == 1"}},
+	}
+	for name, rules := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(rules)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	labeler := &staticLabeler{labels: map[string]map[string]string{
+		"platform": {"team": "platform"},
+		"shop":     {"team": "web"},
+	}}
+	policy, err := New([]Rule{
+		{
+			Name:       "platform-only-dsd-socket-directory",
+			Expression: `volume.type != "DSDSocketDirectory" || namespaceObject.metadata.labels[?"team"].orValue("") == "platform"`,
+			Message:    "only the platform team may mount the DogStatsD socket directory",
+		},
+		{
+			Name:       "datadog-registry",
+			Expression: `volume.type != "DatadogLibrary" || library.registry.startsWith("gcr.io/datadoghq/")`,
+		},
+		{
+			Name:       "read-only-libraries",
+			Expression: `volume.type != "DatadogLibrary" || volume.readOnly`,
+		},
+	})
+	require.NoError(t, err)
+	require.True(t, policy.NeedsNamespaceLabels())
+	require.Equal(t, []string{"platform-only-dsd-socket-directory", "datadog-registry", "read-only-libraries"}, policy.Rules())
+	policy.SetNamespaceLabeler(labeler)
+
+	tests := map[string]struct {
+		input        Input
+		expectedRule string
+	}{
+		"socket directory in a platform namespace": {
+			input: Input{VolumeType: "DSDSocketDirectory", Pod: podinfo.PodInfo{Namespace: "platform"}, PodTrusted: true},
+		},
+		"socket directory in another namespace": {
+			input:        Input{VolumeType: "DSDSocketDirectory", Pod: podinfo.PodInfo{Namespace: "shop"}, PodTrusted: true},
+			expectedRule: "platform-only-dsd-socket-directory",
+		},
+		"socket directory without pod info": {
+			input:        Input{VolumeType: "DSDSocketDirectory", PodTrusted: true},
+			expectedRule: "platform-only-dsd-socket-directory",
+		},
+		"socket directory with an untrusted pod identity": {
+			input:        Input{VolumeType: "DSDSocketDirectory", Pod: podinfo.PodInfo{Namespace: "platform"}},
+			expectedRule: "platform-only-dsd-socket-directory",
+		},
+		"library with an untrusted pod identity": {
+			input: Input{VolumeType: "DatadogLibrary", ReadOnly: true, Library: Library{Registry: "gcr.io/datadoghq/dd-lib-java-init"}, Pod: podinfo.PodInfo{Namespace: "shop"}},
+		},
+		"library from the Datadog registry": {
+			input: Input{VolumeType: "DatadogLibrary", ReadOnly: true, Library: Library{Registry: "gcr.io/datadoghq/dd-lib-java-init"}},
+		},
+		"library from another registry": {
+			input:        Input{VolumeType: "DatadogLibrary", ReadOnly: true, Library: Library{Registry: "docker.io/evil"}},
+			expectedRule: "datadog-registry",
+		},
+		"writable library": {
+			input:        Input{VolumeType: "DatadogLibrary", Library: Library{Registry: "gcr.io/datadoghq/dd-lib-java-init"}},
+			expectedRule: "read-only-libraries",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			decision, err := policy.Evaluate(context.Background(), tc.input)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedRule == "", decision.Allowed)
+			assert.Equal(t, tc.expectedRule, decision.Rule)
+			if !decision.Allowed {
+				assert.NotEmpty(t, decision.Reason)
+			}
+		})
+	}
+}
+
+func TestEvaluate_EvaluationErrorDenies(t *testing.T) {
+	policy, err := New([]Rule{{Name: "team", Expression: `volume.context["team"] == "platform"`}})
+	require.NoError(t, err)
+	require.False(t, policy.NeedsNamespaceLabels())
+
+	decision, err := policy.Evaluate(context.Background(), Input{VolumeType: "APMSocket"})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, "team", decision.Rule)
+	assert.Contains(t, decision.Reason, "failed to evaluate")
+}
+
+func TestEvaluate_NamespaceLabels(t *testing.T) {
+	t.Run("not fetched when no rule needs them", func(t *testing.T) {
+		labeler := &staticLabeler{}
+		policy, err := New([]Rule{{Name: "pod", Expression: `pod.namespace != "kube-system"`}})
+		require.NoError(t, err)
+		policy.SetNamespaceLabeler(labeler)
+
+		decision, err := policy.Evaluate(context.Background(), Input{Pod: podinfo.PodInfo{Namespace: "shop"}, PodTrusted: true})
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+		assert.Zero(t, labeler.calls)
+	})
+
+	t.Run("lookup errors are returned", func(t *testing.T) {
+		labeler := &staticLabeler{err: errors.New("forbidden")}
+		policy, err := New([]Rule{{Name: "team", Expression: `"team" in namespaceObject.metadata.labels`}})
+		require.NoError(t, err)
+		policy.SetNamespaceLabeler(labeler)
+
+		_, err = policy.Evaluate(context.Background(), Input{Pod: podinfo.PodInfo{Namespace: "shop"}, PodTrusted: true})
+		assert.ErrorContains(t, err, "forbidden")
+	})
+}
+
+func TestEvaluate_UntrustedPodInfo(t *testing.T) {
+	t.Run("rules depending on the pod deny without a lookup", func(t *testing.T) {
+		labeler := &staticLabeler{labels: map[string]map[string]string{"platform": {"team": "platform"}}}
+		policy, err := New([]Rule{{Name: "team", Expression: `namespaceObject.metadata.labels[?"team"].orValue("") == "platform"`}})
+		require.NoError(t, err)
+		policy.SetNamespaceLabeler(labeler)
+
+		decision, err := policy.Evaluate(context.Background(), Input{Pod: podinfo.PodInfo{Namespace: "platform"}})
+		require.NoError(t, err)
+		assert.False(t, decision.Allowed)
+		assert.Equal(t, "team", decision.Rule)
+		assert.Contains(t, decision.Reason, "podInfoOnMount")
+		assert.Zero(t, labeler.calls)
+	})
+
+	t.Run("pod keys are removed from the volume context", func(t *testing.T) {
+		policy, err := New([]Rule{{Name: "namespace", Expression: `!("` + podinfo.KeyPodNamespace + `" in volume.context) && volume.context["team"] == "web"`}})
+		require.NoError(t, err)
+		volumeCtx := map[string]string{podinfo.KeyPodNamespace: "platform", "team": "web"}
+
+		decision, err := policy.Evaluate(context.Background(), Input{VolumeContext: volumeCtx})
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+
+		decision, err = policy.Evaluate(context.Background(), Input{VolumeContext: volumeCtx, PodTrusted: true})
+		require.NoError(t, err)
+		assert.False(t, decision.Allowed)
+	})
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`rules:
+- name: no-kube-system
+  expression: pod.namespace != "kube-system"
+  message: volumes cannot be mounted in kube-system
+`), 0o644))
+
+	policy, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"no-kube-system"}, policy.Rules())
+
+	decision, err := policy.Evaluate(context.Background(), Input{Pod: podinfo.PodInfo{Namespace: "kube-system"}, PodTrusted: true})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, "volumes cannot be mounted in kube-system", decision.Reason)
+
+	require.NoError(t, os.WriteFile(path, []byte("rules:\n- name: a\n  expresion: 'true'\n"), 0o644))
+	_, err = Load(path)
+	assert.Error(t, err, "unknown fields are rejected")
+
+	_, err = Load(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+}